@@ -91,16 +91,18 @@ func workaroundMounts() error {
 	return nil
 }
 
-func CheckPrerequisites() error {
+func CheckPrerequisites(cfg config.Config) error {
 	// Check kernel version
 	logger.L().Debug("checking kernel version")
 	if err := checkKernelVersion(KubescapeEBPFEngineMinKernelVersionSupport); err != nil {
 		return err
 	}
-	// Get Node name from environment variable
-	logger.L().Debug("checking node name")
-	if nodeName := os.Getenv(config.NodeNameEnvVar); nodeName == "" {
-		return fmt.Errorf("%s environment variable not set", config.NodeNameEnvVar)
+	// Get Node name from environment variable, there is no node in standalone mode
+	if !cfg.Standalone.Enabled {
+		logger.L().Debug("checking node name")
+		if nodeName := os.Getenv(config.NodeNameEnvVar); nodeName == "" {
+			return fmt.Errorf("%s environment variable not set", config.NodeNameEnvVar)
+		}
 	}
 	// Ensure all filesystems are mounted
 	logger.L().Debug("checking mounts")
@@ -88,7 +88,7 @@ func TestCheckPrerequisites(t *testing.T) {
 			if tt.setEnv {
 				t.Setenv(config.NodeNameEnvVar, "testNode")
 			}
-			if err := CheckPrerequisites(); (err != nil) != tt.wantErr {
+			if err := CheckPrerequisites(config.Config{}); (err != nil) != tt.wantErr {
 				t.Errorf("CheckPrerequisites() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -5,15 +5,39 @@ import (
 	"net/http"
 	"net/url"
 	"node-agent/internal/validator"
+	"node-agent/pkg/anomalydetector"
+	anomalydetectorv1 "node-agent/pkg/anomalydetector/v1"
+	"node-agent/pkg/applicationprofilemanager"
+	applicationprofilemanagerv1 "node-agent/pkg/applicationprofilemanager/v1"
 	"node-agent/pkg/config"
 	"node-agent/pkg/containerwatcher/v1"
+	"node-agent/pkg/driftdetector"
+	driftdetectorv1 "node-agent/pkg/driftdetector/v1"
+	"node-agent/pkg/exporters"
+	exportersv1 "node-agent/pkg/exporters/v1"
 	"node-agent/pkg/filehandler/v1"
+	"node-agent/pkg/garbagecollector/v1"
+	"node-agent/pkg/imageresolver"
+	imageresolverv1 "node-agent/pkg/imageresolver/v1"
+	"node-agent/pkg/libraryresolver/v1"
+	"node-agent/pkg/metadataprovider"
+	metadataproviderv1 "node-agent/pkg/metadataprovider/v1"
+	"node-agent/pkg/networkmanager"
+	networkmanagerv1 "node-agent/pkg/networkmanager/v1"
+	processtreev1 "node-agent/pkg/processtree/v1"
 	"node-agent/pkg/relevancymanager/v1"
+	"node-agent/pkg/ruleengine"
+	ruleenginev1 "node-agent/pkg/ruleengine/v1"
 	"node-agent/pkg/storageclient"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
+	containerutils "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils"
+	runtimeclient "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils/runtime-client"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
 	"github.com/kubescape/go-logger"
 	"github.com/kubescape/go-logger/helpers"
 	"github.com/kubescape/k8s-interface/k8sinterface"
@@ -30,9 +54,17 @@ func main() {
 		logger.L().Ctx(ctx).Fatal("load config error", helpers.Error(err))
 	}
 
-	clusterData, err := config.LoadClusterData("/etc/config")
-	if err != nil {
-		logger.L().Ctx(ctx).Fatal("load clusterData error", helpers.Error(err))
+	if err := config.ValidateStandalone(cfg); err != nil {
+		logger.L().Ctx(ctx).Fatal("invalid standalone configuration", helpers.Error(err))
+	}
+
+	// there is no cluster in standalone mode
+	var clusterData config.ClusterData
+	if !cfg.Standalone.Enabled {
+		clusterData, err = config.LoadClusterData("/etc/config")
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("load clusterData error", helpers.Error(err))
+		}
 	}
 
 	// to enable otel, set OTEL_COLLECTOR_SVC=otel-collector:4317
@@ -45,7 +77,7 @@ func main() {
 		defer logger.ShutdownOtel(ctx)
 	}
 
-	err = validator.CheckPrerequisites()
+	err = validator.CheckPrerequisites(cfg)
 	if err != nil {
 		logger.L().Ctx(ctx).Fatal("error during validation", helpers.Error(err))
 	}
@@ -61,18 +93,145 @@ func main() {
 		logger.L().Ctx(ctx).Fatal("failed to create fileDB", helpers.Error(err))
 	}
 	defer fileHandler.Close()
-	k8sClient := k8sinterface.NewKubernetesApi()
-	storageClient, err := storageclient.CreateSBOMStorageK8SAggregatedAPIClient(ctx)
+	var k8sClient *k8sinterface.KubernetesApi
+	if !cfg.Standalone.Enabled {
+		k8sClient = k8sinterface.NewKubernetesApi()
+	}
+	if cfg.SBOMInput.Format == config.SBOMInputFormatSyft && cfg.SBOMInput.Directory == "" && cfg.Storage.Type != config.StorageTypeHTTP {
+		logger.L().Ctx(ctx).Fatal("Syft SBOMs need sbomInput.directory or the http storage")
+	}
+	var storageClient storageclient.StorageClient
+	switch cfg.Storage.Type {
+	case config.StorageTypeHTTP:
+		storageClient, err = storageclient.CreateStorageHttpClient(cfg.Storage.HTTP)
+	default:
+		storageClient, err = storageclient.CreateSBOMStorageK8SAggregatedAPIClient(ctx)
+	}
 	if err != nil {
 		logger.L().Ctx(ctx).Fatal("error creating the storage client", helpers.Error(err))
 	}
-	relevancyManager, err := relevancymanager.CreateRelevancyManager(cfg, clusterData.ClusterName, fileHandler, k8sClient, afero.NewOsFs(), storageClient)
+	var metadataProviderClient metadataprovider.MetadataProviderClient
+	var imageResolver imageresolver.ImageResolverClient
+	var runtimes []*containerutils.RuntimeConfig
+	if cfg.Standalone.Enabled {
+		// Create the image resolver, the containers of a plain container host have no pod so their images are asked
+		// to the container runtimes
+		dockerSocket := filepath.Join(host.HostRoot, cfg.Standalone.DockerSocket)
+		containerdSocket := filepath.Join(host.HostRoot, cfg.Standalone.ContainerdSocket)
+		imageResolver, err = imageresolverv1.CreateRuntimeImageResolver(dockerSocket, containerdSocket)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the image resolver", helpers.Error(err))
+		}
+		for _, runtime := range []*containerutils.RuntimeConfig{
+			{Name: runtimeclient.DockerName, SocketPath: dockerSocket},
+			{Name: runtimeclient.ContainerdName, SocketPath: containerdSocket},
+		} {
+			if _, err := os.Stat(runtime.SocketPath); err == nil {
+				runtimes = append(runtimes, runtime)
+			}
+		}
+	} else {
+		// Create the metadata provider, it holds the pods of the node so the containers do not query the API server
+		switch cfg.MetadataProvider.Type {
+		case config.MetadataProviderKubelet:
+			metadataProviderClient, err = metadataproviderv1.CreateKubeletProvider(cfg.MetadataProvider.Kubelet, k8sClient)
+		default:
+			metadataProviderClient, err = metadataproviderv1.CreateInformerProvider(k8sClient, os.Getenv(config.NodeNameEnvVar))
+		}
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the metadata provider", helpers.Error(err))
+		}
+		metadataProviderClient.StartMetadataProvider(ctx)
+		// Create the image resolver, it asks the container runtime for the image digests missing from the pod status
+		imageResolver, err = imageresolverv1.CreateCRIImageResolver(cfg.CRISocket, host.HostRoot)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the image resolver", helpers.Error(err))
+		}
+	}
+	relevancyManager, err := relevancymanager.CreateRelevancyManager(cfg, clusterData.ClusterName, fileHandler, metadataProviderClient, imageResolver, afero.NewOsFs(), storageClient)
 	if err != nil {
 		logger.L().Ctx(ctx).Fatal("error creating the relevancy manager", helpers.Error(err))
 	}
 
+	// Create the garbage collector of filtered SBOMs
+	if cfg.GarbageCollection.Enabled {
+		garbageCollector, err := garbagecollector.CreateGarbageCollector(cfg, k8sClient, storageClient, os.Getenv(config.NodeNameEnvVar))
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the garbage collector", helpers.Error(err))
+		}
+		garbageCollector.StartGarbageCollector(ctx)
+	}
+
+	// Create the network manager, the network activity is traced only when it is enabled
+	var networkManagerClient networkmanager.NetworkManagerClient
+	if cfg.EnableNetwork {
+		networkManagerClient, err = networkmanagerv1.CreateNetworkManager(cfg, metadataProviderClient, storageClient)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the network manager", helpers.Error(err))
+		}
+	}
+
+	// Create the application profile manager, the profiles are built only when it is enabled
+	var applicationProfileManagerClient applicationprofilemanager.ApplicationProfileManagerClient
+	if cfg.EnableApplicationProfile {
+		applicationProfileManagerClient, err = applicationprofilemanagerv1.CreateApplicationProfileManager(cfg, metadataProviderClient, storageClient)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the application profile manager", helpers.Error(err))
+		}
+	}
+
+	// Create the drift detector, it compares the files executed and written in the containers with their image SBOM
+	var driftDetectorClient driftdetector.DriftDetectorClient
+	if cfg.DriftDetection.Enabled {
+		driftDetectorClient, err = driftdetectorv1.CreateDriftDetector(cfg, metadataProviderClient, storageClient, imageResolver, host.HostProcFs)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the drift detector", helpers.Error(err))
+		}
+	}
+
+	// Create the process tree, it gives the ancestry of the processes accessing files and raising alerts
+	processTree := processtreev1.CreateProcessTree(host.HostProcFs)
+
+	// Create the alert exporters, they send the alerts of the anomaly detector and of the rule engine
+	var exporter exporters.Exporter
+	if cfg.AnomalyDetection.Enabled || cfg.RuleEngine.Enabled {
+		exporter, err = exportersv1.CreateAlertExporters(cfg.Exporters)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the alert exporters", helpers.Error(err))
+		}
+	}
+
+	// Create the anomaly detector, it compares the activity of the containers with their application profiles
+	var anomalyDetectorClient anomalydetector.AnomalyDetectorClient
+	if cfg.AnomalyDetection.Enabled {
+		if !cfg.EnableApplicationProfile {
+			logger.L().Ctx(ctx).Fatal("anomaly detection needs the application profiles to be enabled")
+		}
+		anomalyDetectorClient, err = anomalydetectorv1.CreateAnomalyDetector(cfg, metadataProviderClient, storageClient, exporter, processTree)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the anomaly detector", helpers.Error(err))
+		}
+	}
+
+	// Create the rule engine, it evaluates the runtime detection rules over the activity of the containers
+	var ruleEngineClient ruleengine.RuleEngineClient
+	if cfg.RuleEngine.Enabled {
+		ruleSet, err := ruleenginev1.LoadRuleSet(cfg.RuleEngine.RulesFile)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error loading the detection rules", helpers.Error(err))
+		}
+		ruleEngineClient, err = ruleenginev1.CreateRuleEngine(ruleSet, exporter, processTree)
+		if err != nil {
+			logger.L().Ctx(ctx).Fatal("error creating the rule engine", helpers.Error(err))
+		}
+	}
+
 	// Create the container handler
-	mainHandler, err := containerwatcher.CreateIGContainerWatcher(k8sClient, relevancyManager)
+	var mappedFilesPeriod time.Duration
+	if cfg.EnableRelevancy && cfg.MappedFiles.Enabled {
+		mappedFilesPeriod = cfg.MappedFiles.Period
+	}
+	mainHandler, err := containerwatcher.CreateIGContainerWatcher(k8sClient, runtimes, libraryresolver.CreateELFLibraryResolver(), applicationProfileManagerClient, anomalyDetectorClient, driftDetectorClient, networkManagerClient, processTree, relevancyManager, ruleEngineClient, mappedFilesPeriod)
 	if err != nil {
 		logger.L().Ctx(ctx).Fatal("error creating the container watcher", helpers.Error(err))
 	}
@@ -82,13 +241,15 @@ func main() {
 	if err != nil {
 		logger.L().Ctx(ctx).Fatal("error starting the container watcher", helpers.Error(err))
 	}
-	defer mainHandler.Stop()
 
 	// Wait for shutdown signal
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 
-	// Exit with success
-	os.Exit(0)
+	// the tracers are stopped before the queued alerts are flushed, returning runs the other deferred calls
+	mainHandler.Stop()
+	if exporter != nil {
+		exporter.Stop()
+	}
 }
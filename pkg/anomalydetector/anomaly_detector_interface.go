@@ -0,0 +1,17 @@
+package anomalydetector
+
+import (
+	"context"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+)
+
+type AnomalyDetectorClient interface {
+	StartAnomalyDetector(ctx context.Context)
+	ReportContainerStarted(ctx context.Context, container *containercollection.Container)
+	ReportContainerTerminated(ctx context.Context, container *containercollection.Container)
+	ReportExecEvent(ctx context.Context, container *containercollection.Container, event *tracerexectype.Event)
+	ReportOpenEvent(ctx context.Context, container *containercollection.Container, event *traceropentype.Event)
+}
@@ -0,0 +1,266 @@
+package anomalydetector
+
+import (
+	"context"
+	"fmt"
+	"node-agent/pkg/anomalydetector"
+	"node-agent/pkg/applicationprofilemanager"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"node-agent/pkg/metadataprovider"
+	"node-agent/pkg/processtree"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+)
+
+const (
+	ruleUnexpectedExec     = "UnexpectedExec"
+	ruleUnexpectedShell    = "UnexpectedShell"
+	ruleUnexpectedEtcWrite = "UnexpectedEtcWrite"
+)
+
+// shells are the binaries whose unexpected execution is reported as a shell spawn
+var shells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "ash": true, "zsh": true, "ksh": true, "csh": true, "tcsh": true, "fish": true,
+}
+
+// AnomalyDetector compares the exec and open events of the containers with their application profiles. The profile
+// of a container is complete once its sniffing time is over and the application profile manager stored it, it is read
+// every update period until it is found and the detection starts then. Each deviation is sent once per container to
+// the exporter.
+type AnomalyDetector struct {
+	cfg              config.Config
+	metadataProvider metadataprovider.MetadataProviderClient
+	storageClient    storageclient.StorageClient
+	exporter         exporters.Exporter
+	// processTree gives the ancestry of the processes in the alerts, it is optional
+	processTree processtree.ProcessTreeClient
+	// watchedContainers maps a container ID to its *containerBaseline
+	watchedContainers sync.Map
+	// getInstanceID is replaced in tests
+	getInstanceID func(ctx context.Context, container *containercollection.Container) (instanceidhandler.IInstanceID, error)
+}
+
+type containerBaseline struct {
+	mutex     sync.Mutex
+	container *containercollection.Container
+	startedAt time.Time
+	// loaded is set once the application profile has been read, the events are not checked before
+	loaded bool
+	execs  map[string]bool
+	// writes holds the paths the container opened for writing
+	writes map[string]bool
+	// alerted holds the anomalies already reported
+	alerted map[string]bool
+}
+
+var _ anomalydetector.AnomalyDetectorClient = (*AnomalyDetector)(nil)
+
+func CreateAnomalyDetector(cfg config.Config, metadataProvider metadataprovider.MetadataProviderClient, storageClient storageclient.StorageClient, exporter exporters.Exporter, processTree processtree.ProcessTreeClient) (*AnomalyDetector, error) {
+	if cfg.MaxSniffingTime <= 0 {
+		return nil, fmt.Errorf("anomaly detection needs a maximal sniffing time, the application profiles are never complete without it")
+	}
+	ad := &AnomalyDetector{
+		cfg:              cfg,
+		metadataProvider: metadataProvider,
+		storageClient:    storageClient,
+		exporter:         exporter,
+		processTree:      processTree,
+	}
+	ad.getInstanceID = ad.podInstanceID
+	return ad, nil
+}
+
+// StartAnomalyDetector reads the application profiles of the containers whose sniffing time is over every update
+// period, the events only look up the profiles already read
+func (ad *AnomalyDetector) StartAnomalyDetector(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ad.cfg.UpdateDataPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ad.loadProfiles(ctx)
+			}
+		}
+	}()
+}
+
+func (ad *AnomalyDetector) ReportContainerStarted(_ context.Context, container *containercollection.Container) {
+	ad.watchedContainers.LoadOrStore(container.ID, &containerBaseline{
+		container: container,
+		startedAt: time.Now(),
+		alerted:   make(map[string]bool),
+	})
+}
+
+func (ad *AnomalyDetector) ReportContainerTerminated(_ context.Context, container *containercollection.Container) {
+	ad.watchedContainers.Delete(container.ID)
+}
+
+func (ad *AnomalyDetector) ReportExecEvent(ctx context.Context, container *containercollection.Container, event *tracerexectype.Event) {
+	baseline := ad.detectedContainer(ctx, container)
+	if baseline == nil {
+		return
+	}
+	path := event.Comm
+	if len(event.Args) > 0 {
+		path = event.Args[0]
+	}
+	ruleName, severity, message := ruleUnexpectedExec, exporters.SeverityMedium, fmt.Sprintf("unexpected exec of %s", path)
+	if shells[filepath.Base(path)] {
+		ruleName, severity, message = ruleUnexpectedShell, exporters.SeverityHigh, fmt.Sprintf("unexpected shell %s started", path)
+	}
+	if !baseline.newAnomaly(ruleName, path, func() bool { return !baseline.execs[path] }) {
+		return
+	}
+	ad.sendAlert(exporters.Alert{
+		RuleName: ruleName,
+		Severity: severity,
+		Message:  message,
+		Pid:      event.Pid,
+		Comm:     event.Comm,
+		Path:     path,
+		Args:     event.Args,
+	}, container)
+}
+
+func (ad *AnomalyDetector) ReportOpenEvent(ctx context.Context, container *containercollection.Container, event *traceropentype.Event) {
+	baseline := ad.detectedContainer(ctx, container)
+	if baseline == nil {
+		return
+	}
+	path := event.FullPath
+	if path == "" {
+		path = event.Path
+	}
+	if !strings.HasPrefix(path, "/etc/") || !utils.IsWriteOpen(event.Flags) {
+		return
+	}
+	if !baseline.newAnomaly(ruleUnexpectedEtcWrite, path, func() bool { return !baseline.writes[path] }) {
+		return
+	}
+	ad.sendAlert(exporters.Alert{
+		RuleName: ruleUnexpectedEtcWrite,
+		Severity: exporters.SeverityHigh,
+		Message:  fmt.Sprintf("unexpected write to %s", path),
+		Pid:      event.Pid,
+		Comm:     event.Comm,
+		Path:     path,
+	}, container)
+}
+
+// detectedContainer returns the baseline of a container whose events are checked, nil when the container is not
+// watched or its application profile is not read yet
+func (ad *AnomalyDetector) detectedContainer(_ context.Context, container *containercollection.Container) *containerBaseline {
+	data, ok := ad.watchedContainers.Load(container.ID)
+	if !ok {
+		return nil
+	}
+	baseline := data.(*containerBaseline)
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+	if !baseline.loaded {
+		return nil
+	}
+	return baseline
+}
+
+// loadProfiles reads the application profiles of the containers whose sniffing time is over and whose profile is not
+// read yet, the profiles not stored yet are read again on the next call
+func (ad *AnomalyDetector) loadProfiles(ctx context.Context) {
+	ad.watchedContainers.Range(func(_, data any) bool {
+		baseline := data.(*containerBaseline)
+		// leave the application profile manager one update period to store the end of the sniffing time
+		if time.Since(baseline.startedAt) < ad.cfg.MaxSniffingTime+ad.cfg.UpdateDataPeriod {
+			return true
+		}
+		baseline.mutex.Lock()
+		loaded := baseline.loaded
+		baseline.mutex.Unlock()
+		if loaded {
+			return true
+		}
+		if err := ad.loadProfile(ctx, baseline); err != nil {
+			container := baseline.container
+			k8sContainerID := utils.CreateK8sContainerID(container.Namespace, container.Podname, container.Name)
+			if storageclient.IsNotFound(err) {
+				logger.L().Debug("application profile not stored yet, the container is not checked for anomalies", helpers.String("container ID", container.ID), helpers.String("k8s workload", k8sContainerID))
+			} else {
+				logger.L().Ctx(ctx).Warning("failed to read the application profile, the container is not checked for anomalies", helpers.String("container ID", container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Error(err))
+			}
+		}
+		return true
+	})
+}
+
+func (ad *AnomalyDetector) loadProfile(ctx context.Context, baseline *containerBaseline) error {
+	instanceID, err := ad.getInstanceID(ctx, baseline.container)
+	if err != nil {
+		return err
+	}
+	slug, err := instanceID.GetSlug()
+	if err != nil {
+		return fmt.Errorf("failed to get the instance ID slug: %w", err)
+	}
+	profile, err := ad.storageClient.GetApplicationProfile(ctx, applicationprofilemanager.ProfileNamePrefix+slug)
+	if err != nil {
+		return err
+	}
+	execs := make(map[string]bool)
+	for _, exec := range profile.Spec.Execs {
+		execs[exec.Path] = true
+	}
+	writes := make(map[string]bool)
+	for _, open := range profile.Spec.Opens {
+		if utils.IsWriteOpen(open.Flags) {
+			writes[open.Path] = true
+		}
+	}
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+	baseline.execs, baseline.writes, baseline.loaded = execs, writes, true
+	return nil
+}
+
+// newAnomaly tells whether an anomaly has to be reported, it is when unexpected returns true and it was not
+// reported for the container yet
+func (baseline *containerBaseline) newAnomaly(ruleName, path string, unexpected func() bool) bool {
+	baseline.mutex.Lock()
+	defer baseline.mutex.Unlock()
+	key := ruleName + "/" + path
+	if baseline.alerted[key] || !unexpected() {
+		return false
+	}
+	baseline.alerted[key] = true
+	return true
+}
+
+func (ad *AnomalyDetector) sendAlert(alert exporters.Alert, container *containercollection.Container) {
+	alert.Time = time.Now()
+	alert.Namespace = container.Namespace
+	alert.Pod = container.Podname
+	alert.Container = container.Name
+	alert.ContainerID = container.ID
+	if ad.processTree != nil {
+		alert.ProcessChain = ad.processTree.GetProcessChain(container, alert.Pid)
+	}
+	ad.exporter.SendAlert(alert)
+}
+
+func (ad *AnomalyDetector) podInstanceID(_ context.Context, container *containercollection.Container) (instanceidhandler.IInstanceID, error) {
+	return utils.GetContainerInstanceID(ad.metadataProvider, container.Namespace, container.Podname, container.Name)
+}
@@ -0,0 +1,148 @@
+package anomalydetector
+
+import (
+	"context"
+	"node-agent/pkg/applicationprofilemanager"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/testutils"
+	"testing"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const instanceIDMock = "apiVersion-v1/namespace-default/kind-deployment/name-nginx/containerName-nginx"
+
+func createAnomalyDetectorMock(t *testing.T) (*AnomalyDetector, *testutils.ApplicationProfileStorageMock, *testutils.ExporterMock) {
+	storageClient := testutils.CreateApplicationProfileStorageMock()
+	exporter := &testutils.ExporterMock{}
+	ad, err := CreateAnomalyDetector(config.Config{MaxSniffingTime: time.Hour, UpdateDataPeriod: time.Minute}, nil, storageClient, exporter, nil)
+	if err != nil {
+		t.Fatalf("fail to create anomaly detector, err: %v", err)
+	}
+	ad.getInstanceID = func(_ context.Context, _ *containercollection.Container) (instanceidhandler.IInstanceID, error) {
+		return instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	}
+	return ad, storageClient, exporter
+}
+
+func storeProfileMock(t *testing.T, storageClient *testutils.ApplicationProfileStorageMock) {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	slug, _ := instanceID.GetSlug()
+	profile := storageclient.ApplicationProfile{Spec: storageclient.ApplicationProfileSpec{
+		ContainerName: "nginx",
+		Execs:         []storageclient.ExecCalls{{Path: "/usr/sbin/nginx", Args: []string{"-g", "daemon off;"}}, {Path: "/bin/sh"}},
+		Opens: []storageclient.OpenCalls{
+			{Path: "/etc/nginx/nginx.conf", Flags: []string{"O_RDONLY"}},
+			{Path: "/etc/nginx/conf.d/default.conf", Flags: []string{"O_WRONLY", "O_CREAT"}},
+		},
+	}}
+	profile.SetName(applicationprofilemanager.ProfileNamePrefix + slug)
+	storageClient.StoreApplicationProfile(profile)
+}
+
+// learnedContainer starts a container whose sniffing time is over
+func learnedContainer(ad *AnomalyDetector) *containercollection.Container {
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+	ad.ReportContainerStarted(context.TODO(), container)
+	data, _ := ad.watchedContainers.Load(container.ID)
+	data.(*containerBaseline).startedAt = time.Now().Add(-2 * time.Hour)
+	return container
+}
+
+func TestAnomalyDetectorExec(t *testing.T) {
+	ad, storageClient, exporter := createAnomalyDetectorMock(t)
+	storeProfileMock(t, storageClient)
+	container := learnedContainer(ad)
+	ctx := context.TODO()
+	ad.loadProfiles(ctx)
+
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("nginx", "/usr/sbin/nginx", "-s", "reload"))
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", "/bin/sh", "-c", "id"))
+	assert.Empty(t, exporter.Alerts())
+
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("curl", "/usr/bin/curl", "http://example.com"))
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("bash", "/bin/bash"))
+	// anomalies are reported once per container
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("curl", "/usr/bin/curl", "http://example.org"))
+	if alerts := exporter.Alerts(); assert.Len(t, alerts, 2) {
+		assert.Equal(t, ruleUnexpectedExec, alerts[0].RuleName)
+		assert.Equal(t, exporters.SeverityMedium, alerts[0].Severity)
+		assert.Equal(t, "/usr/bin/curl", alerts[0].Path)
+		assert.Equal(t, "nginx-1", alerts[0].Pod)
+		assert.Equal(t, "abc", alerts[0].ContainerID)
+		assert.Equal(t, uint32(42), alerts[0].Pid)
+		assert.Equal(t, ruleUnexpectedShell, alerts[1].RuleName)
+		assert.Equal(t, exporters.SeverityHigh, alerts[1].Severity)
+	}
+	// the profile is read once
+	ad.loadProfiles(ctx)
+	assert.Equal(t, 1, storageClient.Gets)
+}
+
+func TestAnomalyDetectorEtcWrite(t *testing.T) {
+	ad, storageClient, exporter := createAnomalyDetectorMock(t)
+	storeProfileMock(t, storageClient)
+	container := learnedContainer(ad)
+	ctx := context.TODO()
+	ad.loadProfiles(ctx)
+
+	ad.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/passwd", "O_RDONLY"))
+	ad.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/conf.d/default.conf", "O_WRONLY", "O_TRUNC"))
+	ad.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/var/log/nginx/access.log", "O_WRONLY", "O_APPEND"))
+	assert.Empty(t, exporter.Alerts())
+
+	// a file the container only read is written
+	ad.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/nginx.conf", "O_RDWR"))
+	ad.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/passwd", "O_WRONLY", "O_APPEND"))
+	if alerts := exporter.Alerts(); assert.Len(t, alerts, 2) {
+		assert.Equal(t, ruleUnexpectedEtcWrite, alerts[0].RuleName)
+		assert.Equal(t, "/etc/nginx/nginx.conf", alerts[0].Path)
+		assert.Equal(t, "/etc/passwd", alerts[1].Path)
+	}
+}
+
+func TestAnomalyDetectorLearning(t *testing.T) {
+	ad, storageClient, exporter := createAnomalyDetectorMock(t)
+	ctx := context.TODO()
+
+	// the events are not checked during the sniffing time
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+	ad.ReportContainerStarted(ctx, container)
+	ad.loadProfiles(ctx)
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("curl", "/usr/bin/curl"))
+	assert.Equal(t, 0, storageClient.Gets)
+
+	// nor while the profile is not stored, the events do not read it
+	container = learnedContainer(ad)
+	ad.loadProfiles(ctx)
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("curl", "/usr/bin/curl"))
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("curl", "/usr/bin/curl"))
+	assert.Equal(t, 1, storageClient.Gets)
+	assert.Empty(t, exporter.Alerts())
+
+	// it is read again on the next update period
+	storeProfileMock(t, storageClient)
+	ad.loadProfiles(ctx)
+	assert.Equal(t, 2, storageClient.Gets)
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("curl", "/usr/bin/curl"))
+	assert.Len(t, exporter.Alerts(), 1)
+
+	// terminated containers are not checked
+	ad.ReportContainerTerminated(ctx, container)
+	ad.ReportExecEvent(ctx, container, testutils.ExecEvent("wget", "/usr/bin/wget"))
+	assert.Len(t, exporter.Alerts(), 1)
+}
+
+func TestCreateAnomalyDetector(t *testing.T) {
+	_, err := CreateAnomalyDetector(config.Config{}, nil, testutils.CreateApplicationProfileStorageMock(), &testutils.ExporterMock{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,21 @@
+package applicationprofilemanager
+
+import (
+	"context"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+)
+
+// ProfileNamePrefix is prepended to the instance ID slug of a container to name its application profile, it keeps
+// application profiles apart from the other objects named after the instance ID
+const ProfileNamePrefix = "application-"
+
+type ApplicationProfileManagerClient interface {
+	ReportContainerStarted(ctx context.Context, container *containercollection.Container)
+	ReportContainerTerminated(ctx context.Context, container *containercollection.Container)
+	ReportExecEvent(ctx context.Context, container *containercollection.Container, event *tracerexectype.Event)
+	ReportOpenEvent(ctx context.Context, container *containercollection.Container, event *traceropentype.Event)
+	StartApplicationProfileManager(ctx context.Context)
+}
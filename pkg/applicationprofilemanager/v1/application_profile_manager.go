@@ -0,0 +1,333 @@
+package applicationprofilemanager
+
+import (
+	"context"
+	"fmt"
+	"node-agent/pkg/applicationprofilemanager"
+	"node-agent/pkg/config"
+	"node-agent/pkg/metadataprovider"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// argsSeparator joins the exec arguments into a map key, it cannot be part of an argument
+	argsSeparator = "\x00"
+)
+
+// The profiles are stored in config maps by the aggregated API client, these limits keep the profile of a busy
+// container well under 1 MiB. The calls above the limits are dropped, the anomaly detector may then report them.
+const (
+	// maxExecs is the number of distinct calls of a container
+	maxExecs = 1000
+	// maxExecArgs and maxExecArgLength bound the arguments kept for a call, the longer ones are truncated
+	maxExecArgs      = 16
+	maxExecArgLength = 256
+	// maxExecArgsPerPath is the number of distinct argument lists of an executable, the others are dropped
+	maxExecArgsPerPath = 10
+	// maxOpens is the number of distinct paths opened by a container
+	maxOpens = 5000
+)
+
+// ApplicationProfileManager aggregates the exec and open events of the containers into application profiles and
+// stores them, the events are collected during the sniffing time of each container
+type ApplicationProfileManager struct {
+	cfg              config.Config
+	metadataProvider metadataprovider.MetadataProviderClient
+	storageClient    storageclient.StorageClient
+	// watchedContainers maps a container ID to its *containerProfileData
+	watchedContainers sync.Map
+	// getInstanceID is replaced in tests
+	getInstanceID func(ctx context.Context, container *containercollection.Container) (instanceidhandler.IInstanceID, error)
+}
+
+type containerProfileData struct {
+	mutex     sync.Mutex
+	container *containercollection.Container
+	startedAt time.Time
+	// execs maps the path and the arguments joined by argsSeparator to the call
+	execs map[string]storageclient.ExecCalls
+	// execsPerPath counts the argument lists of each executable
+	execsPerPath map[string]int
+	// opens maps a path to the set of flags it was opened with
+	opens map[string]map[string]bool
+	// limited is set once a call was dropped because of the limits
+	limited bool
+	// dirty is set when calls were added since the profile was last stored
+	dirty bool
+	// profile is the stored profile, nil until the first successful store
+	profile *storageclient.ApplicationProfile
+}
+
+var _ applicationprofilemanager.ApplicationProfileManagerClient = (*ApplicationProfileManager)(nil)
+
+func CreateApplicationProfileManager(cfg config.Config, metadataProvider metadataprovider.MetadataProviderClient, storageClient storageclient.StorageClient) (*ApplicationProfileManager, error) {
+	am := &ApplicationProfileManager{
+		cfg:              cfg,
+		metadataProvider: metadataProvider,
+		storageClient:    storageClient,
+	}
+	am.getInstanceID = am.podInstanceID
+	return am, nil
+}
+
+func (am *ApplicationProfileManager) ReportContainerStarted(_ context.Context, container *containercollection.Container) {
+	am.watchedContainers.LoadOrStore(container.ID, &containerProfileData{
+		container:    container,
+		startedAt:    time.Now(),
+		execs:        make(map[string]storageclient.ExecCalls),
+		execsPerPath: make(map[string]int),
+		opens:        make(map[string]map[string]bool),
+	})
+}
+
+func (am *ApplicationProfileManager) ReportContainerTerminated(ctx context.Context, container *containercollection.Container) {
+	data, ok := am.watchedContainers.LoadAndDelete(container.ID)
+	if !ok {
+		return
+	}
+	am.storeProfile(ctx, data.(*containerProfileData))
+}
+
+func (am *ApplicationProfileManager) ReportExecEvent(_ context.Context, container *containercollection.Container, event *tracerexectype.Event) {
+	containerData := am.sniffedContainer(container)
+	if containerData == nil {
+		return
+	}
+	exec := storageclient.ExecCalls{Path: event.Comm}
+	if len(event.Args) > 0 {
+		exec.Path = event.Args[0]
+		exec.Args = truncateArgs(event.Args[1:])
+	}
+
+	containerData.mutex.Lock()
+	defer containerData.mutex.Unlock()
+	containerData.addExec(exec)
+}
+
+func (am *ApplicationProfileManager) ReportOpenEvent(_ context.Context, container *containercollection.Container, event *traceropentype.Event) {
+	containerData := am.sniffedContainer(container)
+	if containerData == nil {
+		return
+	}
+	path := event.FullPath
+	if path == "" {
+		path = event.Path
+	}
+
+	containerData.mutex.Lock()
+	defer containerData.mutex.Unlock()
+	containerData.addOpen(procPIDPattern.ReplaceAllString(path, "/proc/*$1"), event.Flags)
+}
+
+// procPIDPattern matches the process directories of /proc, they differ between the runs of a container
+var procPIDPattern = regexp.MustCompile(`^/proc/[0-9]+(/|$)`)
+
+// addExec adds a call within the limits, the caller holds the mutex
+func (data *containerProfileData) addExec(exec storageclient.ExecCalls) {
+	key := strings.Join(append([]string{exec.Path}, exec.Args...), argsSeparator)
+	if _, ok := data.execs[key]; ok {
+		return
+	}
+	if len(data.execs) >= maxExecs || data.execsPerPath[exec.Path] >= maxExecArgsPerPath {
+		data.setLimited()
+		return
+	}
+	data.execs[key] = exec
+	data.execsPerPath[exec.Path]++
+	data.dirty = true
+}
+
+// addOpen adds an open within the limits, the caller holds the mutex
+func (data *containerProfileData) addOpen(path string, flags []string) {
+	if _, ok := data.opens[path]; !ok {
+		if len(data.opens) >= maxOpens {
+			data.setLimited()
+			return
+		}
+		data.opens[path] = make(map[string]bool)
+		data.dirty = true
+	}
+	for _, flag := range flags {
+		if !data.opens[path][flag] {
+			data.opens[path][flag] = true
+			data.dirty = true
+		}
+	}
+}
+
+func (data *containerProfileData) setLimited() {
+	if data.limited {
+		return
+	}
+	data.limited = true
+	logger.L().Warning("application profile limits reached, the calls above them are dropped", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", utils.CreateK8sContainerID(data.container.Namespace, data.container.Podname, data.container.Name)))
+}
+
+// truncateArgs returns the arguments kept in a profile
+func truncateArgs(args []string) []string {
+	if len(args) > maxExecArgs {
+		args = args[:maxExecArgs]
+	}
+	truncated := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) > maxExecArgLength {
+			arg = arg[:maxExecArgLength]
+		}
+		truncated = append(truncated, arg)
+	}
+	return truncated
+}
+
+func (am *ApplicationProfileManager) StartApplicationProfileManager(ctx context.Context) {
+	ctx, span := otel.Tracer("").Start(ctx, "ApplicationProfileManager.StartApplicationProfileManager")
+	defer span.End()
+	go func() {
+		ticker := time.NewTicker(am.cfg.UpdateDataPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				am.watchedContainers.Range(func(containerID, data any) bool {
+					containerData := data.(*containerProfileData)
+					am.storeProfile(ctx, containerData)
+					if am.sniffingTimeOver(containerData) {
+						// the profile is complete, the container is no longer watched
+						am.watchedContainers.Delete(containerID)
+					}
+					return true
+				})
+			}
+		}
+	}()
+}
+
+// sniffedContainer returns the data of a container that is still in its sniffing time, nil otherwise
+func (am *ApplicationProfileManager) sniffedContainer(container *containercollection.Container) *containerProfileData {
+	data, ok := am.watchedContainers.Load(container.ID)
+	if !ok {
+		return nil
+	}
+	containerData := data.(*containerProfileData)
+	if am.sniffingTimeOver(containerData) {
+		return nil
+	}
+	return containerData
+}
+
+func (am *ApplicationProfileManager) sniffingTimeOver(data *containerProfileData) bool {
+	return am.cfg.MaxSniffingTime > 0 && time.Since(data.startedAt) > am.cfg.MaxSniffingTime
+}
+
+// storeProfile creates or updates the application profile of a container when it has new calls
+func (am *ApplicationProfileManager) storeProfile(ctx context.Context, data *containerProfileData) {
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if !data.dirty {
+		return
+	}
+	k8sContainerID := utils.CreateK8sContainerID(data.container.Namespace, data.container.Podname, data.container.Name)
+
+	var err error
+	if data.profile == nil {
+		data.profile, err = am.createProfile(ctx, data)
+	} else {
+		data.profile.Spec.Execs = sortedExecs(data.execs)
+		data.profile.Spec.Opens = sortedOpens(data.opens)
+		err = am.storageClient.UpdateApplicationProfile(ctx, data.profile.Name, data.profile)
+	}
+	if err != nil {
+		logger.L().Ctx(ctx).Warning("failed to store application profile", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Error(err))
+		return
+	}
+	data.dirty = false
+	logger.L().Debug("application profile has been stored", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Int("execs", len(data.execs)), helpers.Int("opens", len(data.opens)))
+}
+
+// createProfile stores the first profile of a container, a profile left by a previous run of the agent is extended
+func (am *ApplicationProfileManager) createProfile(ctx context.Context, data *containerProfileData) (*storageclient.ApplicationProfile, error) {
+	instanceID, err := am.getInstanceID(ctx, data.container)
+	if err != nil {
+		return nil, err
+	}
+	slug, err := instanceID.GetSlug()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the instance ID slug: %w", err)
+	}
+	profile := &storageclient.ApplicationProfile{
+		Spec: storageclient.ApplicationProfileSpec{
+			ContainerName: data.container.Name,
+		},
+	}
+	profile.SetName(applicationprofilemanager.ProfileNamePrefix + slug)
+	profile.SetLabels(utils.InstanceIDLabels(instanceID))
+	profile.SetAnnotations(utils.InstanceIDAnnotations(instanceID))
+	profile.Spec.Execs = sortedExecs(data.execs)
+	profile.Spec.Opens = sortedOpens(data.opens)
+
+	err = am.storageClient.CreateApplicationProfile(ctx, profile)
+	if err == nil || !storageclient.IsAlreadyExist(err) {
+		return profile, err
+	}
+	existing, err := am.storageClient.GetApplicationProfile(ctx, profile.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, exec := range existing.Spec.Execs {
+		data.addExec(exec)
+	}
+	for _, open := range existing.Spec.Opens {
+		data.addOpen(open.Path, open.Flags)
+	}
+	profile.Spec.Execs = sortedExecs(data.execs)
+	profile.Spec.Opens = sortedOpens(data.opens)
+	return profile, am.storageClient.UpdateApplicationProfile(ctx, profile.Name, profile)
+}
+
+func (am *ApplicationProfileManager) podInstanceID(_ context.Context, container *containercollection.Container) (instanceidhandler.IInstanceID, error) {
+	return utils.GetContainerInstanceID(am.metadataProvider, container.Namespace, container.Podname, container.Name)
+}
+
+func sortedExecs(execs map[string]storageclient.ExecCalls) []storageclient.ExecCalls {
+	keys := make([]string, 0, len(execs))
+	for key := range execs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]storageclient.ExecCalls, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, execs[key])
+	}
+	return list
+}
+
+func sortedOpens(opens map[string]map[string]bool) []storageclient.OpenCalls {
+	list := make([]storageclient.OpenCalls, 0, len(opens))
+	for path, flags := range opens {
+		open := storageclient.OpenCalls{Path: path}
+		for flag := range flags {
+			open.Flags = append(open.Flags, flag)
+		}
+		sort.Strings(open.Flags)
+		list = append(list, open)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
@@ -0,0 +1,167 @@
+package applicationprofilemanager
+
+import (
+	"context"
+	"node-agent/pkg/applicationprofilemanager"
+	"node-agent/pkg/config"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/testutils"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const instanceIDMock = "apiVersion-v1/namespace-default/kind-deployment/name-nginx/containerName-nginx"
+
+func createApplicationProfileManagerMock(t *testing.T, cfg config.Config) (*ApplicationProfileManager, *testutils.ApplicationProfileStorageMock) {
+	storageClient := testutils.CreateApplicationProfileStorageMock()
+	am, err := CreateApplicationProfileManager(cfg, nil, storageClient)
+	if err != nil {
+		t.Fatalf("fail to create application profile manager, err: %v", err)
+	}
+	am.getInstanceID = func(_ context.Context, _ *containercollection.Container) (instanceidhandler.IInstanceID, error) {
+		return instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	}
+	return am, storageClient
+}
+
+func profileName(t *testing.T) string {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	slug, _ := instanceID.GetSlug()
+	return applicationprofilemanager.ProfileNamePrefix + slug
+}
+
+func TestApplicationProfileManagerStoreProfile(t *testing.T) {
+	am, storageClient := createApplicationProfileManagerMock(t, config.Config{})
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+	ctx := context.TODO()
+
+	// events of unknown containers are ignored
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", "/bin/sh", "-c", "nginx"))
+	am.ReportContainerStarted(ctx, container)
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", "/bin/sh", "-c", "nginx"))
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", "/bin/sh", "-c", "nginx"))
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("nginx"))
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/nginx.conf", "O_RDONLY"))
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/nginx.conf", "O_RDONLY", "O_CLOEXEC"))
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/var/log/nginx/access.log", "O_WRONLY", "O_APPEND"))
+
+	data, _ := am.watchedContainers.Load(container.ID)
+	am.storeProfile(ctx, data.(*containerProfileData))
+	profile, err := storageClient.GetApplicationProfile(ctx, profileName(t))
+	if err != nil {
+		t.Fatalf("fail to get application profile, err: %v", err)
+	}
+	assert.Equal(t, "nginx", profile.Spec.ContainerName)
+	assert.Equal(t, []storageclient.ExecCalls{
+		{Path: "/bin/sh", Args: []string{"-c", "nginx"}},
+		{Path: "nginx"},
+	}, profile.Spec.Execs)
+	assert.Equal(t, []storageclient.OpenCalls{
+		{Path: "/etc/nginx/nginx.conf", Flags: []string{"O_CLOEXEC", "O_RDONLY"}},
+		{Path: "/var/log/nginx/access.log", Flags: []string{"O_APPEND", "O_WRONLY"}},
+	}, profile.Spec.Opens)
+	assert.Equal(t, "nginx", profile.Labels[instanceidhandlerV1.NameMetadataKey])
+	assert.Equal(t, instanceIDMock, profile.Annotations[instanceidhandlerV1.InstanceIDMetadataKey])
+
+	// nothing new, nothing stored
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/nginx.conf", "O_RDONLY"))
+	am.storeProfile(ctx, data.(*containerProfileData))
+	assert.Equal(t, 1, storageClient.Creates)
+	assert.Equal(t, 0, storageClient.Updates)
+
+	// the last calls are stored when the container terminates
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("nginx", "nginx", "-s", "reload"))
+	am.ReportContainerTerminated(ctx, container)
+	assert.Equal(t, 1, storageClient.Updates)
+	profile, _ = storageClient.GetApplicationProfile(ctx, profileName(t))
+	assert.Len(t, profile.Spec.Execs, 3)
+	_, ok := am.watchedContainers.Load(container.ID)
+	assert.False(t, ok)
+}
+
+func TestApplicationProfileManagerSniffingTime(t *testing.T) {
+	am, storageClient := createApplicationProfileManagerMock(t, config.Config{MaxSniffingTime: time.Minute})
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+	ctx := context.TODO()
+
+	am.ReportContainerStarted(ctx, container)
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("nginx"))
+	data, _ := am.watchedContainers.Load(container.ID)
+	data.(*containerProfileData).startedAt = time.Now().Add(-2 * time.Minute)
+
+	// events after the sniffing time are not part of the profile
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", "/bin/sh"))
+	am.ReportContainerTerminated(ctx, container)
+	profile, _ := storageClient.GetApplicationProfile(ctx, profileName(t))
+	assert.Equal(t, []storageclient.ExecCalls{{Path: "nginx"}}, profile.Spec.Execs)
+}
+
+func TestApplicationProfileManagerExistingProfile(t *testing.T) {
+	am, storageClient := createApplicationProfileManagerMock(t, config.Config{})
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+	ctx := context.TODO()
+
+	// a profile stored by a previous run of the agent is extended
+	existing := storageclient.ApplicationProfile{Spec: storageclient.ApplicationProfileSpec{
+		ContainerName: "nginx",
+		Execs:         []storageclient.ExecCalls{{Path: "/usr/sbin/nginx"}},
+		Opens:         []storageclient.OpenCalls{{Path: "/etc/passwd", Flags: []string{"O_RDONLY"}}},
+	}}
+	existing.SetName(profileName(t))
+	storageClient.StoreApplicationProfile(existing)
+
+	am.ReportContainerStarted(ctx, container)
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("nginx", "/usr/sbin/nginx", "-g", "daemon off;"))
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/passwd", "O_RDONLY", "O_CLOEXEC"))
+	am.ReportContainerTerminated(ctx, container)
+
+	profile, _ := storageClient.GetApplicationProfile(ctx, existing.Name)
+	assert.Len(t, profile.Spec.Execs, 2)
+	assert.Equal(t, []storageclient.OpenCalls{{Path: "/etc/passwd", Flags: []string{"O_CLOEXEC", "O_RDONLY"}}}, profile.Spec.Opens)
+	assert.Equal(t, 1, storageClient.Updates)
+}
+
+func TestApplicationProfileManagerLimits(t *testing.T) {
+	am, storageClient := createApplicationProfileManagerMock(t, config.Config{})
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+	ctx := context.TODO()
+	am.ReportContainerStarted(ctx, container)
+
+	// the arguments of a call are truncated
+	args := []string{"/bin/sh", "-c", strings.Repeat("a", 2*maxExecArgLength)}
+	for i := 0; i < 2*maxExecArgs; i++ {
+		args = append(args, "x")
+	}
+	am.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", args...))
+	// the argument lists of an executable are bounded
+	for i := 0; i < 2*maxExecArgsPerPath; i++ {
+		am.ReportExecEvent(ctx, container, testutils.ExecEvent("sleep", "/bin/sleep", strconv.Itoa(i)))
+	}
+	// the process directories of /proc are opened as one path
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/proc/42/status", "O_RDONLY"))
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/proc/43/status", "O_RDONLY"))
+	am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/proc/self/status", "O_RDONLY"))
+	for i := 0; i < maxOpens; i++ {
+		am.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/tmp/"+strconv.Itoa(i), "O_RDONLY"))
+	}
+	am.ReportContainerTerminated(ctx, container)
+
+	profile, _ := storageClient.GetApplicationProfile(ctx, profileName(t))
+	if assert.Len(t, profile.Spec.Execs, 1+maxExecArgsPerPath) {
+		assert.Len(t, profile.Spec.Execs[0].Args, maxExecArgs)
+		assert.Len(t, profile.Spec.Execs[0].Args[1], maxExecArgLength)
+	}
+	assert.Len(t, profile.Spec.Opens, maxOpens)
+	assert.Equal(t, storageclient.OpenCalls{Path: "/proc/*/status", Flags: []string{"O_RDONLY"}}, profile.Spec.Opens[0])
+	assert.Equal(t, "/proc/self/status", profile.Spec.Opens[1].Path)
+}
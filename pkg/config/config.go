@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/spf13/viper"
@@ -8,6 +10,41 @@ import (
 
 const NodeNameEnvVar = "NODE_NAME"
 
+const (
+	StorageTypeAggregatedAPI = "aggregatedAPI"
+	StorageTypeHTTP          = "http"
+)
+
+// SBOMFormatCycloneDX writes the filtered SBOMs as CycloneDX 1.5 BOMs converted from the filtered SPDX documents.
+// The kubescape storage has no CycloneDX kind, the aggregated API client keeps them in config maps of the kubescape
+// namespace (see storageclient) so they are limited to 1 MiB.
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+const (
+	SBOMInputFormatSPDX = "spdx"
+	SBOMInputFormatSyft = "syft"
+)
+
+const (
+	// RelevancyMatchingExact keeps a package when one of the files the SBOM lists for it is accessed
+	RelevancyMatchingExact = "exact"
+	// RelevancyMatchingInstallPath also keeps a language package when any file under its install directory is accessed
+	RelevancyMatchingInstallPath = "installPath"
+)
+
+const (
+	MetadataProviderAPIServer = "apiServer"
+	MetadataProviderKubelet   = "kubelet"
+)
+
+const (
+	GarbageCollectionModeDelete = "delete"
+	GarbageCollectionModeMark   = "mark"
+)
+
 type ClusterData struct {
 	AccountID   string `mapstructure:"accountID"`
 	ClusterName string `mapstructure:"clusterName"`
@@ -31,10 +68,147 @@ func LoadClusterData(path string) (ClusterData, error) {
 	return config, err
 }
 
+// HTTPStorageConfig holds the settings of a REST SBOM storage backend.
+type HTTPStorageConfig struct {
+	URL                string            `mapstructure:"url"`
+	Headers            map[string]string `mapstructure:"headers"`
+	BearerTokenFile    string            `mapstructure:"bearerTokenFile"`
+	CAFile             string            `mapstructure:"caFile"`
+	CertFile           string            `mapstructure:"certFile"`
+	KeyFile            string            `mapstructure:"keyFile"`
+	InsecureSkipVerify bool              `mapstructure:"insecureSkipVerify"`
+	Timeout            time.Duration     `mapstructure:"timeout"`
+}
+
+// StorageConfig selects the backend used to read image SBOMs and write filtered SBOMs.
+type StorageConfig struct {
+	Type string            `mapstructure:"type"`
+	HTTP HTTPStorageConfig `mapstructure:"http"`
+}
+
+// SBOMInputConfig selects the format of the image SBOMs and where they are read from.
+// Syft documents are filtered into SPDX filtered SBOMs regardless of sbomFormat.
+type SBOMInputConfig struct {
+	Format string `mapstructure:"format"`
+	// Directory holds Syft JSON documents named <image slug>.json. When it is empty the documents are fetched from the
+	// http storage (GET <url>/sboms-syft/<image slug>), the kubescape storage does not serve Syft documents.
+	Directory string `mapstructure:"directory"`
+}
+
+// KubeletConfig holds the settings of the client of the kubelet pods endpoint, the kubelet serving certificate is
+// often self-signed so CAFile or InsecureSkipVerify are usually needed.
+type KubeletConfig struct {
+	URL                string        `mapstructure:"url"`
+	TokenFile          string        `mapstructure:"tokenFile"`
+	CAFile             string        `mapstructure:"caFile"`
+	InsecureSkipVerify bool          `mapstructure:"insecureSkipVerify"`
+	PollInterval       time.Duration `mapstructure:"pollInterval"`
+}
+
+// MetadataProviderConfig selects where the pods of the node are read from, an informer on the API server or the
+// local kubelet. The owners of the pods are read from the API server in both cases.
+type MetadataProviderConfig struct {
+	Type    string        `mapstructure:"type"`
+	Kubelet KubeletConfig `mapstructure:"kubelet"`
+}
+
+// GarbageCollectionConfig controls the cleanup of the filtered SBOMs, profiles and drift reports whose workloads no longer exist.
+type GarbageCollectionConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	Mode     string        `mapstructure:"mode"`
+}
+
+// AnomalyDetectionConfig controls the comparison of the container activity with the learned application profiles.
+// It needs the application profiles, the detection of a container starts once its sniffing time is over.
+type AnomalyDetectionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// RuleEngineConfig controls the evaluation of the runtime detection rules over the container activity.
+// RulesFile is a YAML or JSON rule set replacing the built-in rules.
+type RuleEngineConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	RulesFile string `mapstructure:"rulesFile"`
+}
+
+// DriftDetectionConfig controls the comparison of the files executed and written in the containers with the files
+// of their image SBOM, read like the relevancy one (see SBOMInputConfig). The drifted files of a container are stored
+// as a drift report.
+type DriftDetectionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// HTTPExporterConfig holds the settings of the exporter posting the alerts to a URL, it is enabled when URL is set.
+type HTTPExporterConfig struct {
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout time.Duration     `mapstructure:"timeout"`
+}
+
+// SyslogExporterConfig holds the settings of the syslog exporter, the local syslog is used when Address is empty.
+type SyslogExporterConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Network string `mapstructure:"network"`
+	Address string `mapstructure:"address"`
+	Tag     string `mapstructure:"tag"`
+}
+
+// ExportersConfig selects where the alerts of the anomaly detector and the rule engine are sent, each enabled exporter
+// gets all the alerts. The alerts beyond RateLimit per second (in bursts of RateBurst) are dropped, 0 disables the
+// limit. Each exporter sends the alerts in batches of BatchSize at least every FlushInterval and retries a failed
+// batch MaxRetries times, waiting RetryInterval and then twice as long before each new attempt.
+type ExportersConfig struct {
+	Stdout        bool                 `mapstructure:"stdout"`
+	File          string               `mapstructure:"file"`
+	HTTP          HTTPExporterConfig   `mapstructure:"http"`
+	Syslog        SyslogExporterConfig `mapstructure:"syslog"`
+	RateLimit     float64              `mapstructure:"rateLimit"`
+	RateBurst     int                  `mapstructure:"rateBurst"`
+	BatchSize     int                  `mapstructure:"batchSize"`
+	FlushInterval time.Duration        `mapstructure:"flushInterval"`
+	MaxRetries    int                  `mapstructure:"maxRetries"`
+	RetryInterval time.Duration        `mapstructure:"retryInterval"`
+}
+
+// MappedFilesConfig controls the scan of /proc/<pid>/maps of the container processes for the relevancy, it finds the
+// libraries loaded with dlopen and the program interpreters the open tracer misses. It is a periodic scan rather than
+// a tracer: the processes living less than Period are missed and each scan reads the maps of all the processes of the
+// node, a shorter period costs more CPU.
+type MappedFilesConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Period  time.Duration `mapstructure:"period"`
+}
+
+// StandaloneConfig runs the agent on a plain container host without Kubernetes. The containers of the docker and
+// containerd sockets, relative to the host root, are watched and their filtered SBOMs are keyed by image digest and
+// container name. Only the HTTP storage is available and the features needing workloads are not.
+type StandaloneConfig struct {
+	Enabled          bool   `mapstructure:"enabled"`
+	DockerSocket     string `mapstructure:"dockerSocket"`
+	ContainerdSocket string `mapstructure:"containerdSocket"`
+}
+
 type Config struct {
-	EnableRelevancy  bool          `mapstructure:"relevantCVEServiceEnabled"`
-	MaxSniffingTime  time.Duration `mapstructure:"maxSniffingTimePerContainer"`
-	UpdateDataPeriod time.Duration `mapstructure:"updateDataPeriod"`
+	EnableRelevancy            bool                    `mapstructure:"relevantCVEServiceEnabled"`
+	EnableNetwork              bool                    `mapstructure:"networkServiceEnabled"`
+	EnableApplicationProfile   bool                    `mapstructure:"applicationProfileServiceEnabled"`
+	MaxSniffingTime            time.Duration           `mapstructure:"maxSniffingTimePerContainer"`
+	UpdateDataPeriod           time.Duration           `mapstructure:"updateDataPeriod"`
+	ExitedContainerGracePeriod time.Duration           `mapstructure:"exitedContainerGracePeriod"`
+	SBOMFormat                 string                  `mapstructure:"sbomFormat"`
+	SBOMInput                  SBOMInputConfig         `mapstructure:"sbomInput"`
+	RelevancyMatching          string                  `mapstructure:"relevancyMatching"`
+	MappedFiles                MappedFilesConfig       `mapstructure:"mappedFiles"`
+	Storage                    StorageConfig           `mapstructure:"storage"`
+	MetadataProvider           MetadataProviderConfig  `mapstructure:"metadataProvider"`
+	CRISocket                  string                  `mapstructure:"criSocket"`
+	GarbageCollection          GarbageCollectionConfig `mapstructure:"garbageCollection"`
+	AnomalyDetection           AnomalyDetectionConfig  `mapstructure:"anomalyDetection"`
+	RuleEngine                 RuleEngineConfig        `mapstructure:"ruleEngine"`
+	DriftDetection             DriftDetectionConfig    `mapstructure:"driftDetection"`
+	Exporters                  ExportersConfig         `mapstructure:"exporters"`
+	Standalone                 StandaloneConfig        `mapstructure:"standalone"`
 }
 
 // LoadConfig reads configuration from file or environment variables.
@@ -45,6 +219,28 @@ func LoadConfig(path string) (Config, error) {
 
 	viper.AutomaticEnv()
 
+	viper.SetDefault("exitedContainerGracePeriod", 10*time.Minute)
+	viper.SetDefault("sbomFormat", SBOMFormatSPDX)
+	viper.SetDefault("sbomInput.format", SBOMInputFormatSPDX)
+	viper.SetDefault("relevancyMatching", RelevancyMatchingExact)
+	viper.SetDefault("mappedFiles.period", 5*time.Second)
+	viper.SetDefault("storage.type", StorageTypeAggregatedAPI)
+	viper.SetDefault("metadataProvider.type", MetadataProviderAPIServer)
+	viper.SetDefault("metadataProvider.kubelet.url", "https://127.0.0.1:10250")
+	viper.SetDefault("metadataProvider.kubelet.tokenFile", "/var/run/secrets/kubernetes.io/serviceaccount/token")
+	viper.SetDefault("metadataProvider.kubelet.pollInterval", 2*time.Second)
+	viper.SetDefault("garbageCollection.interval", time.Hour)
+	viper.SetDefault("garbageCollection.mode", GarbageCollectionModeDelete)
+	viper.SetDefault("exporters.stdout", true)
+	viper.SetDefault("exporters.rateLimit", 100)
+	viper.SetDefault("exporters.rateBurst", 1000)
+	viper.SetDefault("exporters.batchSize", 100)
+	viper.SetDefault("exporters.flushInterval", time.Second)
+	viper.SetDefault("exporters.maxRetries", 3)
+	viper.SetDefault("exporters.retryInterval", time.Second)
+	viper.SetDefault("standalone.dockerSocket", "/run/docker.sock")
+	viper.SetDefault("standalone.containerdSocket", "/run/containerd/containerd.sock")
+
 	err := viper.ReadInConfig()
 	if err != nil {
 		return Config{}, err
@@ -54,3 +250,24 @@ func LoadConfig(path string) (Config, error) {
 	err = viper.Unmarshal(&config)
 	return config, err
 }
+
+// ValidateStandalone checks that the features enabled in standalone mode do not need Kubernetes
+func ValidateStandalone(cfg Config) error {
+	if !cfg.Standalone.Enabled {
+		return nil
+	}
+	if cfg.Storage.Type != StorageTypeHTTP {
+		return fmt.Errorf("standalone mode needs the %s storage", StorageTypeHTTP)
+	}
+	switch {
+	case cfg.EnableNetwork:
+		return errors.New("standalone mode does not support the network service")
+	case cfg.EnableApplicationProfile:
+		return errors.New("standalone mode does not support the application profiles")
+	case cfg.DriftDetection.Enabled:
+		return errors.New("standalone mode does not support the drift detection")
+	case cfg.GarbageCollection.Enabled:
+		return errors.New("standalone mode does not support the garbage collection")
+	}
+	return nil
+}
@@ -46,9 +46,40 @@ func TestLoadConfig(t *testing.T) {
 			name: "TestLoadConfig",
 			path: "../../configuration",
 			want: Config{
-				EnableRelevancy:  true,
-				MaxSniffingTime:  6 * time.Hour,
-				UpdateDataPeriod: 1 * time.Minute,
+				EnableRelevancy:            true,
+				MaxSniffingTime:            6 * time.Hour,
+				UpdateDataPeriod:           1 * time.Minute,
+				ExitedContainerGracePeriod: 10 * time.Minute,
+				SBOMFormat:                 SBOMFormatSPDX,
+				SBOMInput:                  SBOMInputConfig{Format: SBOMInputFormatSPDX},
+				RelevancyMatching:          RelevancyMatchingExact,
+				MappedFiles:                MappedFilesConfig{Period: 5 * time.Second},
+				Storage:                    StorageConfig{Type: StorageTypeAggregatedAPI},
+				MetadataProvider: MetadataProviderConfig{
+					Type: MetadataProviderAPIServer,
+					Kubelet: KubeletConfig{
+						URL:          "https://127.0.0.1:10250",
+						TokenFile:    "/var/run/secrets/kubernetes.io/serviceaccount/token",
+						PollInterval: 2 * time.Second,
+					},
+				},
+				GarbageCollection: GarbageCollectionConfig{
+					Interval: time.Hour,
+					Mode:     GarbageCollectionModeDelete,
+				},
+				Exporters: ExportersConfig{
+					Stdout:        true,
+					RateLimit:     100,
+					RateBurst:     1000,
+					BatchSize:     100,
+					FlushInterval: time.Second,
+					MaxRetries:    3,
+					RetryInterval: time.Second,
+				},
+				Standalone: StandaloneConfig{
+					DockerSocket:     "/run/docker.sock",
+					ContainerdSocket: "/run/containerd/containerd.sock",
+				},
 			},
 		},
 	}
@@ -63,3 +94,37 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateStandalone(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name: "kubernetes",
+			cfg:  Config{EnableNetwork: true},
+		},
+		{
+			name: "standalone",
+			cfg:  Config{EnableRelevancy: true, Storage: StorageConfig{Type: StorageTypeHTTP}, Standalone: StandaloneConfig{Enabled: true}},
+		},
+		{
+			name:    "aggregated API storage",
+			cfg:     Config{Storage: StorageConfig{Type: StorageTypeAggregatedAPI}, Standalone: StandaloneConfig{Enabled: true}},
+			wantErr: true,
+		},
+		{
+			name:    "application profiles",
+			cfg:     Config{EnableApplicationProfile: true, Storage: StorageConfig{Type: StorageTypeHTTP}, Standalone: StandaloneConfig{Enabled: true}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateStandalone(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateStandalone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
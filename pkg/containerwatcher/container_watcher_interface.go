@@ -9,5 +9,7 @@ import (
 type ContainerWatcher interface {
 	Start(ctx context.Context) error
 	Stop()
+	// UnregisterContainer tells that the relevancy manager is done with a container, the container stays traced
+	// while other consumers need its events
 	UnregisterContainer(ctx context.Context, container *containercollection.Container)
 }
@@ -3,20 +3,34 @@ package containerwatcher
 import (
 	"context"
 	"fmt"
+	"node-agent/pkg/anomalydetector"
+	"node-agent/pkg/applicationprofilemanager"
 	"node-agent/pkg/config"
 	"node-agent/pkg/containerwatcher"
+	"node-agent/pkg/driftdetector"
+	"node-agent/pkg/libraryresolver"
+	"node-agent/pkg/networkmanager"
+	"node-agent/pkg/processtree"
 	"node-agent/pkg/relevancymanager"
+	"node-agent/pkg/ruleengine"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gammazero/workerpool"
 	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	containerutils "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils"
 	tracerexec "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/tracer"
 	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	tracernetwork "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/network/tracer"
+	tracernetworktype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/network/types"
 	traceropen "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/tracer"
 	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
 	tracercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/tracer-collection"
 	"github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+	"github.com/inspektor-gadget/inspektor-gadget/pkg/utils/host"
 	"github.com/kubescape/go-logger"
 	"github.com/kubescape/go-logger/helpers"
 	"github.com/kubescape/k8s-interface/k8sinterface"
@@ -29,18 +43,41 @@ const (
 )
 
 type IGContainerWatcher struct {
-	containerCollection *containercollection.ContainerCollection
-	k8sClient           *k8sinterface.KubernetesApi
-	relevancyManager    relevancymanager.RelevancyManagerClient
-	tracerCollection    *tracercollection.TracerCollection
-	tracerExec          *tracerexec.Tracer
-	tracerOpen          *traceropen.Tracer
-	eventWorkerPool     *workerpool.WorkerPool
+	containerCollection       *containercollection.ContainerCollection
+	k8sClient                 *k8sinterface.KubernetesApi
+	runtimes                  []*containerutils.RuntimeConfig
+	libraryResolver           libraryresolver.LibraryResolver
+	applicationProfileManager applicationprofilemanager.ApplicationProfileManagerClient
+	anomalyDetector           anomalydetector.AnomalyDetectorClient
+	driftDetector             driftdetector.DriftDetectorClient
+	networkManager            networkmanager.NetworkManagerClient
+	processTree               processtree.ProcessTreeClient
+	relevancyManager          relevancymanager.RelevancyManagerClient
+	ruleEngine                ruleengine.RuleEngineClient
+	tracerCollection          *tracercollection.TracerCollection
+	tracerExec                *tracerexec.Tracer
+	tracerOpen                *traceropen.Tracer
+	tracerMappedFiles         *mappedFilesTracer
+	tracerNetwork             *tracernetwork.Tracer
+	eventWorkerPool           *workerpool.WorkerPool
+	// mappedFilesPeriod is how often the memory mappings of the container processes are scanned, 0 disables the scan
+	mappedFilesPeriod time.Duration
+	// relevancyDone holds the IDs of the containers whose sniffing time is over for the relevancy manager, they stay
+	// traced for the consumers working after it but their file accesses are no longer reported
+	relevancyDone sync.Map
+	// removeFromTracers stops tracing a container, it is replaced in tests
+	removeFromTracers func(event containercollection.PubSubEvent)
 }
 
 var _ containerwatcher.ContainerWatcher = (*IGContainerWatcher)(nil)
 
-func CreateIGContainerWatcher(k8sClient *k8sinterface.KubernetesApi, relevancyManager relevancymanager.RelevancyManagerClient) (*IGContainerWatcher, error) {
+// CreateIGContainerWatcher creates the container watcher, the application profiles are built only when
+// applicationProfileManager is not nil, the anomalies are detected only when anomalyDetector is not nil, the drift
+// from the images is detected only when driftDetector is not nil, the network activity is traced only when
+// networkManager is not nil and the detection rules are evaluated only when ruleEngine is not nil. The memory mappings
+// of the container processes are scanned every mappedFilesPeriod, 0 disables the scan. The containers are enriched
+// from the Kubernetes API, or from the container runtimes when k8sClient is nil.
+func CreateIGContainerWatcher(k8sClient *k8sinterface.KubernetesApi, runtimes []*containerutils.RuntimeConfig, libraryResolver libraryresolver.LibraryResolver, applicationProfileManager applicationprofilemanager.ApplicationProfileManagerClient, anomalyDetector anomalydetector.AnomalyDetectorClient, driftDetector driftdetector.DriftDetectorClient, networkManager networkmanager.NetworkManagerClient, processTree processtree.ProcessTreeClient, relevancyManager relevancymanager.RelevancyManagerClient, ruleEngine ruleengine.RuleEngineClient, mappedFilesPeriod time.Duration) (*IGContainerWatcher, error) {
 	// Use container collection to get notified for new containers
 	containerCollection := &containercollection.ContainerCollection{}
 	// Create a tracer collection instance
@@ -49,19 +86,55 @@ func CreateIGContainerWatcher(k8sClient *k8sinterface.KubernetesApi, relevancyMa
 		return nil, fmt.Errorf("failed to create trace-collection: %s\n", err)
 	}
 
-	return &IGContainerWatcher{
-		containerCollection: containerCollection,
-		k8sClient:           k8sClient,
-		tracerCollection:    tracerCollection,
-		relevancyManager:    relevancyManager,
-		eventWorkerPool:     workerpool.New(eventsWorkersConcurrency),
-	}, nil
+	ch := &IGContainerWatcher{
+		containerCollection:       containerCollection,
+		k8sClient:                 k8sClient,
+		runtimes:                  runtimes,
+		libraryResolver:           libraryResolver,
+		applicationProfileManager: applicationProfileManager,
+		anomalyDetector:           anomalyDetector,
+		driftDetector:             driftDetector,
+		networkManager:            networkManager,
+		processTree:               processTree,
+		tracerCollection:          tracerCollection,
+		relevancyManager:          relevancyManager,
+		ruleEngine:                ruleEngine,
+		mappedFilesPeriod:         mappedFilesPeriod,
+		eventWorkerPool:           workerpool.New(eventsWorkersConcurrency),
+	}
+	ch.removeFromTracers = func(event containercollection.PubSubEvent) {
+		tracerCollection.TracerMapsUpdater()(event)
+	}
+	return ch, nil
 }
 
 func (ch *IGContainerWatcher) Start(ctx context.Context) error {
 
 	ch.relevancyManager.SetContainerHandler(ch)
 	ch.relevancyManager.StartRelevancyManager(ctx)
+	ch.processTree.StartProcessTree(ctx)
+
+	if ch.applicationProfileManager != nil {
+		ch.applicationProfileManager.StartApplicationProfileManager(ctx)
+	}
+
+	if ch.driftDetector != nil {
+		ch.driftDetector.StartDriftDetector(ctx)
+	}
+
+	if ch.anomalyDetector != nil {
+		ch.anomalyDetector.StartAnomalyDetector(ctx)
+	}
+
+	if ch.networkManager != nil {
+		// Create the network tracer before the containers are reported, it is attached to each of them
+		var err error
+		ch.tracerNetwork, err = tracernetwork.NewTracer()
+		if err != nil {
+			return fmt.Errorf("error creating tracerNetwork: %s\n", err)
+		}
+		ch.networkManager.StartNetworkManager(ctx)
+	}
 
 	callback := func(notif containercollection.PubSubEvent) {
 		logger.L().Debug("GetEventCallback", helpers.String("namespaceName", notif.Container.Namespace), helpers.String("podName", notif.Container.Podname), helpers.String("containerName", notif.Container.Name), helpers.String("containerID", notif.Container.ID), helpers.String("type", notif.Type.String()))
@@ -70,10 +143,45 @@ func (ch *IGContainerWatcher) Start(ctx context.Context) error {
 			logger.L().Debug("container has started", helpers.String("namespace", notif.Container.Namespace), helpers.String("Pod name", notif.Container.Podname), helpers.String("ContainerID", notif.Container.ID), helpers.String("Container name", notif.Container.Name))
 			// notify the relevancy manager that a new container has started
 			ch.relevancyManager.ReportContainerStarted(ctx, notif.Container)
+			ch.processTree.ReportContainerStarted(ctx, notif.Container)
+			if ch.applicationProfileManager != nil {
+				ch.applicationProfileManager.ReportContainerStarted(ctx, notif.Container)
+			}
+			if ch.anomalyDetector != nil {
+				ch.anomalyDetector.ReportContainerStarted(ctx, notif.Container)
+			}
+			if ch.driftDetector != nil {
+				ch.driftDetector.ReportContainerStarted(ctx, notif.Container)
+			}
+			if ch.tracerNetwork != nil {
+				ch.networkManager.ReportContainerStarted(ctx, notif.Container)
+				if err := ch.tracerNetwork.Attach(notif.Container.Pid); err != nil {
+					logger.L().Warning("failed to attach the network tracer", helpers.String("ContainerID", notif.Container.ID), helpers.Error(err))
+				}
+			}
 		case containercollection.EventTypeRemoveContainer:
 			logger.L().Debug("container has Terminated", helpers.String("namespace", notif.Container.Namespace), helpers.String("Pod name", notif.Container.Podname), helpers.String("ContainerID", notif.Container.ID), helpers.String("Container name", notif.Container.Name))
 			// notify the relevancy manager that a container has terminated
 			ch.relevancyManager.ReportContainerTerminated(ctx, notif.Container)
+			if ch.applicationProfileManager != nil {
+				ch.applicationProfileManager.ReportContainerTerminated(ctx, notif.Container)
+			}
+			if ch.anomalyDetector != nil {
+				ch.anomalyDetector.ReportContainerTerminated(ctx, notif.Container)
+			}
+			if ch.driftDetector != nil {
+				ch.driftDetector.ReportContainerTerminated(ctx, notif.Container)
+			}
+			ch.processTree.ReportContainerTerminated(ctx, notif.Container)
+			ch.libraryResolver.RemoveContainer(notif.Container.ID)
+			ch.relevancyDone.Delete(notif.Container.ID)
+			if ch.tracerMappedFiles != nil {
+				ch.tracerMappedFiles.RemoveContainer(notif.Container)
+			}
+			if ch.tracerNetwork != nil {
+				_ = ch.tracerNetwork.Detach(notif.Container.Pid)
+				ch.networkManager.ReportContainerTerminated(ctx, notif.Container)
+			}
 		}
 	}
 	containerEventFuncs := []containercollection.FuncNotify{callback}
@@ -90,13 +198,16 @@ func (ch *IGContainerWatcher) Start(ctx context.Context) error {
 
 		// Enrich events with Linux namespaces information, it is needed for per container filtering
 		containercollection.WithLinuxNamespaceEnrichment(),
-
+	}
+	if ch.k8sClient != nil {
 		// Enrich those containers with data from the Kubernetes API
-		containercollection.WithKubernetesEnrichment(os.Getenv(config.NodeNameEnvVar), ch.k8sClient.K8SConfig),
-
-		// Get Notifications from the container collection
-		containercollection.WithPubSub(containerEventFuncs...),
+		opts = append(opts, containercollection.WithKubernetesEnrichment(os.Getenv(config.NodeNameEnvVar), ch.k8sClient.K8SConfig))
+	} else {
+		// Enrich the containers of a plain container host with their names from the container runtimes
+		opts = append(opts, containercollection.WithMultipleContainerRuntimesEnrichment(ch.runtimes))
 	}
+	// Get Notifications from the container collection
+	opts = append(opts, containercollection.WithPubSub(containerEventFuncs...))
 
 	// Initialize the container collection
 	if err := ch.containerCollection.Initialize(opts...); err != nil {
@@ -108,20 +219,7 @@ func (ch *IGContainerWatcher) Start(ctx context.Context) error {
 
 	// Define a callback to handle exec events
 	execEventCallback := func(event *tracerexectype.Event) {
-		if event.Type != types.NORMAL {
-			// dropped event
-			logger.L().Ctx(ctx).Warning("container monitoring got drop events - we may miss some realtime data", helpers.Interface("event", event), helpers.String("error", event.Message))
-			return
-		}
-		if event.Retval > -1 {
-			procImageName := event.Comm
-			if len(event.Args) > 0 {
-				procImageName = event.Args[0]
-			}
-			ch.eventWorkerPool.Submit(func() {
-				ch.relevancyManager.ReportFileAccess(ctx, event.Namespace, event.Pod, event.Container, procImageName)
-			})
-		}
+		ch.handleExecEvent(ctx, event)
 	}
 	if err := ch.tracerCollection.AddTracer(execTraceName, containerSelector); err != nil {
 		return fmt.Errorf("error adding exec tracer: %s\n", err)
@@ -129,16 +227,7 @@ func (ch *IGContainerWatcher) Start(ctx context.Context) error {
 
 	// Define a callback to handle open events
 	openEventCallback := func(event *traceropentype.Event) {
-		if event.Type != types.NORMAL {
-			// dropped event
-			logger.L().Ctx(ctx).Warning("container monitoring got drop events - we may miss some realtime data", helpers.Interface("event", event), helpers.String("error", event.Message))
-			return
-		}
-		if event.Ret > -1 {
-			ch.eventWorkerPool.Submit(func() {
-				ch.relevancyManager.ReportFileAccess(ctx, event.Namespace, event.Pod, event.Container, event.FullPath)
-			})
-		}
+		ch.handleOpenEvent(ctx, event)
 	}
 	if err := ch.tracerCollection.AddTracer(openTraceName, containerSelector); err != nil {
 		return fmt.Errorf("error adding open tracer: %s\n", err)
@@ -168,11 +257,192 @@ func (ch *IGContainerWatcher) Start(ctx context.Context) error {
 		return fmt.Errorf("error creating tracerOpen: %s\n", err)
 	}
 
+	if ch.mappedFilesPeriod > 0 {
+		// Define a callback to handle mapped files events
+		mappedFileEventCallback := func(event *mappedFileEvent) {
+			ch.eventWorkerPool.Submit(func() {
+				ch.relevancyManager.ReportFileAccess(ctx, event.Namespace, event.Pod, event.Container, event.Path, relevancymanager.FileAccessMmap, ch.processChain(event.Mntns, event.Pid))
+			})
+		}
+
+		// Create the mapped files tracer, there is no Inspektor Gadget tracer for mmap. Only the containers still
+		// monitored by the relevancy manager are scanned.
+		ch.tracerMappedFiles = newMappedFilesTracer(host.HostProcFs, ch.mappedFilesPeriod, ch.relevancyContainer, mappedFileEventCallback)
+		ch.tracerMappedFiles.Start()
+	}
+
+	if ch.tracerNetwork != nil {
+		// Define a callback to handle network events
+		ch.tracerNetwork.SetEventHandler(func(event *tracernetworktype.Event) {
+			if event.Type != types.NORMAL {
+				// dropped event
+				logger.L().Ctx(ctx).Warning("network monitoring got drop events - we may miss some realtime data", helpers.Interface("event", event), helpers.String("error", event.Message))
+				return
+			}
+			ch.eventWorkerPool.Submit(func() {
+				for _, container := range ch.networkEventContainers(event) {
+					ch.networkManager.ReportNetworkEvent(ctx, container, event)
+				}
+			})
+		})
+	}
+
 	logger.L().Info("main container handler started")
 
 	return nil
 }
 
+func (ch *IGContainerWatcher) handleExecEvent(ctx context.Context, event *tracerexectype.Event) {
+	if event.Type != types.NORMAL {
+		// dropped event
+		logger.L().Ctx(ctx).Warning("container monitoring got drop events - we may miss some realtime data", helpers.Interface("event", event), helpers.String("error", event.Message))
+		return
+	}
+	if event.Retval < 0 {
+		return
+	}
+	procImageName := event.Comm
+	if len(event.Args) > 0 {
+		procImageName = event.Args[0]
+	}
+	ch.eventWorkerPool.Submit(func() {
+		container := ch.containerCollection.LookupContainerByMntns(event.MountNsID)
+		// the process is added to the tree before its chain is needed
+		if container != nil {
+			ch.processTree.ReportExecEvent(ctx, container, event)
+		}
+		if !ch.isRelevancyDone(container) {
+			process := ch.processChain(event.MountNsID, event.Pid)
+			ch.relevancyManager.ReportFileAccess(ctx, event.Namespace, event.Pod, event.Container, procImageName, relevancymanager.FileAccessExec, process)
+			ch.reportLibraries(ctx, event, procImageName, process)
+		}
+		ch.reportExecEvent(ctx, event)
+	})
+}
+
+func (ch *IGContainerWatcher) handleOpenEvent(ctx context.Context, event *traceropentype.Event) {
+	if event.Type != types.NORMAL {
+		// dropped event
+		logger.L().Ctx(ctx).Warning("container monitoring got drop events - we may miss some realtime data", helpers.Interface("event", event), helpers.String("error", event.Message))
+		return
+	}
+	if event.Ret < 0 {
+		return
+	}
+	ch.eventWorkerPool.Submit(func() {
+		if !ch.isRelevancyDone(ch.containerCollection.LookupContainerByMntns(event.MountNsID)) {
+			ch.relevancyManager.ReportFileAccess(ctx, event.Namespace, event.Pod, event.Container, event.FullPath, relevancymanager.FileAccessOpen, ch.processChain(event.MountNsID, event.Pid))
+		}
+		ch.reportOpenEvent(ctx, event)
+	})
+}
+
+// isRelevancyDone tells whether the relevancy manager unregistered a container, the file accesses of the containers
+// that are not found are reported and dropped by the relevancy manager
+func (ch *IGContainerWatcher) isRelevancyDone(container *containercollection.Container) bool {
+	if container == nil {
+		return false
+	}
+	_, done := ch.relevancyDone.Load(container.ID)
+	return done
+}
+
+// relevancyContainer returns the container of a mount namespace when the relevancy manager still monitors it
+func (ch *IGContainerWatcher) relevancyContainer(mntns uint64) *containercollection.Container {
+	container := ch.containerCollection.LookupContainerByMntns(mntns)
+	if container == nil || ch.isRelevancyDone(container) {
+		return nil
+	}
+	return container
+}
+
+// hasEventConsumers tells whether the exec and open events are needed after the sniffing time of the relevancy
+// manager
+func (ch *IGContainerWatcher) hasEventConsumers() bool {
+	return ch.applicationProfileManager != nil || ch.anomalyDetector != nil || ch.driftDetector != nil || ch.ruleEngine != nil
+}
+
+// reportLibraries reports the shared libraries of an executed binary, they are mapped by the dynamic loader
+// and may not show up as open events under the names the SBOM knows them by
+func (ch *IGContainerWatcher) reportLibraries(ctx context.Context, event *tracerexectype.Event, binary string, process processtree.ProcessChain) {
+	container := ch.containerCollection.LookupContainerByMntns(event.MountNsID)
+	if container == nil || container.Pid == 0 {
+		return
+	}
+	rootPath := filepath.Join(host.HostProcFs, strconv.FormatUint(uint64(container.Pid), 10), "root")
+	for _, library := range ch.libraryResolver.ResolveLibraries(ctx, container.ID, rootPath, binary) {
+		ch.relevancyManager.ReportFileAccess(ctx, event.Namespace, event.Pod, event.Container, library, relevancymanager.FileAccessLibrary, process)
+	}
+}
+
+// processChain returns the ancestry of a process of a watched container
+func (ch *IGContainerWatcher) processChain(mntns uint64, pid uint32) processtree.ProcessChain {
+	container := ch.containerCollection.LookupContainerByMntns(mntns)
+	if container == nil {
+		return nil
+	}
+	return ch.processTree.GetProcessChain(container, pid)
+}
+
+// reportExecEvent hands an exec event to the application profile manager, the anomaly detector, the drift detector
+// and the rule engine
+func (ch *IGContainerWatcher) reportExecEvent(ctx context.Context, event *tracerexectype.Event) {
+	if !ch.hasEventConsumers() {
+		return
+	}
+	container := ch.containerCollection.LookupContainerByMntns(event.MountNsID)
+	if container == nil {
+		return
+	}
+	if ch.applicationProfileManager != nil {
+		ch.applicationProfileManager.ReportExecEvent(ctx, container, event)
+	}
+	if ch.anomalyDetector != nil {
+		ch.anomalyDetector.ReportExecEvent(ctx, container, event)
+	}
+	if ch.driftDetector != nil {
+		ch.driftDetector.ReportExecEvent(ctx, container, event)
+	}
+	if ch.ruleEngine != nil {
+		ch.ruleEngine.ReportExecEvent(ctx, container, event)
+	}
+}
+
+// reportOpenEvent hands an open event to the application profile manager, the anomaly detector, the drift detector
+// and the rule engine
+func (ch *IGContainerWatcher) reportOpenEvent(ctx context.Context, event *traceropentype.Event) {
+	if !ch.hasEventConsumers() {
+		return
+	}
+	container := ch.containerCollection.LookupContainerByMntns(event.MountNsID)
+	if container == nil {
+		return
+	}
+	if ch.applicationProfileManager != nil {
+		ch.applicationProfileManager.ReportOpenEvent(ctx, container, event)
+	}
+	if ch.anomalyDetector != nil {
+		ch.anomalyDetector.ReportOpenEvent(ctx, container, event)
+	}
+	if ch.driftDetector != nil {
+		ch.driftDetector.ReportOpenEvent(ctx, container, event)
+	}
+	if ch.ruleEngine != nil {
+		ch.ruleEngine.ReportOpenEvent(ctx, container, event)
+	}
+}
+
+// networkEventContainers returns the containers a network event belongs to, the network namespace is shared by
+// the containers of a pod so an event without the mount namespace of the process belongs to all of them
+func (ch *IGContainerWatcher) networkEventContainers(event *tracernetworktype.Event) []*containercollection.Container {
+	if event.MountNsID != 0 {
+		if container := ch.containerCollection.LookupContainerByMntns(event.MountNsID); container != nil {
+			return []*containercollection.Container{container}
+		}
+	}
+	return ch.containerCollection.LookupContainersByNetns(event.NetNsID)
+}
+
 //lint:ignore U1000 Ignore unused function temporarily for debugging
 func (ch *IGContainerWatcher) printNsMap(id string) {
 	nsMap, _ := ch.tracerCollection.TracerMountNsMap(id)
@@ -192,15 +462,31 @@ func (ch *IGContainerWatcher) Stop() {
 	_ = ch.tracerCollection.RemoveTracer(execTraceName)
 	ch.tracerOpen.Stop()
 	_ = ch.tracerCollection.RemoveTracer(openTraceName)
+	if ch.tracerMappedFiles != nil {
+		ch.tracerMappedFiles.Stop()
+	}
+	if ch.tracerNetwork != nil {
+		ch.tracerNetwork.Close()
+	}
 	ch.tracerCollection.Close()
 }
 
+// UnregisterContainer is called by the relevancy manager once its sniffing time is over. The container is removed
+// from the exec and open tracers only when no other consumer needs its events, the application profile manager,
+// the anomaly detector, the drift detector and the rule engine keep working after the sniffing time.
 func (ch *IGContainerWatcher) UnregisterContainer(ctx context.Context, container *containercollection.Container) {
+	ch.relevancyDone.Store(container.ID, true)
+	if ch.tracerMappedFiles != nil {
+		ch.tracerMappedFiles.RemoveContainer(container)
+	}
+	if ch.hasEventConsumers() {
+		return
+	}
 
 	event := containercollection.PubSubEvent{
 		Timestamp: time.Now().Format(time.RFC3339),
 		Type:      containercollection.EventTypeRemoveContainer,
 		Container: container,
 	}
-	ch.tracerCollection.TracerMapsUpdater()(event)
+	ch.removeFromTracers(event)
 }
@@ -0,0 +1,142 @@
+package containerwatcher
+
+import (
+	"context"
+	"node-agent/pkg/driftdetector"
+	"node-agent/pkg/processtree"
+	"node-agent/pkg/relevancymanager"
+	"sync"
+	"testing"
+
+	"github.com/gammazero/workerpool"
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	eventtypes "github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// relevancyManagerMock records the reported file accesses
+type relevancyManagerMock struct {
+	relevancymanager.RelevancyManagerClient
+	mutex sync.Mutex
+	files []string
+}
+
+func (rm *relevancyManagerMock) ReportFileAccess(_ context.Context, _, _, _, file string, _ relevancymanager.FileAccessKind, _ processtree.ProcessChain) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rm.files = append(rm.files, file)
+}
+
+// eventConsumerMock records the exec and open events, it stands for the consumers working after the sniffing time.
+// The drift detector interface holds the methods of the rule engine interface and those of the anomaly detector
+// interface but its start.
+type eventConsumerMock struct {
+	driftdetector.DriftDetectorClient
+	mutex sync.Mutex
+	execs []string
+	opens []string
+}
+
+func (c *eventConsumerMock) StartAnomalyDetector(_ context.Context) {}
+
+func (c *eventConsumerMock) ReportExecEvent(_ context.Context, _ *containercollection.Container, event *tracerexectype.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.execs = append(c.execs, event.Args[0])
+}
+
+func (c *eventConsumerMock) ReportOpenEvent(_ context.Context, _ *containercollection.Container, event *traceropentype.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.opens = append(c.opens, event.FullPath)
+}
+
+type processTreeMock struct {
+	processtree.ProcessTreeClient
+}
+
+func (pt *processTreeMock) ReportExecEvent(_ context.Context, _ *containercollection.Container, _ *tracerexectype.Event) {
+}
+
+func (pt *processTreeMock) GetProcessChain(_ *containercollection.Container, _ uint32) processtree.ProcessChain {
+	return nil
+}
+
+// createContainerWatcherMock creates a watcher with one container, the events are handled by a single worker
+func createContainerWatcherMock() (*IGContainerWatcher, *containercollection.Container, *[]containercollection.PubSubEvent) {
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx", Mntns: 4026532000}
+	containerCollection := &containercollection.ContainerCollection{}
+	containerCollection.AddContainer(container)
+	removed := &[]containercollection.PubSubEvent{}
+	ch := &IGContainerWatcher{
+		containerCollection: containerCollection,
+		processTree:         &processTreeMock{},
+		relevancyManager:    &relevancyManagerMock{},
+		eventWorkerPool:     workerpool.New(1),
+		removeFromTracers: func(event containercollection.PubSubEvent) {
+			*removed = append(*removed, event)
+		},
+	}
+	return ch, container, removed
+}
+
+func execEventMock(mntns uint64, args ...string) *tracerexectype.Event {
+	return &tracerexectype.Event{Event: eventtypes.Event{Type: eventtypes.NORMAL}, WithMountNsID: eventtypes.WithMountNsID{MountNsID: mntns}, Pid: 42, Comm: "sh", Args: args}
+}
+
+func openEventMock(mntns uint64, path string) *traceropentype.Event {
+	return &traceropentype.Event{Event: eventtypes.Event{Type: eventtypes.NORMAL}, WithMountNsID: eventtypes.WithMountNsID{MountNsID: mntns}, Pid: 42, Comm: "sh", FullPath: path}
+}
+
+func TestUnregisterContainerKeepsConsumers(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(ch *IGContainerWatcher, consumer *eventConsumerMock)
+	}{
+		{name: "anomaly detector", set: func(ch *IGContainerWatcher, consumer *eventConsumerMock) { ch.anomalyDetector = consumer }},
+		{name: "rule engine", set: func(ch *IGContainerWatcher, consumer *eventConsumerMock) { ch.ruleEngine = consumer }},
+		{name: "drift detector", set: func(ch *IGContainerWatcher, consumer *eventConsumerMock) { ch.driftDetector = consumer }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, container, removed := createContainerWatcherMock()
+			consumer := &eventConsumerMock{}
+			tt.set(ch, consumer)
+			ctx := context.TODO()
+
+			ch.handleExecEvent(ctx, execEventMock(container.Mntns, "/usr/sbin/nginx"))
+			ch.handleOpenEvent(ctx, openEventMock(container.Mntns, "/etc/nginx/nginx.conf"))
+			// the sniffing time of the relevancy manager is over once the events above are handled
+			ch.eventWorkerPool.SubmitWait(func() {})
+			ch.UnregisterContainer(ctx, container)
+			ch.handleExecEvent(ctx, execEventMock(container.Mntns, "/bin/bash"))
+			ch.handleOpenEvent(ctx, openEventMock(container.Mntns, "/etc/passwd"))
+			ch.eventWorkerPool.StopWait()
+
+			// the container is still traced for the consumer
+			assert.Empty(t, *removed)
+			assert.Equal(t, []string{"/usr/sbin/nginx", "/bin/bash"}, consumer.execs)
+			assert.Equal(t, []string{"/etc/nginx/nginx.conf", "/etc/passwd"}, consumer.opens)
+			// the relevancy manager only gets the accesses of its sniffing time
+			assert.Equal(t, []string{"/usr/sbin/nginx", "/etc/nginx/nginx.conf"}, ch.relevancyManager.(*relevancyManagerMock).files)
+		})
+	}
+}
+
+func TestUnregisterContainerWithoutConsumers(t *testing.T) {
+	ch, container, removed := createContainerWatcherMock()
+	ctx := context.TODO()
+
+	assert.Equal(t, container, ch.relevancyContainer(container.Mntns))
+	ch.UnregisterContainer(ctx, container)
+	ch.eventWorkerPool.StopWait()
+
+	// the memory mappings of the container are no longer scanned
+	assert.Nil(t, ch.relevancyContainer(container.Mntns))
+	if assert.Len(t, *removed, 1) {
+		assert.Equal(t, containercollection.EventTypeRemoveContainer, (*removed)[0].Type)
+		assert.Equal(t, container, (*removed)[0].Container)
+	}
+}
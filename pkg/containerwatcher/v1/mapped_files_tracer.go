@@ -0,0 +1,176 @@
+package containerwatcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+// mappedFileEvent is an executable file mapped in the memory of a container process
+type mappedFileEvent struct {
+	Namespace string
+	Pod       string
+	Container string
+	Mntns     uint64
+	Pid       uint32
+	Path      string
+}
+
+// mappedFilesTracer reports the executable files mapped by the processes of the watched containers.
+// Inspektor Gadget has no mmap tracer, so /proc/<pid>/maps is scanned periodically instead. This covers the
+// libraries loaded with dlopen or mapped after an open the open tracer did not see (openat2, the program
+// interpreter loaded by the kernel on execve), as long as the process lives until the next scan.
+type mappedFilesTracer struct {
+	procPath        string
+	period          time.Duration
+	lookupContainer func(mntns uint64) *containercollection.Container
+	callback        func(event *mappedFileEvent)
+	// reported holds the files already reported per mount namespace
+	reported     map[uint64]map[string]bool
+	reportedLock sync.Mutex
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+func newMappedFilesTracer(procPath string, period time.Duration, lookupContainer func(mntns uint64) *containercollection.Container, callback func(event *mappedFileEvent)) *mappedFilesTracer {
+	return &mappedFilesTracer{
+		procPath:        procPath,
+		period:          period,
+		lookupContainer: lookupContainer,
+		callback:        callback,
+		reported:        make(map[uint64]map[string]bool),
+		stop:            make(chan struct{}),
+	}
+}
+
+func (t *mappedFilesTracer) Start() {
+	go func() {
+		ticker := time.NewTicker(t.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.scan()
+			}
+		}
+	}()
+}
+
+func (t *mappedFilesTracer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+}
+
+// RemoveContainer forgets the files reported for a container, its mount namespace may be reused
+func (t *mappedFilesTracer) RemoveContainer(container *containercollection.Container) {
+	t.reportedLock.Lock()
+	defer t.reportedLock.Unlock()
+	delete(t.reported, container.Mntns)
+}
+
+func (t *mappedFilesTracer) scan() {
+	entries, err := os.ReadDir(t.procPath)
+	if err != nil {
+		logger.L().Warning("failed to list processes for mapped files", helpers.String("path", t.procPath), helpers.Error(err))
+		return
+	}
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		mntns, err := readMountNamespace(filepath.Join(t.procPath, entry.Name()))
+		if err != nil {
+			// the process is gone
+			continue
+		}
+		container := t.lookupContainer(mntns)
+		if container == nil {
+			continue
+		}
+		files, err := readExecutableMappings(filepath.Join(t.procPath, entry.Name(), "maps"))
+		if err != nil {
+			continue
+		}
+		for _, file := range t.newFiles(mntns, files) {
+			t.callback(&mappedFileEvent{
+				Namespace: container.Namespace,
+				Pod:       container.Podname,
+				Container: container.Name,
+				Mntns:     mntns,
+				Pid:       uint32(pid),
+				Path:      file,
+			})
+		}
+	}
+}
+
+func (t *mappedFilesTracer) newFiles(mntns uint64, files []string) []string {
+	t.reportedLock.Lock()
+	defer t.reportedLock.Unlock()
+	if _, ok := t.reported[mntns]; !ok {
+		t.reported[mntns] = make(map[string]bool)
+	}
+	var newFiles []string
+	for _, file := range files {
+		if !t.reported[mntns][file] {
+			t.reported[mntns][file] = true
+			newFiles = append(newFiles, file)
+		}
+	}
+	return newFiles
+}
+
+// readMountNamespace returns the mount namespace inode of a process, the ns/mnt link reads "mnt:[<inode>]"
+func readMountNamespace(processPath string) (uint64, error) {
+	link, err := os.Readlink(filepath.Join(processPath, "ns", "mnt"))
+	if err != nil {
+		return 0, err
+	}
+	inode := strings.TrimSuffix(strings.TrimPrefix(link, "mnt:["), "]")
+	mntns, err := strconv.ParseUint(inode, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected mount namespace link %s: %w", link, err)
+	}
+	return mntns, nil
+}
+
+// readExecutableMappings returns the files of the executable mappings listed in a /proc/<pid>/maps file,
+// lines look like "7f2c5a200000-7f2c5a395000 r-xp 00028000 08:01 1835 /usr/lib/x86_64-linux-gnu/libc.so.6"
+func readExecutableMappings(mapsPath string) ([]string, error) {
+	mapsFile, err := os.Open(mapsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer mapsFile.Close()
+
+	var files []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(mapsFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || len(fields[1]) < 3 || fields[1][2] != 'x' {
+			continue
+		}
+		file := strings.Join(fields[5:], " ")
+		// skip pseudo mappings like [vdso] and files deleted since they were mapped
+		if !strings.HasPrefix(file, "/") || strings.HasSuffix(file, " (deleted)") || seen[file] {
+			continue
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	return files, scanner.Err()
+}
@@ -0,0 +1,103 @@
+package containerwatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/stretchr/testify/assert"
+)
+
+const mapsMock = `55d4c3a00000-55d4c3a28000 r--p 00000000 00:2f 1311   /usr/sbin/nginx
+55d4c3a28000-55d4c3b2b000 r-xp 00028000 00:2f 1311   /usr/sbin/nginx
+7f2c5a200000-7f2c5a228000 r--p 00000000 00:2f 1835   /usr/lib/x86_64-linux-gnu/libc.so.6
+7f2c5a228000-7f2c5a3bd000 r-xp 00028000 00:2f 1835   /usr/lib/x86_64-linux-gnu/libc.so.6
+7f2c5a400000-7f2c5a402000 r-xp 00000000 00:2f 2040   /usr/lib/nginx/modules/ngx_http_geoip_module.so
+7f2c5a500000-7f2c5a502000 r-xp 00000000 00:2f 2041   /tmp/plugin.so (deleted)
+7f2c5a600000-7f2c5a602000 r-xp 00000000 00:2f 2042   /opt/my app/lib.so
+7ffd1d5f2000-7ffd1d613000 rw-p 00000000 00:00 0      [stack]
+7ffd1d7d8000-7ffd1d7da000 r-xp 00000000 00:00 0      [vdso]
+`
+
+func createProcMock(t *testing.T, processes map[string]string) string {
+	procPath := t.TempDir()
+	for pid, mntns := range processes {
+		if err := os.MkdirAll(filepath.Join(procPath, pid, "ns"), 0755); err != nil {
+			t.Fatalf("fail to create directory, err: %v", err)
+		}
+		if err := os.Symlink("mnt:["+mntns+"]", filepath.Join(procPath, pid, "ns", "mnt")); err != nil {
+			t.Fatalf("fail to create symlink, err: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(procPath, pid, "maps"), []byte(mapsMock), 0644); err != nil {
+			t.Fatalf("fail to write maps file, err: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(procPath, "sys"), 0755); err != nil {
+		t.Fatalf("fail to create directory, err: %v", err)
+	}
+	return procPath
+}
+
+func TestReadExecutableMappings(t *testing.T) {
+	procPath := createProcMock(t, map[string]string{"10": "4026532000"})
+	files, err := readExecutableMappings(filepath.Join(procPath, "10", "maps"))
+	if err != nil {
+		t.Fatalf("fail to read maps file, err: %v", err)
+	}
+	assert.Equal(t, []string{
+		"/usr/sbin/nginx",
+		"/usr/lib/x86_64-linux-gnu/libc.so.6",
+		"/usr/lib/nginx/modules/ngx_http_geoip_module.so",
+		"/opt/my app/lib.so",
+	}, files)
+
+	_, err = readExecutableMappings(filepath.Join(procPath, "20", "maps"))
+	assert.Error(t, err)
+}
+
+func TestMappedFilesTracerScan(t *testing.T) {
+	// 10 and 11 are processes of the same container, 20 is a process of the node
+	procPath := createProcMock(t, map[string]string{"10": "4026532000", "11": "4026532000", "20": "4026531840"})
+	container := &containercollection.Container{Namespace: "default", Podname: "nginx", Name: "nginx", Mntns: 4026532000}
+	lookupContainer := func(mntns uint64) *containercollection.Container {
+		if mntns == container.Mntns {
+			return container
+		}
+		return nil
+	}
+	var events []*mappedFileEvent
+	tracer := newMappedFilesTracer(procPath, time.Second, lookupContainer, func(event *mappedFileEvent) {
+		events = append(events, event)
+	})
+
+	tracer.scan()
+	// files are reported once per container
+	assert.Len(t, events, 4)
+	for _, event := range events {
+		assert.Equal(t, "default", event.Namespace)
+		assert.Equal(t, "nginx", event.Pod)
+		assert.Equal(t, "nginx", event.Container)
+	}
+
+	events = nil
+	tracer.scan()
+	assert.Empty(t, events)
+
+	tracer.RemoveContainer(container)
+	tracer.scan()
+	assert.Len(t, events, 4)
+}
+
+func TestReadMountNamespace(t *testing.T) {
+	procPath := createProcMock(t, map[string]string{"10": "4026532000"})
+	mntns, err := readMountNamespace(filepath.Join(procPath, "10"))
+	if err != nil {
+		t.Fatalf("fail to read mount namespace, err: %v", err)
+	}
+	assert.Equal(t, uint64(4026532000), mntns)
+
+	_, err = readMountNamespace(filepath.Join(procPath, "sys"))
+	assert.Error(t, err)
+}
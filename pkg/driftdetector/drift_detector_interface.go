@@ -0,0 +1,20 @@
+package driftdetector
+
+import (
+	"context"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+)
+
+// ReportNamePrefix is the prefix of the drift report names, it is followed by the slug of the container instance ID
+const ReportNamePrefix = "drift-"
+
+type DriftDetectorClient interface {
+	ReportContainerStarted(ctx context.Context, container *containercollection.Container)
+	ReportContainerTerminated(ctx context.Context, container *containercollection.Container)
+	ReportExecEvent(ctx context.Context, container *containercollection.Container, event *tracerexectype.Event)
+	ReportOpenEvent(ctx context.Context, container *containercollection.Container, event *traceropentype.Event)
+	StartDriftDetector(ctx context.Context)
+}
@@ -0,0 +1,457 @@
+package driftdetector
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"node-agent/pkg/config"
+	"node-agent/pkg/driftdetector"
+	"node-agent/pkg/imageresolver"
+	"node-agent/pkg/metadataprovider"
+	"node-agent/pkg/sbom"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// maxFiles bounds the files waiting to be checked
+	maxFiles = 10000
+	// maxDriftedFiles bounds the drifted files of a container, it keeps the report well under the 1 MiB of a config map
+	maxDriftedFiles = 4000
+	// maxHashSize is the size of the largest file hashed, the checksum of larger files is not compared
+	maxHashSize = 128 * 1024 * 1024
+)
+
+// pseudoFilesystems are not part of the image, the files written under them are not drifted
+var pseudoFilesystems = []string{"/proc", "/sys", "/dev"}
+
+// checksumAlgorithms are the SBOM checksum algorithms that can be compared, by order of preference
+var checksumAlgorithms = []spdxv1beta1.ChecksumAlgorithm{spdxv1beta1.SHA256, spdxv1beta1.SHA512, spdxv1beta1.SHA1, spdxv1beta1.MD5}
+
+// DriftDetector compares the files executed and written in the containers with the files of their image SBOM. The
+// files are collected from the events and checked every update period once the SBOM of the image is read: a file
+// absent from the SBOM is added and a file whose checksum differs from the SBOM one is modified. The drifted files of
+// each container are stored as a drift report.
+type DriftDetector struct {
+	cfg              config.Config
+	metadataProvider metadataprovider.MetadataProviderClient
+	storageClient    storageclient.StorageClient
+	imageResolver    imageresolver.ImageResolverClient
+	procPath         string
+	// watchedContainers maps a container ID to its *containerDriftData
+	watchedContainers sync.Map
+	// getContainerImage is replaced in tests
+	getContainerImage func(ctx context.Context, container *containercollection.Container) (string, string, instanceidhandler.IInstanceID, error)
+}
+
+type containerDriftData struct {
+	mutex     sync.Mutex
+	container *containercollection.Container
+	imageID   string
+	// imageFiles maps the files of the image SBOM to their checksums, it is nil until the SBOM is read
+	imageFiles map[string][]spdxv1beta1.Checksum
+	instanceID instanceidhandler.IInstanceID
+	// candidates maps the files waiting to be checked to the operation that touched them
+	candidates map[string]string
+	// checkedExecs holds the executables already checked, a written file is checked again after each write
+	checkedExecs map[string]bool
+	drifted      map[string]storageclient.DriftedFile
+	dirty        bool
+	report       *storageclient.DriftReport
+}
+
+var _ driftdetector.DriftDetectorClient = (*DriftDetector)(nil)
+
+func CreateDriftDetector(cfg config.Config, metadataProvider metadataprovider.MetadataProviderClient, storageClient storageclient.StorageClient, imageResolver imageresolver.ImageResolverClient, procPath string) (*DriftDetector, error) {
+	if cfg.UpdateDataPeriod <= 0 {
+		return nil, fmt.Errorf("drift detection needs an update data period to check the files")
+	}
+	dd := &DriftDetector{
+		cfg:              cfg,
+		metadataProvider: metadataProvider,
+		storageClient:    storageClient,
+		imageResolver:    imageResolver,
+		procPath:         procPath,
+	}
+	dd.getContainerImage = dd.podContainerImage
+	return dd, nil
+}
+
+func (dd *DriftDetector) ReportContainerStarted(_ context.Context, container *containercollection.Container) {
+	dd.watchedContainers.LoadOrStore(container.ID, &containerDriftData{
+		container:    container,
+		candidates:   make(map[string]string),
+		checkedExecs: make(map[string]bool),
+		drifted:      make(map[string]storageclient.DriftedFile),
+	})
+}
+
+func (dd *DriftDetector) ReportContainerTerminated(ctx context.Context, container *containercollection.Container) {
+	if data, ok := dd.watchedContainers.LoadAndDelete(container.ID); ok {
+		// the files collected since the last check are still reported, their content may be gone
+		go dd.checkContainer(ctx, data.(*containerDriftData))
+	}
+}
+
+func (dd *DriftDetector) ReportExecEvent(_ context.Context, container *containercollection.Container, event *tracerexectype.Event) {
+	data, ok := dd.watchedContainers.Load(container.ID)
+	if !ok {
+		return
+	}
+	path := dd.executablePath(event)
+	if path == "" {
+		return
+	}
+	containerData := data.(*containerDriftData)
+	containerData.mutex.Lock()
+	defer containerData.mutex.Unlock()
+	if !containerData.checkedExecs[path] {
+		containerData.addCandidate(path, storageclient.DriftOperationExec)
+	}
+}
+
+func (dd *DriftDetector) ReportOpenEvent(_ context.Context, container *containercollection.Container, event *traceropentype.Event) {
+	if !utils.IsWriteOpen(event.Flags) {
+		return
+	}
+	data, ok := dd.watchedContainers.Load(container.ID)
+	if !ok {
+		return
+	}
+	path := event.FullPath
+	if path == "" {
+		path = event.Path
+	}
+	if !filepath.IsAbs(path) || !inImage(container, path) {
+		return
+	}
+	containerData := data.(*containerDriftData)
+	containerData.mutex.Lock()
+	defer containerData.mutex.Unlock()
+	// an executed file that is written is reported as executed, it is checked again anyway
+	if containerData.candidates[path] != storageclient.DriftOperationExec {
+		containerData.addCandidate(path, storageclient.DriftOperationWrite)
+	}
+}
+
+func (dd *DriftDetector) StartDriftDetector(ctx context.Context) {
+	ctx, span := otel.Tracer("").Start(ctx, "DriftDetector.StartDriftDetector")
+	defer span.End()
+	go func() {
+		ticker := time.NewTicker(dd.cfg.UpdateDataPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dd.watchedContainers.Range(func(_, data any) bool {
+					dd.checkContainer(ctx, data.(*containerDriftData))
+					return true
+				})
+			}
+		}
+	}()
+}
+
+// addCandidate adds a file to check, the caller holds the mutex
+func (data *containerDriftData) addCandidate(path, operation string) {
+	if _, ok := data.candidates[path]; !ok && len(data.candidates) >= maxFiles {
+		return
+	}
+	data.candidates[path] = operation
+}
+
+// checkContainer checks the files collected for a container against its image SBOM and stores the drift report
+func (dd *DriftDetector) checkContainer(ctx context.Context, data *containerDriftData) {
+	data.mutex.Lock()
+	loaded := data.imageFiles != nil
+	data.mutex.Unlock()
+	if !loaded {
+		imageFiles, imageID, instanceID, err := dd.loadImageFiles(ctx, data.container)
+		if err != nil {
+			logger.L().Debug("image SBOM is not available yet, drift is not checked", helpers.String("container ID", data.container.ID), helpers.Error(err))
+			return
+		}
+		data.mutex.Lock()
+		data.imageFiles, data.imageID, data.instanceID = imageFiles, imageID, instanceID
+		data.mutex.Unlock()
+	}
+
+	data.mutex.Lock()
+	imageFiles := data.imageFiles
+	candidates := data.candidates
+	data.candidates = make(map[string]string)
+	data.mutex.Unlock()
+
+	// the files are hashed without holding the mutex, the events of the container keep coming
+	rootPath := filepath.Join(dd.procPath, strconv.FormatUint(uint64(data.container.Pid), 10), "root")
+	drifted := make(map[string]storageclient.DriftedFile)
+	for path, operation := range candidates {
+		checksums, inSBOM := imageFiles[path]
+		if file, ok := checkFile(rootPath, path, operation, checksums, inSBOM); ok {
+			drifted[path] = file
+		}
+	}
+
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	for path, operation := range candidates {
+		if operation == storageclient.DriftOperationExec {
+			data.checkedExecs[path] = true
+		}
+	}
+	for path, file := range drifted {
+		if _, ok := data.drifted[path]; !ok && len(data.drifted) >= maxDriftedFiles {
+			continue
+		}
+		if data.drifted[path] != file {
+			data.drifted[path] = file
+			data.dirty = true
+		}
+	}
+	dd.storeReport(ctx, data)
+}
+
+// loadImageFiles reads the files of the image SBOM of a container with their checksums, the image ID and the
+// instance ID of the container
+func (dd *DriftDetector) loadImageFiles(ctx context.Context, container *containercollection.Container) (map[string][]spdxv1beta1.Checksum, string, instanceidhandler.IInstanceID, error) {
+	imageTag, imageID, instanceID, err := dd.getContainerImage(ctx, container)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// the files missing from an incomplete SBOM would all be reported as drifted, it is not read
+	imageFiles, err := sbom.FetchImageFiles(ctx, dd.storageClient, imageTag, imageID, dd.cfg)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return imageFiles, imageID, instanceID, nil
+}
+
+// storeReport creates or updates the drift report of a container when it has new drifted files, the caller holds
+// the mutex
+func (dd *DriftDetector) storeReport(ctx context.Context, data *containerDriftData) {
+	if !data.dirty {
+		return
+	}
+	k8sContainerID := utils.CreateK8sContainerID(data.container.Namespace, data.container.Podname, data.container.Name)
+
+	var err error
+	if data.report == nil {
+		data.report, err = dd.createReport(ctx, data)
+	} else {
+		data.report.Spec.Files = sortedFiles(data.drifted)
+		err = dd.storageClient.UpdateDriftReport(ctx, data.report.Name, data.report)
+	}
+	if err != nil {
+		logger.L().Ctx(ctx).Warning("failed to store drift report", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Error(err))
+		return
+	}
+	data.dirty = false
+	logger.L().Info("container drift report has been stored", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Int("drifted files", len(data.drifted)))
+}
+
+// createReport stores the first drift report of a container, a report left by a previous run of the agent is
+// extended
+func (dd *DriftDetector) createReport(ctx context.Context, data *containerDriftData) (*storageclient.DriftReport, error) {
+	slug, err := data.instanceID.GetSlug()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the instance ID slug: %w", err)
+	}
+	report := &storageclient.DriftReport{
+		Spec: storageclient.DriftReportSpec{
+			ContainerName: data.container.Name,
+			ImageID:       data.imageID,
+		},
+	}
+	report.SetName(driftdetector.ReportNamePrefix + slug)
+	report.SetLabels(utils.InstanceIDLabels(data.instanceID))
+	report.SetAnnotations(utils.InstanceIDAnnotations(data.instanceID))
+	report.Spec.Files = sortedFiles(data.drifted)
+
+	err = dd.storageClient.CreateDriftReport(ctx, report)
+	if err == nil || !storageclient.IsAlreadyExist(err) {
+		return report, err
+	}
+	existing, err := dd.storageClient.GetDriftReport(ctx, report.Name)
+	if err != nil {
+		return nil, err
+	}
+	// the files of a report on another image are not drifted from this one
+	if existing.Spec.ImageID == data.imageID {
+		for _, file := range existing.Spec.Files {
+			if _, ok := data.drifted[file.Path]; !ok && len(data.drifted) < maxDriftedFiles {
+				data.drifted[file.Path] = file
+			}
+		}
+	}
+	report.Spec.Files = sortedFiles(data.drifted)
+	return report, dd.storageClient.UpdateDriftReport(ctx, report.Name, report)
+}
+
+// executablePath returns the path of an executed file in its container, the link to the executable is preferred
+// to the first argument which may be relative or a symbolic link
+func (dd *DriftDetector) executablePath(event *tracerexectype.Event) string {
+	if exe, err := os.Readlink(filepath.Join(dd.procPath, strconv.FormatUint(uint64(event.Pid), 10), "exe")); err == nil && filepath.IsAbs(exe) {
+		return exe
+	}
+	if len(event.Args) > 0 && filepath.IsAbs(event.Args[0]) {
+		return filepath.Clean(event.Args[0])
+	}
+	return ""
+}
+
+func (dd *DriftDetector) podContainerImage(ctx context.Context, container *containercollection.Container) (string, string, instanceidhandler.IInstanceID, error) {
+	pod, err := utils.GetPod(dd.metadataProvider, container.Namespace, container.Podname)
+	if err != nil {
+		return "", "", nil, err
+	}
+	imageTag, imageID, err := utils.GetPodContainerImage(pod, container.Name)
+	if err != nil {
+		return "", "", nil, err
+	}
+	imageID, err = dd.imageResolver.ResolveImageID(ctx, container.ID, imageTag, imageID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	instanceID, err := utils.GetPodContainerInstanceID(pod, container.Name)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return imageTag, imageID, instanceID, nil
+}
+
+// inImage tells whether a path of a container is in its image filesystem, the files under the mounts of the
+// container are not
+func inImage(container *containercollection.Container, path string) bool {
+	for _, mount := range pseudoFilesystems {
+		if isUnder(path, mount) {
+			return false
+		}
+	}
+	if container.OciConfig != nil {
+		for _, mount := range container.OciConfig.Mounts {
+			if mount.Destination != "/" && isUnder(path, mount.Destination) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isUnder(path, directory string) bool {
+	return path == directory || strings.HasPrefix(path, directory+"/")
+}
+
+// checkFile tells whether a file touched by an operation drifted from the image, checksums are the ones of the file
+// in the image SBOM when inSBOM is true
+func checkFile(rootPath, path, operation string, checksums []spdxv1beta1.Checksum, inSBOM bool) (storageclient.DriftedFile, bool) {
+	file := storageclient.DriftedFile{Path: path, Operation: operation, Kind: storageclient.DriftKindAdded}
+	algorithm, expected := comparableChecksum(checksums)
+	if inSBOM && algorithm == "" {
+		// the file is in the image and there is no checksum to compare
+		return file, false
+	}
+	sums, err := hashFile(filepath.Join(rootPath, path), algorithm)
+	if inSBOM {
+		// a file that cannot be read is gone, it cannot be compared
+		if err != nil || strings.EqualFold(sums[algorithm], expected) {
+			return file, false
+		}
+		file.Kind = storageclient.DriftKindModified
+	}
+	file.Checksum = sums[spdxv1beta1.SHA256]
+	return file, true
+}
+
+// comparableChecksum returns the preferred checksum of a file that can be computed, the zero checksums some SBOM
+// generators use as a placeholder are skipped
+func comparableChecksum(checksums []spdxv1beta1.Checksum) (spdxv1beta1.ChecksumAlgorithm, string) {
+	for _, algorithm := range checksumAlgorithms {
+		for _, checksum := range checksums {
+			if checksum.Algorithm == algorithm && strings.Trim(checksum.Value, "0") != "" {
+				return algorithm, checksum.Value
+			}
+		}
+	}
+	return "", ""
+}
+
+func newHash(algorithm spdxv1beta1.ChecksumAlgorithm) hash.Hash {
+	switch algorithm {
+	case spdxv1beta1.SHA256:
+		return sha256.New()
+	case spdxv1beta1.SHA512:
+		return sha512.New()
+	case spdxv1beta1.SHA1:
+		return sha1.New()
+	case spdxv1beta1.MD5:
+		return md5.New()
+	}
+	return nil
+}
+
+// hashFile returns the SHA256 of a regular file and its checksum with algorithm, when it is set
+func hashFile(path string, algorithm spdxv1beta1.ChecksumAlgorithm) (map[spdxv1beta1.ChecksumAlgorithm]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxHashSize {
+		return nil, fmt.Errorf("file %s is not hashed", path)
+	}
+	hashes := map[spdxv1beta1.ChecksumAlgorithm]hash.Hash{spdxv1beta1.SHA256: sha256.New()}
+	if algorithm != "" && algorithm != spdxv1beta1.SHA256 {
+		hashes[algorithm] = newHash(algorithm)
+	}
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return nil, err
+	}
+	sums := make(map[spdxv1beta1.ChecksumAlgorithm]string, len(hashes))
+	for algorithm, h := range hashes {
+		sums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+func sortedFiles(drifted map[string]storageclient.DriftedFile) []storageclient.DriftedFile {
+	files := make([]storageclient.DriftedFile, 0, len(drifted))
+	for _, file := range drifted {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
@@ -0,0 +1,224 @@
+package driftdetector
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"node-agent/pkg/config"
+	"node-agent/pkg/driftdetector"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/testutils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	ocispec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const instanceIDMock = "apiVersion-v1/namespace-default/kind-deployment/name-nginx/containerName-nginx"
+
+// storageClientMock serves the image SBOM and records the drift reports, the other methods are not used by the
+// drift detector
+type storageClientMock struct {
+	storageclient.StorageClient
+	sbom    *spdxv1beta1.SBOMSPDXv2p3
+	reports map[string]*storageclient.DriftReport
+}
+
+func (sc *storageClientMock) GetImageSBOM(_ context.Context, _ string) (*spdxv1beta1.SBOMSPDXv2p3, error) {
+	if sc.sbom == nil {
+		return nil, storageclient.ErrNotFound
+	}
+	return sc.sbom, nil
+}
+
+func (sc *storageClientMock) GetDriftReport(_ context.Context, key string) (*storageclient.DriftReport, error) {
+	report, ok := sc.reports[key]
+	if !ok {
+		return nil, storageclient.ErrNotFound
+	}
+	return report, nil
+}
+
+func (sc *storageClientMock) CreateDriftReport(_ context.Context, report *storageclient.DriftReport) error {
+	if _, ok := sc.reports[report.Name]; ok {
+		return storageclient.ErrAlreadyExist
+	}
+	sc.reports[report.Name] = report
+	return nil
+}
+
+func (sc *storageClientMock) UpdateDriftReport(_ context.Context, key string, report *storageclient.DriftReport) error {
+	sc.reports[key] = report
+	return nil
+}
+
+func sha1Sum(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Sum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func sbomMock() *spdxv1beta1.SBOMSPDXv2p3 {
+	sbom := &spdxv1beta1.SBOMSPDXv2p3{}
+	sbom.Spec.SPDX.Files = []*spdxv1beta1.File{
+		{FileName: "/usr/sbin/nginx", Checksums: []spdxv1beta1.Checksum{{Algorithm: spdxv1beta1.SHA256, Value: sha256Sum("nginx")}}},
+		{FileName: "/etc/nginx/nginx.conf", Checksums: []spdxv1beta1.Checksum{{Algorithm: spdxv1beta1.SHA1, Value: sha1Sum("worker_processes 1;")}}},
+		// some generators do not compute the checksums
+		{FileName: "usr/bin/env", Checksums: []spdxv1beta1.Checksum{{Algorithm: spdxv1beta1.SHA1, Value: "0000000000000000000000000000000000000000"}}},
+	}
+	return sbom
+}
+
+// createRootMock writes the files of the container root filesystem, the container runs as pid 10
+func createRootMock(t *testing.T, files map[string]string) string {
+	procPath := t.TempDir()
+	for path, content := range files {
+		fullPath := filepath.Join(procPath, "10", "root", path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("fail to create directory, err: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("fail to write file, err: %v", err)
+		}
+	}
+	return procPath
+}
+
+func createDriftDetectorMock(t *testing.T, procPath string) (*DriftDetector, *storageClientMock) {
+	storageClient := &storageClientMock{reports: map[string]*storageclient.DriftReport{}}
+	dd, err := CreateDriftDetector(config.Config{UpdateDataPeriod: time.Minute}, nil, storageClient, nil, procPath)
+	if err != nil {
+		t.Fatalf("fail to create drift detector, err: %v", err)
+	}
+	dd.getContainerImage = func(_ context.Context, _ *containercollection.Container) (string, string, instanceidhandler.IInstanceID, error) {
+		instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+		return "nginx:1.25", "docker.io/library/nginx@sha256:0123456789abcdef", instanceID, err
+	}
+	return dd, storageClient
+}
+
+func containerMock() *containercollection.Container {
+	return &containercollection.Container{
+		ID: "abc", Pid: 10, Namespace: "default", Podname: "nginx-1", Name: "nginx",
+		OciConfig: &ocispec.Spec{Mounts: []ocispec.Mount{{Destination: "/var/log/nginx"}, {Destination: "/etc/hosts"}}},
+	}
+}
+
+func reportName(t *testing.T) string {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	slug, _ := instanceID.GetSlug()
+	return driftdetector.ReportNamePrefix + slug
+}
+
+func TestDriftDetector(t *testing.T) {
+	procPath := createRootMock(t, map[string]string{
+		"/usr/sbin/nginx":            "nginx",
+		"/usr/bin/env":               "env",
+		"/tmp/miner":                 "miner",
+		"/etc/nginx/nginx.conf":      "worker_processes 8;",
+		"/etc/nginx/conf.d/new.conf": "server {}",
+	})
+	dd, storageClient := createDriftDetectorMock(t, procPath)
+	ctx := context.TODO()
+	container := containerMock()
+	dd.ReportContainerStarted(ctx, container)
+
+	dd.ReportExecEvent(ctx, container, testutils.ExecEvent("nginx", "/usr/sbin/nginx", "-g", "daemon off;"))
+	dd.ReportExecEvent(ctx, container, testutils.ExecEvent("env", "/usr/bin/env"))
+	dd.ReportExecEvent(ctx, container, testutils.ExecEvent("miner", "/tmp/miner", "--pool", "x"))
+	// relative paths without an executable link cannot be checked
+	dd.ReportExecEvent(ctx, container, testutils.ExecEvent("sh", "sh"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/nginx.conf", "O_WRONLY", "O_TRUNC"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/nginx/conf.d/new.conf", "O_WRONLY", "O_CREAT"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/passwd", "O_RDONLY"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/var/log/nginx/access.log", "O_WRONLY", "O_APPEND"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/etc/hosts", "O_RDWR"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/dev/null", "O_WRONLY"))
+
+	// nothing is checked before the image SBOM is available
+	data, _ := dd.watchedContainers.Load(container.ID)
+	dd.checkContainer(ctx, data.(*containerDriftData))
+	assert.Empty(t, storageClient.reports)
+
+	storageClient.sbom = sbomMock()
+	dd.checkContainer(ctx, data.(*containerDriftData))
+	report, ok := storageClient.reports[reportName(t)]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "nginx", report.Spec.ContainerName)
+	assert.Equal(t, "docker.io/library/nginx@sha256:0123456789abcdef", report.Spec.ImageID)
+	assert.Equal(t, instanceIDMock, report.Annotations[instanceidhandlerV1.InstanceIDMetadataKey])
+	assert.Equal(t, []storageclient.DriftedFile{
+		{Path: "/etc/nginx/conf.d/new.conf", Operation: storageclient.DriftOperationWrite, Kind: storageclient.DriftKindAdded, Checksum: sha256Sum("server {}")},
+		{Path: "/etc/nginx/nginx.conf", Operation: storageclient.DriftOperationWrite, Kind: storageclient.DriftKindModified, Checksum: sha256Sum("worker_processes 8;")},
+		{Path: "/tmp/miner", Operation: storageclient.DriftOperationExec, Kind: storageclient.DriftKindAdded, Checksum: sha256Sum("miner")},
+	}, report.Spec.Files)
+
+	// executables are checked once, the report is updated with the new drifted files only
+	dd.ReportExecEvent(ctx, container, testutils.ExecEvent("miner", "/tmp/miner"))
+	dd.ReportOpenEvent(ctx, container, testutils.OpenEvent("nginx", "/usr/sbin/nginx", "O_WRONLY"))
+	assert.Len(t, data.(*containerDriftData).candidates, 1)
+	if err := os.WriteFile(filepath.Join(procPath, "10", "root", "usr", "sbin", "nginx"), []byte("backdoor"), 0755); err != nil {
+		t.Fatalf("fail to write file, err: %v", err)
+	}
+	dd.checkContainer(ctx, data.(*containerDriftData))
+	report = storageClient.reports[reportName(t)]
+	if assert.Len(t, report.Spec.Files, 4) {
+		assert.Equal(t, storageclient.DriftedFile{Path: "/usr/sbin/nginx", Operation: storageclient.DriftOperationWrite, Kind: storageclient.DriftKindModified, Checksum: sha256Sum("backdoor")}, report.Spec.Files[3])
+	}
+}
+
+func TestDriftDetectorExistingReport(t *testing.T) {
+	procPath := createRootMock(t, map[string]string{"/tmp/miner": "miner"})
+	dd, storageClient := createDriftDetectorMock(t, procPath)
+	storageClient.sbom = sbomMock()
+	// a report left by a previous run of the agent for the same image
+	existing := &storageclient.DriftReport{Spec: storageclient.DriftReportSpec{
+		ContainerName: "nginx",
+		ImageID:       "docker.io/library/nginx@sha256:0123456789abcdef",
+		Files:         []storageclient.DriftedFile{{Path: "/tmp/old", Operation: storageclient.DriftOperationExec, Kind: storageclient.DriftKindAdded}},
+	}}
+	existing.SetName(reportName(t))
+	storageClient.reports[existing.Name] = existing
+
+	ctx := context.TODO()
+	container := containerMock()
+	dd.ReportContainerStarted(ctx, container)
+	dd.ReportExecEvent(ctx, container, testutils.ExecEvent("miner", "/tmp/miner"))
+	data, _ := dd.watchedContainers.Load(container.ID)
+	dd.checkContainer(ctx, data.(*containerDriftData))
+
+	report := storageClient.reports[reportName(t)]
+	if assert.Len(t, report.Spec.Files, 2) {
+		assert.Equal(t, "/tmp/miner", report.Spec.Files[0].Path)
+		assert.Equal(t, "/tmp/old", report.Spec.Files[1].Path)
+	}
+}
+
+func TestComparableChecksum(t *testing.T) {
+	algorithm, value := comparableChecksum([]spdxv1beta1.Checksum{
+		{Algorithm: spdxv1beta1.SHA1, Value: "abc"},
+		{Algorithm: spdxv1beta1.SHA256, Value: "def"},
+	})
+	assert.Equal(t, spdxv1beta1.SHA256, algorithm)
+	assert.Equal(t, "def", value)
+
+	algorithm, _ = comparableChecksum([]spdxv1beta1.Checksum{{Algorithm: spdxv1beta1.SHA1, Value: "0000"}, {Algorithm: "MD6", Value: "abc"}})
+	assert.Empty(t, algorithm)
+}
@@ -0,0 +1,39 @@
+package exporters
+
+import (
+	"node-agent/pkg/processtree"
+	"time"
+)
+
+// Exporter sends the alerts out of the agent, the alerts are queued and sent asynchronously
+type Exporter interface {
+	SendAlert(alert Alert)
+	// Stop sends the queued alerts and releases the exporter
+	Stop()
+}
+
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// Alert is a security finding on a container, a deviation from its learned application profile or an event
+// matching a runtime detection rule
+type Alert struct {
+	Time        time.Time `json:"time"`
+	RuleName    string    `json:"ruleName"`
+	Severity    string    `json:"severity"`
+	Message     string    `json:"message"`
+	Namespace   string    `json:"namespace"`
+	Pod         string    `json:"pod"`
+	Container   string    `json:"container"`
+	ContainerID string    `json:"containerID"`
+	Pid         uint32    `json:"pid"`
+	Comm        string    `json:"comm"`
+	Path        string    `json:"path"`
+	Args        []string  `json:"args,omitempty"`
+	// ProcessChain is the ancestry of the process, from the process itself to the first process of the container
+	ProcessChain processtree.ProcessChain `json:"processChain,omitempty"`
+}
@@ -0,0 +1,216 @@
+package exporters
+
+import (
+	"context"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// alertQueueSize is the number of alerts an exporter queues while a batch is sent, the alerts are dropped beyond
+	alertQueueSize       = 1024
+	defaultBatchSize     = 1
+	defaultFlushInterval = time.Second
+)
+
+// alertSender writes a batch of alerts to a destination. It returns the number of alerts of the batch it sent, the
+// following ones are sent again when an error is returned.
+type alertSender interface {
+	name() string
+	send(ctx context.Context, alerts []exporters.Alert) (int, error)
+	close() error
+}
+
+// AlertExporters sends each alert to all the enabled exporters, the alerts beyond the rate limit are dropped
+type AlertExporters struct {
+	exporters []*batchExporter
+	limiter   *rate.Limiter
+	// dropped counts the alerts dropped by the rate limiter since it was last logged
+	dropped atomic.Uint64
+}
+
+var _ exporters.Exporter = (*AlertExporters)(nil)
+
+// CreateAlertExporters creates the exporters enabled in the configuration
+func CreateAlertExporters(cfg config.ExportersConfig) (*AlertExporters, error) {
+	var senders []alertSender
+	if cfg.Stdout {
+		senders = append(senders, newWriterSender("stdout", os.Stdout))
+	}
+	if cfg.File != "" {
+		sender, err := newFileSender(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender)
+	}
+	if cfg.HTTP.URL != "" {
+		sender, err := newHTTPSender(cfg.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender)
+	}
+	if cfg.Syslog.Enabled {
+		sender, err := newSyslogSender(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender)
+	}
+	if len(senders) == 0 {
+		logger.L().Warning("no alert exporter is enabled, the alerts are dropped")
+	}
+	return createAlertExporters(cfg, senders), nil
+}
+
+func createAlertExporters(cfg config.ExportersConfig, senders []alertSender) *AlertExporters {
+	ae := &AlertExporters{}
+	if cfg.RateLimit > 0 {
+		burst := cfg.RateBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		ae.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+	for _, sender := range senders {
+		ae.exporters = append(ae.exporters, newBatchExporter(cfg, sender))
+	}
+	return ae
+}
+
+func (ae *AlertExporters) SendAlert(alert exporters.Alert) {
+	if ae.limiter != nil && !ae.limiter.Allow() {
+		if ae.dropped.Add(1) == 1 {
+			logger.L().Warning("too many alerts, alerts are dropped", helpers.String("rule", alert.RuleName))
+		}
+		return
+	}
+	if dropped := ae.dropped.Swap(0); dropped > 1 {
+		logger.L().Warning("alerts have been dropped by the rate limit", helpers.Int("alerts", int(dropped)))
+	}
+	for _, exporter := range ae.exporters {
+		exporter.sendAlert(alert)
+	}
+}
+
+func (ae *AlertExporters) Stop() {
+	for _, exporter := range ae.exporters {
+		exporter.stop()
+	}
+}
+
+// batchExporter queues the alerts of a sender and sends them in batches from its own goroutine
+type batchExporter struct {
+	sender        alertSender
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryInterval time.Duration
+	queue         chan exporters.Alert
+	// stopped is set under queueLock once the queue is closed
+	stopped   bool
+	queueLock sync.RWMutex
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newBatchExporter(cfg config.ExportersConfig, sender alertSender) *batchExporter {
+	e := &batchExporter{
+		sender:        sender,
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		maxRetries:    cfg.MaxRetries,
+		retryInterval: cfg.RetryInterval,
+		queue:         make(chan exporters.Alert, alertQueueSize),
+		done:          make(chan struct{}),
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	go e.run()
+	return e
+}
+
+func (e *batchExporter) sendAlert(alert exporters.Alert) {
+	e.queueLock.RLock()
+	defer e.queueLock.RUnlock()
+	if e.stopped {
+		return
+	}
+	select {
+	case e.queue <- alert:
+	default:
+		logger.L().Warning("alert queue is full, alert is dropped", helpers.String("exporter", e.sender.name()), helpers.String("rule", alert.RuleName))
+	}
+}
+
+func (e *batchExporter) stop() {
+	e.stopOnce.Do(func() {
+		e.queueLock.Lock()
+		e.stopped = true
+		close(e.queue)
+		e.queueLock.Unlock()
+		<-e.done
+		if err := e.sender.close(); err != nil {
+			logger.L().Warning("failed to close exporter", helpers.String("exporter", e.sender.name()), helpers.Error(err))
+		}
+	})
+}
+
+func (e *batchExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	var batch []exporters.Alert
+	for {
+		select {
+		case alert, ok := <-e.queue:
+			if !ok {
+				e.flush(batch)
+				return
+			}
+			batch = append(batch, alert)
+			if len(batch) >= e.batchSize {
+				e.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush sends a batch, the alerts not sent by a failed attempt are retried with an exponential backoff
+func (e *batchExporter) flush(batch []exporters.Alert) {
+	if len(batch) == 0 {
+		return
+	}
+	backoff := e.retryInterval
+	for attempt := 0; ; attempt++ {
+		sent, err := e.sender.send(context.Background(), batch)
+		if err == nil {
+			return
+		}
+		batch = batch[sent:]
+		if attempt >= e.maxRetries {
+			logger.L().Warning("failed to export alerts, alerts are dropped", helpers.String("exporter", e.sender.name()), helpers.Int("alerts", len(batch)), helpers.Error(err))
+			return
+		}
+		logger.L().Debug("failed to export alerts, retrying", helpers.String("exporter", e.sender.name()), helpers.Int("attempt", attempt+1), helpers.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,167 @@
+package exporters
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// senderMock records the batches it gets, the first failures attempts fail after sending partial alerts
+type senderMock struct {
+	mutex    sync.Mutex
+	batches  [][]exporters.Alert
+	failures int
+	partial  int
+	attempts int
+	closed   bool
+}
+
+func (s *senderMock) name() string {
+	return "mock"
+}
+
+func (s *senderMock) send(_ context.Context, alerts []exporters.Alert) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		if s.partial > 0 {
+			s.batches = append(s.batches, append([]exporters.Alert(nil), alerts[:s.partial]...))
+		}
+		return s.partial, fmt.Errorf("attempt %d failed", s.attempts)
+	}
+	s.batches = append(s.batches, append([]exporters.Alert(nil), alerts...))
+	return len(alerts), nil
+}
+
+func (s *senderMock) close() error {
+	s.closed = true
+	return nil
+}
+
+func alertMock(i int) exporters.Alert {
+	return exporters.Alert{RuleName: "rule", Severity: exporters.SeverityHigh, Path: fmt.Sprintf("/tmp/%d", i)}
+}
+
+func TestAlertExportersBatches(t *testing.T) {
+	sender := &senderMock{}
+	ae := createAlertExporters(config.ExportersConfig{BatchSize: 2, FlushInterval: time.Hour}, []alertSender{sender})
+	for i := 0; i < 5; i++ {
+		ae.SendAlert(alertMock(i))
+	}
+	// the last alert is sent when the exporter stops
+	ae.Stop()
+	assert.True(t, sender.closed)
+	if assert.Len(t, sender.batches, 3) {
+		assert.Len(t, sender.batches[0], 2)
+		assert.Len(t, sender.batches[2], 1)
+		assert.Equal(t, "/tmp/4", sender.batches[2][0].Path)
+	}
+	// alerts sent after stop are dropped
+	ae.SendAlert(alertMock(5))
+	assert.Len(t, sender.batches, 3)
+}
+
+func TestAlertExportersFlushInterval(t *testing.T) {
+	sender := &senderMock{}
+	ae := createAlertExporters(config.ExportersConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond}, []alertSender{sender})
+	defer ae.Stop()
+	ae.SendAlert(alertMock(0))
+	assert.Eventually(t, func() bool {
+		sender.mutex.Lock()
+		defer sender.mutex.Unlock()
+		return len(sender.batches) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAlertExportersRetries(t *testing.T) {
+	// the batch succeeds on the third attempt
+	sender := &senderMock{failures: 2}
+	ae := createAlertExporters(config.ExportersConfig{MaxRetries: 2, RetryInterval: time.Millisecond}, []alertSender{sender})
+	ae.SendAlert(alertMock(0))
+	ae.Stop()
+	assert.Equal(t, 3, sender.attempts)
+	assert.Len(t, sender.batches, 1)
+
+	// the batch is dropped after the retries
+	sender = &senderMock{failures: 10}
+	ae = createAlertExporters(config.ExportersConfig{MaxRetries: 2, RetryInterval: time.Millisecond}, []alertSender{sender})
+	ae.SendAlert(alertMock(0))
+	ae.Stop()
+	assert.Equal(t, 3, sender.attempts)
+	assert.Empty(t, sender.batches)
+}
+
+func TestAlertExportersPartialRetry(t *testing.T) {
+	// the first attempt sends two alerts of the batch before failing, only the last one is sent again
+	sender := &senderMock{failures: 1, partial: 2}
+	ae := createAlertExporters(config.ExportersConfig{BatchSize: 3, FlushInterval: time.Hour, MaxRetries: 1, RetryInterval: time.Millisecond}, []alertSender{sender})
+	for i := 0; i < 3; i++ {
+		ae.SendAlert(alertMock(i))
+	}
+	ae.Stop()
+	assert.Equal(t, 2, sender.attempts)
+	var paths []string
+	for _, batch := range sender.batches {
+		for _, alert := range batch {
+			paths = append(paths, alert.Path)
+		}
+	}
+	assert.Equal(t, []string{"/tmp/0", "/tmp/1", "/tmp/2"}, paths)
+}
+
+func TestAlertExportersRateLimit(t *testing.T) {
+	first, second := &senderMock{}, &senderMock{}
+	ae := createAlertExporters(config.ExportersConfig{BatchSize: 100, FlushInterval: time.Hour, RateLimit: 0.001, RateBurst: 3}, []alertSender{first, second})
+	for i := 0; i < 10; i++ {
+		ae.SendAlert(alertMock(i))
+	}
+	ae.Stop()
+	// each exporter gets the alerts of the burst
+	for _, sender := range []*senderMock{first, second} {
+		if assert.Len(t, sender.batches, 1) {
+			assert.Len(t, sender.batches[0], 3)
+		}
+	}
+	assert.Equal(t, uint64(7), ae.dropped.Load())
+}
+
+func TestCreateAlertExporters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	ae, err := CreateAlertExporters(config.ExportersConfig{File: path, BatchSize: 10})
+	if err != nil {
+		t.Fatalf("fail to create exporters, err: %v", err)
+	}
+	ae.SendAlert(alertMock(0))
+	ae.SendAlert(alertMock(1))
+	ae.Stop()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("fail to open alerts file, err: %v", err)
+	}
+	defer file.Close()
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var alert exporters.Alert
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &alert))
+		paths = append(paths, alert.Path)
+	}
+	assert.Equal(t, []string{"/tmp/0", "/tmp/1"}, paths)
+
+	_, err = CreateAlertExporters(config.ExportersConfig{File: filepath.Join(t.TempDir(), "missing", "alerts.json")})
+	assert.Error(t, err)
+	_, err = CreateAlertExporters(config.ExportersConfig{HTTP: config.HTTPExporterConfig{URL: "not a url"}})
+	assert.Error(t, err)
+}
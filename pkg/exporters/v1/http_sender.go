@@ -0,0 +1,72 @@
+package exporters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"time"
+)
+
+const defaultHTTPTimeout = 10 * time.Second
+
+// httpSender posts each batch of alerts as a JSON array to a URL, any status other than 2xx is a failure
+type httpSender struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+func newHTTPSender(cfg config.HTTPExporterConfig) (*httpSender, error) {
+	if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid alerts URL %s: %w", cfg.URL, err)
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	return &httpSender{
+		url:        cfg.URL,
+		headers:    cfg.Headers,
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *httpSender) name() string {
+	return "http"
+}
+
+func (s *httpSender) send(ctx context.Context, alerts []exporters.Alert) (int, error) {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return len(alerts), nil
+}
+
+func (s *httpSender) close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,53 @@
+package exporters
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSender(t *testing.T) {
+	var received []exporters.Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var alerts []exporters.Alert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(alerts) > 0 && alerts[0].Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, alerts...)
+	}))
+	defer server.Close()
+
+	sender, err := newHTTPSender(config.HTTPExporterConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err != nil {
+		t.Fatalf("fail to create http sender, err: %v", err)
+	}
+	defer sender.close()
+	sent, err := sender.send(context.TODO(), []exporters.Alert{alertMock(0), alertMock(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	_, err = sender.send(context.TODO(), []exporters.Alert{{Path: "/fail"}})
+	assert.Error(t, err)
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "/tmp/1", received[1].Path)
+	}
+
+	// the headers are required by the server
+	sender, _ = newHTTPSender(config.HTTPExporterConfig{URL: server.URL})
+	_, err = sender.send(context.TODO(), []exporters.Alert{alertMock(0)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package exporters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+)
+
+const defaultSyslogTag = "node-agent"
+
+// syslogSender writes each alert as a JSON syslog message, the message priority follows the alert severity
+type syslogSender struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSender(cfg config.SyslogExporterConfig) (*syslogSender, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_WARNING|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog %s: %w", cfg.Address, err)
+	}
+	return &syslogSender{writer: writer}, nil
+}
+
+func (s *syslogSender) name() string {
+	return "syslog"
+}
+
+// send writes one message per alert, the alerts written before a failure are not sent again
+func (s *syslogSender) send(_ context.Context, alerts []exporters.Alert) (int, error) {
+	for i, alert := range alerts {
+		message, err := json.Marshal(alert)
+		if err != nil {
+			return i, err
+		}
+		switch alert.Severity {
+		case exporters.SeverityCritical:
+			err = s.writer.Crit(string(message))
+		case exporters.SeverityHigh:
+			err = s.writer.Err(string(message))
+		case exporters.SeverityLow:
+			err = s.writer.Notice(string(message))
+		default:
+			err = s.writer.Warning(string(message))
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(alerts), nil
+}
+
+func (s *syslogSender) close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,47 @@
+package exporters
+
+import (
+	"context"
+	"net"
+	"node-agent/pkg/config"
+	"node-agent/pkg/exporters"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogSender(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen, err: %v", err)
+	}
+	defer conn.Close()
+
+	sender, err := newSyslogSender(config.SyslogExporterConfig{Enabled: true, Network: "udp", Address: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("fail to create syslog sender, err: %v", err)
+	}
+	defer sender.close()
+	alerts := []exporters.Alert{
+		{RuleName: "ReadShadow", Severity: exporters.SeverityCritical, Path: "/etc/shadow"},
+		{RuleName: "KubectlInPod", Severity: exporters.SeverityMedium, Path: "kubectl"},
+	}
+	if sent, err := sender.send(context.TODO(), alerts); err != nil || sent != len(alerts) {
+		t.Fatalf("fail to send alerts, err: %v", err)
+	}
+
+	// the priority is facility * 8 + severity, daemon is 3, crit is 2 and warning is 4
+	for _, want := range []string{"<26>", "<28>"} {
+		buf := make([]byte, 4096)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("fail to read syslog message, err: %v", err)
+		}
+		message := string(buf[:n])
+		assert.True(t, strings.HasPrefix(message, want), message)
+		assert.Contains(t, message, defaultSyslogTag)
+	}
+}
@@ -0,0 +1,57 @@
+package exporters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"node-agent/pkg/exporters"
+	"os"
+)
+
+// writerSender writes the alerts as JSON lines, to stdout or to a file
+type writerSender struct {
+	senderName string
+	writer     io.Writer
+	closer     io.Closer
+}
+
+func newWriterSender(name string, writer io.Writer) *writerSender {
+	return &writerSender{senderName: name, writer: writer}
+}
+
+// newFileSender appends the alerts to a file, it is created when it does not exist
+func newFileSender(path string) (*writerSender, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open alerts file %s: %w", path, err)
+	}
+	return &writerSender{senderName: "file", writer: file, closer: file}, nil
+}
+
+func (s *writerSender) name() string {
+	return s.senderName
+}
+
+func (s *writerSender) send(_ context.Context, alerts []exporters.Alert) (int, error) {
+	// encode the whole batch first so a failed batch is not written partially
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, alert := range alerts {
+		if err := encoder.Encode(alert); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := s.writer.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(alerts), nil
+}
+
+func (s *writerSender) close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
@@ -0,0 +1,7 @@
+package garbagecollector
+
+import "context"
+
+type GarbageCollectorClient interface {
+	StartGarbageCollector(ctx context.Context)
+}
@@ -0,0 +1,280 @@
+package garbagecollector
+
+import (
+	"context"
+	"fmt"
+	"node-agent/pkg/config"
+	"node-agent/pkg/garbagecollector"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"time"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"go.opentelemetry.io/otel"
+	apimachineryerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseName          = "node-agent-garbage-collector"
+	leaseDuration      = 60 * time.Second
+	leaseRenewDeadline = 15 * time.Second
+	leaseRetryPeriod   = 5 * time.Second
+
+	// OrphanedMetadataKey marks stored objects whose workload no longer exists when running in mark mode
+	OrphanedMetadataKey = "kubescape.io/orphaned"
+)
+
+// GarbageCollector removes (or marks) the filtered SBOMs, profiles and drift reports that belong to workloads that
+// were deleted.
+// All the node agents take part in a leader election so that only one of them performs the cleanup.
+type GarbageCollector struct {
+	cfg           config.Config
+	k8sClient     *k8sinterface.KubernetesApi
+	storageClient storageclient.StorageClient
+	nodeName      string
+	getWorkload   func(namespace, kind, name string) (k8sinterface.IWorkload, error)
+}
+
+var _ garbagecollector.GarbageCollectorClient = (*GarbageCollector)(nil)
+
+func CreateGarbageCollector(cfg config.Config, k8sClient *k8sinterface.KubernetesApi, storageClient storageclient.StorageClient, nodeName string) (*GarbageCollector, error) {
+	if cfg.GarbageCollection.Mode != config.GarbageCollectionModeDelete && cfg.GarbageCollection.Mode != config.GarbageCollectionModeMark {
+		return nil, fmt.Errorf("unsupported garbage collection mode %s", cfg.GarbageCollection.Mode)
+	}
+	if cfg.GarbageCollection.Interval <= 0 {
+		return nil, fmt.Errorf("garbage collection interval must be positive")
+	}
+	return &GarbageCollector{
+		cfg:           cfg,
+		k8sClient:     k8sClient,
+		storageClient: storageClient,
+		nodeName:      nodeName,
+		getWorkload:   k8sClient.GetWorkload,
+	}, nil
+}
+
+func (gc *GarbageCollector) StartGarbageCollector(ctx context.Context) {
+	go gc.runLeaderElection(ctx)
+}
+
+func (gc *GarbageCollector) runLeaderElection(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: storageclient.KubescapeNamespace,
+		},
+		Client: gc.k8sClient.KubernetesClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: gc.nodeName,
+		},
+	}
+	// RunOrDie returns when the leadership is lost, try again until the context is done
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			ReleaseOnCancel: true,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   leaseRenewDeadline,
+			RetryPeriod:     leaseRetryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					logger.L().Info("garbage collector elected as leader", helpers.String("node", gc.nodeName))
+					gc.run(ctx)
+				},
+				OnStoppedLeading: func() {
+					logger.L().Info("garbage collector lost leadership", helpers.String("node", gc.nodeName))
+				},
+			},
+		})
+	}
+}
+
+func (gc *GarbageCollector) run(ctx context.Context) {
+	ticker := time.NewTicker(gc.cfg.GarbageCollection.Interval)
+	defer ticker.Stop()
+	for {
+		gc.collect(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// storedKind is a kind of object the node agent keeps for a workload container
+type storedKind struct {
+	name string
+	// list returns all the objects of the kind, the storage client reads them by pages but a collection holds the
+	// objects of a kind in memory, about one object per watched container of the cluster
+	list   func(ctx context.Context) ([]metav1.Object, error)
+	delete func(ctx context.Context, key string) error
+	update func(ctx context.Context, object metav1.Object) error
+}
+
+// storedObjects returns the items of a list as objects
+func storedObjects[T any, P interface {
+	*T
+	metav1.Object
+}](list []T, err error) ([]metav1.Object, error) {
+	objects := make([]metav1.Object, 0, len(list))
+	for i := range list {
+		objects = append(objects, P(&list[i]))
+	}
+	return objects, err
+}
+
+func (gc *GarbageCollector) storedKinds() []storedKind {
+	return []storedKind{
+		{
+			name: "filtered SBOM",
+			list: func(ctx context.Context) ([]metav1.Object, error) {
+				return storedObjects(gc.storageClient.ListFilteredSBOMs(ctx))
+			},
+			delete: gc.storageClient.DeleteFilteredSBOM,
+			update: func(ctx context.Context, object metav1.Object) error {
+				return gc.storageClient.UpdateFilteredSBOM(ctx, object.GetName(), object.(*spdxv1beta1.SBOMSPDXv2p3Filtered))
+			},
+		},
+		{
+			name: "CycloneDX filtered SBOM",
+			list: func(ctx context.Context) ([]metav1.Object, error) {
+				return storedObjects(gc.storageClient.ListFilteredSBOMsCycloneDX(ctx))
+			},
+			delete: gc.storageClient.DeleteFilteredSBOMCycloneDX,
+			update: func(ctx context.Context, object metav1.Object) error {
+				return gc.storageClient.UpdateFilteredSBOMCycloneDX(ctx, object.GetName(), object.(*storageclient.SBOMCycloneDXFiltered))
+			},
+		},
+		{
+			name: "application profile",
+			list: func(ctx context.Context) ([]metav1.Object, error) {
+				return storedObjects(gc.storageClient.ListApplicationProfiles(ctx))
+			},
+			delete: gc.storageClient.DeleteApplicationProfile,
+			update: func(ctx context.Context, object metav1.Object) error {
+				return gc.storageClient.UpdateApplicationProfile(ctx, object.GetName(), object.(*storageclient.ApplicationProfile))
+			},
+		},
+		{
+			name: "network profile",
+			list: func(ctx context.Context) ([]metav1.Object, error) {
+				return storedObjects(gc.storageClient.ListNetworkProfiles(ctx))
+			},
+			delete: gc.storageClient.DeleteNetworkProfile,
+			update: func(ctx context.Context, object metav1.Object) error {
+				return gc.storageClient.UpdateNetworkProfile(ctx, object.GetName(), object.(*storageclient.NetworkProfile))
+			},
+		},
+		{
+			name: "drift report",
+			list: func(ctx context.Context) ([]metav1.Object, error) {
+				return storedObjects(gc.storageClient.ListDriftReports(ctx))
+			},
+			delete: gc.storageClient.DeleteDriftReport,
+			update: func(ctx context.Context, object metav1.Object) error {
+				return gc.storageClient.UpdateDriftReport(ctx, object.GetName(), object.(*storageclient.DriftReport))
+			},
+		},
+	}
+}
+
+func (gc *GarbageCollector) collect(ctx context.Context) {
+	ctx, span := otel.Tracer("").Start(ctx, "GarbageCollector.collect")
+	defer span.End()
+
+	for _, kind := range gc.storedKinds() {
+		gc.collectKind(ctx, kind)
+	}
+}
+
+func (gc *GarbageCollector) collectKind(ctx context.Context, kind storedKind) {
+	objects, err := kind.list(ctx)
+	if err != nil {
+		logger.L().Ctx(ctx).Warning("failed to list stored objects", helpers.String("kind", kind.name), helpers.Error(err))
+		return
+	}
+	for _, object := range objects {
+		orphaned, err := gc.isOrphaned(object.GetAnnotations())
+		if err != nil {
+			logger.L().Debug("failed to check stored object workload", helpers.String("kind", kind.name), helpers.String("name", object.GetName()), helpers.Error(err))
+			continue
+		}
+		if !orphaned || object.GetAnnotations()[OrphanedMetadataKey] == "true" {
+			continue
+		}
+		switch gc.cfg.GarbageCollection.Mode {
+		case config.GarbageCollectionModeDelete:
+			err = kind.delete(ctx, object.GetName())
+		case config.GarbageCollectionModeMark:
+			err = gc.markOrphaned(ctx, kind, object)
+		}
+		if err != nil && !storageclient.IsNotFound(err) {
+			logger.L().Ctx(ctx).Warning("failed to clean orphaned stored object", helpers.String("kind", kind.name), helpers.String("name", object.GetName()), helpers.Error(err))
+			continue
+		}
+		logger.L().Info("orphaned stored object cleaned", helpers.String("kind", kind.name), helpers.String("name", object.GetName()), helpers.String("mode", gc.cfg.GarbageCollection.Mode))
+	}
+}
+
+// isOrphaned returns true if the workload container an object was created for does not exist anymore, the container
+// is read from the instance ID annotation of the object
+func (gc *GarbageCollector) isOrphaned(annotations map[string]string) (bool, error) {
+	instanceIDString, ok := annotations[instanceidhandlerV1.InstanceIDMetadataKey]
+	if !ok {
+		return false, fmt.Errorf("missing %s annotation", instanceidhandlerV1.InstanceIDMetadataKey)
+	}
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDString)
+	if err != nil {
+		return false, err
+	}
+	wl, err := gc.getWorkload(instanceID.GetNamespace(), instanceID.GetKind(), instanceID.GetName())
+	if err != nil {
+		if apimachineryerrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	workload, ok := wl.(*workloadinterface.Workload)
+	if !ok {
+		return false, nil
+	}
+	containers, err := workload.GetContainers()
+	if err != nil {
+		return false, err
+	}
+	initContainers, err := workload.GetInitContainers()
+	if err != nil {
+		return false, err
+	}
+	for _, container := range append(containers, initContainers...) {
+		if container.Name == instanceID.GetContainerName() {
+			return false, nil
+		}
+	}
+	// the ephemeral containers are only in the pods
+	if workload.GetKind() == "Pod" {
+		if _, err := utils.GetPodContainer(workload, instanceID.GetContainerName()); err == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (gc *GarbageCollector) markOrphaned(ctx context.Context, kind storedKind, object metav1.Object) error {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[OrphanedMetadataKey] = "true"
+	object.SetAnnotations(annotations)
+	return kind.update(ctx, object)
+}
@@ -0,0 +1,166 @@
+package garbagecollector
+
+import (
+	"context"
+	"node-agent/pkg/config"
+	"node-agent/pkg/storageclient"
+	"testing"
+
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/stretchr/testify/assert"
+	apimachineryerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// storageClientMock serves the List, Update and Delete calls of the garbage collector, there are no network profiles
+// nor drift reports
+type storageClientMock struct {
+	storageclient.StorageClient
+	filteredSBOMs          map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered
+	filteredSBOMsCycloneDX map[string]*storageclient.SBOMCycloneDXFiltered
+	applicationProfiles    map[string]*storageclient.ApplicationProfile
+}
+
+func (sc *storageClientMock) ListFilteredSBOMs(_ context.Context) ([]spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	var list []spdxv1beta1.SBOMSPDXv2p3Filtered
+	for _, filteredSBOM := range sc.filteredSBOMs {
+		list = append(list, *filteredSBOM.DeepCopy())
+	}
+	return list, nil
+}
+func (sc *storageClientMock) UpdateFilteredSBOM(_ context.Context, key string, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	sc.filteredSBOMs[key] = SBOM
+	return nil
+}
+func (sc *storageClientMock) DeleteFilteredSBOM(_ context.Context, key string) error {
+	delete(sc.filteredSBOMs, key)
+	return nil
+}
+func (sc *storageClientMock) ListFilteredSBOMsCycloneDX(_ context.Context) ([]storageclient.SBOMCycloneDXFiltered, error) {
+	var list []storageclient.SBOMCycloneDXFiltered
+	for _, filteredSBOM := range sc.filteredSBOMsCycloneDX {
+		list = append(list, storageclient.SBOMCycloneDXFiltered{ObjectMeta: *filteredSBOM.ObjectMeta.DeepCopy()})
+	}
+	return list, nil
+}
+func (sc *storageClientMock) UpdateFilteredSBOMCycloneDX(_ context.Context, key string, SBOM *storageclient.SBOMCycloneDXFiltered) error {
+	sc.filteredSBOMsCycloneDX[key] = SBOM
+	return nil
+}
+func (sc *storageClientMock) DeleteFilteredSBOMCycloneDX(_ context.Context, key string) error {
+	delete(sc.filteredSBOMsCycloneDX, key)
+	return nil
+}
+func (sc *storageClientMock) ListApplicationProfiles(_ context.Context) ([]storageclient.ApplicationProfile, error) {
+	var list []storageclient.ApplicationProfile
+	for _, profile := range sc.applicationProfiles {
+		list = append(list, storageclient.ApplicationProfile{ObjectMeta: *profile.ObjectMeta.DeepCopy()})
+	}
+	return list, nil
+}
+func (sc *storageClientMock) UpdateApplicationProfile(_ context.Context, key string, profile *storageclient.ApplicationProfile) error {
+	sc.applicationProfiles[key] = profile
+	return nil
+}
+func (sc *storageClientMock) DeleteApplicationProfile(_ context.Context, key string) error {
+	delete(sc.applicationProfiles, key)
+	return nil
+}
+func (sc *storageClientMock) ListNetworkProfiles(_ context.Context) ([]storageclient.NetworkProfile, error) {
+	return nil, nil
+}
+func (sc *storageClientMock) ListDriftReports(_ context.Context) ([]storageclient.DriftReport, error) {
+	return nil, nil
+}
+
+func filteredSBOMMock(name, instanceID string) *spdxv1beta1.SBOMSPDXv2p3Filtered {
+	filteredSBOM := &spdxv1beta1.SBOMSPDXv2p3Filtered{}
+	filteredSBOM.SetName(name)
+	filteredSBOM.SetAnnotations(map[string]string{instanceidhandlerV1.InstanceIDMetadataKey: instanceID})
+	return filteredSBOM
+}
+
+func getWorkloadMock(namespace, kind, name string) (k8sinterface.IWorkload, error) {
+	if name != "nginx" {
+		return nil, apimachineryerrors.NewNotFound(schema.GroupResource{Resource: kind}, name)
+	}
+	return workloadinterface.NewWorkloadObj(map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "nginx", "image": "nginx"},
+					},
+				},
+			},
+		},
+	}), nil
+}
+
+func createGarbageCollectorMock(t *testing.T, mode string) (*GarbageCollector, *storageClientMock) {
+	storageClient := &storageClientMock{
+		filteredSBOMs: map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered{
+			"live":              filteredSBOMMock("live", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-nginx/containerName-nginx"),
+			"deleted-workload":  filteredSBOMMock("deleted-workload", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-redis/containerName-redis"),
+			"deleted-container": filteredSBOMMock("deleted-container", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-nginx/containerName-sidecar"),
+			"no-annotation":     {},
+		},
+		filteredSBOMsCycloneDX: map[string]*storageclient.SBOMCycloneDXFiltered{
+			"live":             {ObjectMeta: filteredSBOMMock("live", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-nginx/containerName-nginx").ObjectMeta},
+			"deleted-workload": {ObjectMeta: filteredSBOMMock("deleted-workload", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-redis/containerName-redis").ObjectMeta},
+		},
+		applicationProfiles: map[string]*storageclient.ApplicationProfile{
+			"application-live":             {ObjectMeta: filteredSBOMMock("application-live", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-nginx/containerName-nginx").ObjectMeta},
+			"application-deleted-workload": {ObjectMeta: filteredSBOMMock("application-deleted-workload", "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-redis/containerName-redis").ObjectMeta},
+		},
+	}
+	cfg := config.Config{GarbageCollection: config.GarbageCollectionConfig{Enabled: true, Interval: 1, Mode: mode}}
+	gc, err := CreateGarbageCollector(cfg, nil, storageClient, "node")
+	if err != nil {
+		t.Fatalf("fail to create garbage collector, err: %v", err)
+	}
+	gc.getWorkload = getWorkloadMock
+	return gc, storageClient
+}
+
+func TestCollectDelete(t *testing.T) {
+	gc, storageClient := createGarbageCollectorMock(t, config.GarbageCollectionModeDelete)
+	gc.collect(context.TODO())
+
+	assert.Contains(t, storageClient.filteredSBOMs, "live")
+	assert.Contains(t, storageClient.filteredSBOMs, "no-annotation")
+	assert.NotContains(t, storageClient.filteredSBOMs, "deleted-workload")
+	assert.NotContains(t, storageClient.filteredSBOMs, "deleted-container")
+	assert.Contains(t, storageClient.filteredSBOMsCycloneDX, "live")
+	assert.NotContains(t, storageClient.filteredSBOMsCycloneDX, "deleted-workload")
+	assert.Contains(t, storageClient.applicationProfiles, "application-live")
+	assert.NotContains(t, storageClient.applicationProfiles, "application-deleted-workload")
+}
+
+func TestCollectMark(t *testing.T) {
+	gc, storageClient := createGarbageCollectorMock(t, config.GarbageCollectionModeMark)
+	gc.collect(context.TODO())
+
+	assert.Len(t, storageClient.filteredSBOMs, 4)
+	assert.Empty(t, storageClient.filteredSBOMs["live"].GetAnnotations()[OrphanedMetadataKey])
+	assert.Equal(t, "true", storageClient.filteredSBOMs["deleted-workload"].GetAnnotations()[OrphanedMetadataKey])
+	assert.Equal(t, "true", storageClient.filteredSBOMs["deleted-container"].GetAnnotations()[OrphanedMetadataKey])
+	assert.Empty(t, storageClient.filteredSBOMsCycloneDX["live"].GetAnnotations()[OrphanedMetadataKey])
+	assert.Equal(t, "true", storageClient.filteredSBOMsCycloneDX["deleted-workload"].GetAnnotations()[OrphanedMetadataKey])
+	assert.Equal(t, "true", storageClient.applicationProfiles["application-deleted-workload"].GetAnnotations()[OrphanedMetadataKey])
+}
+
+func TestCreateGarbageCollectorInvalidMode(t *testing.T) {
+	cfg := config.Config{GarbageCollection: config.GarbageCollectionConfig{Enabled: true, Interval: 1, Mode: "unknown"}}
+	_, err := CreateGarbageCollector(cfg, nil, &storageClientMock{}, "node")
+	assert.Error(t, err)
+}
@@ -0,0 +1,13 @@
+package imageresolver
+
+import "context"
+
+// ImageResolverClient gives the image ID of a container as a repository digest, <repository>@sha256:<digest>
+type ImageResolverClient interface {
+	// ResolveImageID normalizes imageID, the image ID of the pod status, and asks the container runtime for the digest
+	// when it is empty or not a digest
+	ResolveImageID(ctx context.Context, containerID, imageTag, imageID string) (string, error)
+	// ResolveContainerImage gives the image tag and the image ID of a container without a pod, the container runtime
+	// named runtime is asked first when it is not empty
+	ResolveContainerImage(ctx context.Context, runtime, containerID string) (string, string, error)
+}
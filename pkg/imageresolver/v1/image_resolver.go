@@ -0,0 +1,162 @@
+package imageresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"node-agent/pkg/imageresolver"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const criTimeout = 5 * time.Second
+
+// criSocketPaths are the default CRI sockets of containerd, k3s and CRI-O, relative to the host root
+var criSocketPaths = []string{
+	"/run/containerd/containerd.sock",
+	"/run/k3s/containerd/containerd.sock",
+	"/run/crio/crio.sock",
+	"/var/run/crio/crio.sock",
+}
+
+// digestPattern matches the image IDs that are repository digests
+var digestPattern = regexp.MustCompile(`^[^@]+@sha256:[a-f0-9]{64}$`)
+
+// imageIDPrefixes are the runtime specific prefixes of the image IDs of the pod status
+var imageIDPrefixes = []string{"docker-pullable://", "docker://", "containerd://", "cri-o://"}
+
+// CRIImageResolver resolves the image digests with the CRI API of the container runtime, without a runtime the image
+// IDs are only normalized
+type CRIImageResolver struct {
+	runtimeClient runtimeapi.RuntimeServiceClient
+	imageClient   runtimeapi.ImageServiceClient
+}
+
+var _ imageresolver.ImageResolverClient = (*CRIImageResolver)(nil)
+
+// CreateCRIImageResolver connects to the CRI socket, the default sockets are looked for under hostRoot when socketPath
+// is empty
+func CreateCRIImageResolver(socketPath, hostRoot string) (*CRIImageResolver, error) {
+	if socketPath == "" {
+		for _, path := range criSocketPaths {
+			if _, err := os.Stat(filepath.Join(hostRoot, path)); err == nil {
+				socketPath = filepath.Join(hostRoot, path)
+				break
+			}
+		}
+	}
+	if socketPath == "" {
+		logger.L().Info("no CRI socket found, the image IDs are not resolved with the container runtime")
+		return &CRIImageResolver{}, nil
+	}
+	conn, err := grpc.Dial(socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			d := net.Dialer{Timeout: criTimeout}
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CRI socket %s: %w", socketPath, err)
+	}
+	logger.L().Info("image IDs are resolved with the container runtime", helpers.String("socket", socketPath))
+	return &CRIImageResolver{
+		runtimeClient: runtimeapi.NewRuntimeServiceClient(conn),
+		imageClient:   runtimeapi.NewImageServiceClient(conn),
+	}, nil
+}
+
+// normalizeImageID removes the runtime prefix of an image ID, it reports whether the image ID is a repository digest
+func normalizeImageID(imageID string) (string, bool) {
+	for _, prefix := range imageIDPrefixes {
+		imageID = strings.TrimPrefix(imageID, prefix)
+	}
+	return imageID, digestPattern.MatchString(imageID)
+}
+
+func (r *CRIImageResolver) ResolveImageID(ctx context.Context, containerID, imageTag, imageID string) (string, error) {
+	normalized, ok := normalizeImageID(imageID)
+	if ok {
+		return normalized, nil
+	}
+	if r.runtimeClient == nil {
+		// without a runtime the image ID of the pod status is the best key there is
+		if normalized != "" {
+			return normalized, nil
+		}
+		return "", fmt.Errorf("image ID of container %s is empty", containerID)
+	}
+	ctx, cancel := context.WithTimeout(ctx, criTimeout)
+	defer cancel()
+	status, err := r.runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+	if err != nil {
+		return "", fmt.Errorf("failed to get status of container %s: %w", containerID, err)
+	}
+	imageRef := status.GetStatus().GetImageRef()
+	if normalized, ok = normalizeImageID(imageRef); ok {
+		return normalized, nil
+	}
+	// the image reference is the ID of the image config, the digests are in the image status
+	image := imageRef
+	if image == "" {
+		image = status.GetStatus().GetImage().GetImage()
+	}
+	imageStatus, err := r.imageClient.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{Image: &runtimeapi.ImageSpec{Image: image}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get status of image %s: %w", image, err)
+	}
+	return selectRepoDigest(imageStatus.GetImage().GetRepoDigests(), imageTag)
+}
+
+func (r *CRIImageResolver) ResolveContainerImage(ctx context.Context, _, containerID string) (string, string, error) {
+	if r.runtimeClient == nil {
+		return "", "", fmt.Errorf("no CRI runtime to get the image of container %s", containerID)
+	}
+	statusCtx, cancel := context.WithTimeout(ctx, criTimeout)
+	defer cancel()
+	status, err := r.runtimeClient.ContainerStatus(statusCtx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get status of container %s: %w", containerID, err)
+	}
+	imageTag := status.GetStatus().GetImage().GetImage()
+	imageID, err := r.ResolveImageID(ctx, containerID, imageTag, status.GetStatus().GetImageRef())
+	if err != nil {
+		return "", "", err
+	}
+	return imageTag, imageID, nil
+}
+
+// selectRepoDigest returns the digest of the repository of the image tag, an image pushed to several repositories
+// has one digest per repository
+func selectRepoDigest(repoDigests []string, imageTag string) (string, error) {
+	var digests []string
+	for _, repoDigest := range repoDigests {
+		if normalized, ok := normalizeImageID(repoDigest); ok {
+			digests = append(digests, normalized)
+		}
+	}
+	if len(digests) == 0 {
+		return "", errors.New("image has no repository digest")
+	}
+	named, err := reference.ParseNormalizedNamed(imageTag)
+	if err != nil {
+		return digests[0], nil
+	}
+	for _, digest := range digests {
+		if digestNamed, err := reference.ParseNormalizedNamed(digest); err == nil && digestNamed.Name() == named.Name() {
+			return digest, nil
+		}
+	}
+	return digests[0], nil
+}
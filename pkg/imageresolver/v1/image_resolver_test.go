@@ -0,0 +1,154 @@
+package imageresolver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	digestMock   = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	imageIDMock  = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	nginxDigest  = "docker.io/library/nginx@" + digestMock
+	mirrorDigest = "registry.example.com/nginx@" + digestMock
+)
+
+// runtimeClientMock gives the status of the containers, the other methods are not used by the resolver
+type runtimeClientMock struct {
+	runtimeapi.RuntimeServiceClient
+	statuses map[string]*runtimeapi.ContainerStatus
+}
+
+func (rc *runtimeClientMock) ContainerStatus(_ context.Context, in *runtimeapi.ContainerStatusRequest, _ ...grpc.CallOption) (*runtimeapi.ContainerStatusResponse, error) {
+	status, ok := rc.statuses[in.ContainerId]
+	if !ok {
+		return nil, errors.New("container not found")
+	}
+	return &runtimeapi.ContainerStatusResponse{Status: status}, nil
+}
+
+// imageClientMock gives the status of the images, the other methods are not used by the resolver
+type imageClientMock struct {
+	runtimeapi.ImageServiceClient
+	images map[string]*runtimeapi.Image
+}
+
+func (ic *imageClientMock) ImageStatus(_ context.Context, in *runtimeapi.ImageStatusRequest, _ ...grpc.CallOption) (*runtimeapi.ImageStatusResponse, error) {
+	return &runtimeapi.ImageStatusResponse{Image: ic.images[in.Image.Image]}, nil
+}
+
+func TestResolveImageID(t *testing.T) {
+	resolver := &CRIImageResolver{
+		runtimeClient: &runtimeClientMock{statuses: map[string]*runtimeapi.ContainerStatus{
+			"containerd": {ImageRef: nginxDigest},
+			"crio":       {ImageRef: imageIDMock, Image: &runtimeapi.ImageSpec{Image: "nginx:1.25"}},
+			"local":      {ImageRef: "sha256:abc"},
+		}},
+		imageClient: &imageClientMock{images: map[string]*runtimeapi.Image{
+			imageIDMock:  {Id: imageIDMock, RepoDigests: []string{mirrorDigest, nginxDigest}},
+			"sha256:abc": {Id: "sha256:abc"},
+		}},
+	}
+	tests := []struct {
+		name        string
+		containerID string
+		imageTag    string
+		imageID     string
+		want        string
+		wantErr     bool
+	}{
+		{name: "digest", containerID: "unknown", imageTag: "nginx:1.25", imageID: nginxDigest, want: nginxDigest},
+		{name: "docker pullable", containerID: "unknown", imageTag: "nginx:1.25", imageID: "docker-pullable://" + nginxDigest, want: nginxDigest},
+		{name: "empty image ID", containerID: "containerd", imageTag: "nginx:1.25", want: nginxDigest},
+		{name: "image config ID", containerID: "crio", imageTag: "nginx:1.25", imageID: "docker://" + imageIDMock, want: nginxDigest},
+		{name: "mirror", containerID: "crio", imageTag: "registry.example.com/nginx:1.25", imageID: "nginx:1.25", want: mirrorDigest},
+		{name: "image not pushed", containerID: "local", imageTag: "app:dev", imageID: "sha256:abc", wantErr: true},
+		{name: "unknown container", containerID: "unknown", imageTag: "nginx:1.25", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.ResolveImageID(context.TODO(), tt.containerID, tt.imageTag, tt.imageID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveImageID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveImageIDWithoutRuntime(t *testing.T) {
+	resolver, err := CreateCRIImageResolver("", t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to create image resolver, err: %v", err)
+	}
+	imageID, err := resolver.ResolveImageID(context.TODO(), "abc", "nginx:1.25", "docker-pullable://"+nginxDigest)
+	if err != nil {
+		t.Fatalf("fail to resolve image ID, err: %v", err)
+	}
+	assert.Equal(t, nginxDigest, imageID)
+	// the image ID of the status is kept when it cannot be resolved
+	imageID, _ = resolver.ResolveImageID(context.TODO(), "abc", "app:dev", "docker://"+imageIDMock)
+	assert.Equal(t, imageIDMock, imageID)
+	_, err = resolver.ResolveImageID(context.TODO(), "abc", "nginx:1.25", "")
+	assert.Error(t, err)
+}
+
+// runtimeImageClientMock gives the images of the containers of a runtime
+type runtimeImageClientMock struct {
+	images map[string]*containerImage
+}
+
+func (rc *runtimeImageClientMock) getContainerImage(_ context.Context, containerID string) (*containerImage, error) {
+	image, ok := rc.images[containerID]
+	if !ok {
+		return nil, errContainerNotFound
+	}
+	return image, nil
+}
+
+func TestResolveContainerImage(t *testing.T) {
+	resolver := &RuntimeImageResolver{clients: []namedRuntimeClient{
+		{name: "docker", client: &runtimeImageClientMock{images: map[string]*containerImage{
+			"nginx": {tag: "nginx:1.25", id: imageIDMock, repoDigests: []string{mirrorDigest, nginxDigest}},
+			"local": {tag: "app:dev", id: imageIDMock},
+		}}},
+		{name: "containerd", client: &runtimeImageClientMock{images: map[string]*containerImage{
+			"mirror": {tag: "registry.example.com/nginx:1.25", id: digestMock, repoDigests: []string{mirrorDigest}},
+		}}},
+	}}
+	tests := []struct {
+		name        string
+		runtime     string
+		containerID string
+		wantTag     string
+		wantID      string
+		wantErr     bool
+	}{
+		{name: "docker", runtime: "docker", containerID: "nginx", wantTag: "nginx:1.25", wantID: nginxDigest},
+		{name: "containerd", runtime: "containerd", containerID: "mirror", wantTag: "registry.example.com/nginx:1.25", wantID: mirrorDigest},
+		{name: "unknown runtime", containerID: "mirror", wantTag: "registry.example.com/nginx:1.25", wantID: mirrorDigest},
+		{name: "image not pushed", runtime: "docker", containerID: "local", wantTag: "app:dev", wantID: imageIDMock},
+		{name: "wrong runtime", runtime: "docker", containerID: "mirror", wantErr: true},
+		{name: "unknown container", containerID: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageTag, imageID, err := resolver.ResolveContainerImage(context.TODO(), tt.runtime, tt.containerID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveContainerImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantTag, imageTag)
+			assert.Equal(t, tt.wantID, imageID)
+		})
+	}
+}
+
+func TestCreateRuntimeImageResolver(t *testing.T) {
+	_, err := CreateRuntimeImageResolver(filepath.Join(t.TempDir(), "docker.sock"), filepath.Join(t.TempDir(), "containerd.sock"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+package imageresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"node-agent/pkg/imageresolver"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/docker/distribution/reference"
+	dockerclient "github.com/docker/docker/client"
+	runtimeclient "github.com/inspektor-gadget/inspektor-gadget/pkg/container-utils/runtime-client"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+const runtimeTimeout = 5 * time.Second
+
+var errContainerNotFound = errors.New("container not found")
+
+// containerImage is the image a container was created from
+type containerImage struct {
+	// tag is the image reference the container was created with
+	tag string
+	// id is the ID of the image, the ID of its config for docker and the digest of its manifest for containerd
+	id string
+	// repoDigests are the digests of the image in the repositories it was pulled from
+	repoDigests []string
+}
+
+// runtimeClient gets the image of a container from a container runtime, it returns errContainerNotFound when the
+// container is not one of the runtime
+type runtimeClient interface {
+	getContainerImage(ctx context.Context, containerID string) (*containerImage, error)
+}
+
+// namedRuntimeClient is a runtime client and the name Inspektor Gadget gives to its runtime
+type namedRuntimeClient struct {
+	name   string
+	client runtimeClient
+}
+
+// RuntimeImageResolver resolves the images of the containers of a plain container host with the docker and containerd
+// APIs, the containers have no pod to read their image from
+type RuntimeImageResolver struct {
+	clients []namedRuntimeClient
+}
+
+var _ imageresolver.ImageResolverClient = (*RuntimeImageResolver)(nil)
+
+// CreateRuntimeImageResolver connects to the docker and containerd sockets that exist, one of them is needed
+func CreateRuntimeImageResolver(dockerSocket, containerdSocket string) (*RuntimeImageResolver, error) {
+	r := &RuntimeImageResolver{}
+	if _, err := os.Stat(dockerSocket); err == nil {
+		client, err := dockerclient.NewClientWithOpts(dockerclient.WithHost("unix://"+dockerSocket), dockerclient.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create docker client for socket %s: %w", dockerSocket, err)
+		}
+		r.clients = append(r.clients, namedRuntimeClient{name: runtimeclient.DockerName, client: &dockerRuntimeClient{client: client}})
+		logger.L().Info("images are resolved with docker", helpers.String("socket", dockerSocket))
+	}
+	if _, err := os.Stat(containerdSocket); err == nil {
+		client, err := containerd.New(containerdSocket, containerd.WithTimeout(runtimeTimeout))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to containerd socket %s: %w", containerdSocket, err)
+		}
+		r.clients = append(r.clients, namedRuntimeClient{name: runtimeclient.ContainerdName, client: &containerdRuntimeClient{client: client}})
+		logger.L().Info("images are resolved with containerd", helpers.String("socket", containerdSocket))
+	}
+	if len(r.clients) == 0 {
+		return nil, fmt.Errorf("neither docker socket %s nor containerd socket %s found", dockerSocket, containerdSocket)
+	}
+	return r, nil
+}
+
+// getContainerImage asks the runtime of the container for its image, each runtime is asked when the container was not
+// enriched with its runtime
+func (r *RuntimeImageResolver) getContainerImage(ctx context.Context, runtime, containerID string) (*containerImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, runtimeTimeout)
+	defer cancel()
+	for _, c := range r.clients {
+		if c.name == runtime {
+			return c.client.getContainerImage(ctx, containerID)
+		}
+	}
+	for _, c := range r.clients {
+		image, err := c.client.getContainerImage(ctx, containerID)
+		if errors.Is(err, errContainerNotFound) {
+			continue
+		}
+		return image, err
+	}
+	return nil, fmt.Errorf("container %s: %w", containerID, errContainerNotFound)
+}
+
+func (r *RuntimeImageResolver) ResolveImageID(ctx context.Context, containerID, imageTag, imageID string) (string, error) {
+	if normalized, ok := normalizeImageID(imageID); ok {
+		return normalized, nil
+	}
+	image, err := r.getContainerImage(ctx, "", containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image of container %s: %w", containerID, err)
+	}
+	return selectRepoDigest(image.repoDigests, imageTag)
+}
+
+func (r *RuntimeImageResolver) ResolveContainerImage(ctx context.Context, runtime, containerID string) (string, string, error) {
+	image, err := r.getContainerImage(ctx, runtime, containerID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get image of container %s: %w", containerID, err)
+	}
+	imageID, err := selectRepoDigest(image.repoDigests, image.tag)
+	if err != nil {
+		// the images built on the host were never pushed, they are keyed by their image ID
+		if image.id == "" {
+			return "", "", fmt.Errorf("image %s of container %s has no ID", image.tag, containerID)
+		}
+		logger.L().Debug("image has no repository digest, using its image ID", helpers.String("image", image.tag), helpers.String("container ID", containerID))
+		imageID, _ = normalizeImageID(image.id)
+	}
+	return image.tag, imageID, nil
+}
+
+// dockerRuntimeClient gets the images of the containers from the docker API
+type dockerRuntimeClient struct {
+	client *dockerclient.Client
+}
+
+func (dc *dockerRuntimeClient) getContainerImage(ctx context.Context, containerID string) (*containerImage, error) {
+	container, err := dc.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		if dockerclient.IsErrNotFound(err) {
+			return nil, errContainerNotFound
+		}
+		return nil, err
+	}
+	if container.ContainerJSONBase == nil || container.Config == nil {
+		return nil, fmt.Errorf("docker container %s has no image", containerID)
+	}
+	image, _, err := dc.client.ImageInspectWithRaw(ctx, container.Image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", container.Image, err)
+	}
+	return &containerImage{tag: container.Config.Image, id: container.Image, repoDigests: image.RepoDigests}, nil
+}
+
+// containerdRuntimeClient gets the images of the containers from the containerd API, the containers of every namespace
+// are looked for since the plain containerd clients do not use the namespace of Kubernetes
+type containerdRuntimeClient struct {
+	client *containerd.Client
+}
+
+func (cc *containerdRuntimeClient) getContainerImage(ctx context.Context, containerID string) (*containerImage, error) {
+	namespaceList, err := cc.client.NamespaceService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containerd namespaces: %w", err)
+	}
+	for _, namespace := range namespaceList {
+		namespaceCtx := namespaces.WithNamespace(ctx, namespace)
+		container, err := cc.client.ContainerService().Get(namespaceCtx, containerID)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if container.Image == "" {
+			// the containers of docker are in containerd too, without their image
+			continue
+		}
+		image, err := cc.client.ImageService().Get(namespaceCtx, container.Image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image %s: %w", container.Image, err)
+		}
+		// the target of an image is the manifest pulled from the repository of its name
+		var repoDigests []string
+		if named, err := reference.ParseNormalizedNamed(image.Name); err == nil {
+			repoDigests = append(repoDigests, reference.TrimNamed(named).String()+"@"+image.Target.Digest.String())
+		}
+		return &containerImage{tag: container.Image, id: image.Target.Digest.String(), repoDigests: repoDigests}, nil
+	}
+	return nil, errContainerNotFound
+}
@@ -0,0 +1,12 @@
+package libraryresolver
+
+import (
+	"context"
+)
+
+type LibraryResolver interface {
+	// ResolveLibraries returns the paths, inside the container, of the shared libraries (and the program interpreter)
+	// the dynamic loader maps for binary. rootPath is the container root file system as seen from the node.
+	ResolveLibraries(ctx context.Context, containerID, rootPath, binary string) []string
+	RemoveContainer(containerID string)
+}
@@ -0,0 +1,348 @@
+package libraryresolver
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"node-agent/pkg/libraryresolver"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+const (
+	// maxSymlinks is the number of symbolic links followed when resolving a path, like the kernel limit
+	maxSymlinks = 40
+	// maxObjects bounds the number of shared objects read for a single binary
+	maxObjects = 256
+	// maxLdConfigIncludes bounds the nesting of include directives in ld.so.conf
+	maxLdConfigIncludes = 8
+)
+
+var (
+	// binaryDirectories is the search path of binaries executed without a path, as in the default PATH of container images
+	binaryDirectories = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+	// libraryDirectories are the trusted directories searched by glibc and musl after the configured ones
+	libraryDirectories = []string{"/lib64", "/usr/lib64", "/lib", "/usr/local/lib", "/usr/lib"}
+)
+
+var errNotFound = errors.New("not found")
+
+// ELFLibraryResolver reads the dynamic section of the executed ELF binaries from the container root file system
+// and resolves their DT_NEEDED entries recursively, the same way the dynamic loader would.
+type ELFLibraryResolver struct {
+	// librariesByContainer maps a container ID to a *sync.Map of binary to resolved libraries
+	librariesByContainer sync.Map
+}
+
+var _ libraryresolver.LibraryResolver = (*ELFLibraryResolver)(nil)
+
+func CreateELFLibraryResolver() *ELFLibraryResolver {
+	return &ELFLibraryResolver{}
+}
+
+func (r *ELFLibraryResolver) ResolveLibraries(ctx context.Context, containerID, rootPath, binary string) []string {
+	if binary == "" || rootPath == "" {
+		return nil
+	}
+	cache, _ := r.librariesByContainer.LoadOrStore(containerID, &sync.Map{})
+	if libraries, ok := cache.(*sync.Map).Load(binary); ok {
+		return libraries.([]string)
+	}
+	root := &containerRoot{path: rootPath}
+	libraries, err := root.resolveLibraries(binary)
+	if err != nil {
+		logger.L().Debug("failed to resolve shared libraries", helpers.String("container ID", containerID), helpers.String("binary", binary), helpers.Error(err))
+	}
+	// failures are cached too, the binary is not going to become readable later
+	cache.(*sync.Map).Store(binary, libraries)
+	return libraries
+}
+
+func (r *ELFLibraryResolver) RemoveContainer(containerID string) {
+	r.librariesByContainer.Delete(containerID)
+}
+
+// containerRoot gives access to the files of a container through its root file system, paths are container paths
+type containerRoot struct {
+	path         string
+	ldConfigDirs []string
+}
+
+func (root *containerRoot) hostPath(name string) string {
+	return filepath.Join(root.path, name)
+}
+
+// resolveLibraries returns the libraries loaded for binary, both as found in the search path and after resolving symbolic links
+func (root *containerRoot) resolveLibraries(binary string) ([]string, error) {
+	binaryPath, err := root.findBinary(binary)
+	if err != nil {
+		return nil, err
+	}
+	binaryFile, err := elf.Open(root.hostPath(binaryPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ELF binary %s: %w", binaryPath, err)
+	}
+	_ = binaryFile.Close()
+
+	var libraries []string
+	visited := map[string]bool{binaryPath: true}
+	queue := []string{binaryPath}
+	for len(queue) > 0 && len(visited) <= maxObjects {
+		objectPath := queue[0]
+		queue = queue[1:]
+		for _, dependency := range root.dependencies(objectPath) {
+			realPath, err := root.realPath(dependency)
+			if err != nil {
+				continue
+			}
+			libraries = appendUnique(libraries, dependency, realPath)
+			if !visited[realPath] {
+				visited[realPath] = true
+				queue = append(queue, realPath)
+			}
+		}
+	}
+	return libraries, nil
+}
+
+// dependencies returns the located program interpreter and DT_NEEDED entries of the shared object at objectPath
+func (root *containerRoot) dependencies(objectPath string) []string {
+	file, err := elf.Open(root.hostPath(objectPath))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var dependencies []string
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interpreter, err := readInterpreter(prog)
+		if err == nil && path.IsAbs(interpreter) {
+			dependencies = append(dependencies, interpreter)
+		}
+	}
+
+	needed, err := file.DynString(elf.DT_NEEDED)
+	if err != nil || len(needed) == 0 {
+		return dependencies
+	}
+	searchPath := root.searchPath(file, objectPath)
+	for _, name := range needed {
+		if libraryPath, err := root.findLibrary(file, name, searchPath); err == nil {
+			dependencies = append(dependencies, libraryPath)
+		}
+	}
+	return dependencies
+}
+
+// searchPath returns the library directories of an object in the dynamic loader order: DT_RPATH when there is no
+// DT_RUNPATH, DT_RUNPATH, the ld.so configuration and the trusted directories
+func (root *containerRoot) searchPath(file *elf.File, objectPath string) []string {
+	var searchPath []string
+	runPath, _ := file.DynString(elf.DT_RUNPATH)
+	if len(runPath) == 0 {
+		rPath, _ := file.DynString(elf.DT_RPATH)
+		searchPath = append(searchPath, expandSearchPath(rPath, objectPath)...)
+	}
+	searchPath = append(searchPath, expandSearchPath(runPath, objectPath)...)
+	searchPath = append(searchPath, root.ldConfigDirectories()...)
+	return append(searchPath, libraryDirectories...)
+}
+
+// findLibrary locates a DT_NEEDED entry, skipping the candidates built for another architecture
+func (root *containerRoot) findLibrary(object *elf.File, name string, searchPath []string) (string, error) {
+	if strings.Contains(name, "/") {
+		if !path.IsAbs(name) {
+			return "", fmt.Errorf("relative library path %s: %w", name, errNotFound)
+		}
+		return name, nil
+	}
+	for _, dir := range searchPath {
+		candidate := path.Join(dir, name)
+		realPath, err := root.realPath(candidate)
+		if err != nil {
+			continue
+		}
+		library, err := elf.Open(root.hostPath(realPath))
+		if err != nil {
+			continue
+		}
+		compatible := library.Class == object.Class && library.Machine == object.Machine
+		_ = library.Close()
+		if compatible {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("library %s: %w", name, errNotFound)
+}
+
+// findBinary returns the real path of an executed binary, searching the default PATH for bare names
+func (root *containerRoot) findBinary(binary string) (string, error) {
+	if strings.Contains(binary, "/") {
+		if !path.IsAbs(binary) {
+			// relative to the working directory of a process which may be gone by now
+			return "", fmt.Errorf("relative binary path %s: %w", binary, errNotFound)
+		}
+		return root.realPath(binary)
+	}
+	for _, dir := range binaryDirectories {
+		realPath, err := root.realPath(path.Join(dir, binary))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(root.hostPath(realPath)); err == nil && info.Mode().IsRegular() {
+			return realPath, nil
+		}
+	}
+	return "", fmt.Errorf("binary %s: %w", binary, errNotFound)
+}
+
+// realPath resolves the symbolic links of name inside the container root, absolute link targets are resolved
+// against the container root and not against the root of the node
+func (root *containerRoot) realPath(name string) (string, error) {
+	resolved := "/"
+	components := strings.Split(name, "/")
+	links := 0
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, component)
+		info, err := os.Lstat(root.hostPath(next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+		target, err := os.Readlink(root.hostPath(next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		components = append(strings.Split(target, "/"), components...)
+	}
+	return resolved, nil
+}
+
+// ldConfigDirectories returns the directories configured in /etc/ld.so.conf (glibc) and /etc/ld-musl-*.path (musl)
+func (root *containerRoot) ldConfigDirectories() []string {
+	if root.ldConfigDirs != nil {
+		return root.ldConfigDirs
+	}
+	root.ldConfigDirs = []string{}
+	root.parseLdConfig("/etc/ld.so.conf", 0)
+	muslPaths, _ := filepath.Glob(root.hostPath("/etc/ld-musl-*.path"))
+	for _, muslPath := range muslPaths {
+		content, err := os.ReadFile(muslPath)
+		if err != nil {
+			continue
+		}
+		for _, dir := range strings.FieldsFunc(string(content), func(r rune) bool { return r == ':' || r == '\n' }) {
+			if path.IsAbs(dir) {
+				root.ldConfigDirs = appendUnique(root.ldConfigDirs, path.Clean(dir))
+			}
+		}
+	}
+	return root.ldConfigDirs
+}
+
+func (root *containerRoot) parseLdConfig(name string, depth int) {
+	if depth > maxLdConfigIncludes {
+		return
+	}
+	realPath, err := root.realPath(name)
+	if err != nil {
+		return
+	}
+	content, err := os.ReadFile(root.hostPath(realPath))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if pattern, found := strings.CutPrefix(line, "include"); found && strings.TrimSpace(pattern) != pattern {
+			for _, pattern := range strings.Fields(pattern) {
+				if !path.IsAbs(pattern) {
+					pattern = path.Join(path.Dir(name), pattern)
+				}
+				includes, _ := filepath.Glob(root.hostPath(pattern))
+				sort.Strings(includes)
+				for _, include := range includes {
+					root.parseLdConfig(strings.TrimPrefix(include, filepath.Clean(root.path)), depth+1)
+				}
+			}
+			continue
+		}
+		if path.IsAbs(line) {
+			root.ldConfigDirs = appendUnique(root.ldConfigDirs, path.Clean(line))
+		}
+	}
+}
+
+// readInterpreter returns the PT_INTERP path, a NUL terminated string
+func readInterpreter(prog *elf.Prog) (string, error) {
+	content := make([]byte, prog.Filesz)
+	if _, err := prog.ReadAt(content, 0); err != nil {
+		return "", err
+	}
+	interpreter, _, _ := strings.Cut(string(content), "\x00")
+	return interpreter, nil
+}
+
+// expandSearchPath splits DT_RPATH/DT_RUNPATH values and substitutes $ORIGIN, entries with other dynamic string tokens are skipped
+func expandSearchPath(values []string, objectPath string) []string {
+	var dirs []string
+	origin := path.Dir(objectPath)
+	for _, value := range values {
+		for _, dir := range strings.Split(value, ":") {
+			dir = strings.NewReplacer("${ORIGIN}", origin, "$ORIGIN", origin).Replace(dir)
+			if dir == "" || strings.Contains(dir, "$") || !path.IsAbs(dir) {
+				continue
+			}
+			dirs = append(dirs, path.Clean(dir))
+		}
+	}
+	return dirs
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for i := range list {
+			if list[i] == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
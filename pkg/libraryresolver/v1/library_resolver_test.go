@@ -0,0 +1,122 @@
+package libraryresolver
+
+import (
+	"context"
+	"node-agent/pkg/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createRootFsMock lays out a container root file system with the testdata binaries:
+// app needs libssl.so.3 (found through its DT_RUNPATH $ORIGIN/../lib) and libc.so.6 (missing),
+// libssl.so.3 needs libcrypto.so.3 (found through ld.so.conf)
+func createRootFsMock(t *testing.T) string {
+	root := t.TempDir()
+	copyFile := func(source, target string) {
+		content, err := os.ReadFile(path.Join(utils.CurrentDir(), "testdata", source))
+		if err != nil {
+			t.Fatalf("fail to read testdata file, err: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Join(root, path.Dir(target)), 0755); err != nil {
+			t.Fatalf("fail to create directory, err: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, target), content, 0755); err != nil {
+			t.Fatalf("fail to write file, err: %v", err)
+		}
+	}
+	symlink := func(target, name string) {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatalf("fail to create symlink, err: %v", err)
+		}
+	}
+	writeFile := func(name, content string) {
+		if err := os.MkdirAll(filepath.Join(root, path.Dir(name)), 0755); err != nil {
+			t.Fatalf("fail to create directory, err: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("fail to write file, err: %v", err)
+		}
+	}
+
+	copyFile("app", "/usr/bin/app")
+	copyFile("libssl.so.3", "/usr/lib/libssl.so.3")
+	copyFile("libcrypto.so.3", "/usr/lib/x86_64-linux-gnu/libcrypto.so.3.0")
+	copyFile("libcrypto.so.3", "/usr/lib64/ld-linux-x86-64.so.2")
+	// absolute links must be resolved inside the root
+	symlink("/usr/bin", "/bin")
+	symlink("/usr/lib64", "/lib64")
+	symlink("libcrypto.so.3.0", "/usr/lib/x86_64-linux-gnu/libcrypto.so.3")
+	symlink("../../../..", "/usr/lib/escape")
+	writeFile("/etc/ld.so.conf", "# comment\ninclude /etc/ld.so.conf.d/*.conf\n")
+	writeFile("/etc/ld.so.conf.d/x86_64-linux-gnu.conf", "/usr/lib/x86_64-linux-gnu\n")
+	writeFile("/usr/bin/script.sh", "#!/bin/sh\n")
+	return root
+}
+
+func TestResolveLibraries(t *testing.T) {
+	root := createRootFsMock(t)
+	resolver := CreateELFLibraryResolver()
+
+	expected := []string{
+		"/lib64/ld-linux-x86-64.so.2",
+		"/usr/lib64/ld-linux-x86-64.so.2",
+		"/usr/lib/libssl.so.3",
+		"/usr/lib/x86_64-linux-gnu/libcrypto.so.3",
+		"/usr/lib/x86_64-linux-gnu/libcrypto.so.3.0",
+	}
+	assert.ElementsMatch(t, expected, resolver.ResolveLibraries(context.TODO(), "container", root, "/usr/bin/app"))
+	assert.ElementsMatch(t, expected, resolver.ResolveLibraries(context.TODO(), "container", root, "/bin/app"))
+	// bare names are searched in the default PATH
+	assert.ElementsMatch(t, expected, resolver.ResolveLibraries(context.TODO(), "container", root, "app"))
+
+	assert.Empty(t, resolver.ResolveLibraries(context.TODO(), "container", root, "/usr/bin/script.sh"))
+	assert.Empty(t, resolver.ResolveLibraries(context.TODO(), "container", root, "./app"))
+	assert.Empty(t, resolver.ResolveLibraries(context.TODO(), "container", root, "/not/found"))
+}
+
+func TestResolveLibrariesCache(t *testing.T) {
+	root := createRootFsMock(t)
+	resolver := CreateELFLibraryResolver()
+
+	assert.Len(t, resolver.ResolveLibraries(context.TODO(), "container", root, "/usr/bin/app"), 5)
+	if err := os.RemoveAll(filepath.Join(root, "usr")); err != nil {
+		t.Fatalf("fail to remove directory, err: %v", err)
+	}
+	assert.Len(t, resolver.ResolveLibraries(context.TODO(), "container", root, "/usr/bin/app"), 5)
+	resolver.RemoveContainer("container")
+	assert.Empty(t, resolver.ResolveLibraries(context.TODO(), "container", root, "/usr/bin/app"))
+}
+
+func TestRealPath(t *testing.T) {
+	root := &containerRoot{path: createRootFsMock(t)}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "/bin/app", want: "/usr/bin/app"},
+		{name: "/lib64/../bin/app", want: "/usr/bin/app"},
+		{name: "/usr/lib/x86_64-linux-gnu/libcrypto.so.3", want: "/usr/lib/x86_64-linux-gnu/libcrypto.so.3.0"},
+		{name: "/usr/lib/escape/usr/bin/app", want: "/usr/bin/app"},
+		{name: "/usr/bin/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := root.realPath(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("realPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpandSearchPath(t *testing.T) {
+	assert.Equal(t, []string{"/opt/app/lib", "/opt/usr/lib/app"}, expandSearchPath([]string{"$ORIGIN/../lib:${ORIGIN}/../../usr/lib/app", "$LIB/app:relative"}, "/opt/app/bin/app"))
+	assert.Nil(t, expandSearchPath(nil, "/usr/bin/app"))
+}
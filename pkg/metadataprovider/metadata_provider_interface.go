@@ -0,0 +1,17 @@
+package metadataprovider
+
+import (
+	"context"
+
+	"github.com/kubescape/k8s-interface/workloadinterface"
+)
+
+// MetadataProviderClient gives the pods of the node and their owners
+type MetadataProviderClient interface {
+	GetPod(namespace, name string) (*workloadinterface.Workload, error)
+	// WaitForPod returns the pod once condition is true for it, it waits for the updates of the pod until ctx is done
+	WaitForPod(ctx context.Context, namespace, name string, condition func(pod *workloadinterface.Workload) bool) (*workloadinterface.Workload, error)
+	// GetPodParent returns the kind and the name of the top level owner of a pod
+	GetPodParent(pod *workloadinterface.Workload) (string, string, error)
+	StartMetadataProvider(ctx context.Context)
+}
@@ -0,0 +1,115 @@
+package metadataprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"node-agent/pkg/metadataprovider"
+	"time"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"go.opentelemetry.io/otel"
+	apimachineryerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// InformerProvider holds the pods of the node with an informer on the API server
+type InformerProvider struct {
+	k8sClient       *k8sinterface.KubernetesApi
+	informerFactory informers.SharedInformerFactory
+	lister          corelisters.PodLister
+	updates         *podUpdates
+	owners          *ownerCache
+}
+
+var _ metadataprovider.MetadataProviderClient = (*InformerProvider)(nil)
+
+func CreateInformerProvider(k8sClient *k8sinterface.KubernetesApi, nodeName string) (*InformerProvider, error) {
+	if nodeName == "" {
+		return nil, errors.New("node name is empty, the informer needs it to watch the pods of the node")
+	}
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(k8sClient.KubernetesClient, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+	}))
+	podInformer := informerFactory.Core().V1().Pods()
+	ip := &InformerProvider{
+		k8sClient:       k8sClient,
+		informerFactory: informerFactory,
+		lister:          podInformer.Lister(),
+		updates:         newPodUpdates(),
+		owners:          newOwnerCache(k8sClient),
+	}
+	_, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ip.notify,
+		UpdateFunc: func(_, obj any) { ip.notify(obj) },
+		DeleteFunc: ip.notify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add the pod event handler: %w", err)
+	}
+	return ip, nil
+}
+
+func (ip *InformerProvider) notify(obj any) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ip.updates.notify(key)
+}
+
+// GetPod returns a pod from the cache, the API server is only queried for the pods not yet in the cache
+func (ip *InformerProvider) GetPod(namespace, name string) (*workloadinterface.Workload, error) {
+	pod, err := ip.lister.Pods(namespace).Get(name)
+	if err != nil {
+		if !apimachineryerrors.IsNotFound(err) {
+			return nil, err
+		}
+		wl, err := ip.k8sClient.GetWorkload(namespace, "Pod", name)
+		if err != nil {
+			return nil, err
+		}
+		return wl.(*workloadinterface.Workload), nil
+	}
+	return podToWorkload(pod)
+}
+
+func (ip *InformerProvider) WaitForPod(ctx context.Context, namespace, name string, condition func(pod *workloadinterface.Workload) bool) (*workloadinterface.Workload, error) {
+	return ip.updates.waitForPod(ctx, ip.GetPod, namespace, name, condition)
+}
+
+func (ip *InformerProvider) GetPodParent(pod *workloadinterface.Workload) (string, string, error) {
+	return ip.owners.getPodParent(pod)
+}
+
+func (ip *InformerProvider) StartMetadataProvider(ctx context.Context) {
+	ctx, span := otel.Tracer("").Start(ctx, "InformerProvider.StartMetadataProvider")
+	defer span.End()
+	ip.informerFactory.Start(ctx.Done())
+	for _, synced := range ip.informerFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			logger.L().Ctx(ctx).Warning("pod informer not synced, the pods are read from the API server until it is")
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ip.owners.prune()
+				ip.updates.reset()
+			}
+		}
+	}()
+	logger.L().Info("pod informer started", helpers.Int("pods", len(ip.informerFactory.Core().V1().Pods().Informer().GetStore().ListKeys())))
+}
@@ -0,0 +1,110 @@
+package metadataprovider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func podMock() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nginx-1",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "nginx-5d8f", UID: "1"}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node",
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+}
+
+func createInformerProviderMock(t *testing.T, ctx context.Context, objects ...*corev1.Pod) (*InformerProvider, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset()
+	for _, obj := range objects {
+		if err := clientset.Tracker().Add(obj); err != nil {
+			t.Fatalf("fail to add pod, err: %v", err)
+		}
+	}
+	ip, err := CreateInformerProvider(&k8sinterface.KubernetesApi{KubernetesClient: clientset}, "node")
+	if err != nil {
+		t.Fatalf("fail to create informer provider, err: %v", err)
+	}
+	ip.StartMetadataProvider(ctx)
+	return ip, clientset
+}
+
+func TestWaitForPod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ip, clientset := createInformerProviderMock(t, ctx, podMock())
+
+	pod, err := ip.GetPod("default", "nginx-1")
+	if err != nil {
+		t.Fatalf("fail to get pod, err: %v", err)
+	}
+	assert.Equal(t, "Pod", pod.GetKind())
+	assert.Equal(t, "nginx-1", pod.GetName())
+
+	hasImageID := func(pod *workloadinterface.Workload) bool {
+		status, err := pod.GetPodStatus()
+		return err == nil && len(status.ContainerStatuses) > 0 && status.ContainerStatuses[0].ImageID != ""
+	}
+	// the image ID is not in the status yet
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	_, err = ip.WaitForPod(waitCtx, "default", "nginx-1", hasImageID)
+	assert.Error(t, err)
+
+	// the kubelet updates the status once the container started
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		updated := podMock()
+		updated.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "nginx", ImageID: "docker.io/library/nginx@sha256:01"}}
+		_, _ = clientset.CoreV1().Pods("default").UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	}()
+	waitCtx, waitCancel = context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	pod, err = ip.WaitForPod(waitCtx, "default", "nginx-1", hasImageID)
+	if err != nil {
+		t.Fatalf("fail to wait for pod, err: %v", err)
+	}
+	assert.True(t, hasImageID(pod))
+}
+
+func TestGetPodParent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ip, _ := createInformerProviderMock(t, ctx)
+	calls := 0
+	ip.owners.calculateParent = func(_ k8sinterface.IWorkload) (string, string, error) {
+		calls++
+		return "Deployment", "nginx", nil
+	}
+	pod, err := podToWorkload(podMock())
+	if err != nil {
+		t.Fatalf("fail to convert pod, err: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		kind, name, err := ip.GetPodParent(pod)
+		if err != nil {
+			t.Fatalf("fail to get pod parent, err: %v", err)
+		}
+		assert.Equal(t, "Deployment", kind)
+		assert.Equal(t, "nginx", name)
+	}
+	// the owner is resolved once for all the pods of the replica set
+	assert.Equal(t, 1, calls)
+
+	ip.owners.owners["1"].lastUsed = time.Now().Add(-2 * ownerCacheTTL)
+	ip.owners.prune()
+	assert.Empty(t, ip.owners.owners)
+}
@@ -0,0 +1,187 @@
+package metadataprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"node-agent/pkg/config"
+	"node-agent/pkg/metadataprovider"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"go.opentelemetry.io/otel"
+	corev1 "k8s.io/api/core/v1"
+	apimachineryerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const kubeletRequestTimeout = 10 * time.Second
+
+// KubeletProvider polls the pods of the node from the local kubelet, only the owners of the pods are read from the API
+// server
+type KubeletProvider struct {
+	podsURL      string
+	httpClient   *http.Client
+	tokenFile    string
+	pollInterval time.Duration
+	mutex        sync.RWMutex
+	// pods maps a namespace/name key to the pod
+	pods    map[string]*corev1.Pod
+	updates *podUpdates
+	owners  *ownerCache
+}
+
+var _ metadataprovider.MetadataProviderClient = (*KubeletProvider)(nil)
+
+func CreateKubeletProvider(cfg config.KubeletConfig, k8sClient *k8sinterface.KubernetesApi) (*KubeletProvider, error) {
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil || cfg.URL == "" {
+		return nil, fmt.Errorf("invalid kubelet url %q", cfg.URL)
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, fmt.Errorf("invalid kubelet poll interval %s", cfg.PollInterval)
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		caCert, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubelet CA file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse kubelet CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &KubeletProvider{
+		podsURL:      baseURL.JoinPath("pods").String(),
+		httpClient:   &http.Client{Timeout: kubeletRequestTimeout, Transport: transport},
+		tokenFile:    cfg.TokenFile,
+		pollInterval: cfg.PollInterval,
+		pods:         make(map[string]*corev1.Pod),
+		updates:      newPodUpdates(),
+		owners:       newOwnerCache(k8sClient),
+	}, nil
+}
+
+// listPods gets the pods of the node from the kubelet
+func (kp *KubeletProvider) listPods(ctx context.Context) (*corev1.PodList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, kp.podsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if kp.tokenFile != "" {
+		// read the token on every request so rotated tokens are picked up
+		token, err := os.ReadFile(kp.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := kp.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("kubelet returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	podList := &corev1.PodList{}
+	if err := json.NewDecoder(resp.Body).Decode(podList); err != nil {
+		return nil, fmt.Errorf("failed to decode kubelet pods: %w", err)
+	}
+	return podList, nil
+}
+
+// refresh replaces the pods with the ones of the kubelet, the waiters of the pods that changed are woken up
+func (kp *KubeletProvider) refresh(ctx context.Context) error {
+	podList, err := kp.listPods(ctx)
+	if err != nil {
+		return err
+	}
+	pods := make(map[string]*corev1.Pod, len(podList.Items))
+	for i := range podList.Items {
+		pods[podList.Items[i].Namespace+"/"+podList.Items[i].Name] = &podList.Items[i]
+	}
+	var changed []string
+	kp.mutex.Lock()
+	for key, pod := range pods {
+		if previous, ok := kp.pods[key]; !ok || previous.ResourceVersion != pod.ResourceVersion {
+			changed = append(changed, key)
+		}
+	}
+	for key := range kp.pods {
+		if _, ok := pods[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	kp.pods = pods
+	kp.mutex.Unlock()
+	for _, key := range changed {
+		kp.updates.notify(key)
+	}
+	return nil
+}
+
+func (kp *KubeletProvider) GetPod(namespace, name string) (*workloadinterface.Workload, error) {
+	kp.mutex.RLock()
+	pod, ok := kp.pods[namespace+"/"+name]
+	kp.mutex.RUnlock()
+	if !ok {
+		return nil, apimachineryerrors.NewNotFound(corev1.Resource("pods"), name)
+	}
+	return podToWorkload(pod)
+}
+
+func (kp *KubeletProvider) WaitForPod(ctx context.Context, namespace, name string, condition func(pod *workloadinterface.Workload) bool) (*workloadinterface.Workload, error) {
+	return kp.updates.waitForPod(ctx, kp.GetPod, namespace, name, condition)
+}
+
+func (kp *KubeletProvider) GetPodParent(pod *workloadinterface.Workload) (string, string, error) {
+	return kp.owners.getPodParent(pod)
+}
+
+func (kp *KubeletProvider) StartMetadataProvider(ctx context.Context) {
+	ctx, span := otel.Tracer("").Start(ctx, "KubeletProvider.StartMetadataProvider")
+	defer span.End()
+	if err := kp.refresh(ctx); err != nil {
+		logger.L().Ctx(ctx).Warning("failed to get the pods from the kubelet", helpers.Error(err), helpers.String("url", kp.podsURL))
+	}
+	go func() {
+		pollTicker := time.NewTicker(kp.pollInterval)
+		defer pollTicker.Stop()
+		pruneTicker := time.NewTicker(pruneInterval)
+		defer pruneTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pollTicker.C:
+				if err := kp.refresh(ctx); err != nil {
+					logger.L().Debug("failed to get the pods from the kubelet", helpers.Error(err), helpers.String("url", kp.podsURL))
+				}
+			case <-pruneTicker.C:
+				kp.owners.prune()
+				kp.updates.reset()
+			}
+		}
+	}()
+	kp.mutex.RLock()
+	defer kp.mutex.RUnlock()
+	logger.L().Info("kubelet metadata provider started", helpers.Int("pods", len(kp.pods)))
+}
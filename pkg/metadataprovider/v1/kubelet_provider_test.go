@@ -0,0 +1,95 @@
+package metadataprovider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"node-agent/pkg/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apimachineryerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// kubeletMock serves the pods endpoint of the kubelet
+type kubeletMock struct {
+	mutex sync.Mutex
+	pods  []corev1.Pod
+}
+
+func (km *kubeletMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/pods" || r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	_ = json.NewEncoder(w).Encode(corev1.PodList{Items: km.pods})
+}
+
+func (km *kubeletMock) setPods(pods ...corev1.Pod) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	km.pods = pods
+}
+
+func TestKubeletProvider(t *testing.T) {
+	kubelet := &kubeletMock{}
+	server := httptest.NewTLSServer(kubelet)
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token\n"), 0600); err != nil {
+		t.Fatalf("fail to write token file, err: %v", err)
+	}
+	cfg := config.KubeletConfig{URL: server.URL, TokenFile: tokenFile, InsecureSkipVerify: true, PollInterval: 50 * time.Millisecond}
+	kp, err := CreateKubeletProvider(cfg, &k8sinterface.KubernetesApi{})
+	if err != nil {
+		t.Fatalf("fail to create kubelet provider, err: %v", err)
+	}
+	pod := podMock()
+	pod.ResourceVersion = "1"
+	kubelet.setPods(*pod)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	kp.StartMetadataProvider(ctx)
+
+	wl, err := kp.GetPod("default", "nginx-1")
+	if err != nil {
+		t.Fatalf("fail to get pod, err: %v", err)
+	}
+	assert.Equal(t, "Pod", wl.GetKind())
+	_, err = kp.GetPod("default", "nginx-2")
+	assert.True(t, apimachineryerrors.IsNotFound(err))
+
+	// the status is polled once the container started
+	updated := podMock()
+	updated.ResourceVersion = "2"
+	updated.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "nginx", ImageID: "docker.io/library/nginx@sha256:01"}}
+	kubelet.setPods(*updated)
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	wl, err = kp.WaitForPod(waitCtx, "default", "nginx-1", func(pod *workloadinterface.Workload) bool {
+		status, err := pod.GetPodStatus()
+		return err == nil && len(status.ContainerStatuses) == 1
+	})
+	if err != nil {
+		t.Fatalf("fail to wait for pod, err: %v", err)
+	}
+	status, _ := wl.GetPodStatus()
+	assert.Equal(t, "docker.io/library/nginx@sha256:01", status.ContainerStatuses[0].ImageID)
+}
+
+func TestCreateKubeletProvider(t *testing.T) {
+	_, err := CreateKubeletProvider(config.KubeletConfig{PollInterval: time.Second}, &k8sinterface.KubernetesApi{})
+	assert.Error(t, err)
+	_, err = CreateKubeletProvider(config.KubeletConfig{URL: "https://127.0.0.1:10250"}, &k8sinterface.KubernetesApi{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package metadataprovider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kubescape/k8s-interface/k8sinterface"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ownerCacheTTL is how long the owner of pods is kept once no pod of the node uses it
+	ownerCacheTTL = time.Hour
+	pruneInterval = 10 * time.Minute
+)
+
+// ownerCache holds the top level owners of the direct owners of the pods, they are read from the API server
+type ownerCache struct {
+	// calculateParent is replaced in the tests
+	calculateParent func(workload k8sinterface.IWorkload) (string, string, error)
+	mutex           sync.Mutex
+	// owners maps the UID of the direct owner of pods to their top level owner
+	owners map[types.UID]*podOwner
+}
+
+type podOwner struct {
+	kind     string
+	name     string
+	lastUsed time.Time
+}
+
+func newOwnerCache(k8sClient *k8sinterface.KubernetesApi) *ownerCache {
+	return &ownerCache{
+		calculateParent: k8sClient.CalculateWorkloadParentRecursive,
+		owners:          make(map[types.UID]*podOwner),
+	}
+}
+
+func (oc *ownerCache) getPodParent(pod *workloadinterface.Workload) (string, string, error) {
+	ownerReferences, err := pod.GetOwnerReferences()
+	if err != nil || len(ownerReferences) == 0 {
+		return oc.calculateParent(pod)
+	}
+	uid := ownerReferences[0].UID
+	oc.mutex.Lock()
+	if owner, ok := oc.owners[uid]; ok {
+		owner.lastUsed = time.Now()
+		oc.mutex.Unlock()
+		return owner.kind, owner.name, nil
+	}
+	oc.mutex.Unlock()
+
+	kind, name, err := oc.calculateParent(pod)
+	if err != nil {
+		return "", "", err
+	}
+	oc.mutex.Lock()
+	defer oc.mutex.Unlock()
+	oc.owners[uid] = &podOwner{kind: kind, name: name, lastUsed: time.Now()}
+	return kind, name, nil
+}
+
+// prune removes the owners no longer used
+func (oc *ownerCache) prune() {
+	oc.mutex.Lock()
+	defer oc.mutex.Unlock()
+	for uid, owner := range oc.owners {
+		if time.Since(owner.lastUsed) > ownerCacheTTL {
+			delete(oc.owners, uid)
+		}
+	}
+}
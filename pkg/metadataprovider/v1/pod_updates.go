@@ -0,0 +1,83 @@
+package metadataprovider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// podUpdates wakes up the goroutines waiting for an update of a pod
+type podUpdates struct {
+	mutex sync.Mutex
+	// updates maps a namespace/name key to a channel closed on the next update of the pod
+	updates map[string]chan struct{}
+}
+
+func newPodUpdates() *podUpdates {
+	return &podUpdates{updates: make(map[string]chan struct{})}
+}
+
+func (pu *podUpdates) get(key string) chan struct{} {
+	pu.mutex.Lock()
+	defer pu.mutex.Unlock()
+	update, ok := pu.updates[key]
+	if !ok {
+		update = make(chan struct{})
+		pu.updates[key] = update
+	}
+	return update
+}
+
+func (pu *podUpdates) notify(key string) {
+	pu.mutex.Lock()
+	defer pu.mutex.Unlock()
+	if update, ok := pu.updates[key]; ok {
+		close(update)
+		delete(pu.updates, key)
+	}
+}
+
+// reset wakes up the waiters of the pods that were never updated, they check their pod again
+func (pu *podUpdates) reset() {
+	pu.mutex.Lock()
+	defer pu.mutex.Unlock()
+	for key, update := range pu.updates {
+		close(update)
+		delete(pu.updates, key)
+	}
+}
+
+// waitForPod reads the pod with getPod on each of its updates until condition is true for it
+func (pu *podUpdates) waitForPod(ctx context.Context, getPod func(namespace, name string) (*workloadinterface.Workload, error), namespace, name string, condition func(pod *workloadinterface.Workload) bool) (*workloadinterface.Workload, error) {
+	for {
+		// the update channel is taken before reading the pod so an update in between is not missed
+		update := pu.get(namespace + "/" + name)
+		pod, err := getPod(namespace, name)
+		if err == nil && condition(pod) {
+			return pod, nil
+		}
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to wait for pod %s in namespace %s: %w", name, namespace, err)
+		case <-update:
+		}
+	}
+}
+
+// podToWorkload converts a pod read without its type meta
+func podToWorkload(pod *corev1.Pod) (*workloadinterface.Workload, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert pod %s in namespace %s: %w", pod.GetName(), pod.GetNamespace(), err)
+	}
+	obj["apiVersion"] = "v1"
+	obj["kind"] = "Pod"
+	return workloadinterface.NewWorkloadObj(obj), nil
+}
@@ -0,0 +1,15 @@
+package networkmanager
+
+import (
+	"context"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracernetworktype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/network/types"
+)
+
+type NetworkManagerClient interface {
+	ReportContainerStarted(ctx context.Context, container *containercollection.Container)
+	ReportContainerTerminated(ctx context.Context, container *containercollection.Container)
+	ReportNetworkEvent(ctx context.Context, container *containercollection.Container, event *tracernetworktype.Event)
+	StartNetworkManager(ctx context.Context)
+}
@@ -0,0 +1,234 @@
+package networkmanager
+
+import (
+	"context"
+	"fmt"
+	"node-agent/pkg/config"
+	"node-agent/pkg/metadataprovider"
+	"node-agent/pkg/networkmanager"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"sort"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracernetworktype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/network/types"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// packet types of the trace_network gadget, see include/uapi/linux/if_packet.h
+	packetTypeHost     = "HOST"
+	packetTypeOutgoing = "OUTGOING"
+	// networkProfilePrefix keeps network profiles apart from the other objects named after the instance ID
+	networkProfilePrefix = "network-"
+	// maxConnections keeps the profile well under the 1 MiB of a config map, the connections above it are dropped
+	maxConnections = 5000
+)
+
+// NetworkManager aggregates the network activity of the containers into connection profiles and stores them
+type NetworkManager struct {
+	cfg              config.Config
+	metadataProvider metadataprovider.MetadataProviderClient
+	storageClient    storageclient.StorageClient
+	// watchedContainers maps a container ID to its *containerNetworkData
+	watchedContainers sync.Map
+	// getInstanceID is replaced in tests
+	getInstanceID func(ctx context.Context, container *containercollection.Container) (instanceidhandler.IInstanceID, error)
+}
+
+type containerNetworkData struct {
+	mutex       sync.Mutex
+	container   *containercollection.Container
+	connections map[storageclient.NetworkConnection]bool
+	// dirty is set when connections were added since the profile was last stored
+	dirty bool
+	// limited is set once a connection was dropped because of maxConnections
+	limited bool
+	// profile is the stored profile, nil until the first successful store
+	profile *storageclient.NetworkProfile
+}
+
+var _ networkmanager.NetworkManagerClient = (*NetworkManager)(nil)
+
+func CreateNetworkManager(cfg config.Config, metadataProvider metadataprovider.MetadataProviderClient, storageClient storageclient.StorageClient) (*NetworkManager, error) {
+	nm := &NetworkManager{
+		cfg:              cfg,
+		metadataProvider: metadataProvider,
+		storageClient:    storageClient,
+	}
+	nm.getInstanceID = nm.podInstanceID
+	return nm, nil
+}
+
+func (nm *NetworkManager) ReportContainerStarted(_ context.Context, container *containercollection.Container) {
+	nm.watchedContainers.LoadOrStore(container.ID, &containerNetworkData{
+		container:   container,
+		connections: make(map[storageclient.NetworkConnection]bool),
+	})
+}
+
+func (nm *NetworkManager) ReportContainerTerminated(ctx context.Context, container *containercollection.Container) {
+	data, ok := nm.watchedContainers.LoadAndDelete(container.ID)
+	if !ok {
+		return
+	}
+	nm.storeProfile(ctx, data.(*containerNetworkData))
+}
+
+func (nm *NetworkManager) ReportNetworkEvent(_ context.Context, container *containercollection.Container, event *tracernetworktype.Event) {
+	data, ok := nm.watchedContainers.Load(container.ID)
+	if !ok {
+		return
+	}
+	connection, ok := networkConnection(event)
+	if !ok {
+		return
+	}
+	containerData := data.(*containerNetworkData)
+	containerData.mutex.Lock()
+	defer containerData.mutex.Unlock()
+	containerData.addConnection(connection)
+}
+
+// addConnection adds a connection within maxConnections, the caller holds the mutex
+func (data *containerNetworkData) addConnection(connection storageclient.NetworkConnection) {
+	if data.connections[connection] {
+		return
+	}
+	if len(data.connections) >= maxConnections {
+		if !data.limited {
+			data.limited = true
+			logger.L().Warning("network profile limit reached, the new connections are dropped", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", utils.CreateK8sContainerID(data.container.Namespace, data.container.Podname, data.container.Name)))
+		}
+		return
+	}
+	data.connections[connection] = true
+	data.dirty = true
+}
+
+func (nm *NetworkManager) StartNetworkManager(ctx context.Context) {
+	ctx, span := otel.Tracer("").Start(ctx, "NetworkManager.StartNetworkManager")
+	defer span.End()
+	go func() {
+		ticker := time.NewTicker(nm.cfg.UpdateDataPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				nm.watchedContainers.Range(func(_, data any) bool {
+					nm.storeProfile(ctx, data.(*containerNetworkData))
+					return true
+				})
+			}
+		}
+	}()
+}
+
+// storeProfile creates or updates the network profile of a container when it has new connections
+func (nm *NetworkManager) storeProfile(ctx context.Context, data *containerNetworkData) {
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if !data.dirty {
+		return
+	}
+	k8sContainerID := utils.CreateK8sContainerID(data.container.Namespace, data.container.Podname, data.container.Name)
+
+	var err error
+	if data.profile == nil {
+		data.profile, err = nm.createProfile(ctx, data)
+	} else {
+		data.profile.Spec.Connections = sortedConnections(data.connections)
+		err = nm.storageClient.UpdateNetworkProfile(ctx, data.profile.Name, data.profile)
+	}
+	if err != nil {
+		logger.L().Ctx(ctx).Warning("failed to store network profile", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Error(err))
+		return
+	}
+	data.dirty = false
+	logger.L().Debug("network profile has been stored", helpers.String("container ID", data.container.ID), helpers.String("k8s workload", k8sContainerID), helpers.Int("connections", len(data.connections)))
+}
+
+// createProfile stores the first profile of a container, a profile left by a previous run of the agent is extended
+func (nm *NetworkManager) createProfile(ctx context.Context, data *containerNetworkData) (*storageclient.NetworkProfile, error) {
+	instanceID, err := nm.getInstanceID(ctx, data.container)
+	if err != nil {
+		return nil, err
+	}
+	slug, err := instanceID.GetSlug()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the instance ID slug: %w", err)
+	}
+	profile := &storageclient.NetworkProfile{
+		Spec: storageclient.NetworkProfileSpec{
+			ContainerName: data.container.Name,
+		},
+	}
+	profile.SetName(networkProfilePrefix + slug)
+	profile.SetLabels(utils.InstanceIDLabels(instanceID))
+	profile.SetAnnotations(utils.InstanceIDAnnotations(instanceID))
+	profile.Spec.Connections = sortedConnections(data.connections)
+
+	err = nm.storageClient.CreateNetworkProfile(ctx, profile)
+	if err == nil || !storageclient.IsAlreadyExist(err) {
+		return profile, err
+	}
+	existing, err := nm.storageClient.GetNetworkProfile(ctx, profile.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, connection := range existing.Spec.Connections {
+		data.addConnection(connection)
+	}
+	profile.Spec.Connections = sortedConnections(data.connections)
+	return profile, nm.storageClient.UpdateNetworkProfile(ctx, profile.Name, profile)
+}
+
+func (nm *NetworkManager) podInstanceID(_ context.Context, container *containercollection.Container) (instanceidhandler.IInstanceID, error) {
+	return utils.GetContainerInstanceID(nm.metadataProvider, container.Namespace, container.Podname, container.Name)
+}
+
+// networkConnection converts a trace_network event, packets received by the container are ingress and packets
+// sent by the container are egress, other packet types (broadcast, loopback...) are not part of the profile
+func networkConnection(event *tracernetworktype.Event) (storageclient.NetworkConnection, bool) {
+	connection := storageclient.NetworkConnection{
+		Protocol: event.Proto,
+		Port:     event.Port,
+		Address:  event.DstEndpoint.Addr,
+	}
+	switch event.PktType {
+	case packetTypeHost:
+		connection.Direction = storageclient.NetworkDirectionIngress
+	case packetTypeOutgoing:
+		connection.Direction = storageclient.NetworkDirectionEgress
+	default:
+		return connection, false
+	}
+	return connection, true
+}
+
+func sortedConnections(connections map[storageclient.NetworkConnection]bool) []storageclient.NetworkConnection {
+	list := make([]storageclient.NetworkConnection, 0, len(connections))
+	for connection := range connections {
+		list = append(list, connection)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Direction != list[j].Direction {
+			return list[i].Direction < list[j].Direction
+		}
+		if list[i].Address != list[j].Address {
+			return list[i].Address < list[j].Address
+		}
+		if list[i].Protocol != list[j].Protocol {
+			return list[i].Protocol < list[j].Protocol
+		}
+		return list[i].Port < list[j].Port
+	})
+	return list
+}
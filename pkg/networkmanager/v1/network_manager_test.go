@@ -0,0 +1,163 @@
+package networkmanager
+
+import (
+	"context"
+	"node-agent/pkg/config"
+	"node-agent/pkg/storageclient"
+	"sync"
+	"testing"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracernetworktype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/network/types"
+	eventtypes "github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const instanceIDMock = "apiVersion-v1/namespace-default/kind-deployment/name-redis/containerName-redis"
+
+// storageClientMock keeps the network profiles in memory, the other methods are not used by the network manager
+type storageClientMock struct {
+	storageclient.StorageClient
+	mutex    sync.Mutex
+	profiles map[string]storageclient.NetworkProfile
+	creates  int
+	updates  int
+}
+
+func (sc *storageClientMock) GetNetworkProfile(_ context.Context, key string) (*storageclient.NetworkProfile, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	profile, ok := sc.profiles[key]
+	if !ok {
+		return nil, storageclient.ErrNotFound
+	}
+	return &profile, nil
+}
+
+func (sc *storageClientMock) CreateNetworkProfile(_ context.Context, profile *storageclient.NetworkProfile) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.creates++
+	if _, ok := sc.profiles[profile.Name]; ok {
+		return storageclient.ErrAlreadyExist
+	}
+	sc.profiles[profile.Name] = *profile
+	return nil
+}
+
+func (sc *storageClientMock) UpdateNetworkProfile(_ context.Context, key string, profile *storageclient.NetworkProfile) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.updates++
+	sc.profiles[key] = *profile
+	return nil
+}
+
+func createNetworkManagerMock(t *testing.T) (*NetworkManager, *storageClientMock) {
+	storageClient := &storageClientMock{profiles: map[string]storageclient.NetworkProfile{}}
+	nm, err := CreateNetworkManager(config.Config{}, nil, storageClient)
+	if err != nil {
+		t.Fatalf("fail to create network manager, err: %v", err)
+	}
+	nm.getInstanceID = func(_ context.Context, _ *containercollection.Container) (instanceidhandler.IInstanceID, error) {
+		return instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	}
+	return nm, storageClient
+}
+
+func networkEventMock(pktType, proto, addr string, port uint16) *tracernetworktype.Event {
+	return &tracernetworktype.Event{
+		Event:       eventtypes.Event{Type: eventtypes.NORMAL},
+		PktType:     pktType,
+		Proto:       proto,
+		Port:        port,
+		DstEndpoint: eventtypes.L3Endpoint{Addr: addr},
+	}
+}
+
+func TestNetworkManagerStoreProfile(t *testing.T) {
+	nm, storageClient := createNetworkManagerMock(t)
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "redis-1", Name: "redis"}
+	ctx := context.TODO()
+
+	// events of unknown containers are ignored
+	nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeHost, "tcp", "10.0.0.2", 6379))
+	nm.ReportContainerStarted(ctx, container)
+	nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeHost, "tcp", "10.0.0.2", 6379))
+	nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeHost, "tcp", "10.0.0.2", 6379))
+	nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeOutgoing, "udp", "10.96.0.10", 53))
+	nm.ReportNetworkEvent(ctx, container, networkEventMock("BROADCAST", "udp", "255.255.255.255", 67))
+
+	data, _ := nm.watchedContainers.Load(container.ID)
+	nm.storeProfile(ctx, data.(*containerNetworkData))
+	slug, _ := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	name, _ := slug.GetSlug()
+	profile, err := storageClient.GetNetworkProfile(ctx, networkProfilePrefix+name)
+	if err != nil {
+		t.Fatalf("fail to get network profile, err: %v", err)
+	}
+	assert.Equal(t, "redis", profile.Spec.ContainerName)
+	assert.Equal(t, []storageclient.NetworkConnection{
+		{Direction: storageclient.NetworkDirectionEgress, Protocol: "udp", Port: 53, Address: "10.96.0.10"},
+		{Direction: storageclient.NetworkDirectionIngress, Protocol: "tcp", Port: 6379, Address: "10.0.0.2"},
+	}, profile.Spec.Connections)
+	assert.Equal(t, "redis", profile.Labels[instanceidhandlerV1.NameMetadataKey])
+	assert.Equal(t, instanceIDMock, profile.Annotations[instanceidhandlerV1.InstanceIDMetadataKey])
+
+	// nothing new, nothing stored
+	nm.storeProfile(ctx, data.(*containerNetworkData))
+	assert.Equal(t, 1, storageClient.creates)
+	assert.Equal(t, 0, storageClient.updates)
+
+	// the last connections are stored when the container terminates
+	nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeOutgoing, "tcp", "10.0.0.3", 443))
+	nm.ReportContainerTerminated(ctx, container)
+	assert.Equal(t, 1, storageClient.updates)
+	profile, _ = storageClient.GetNetworkProfile(ctx, networkProfilePrefix+name)
+	assert.Len(t, profile.Spec.Connections, 3)
+	_, ok := nm.watchedContainers.Load(container.ID)
+	assert.False(t, ok)
+}
+
+func TestNetworkManagerExistingProfile(t *testing.T) {
+	nm, storageClient := createNetworkManagerMock(t)
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "redis-1", Name: "redis"}
+	ctx := context.TODO()
+
+	// a profile stored by a previous run of the agent is extended
+	instanceID, _ := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	slug, _ := instanceID.GetSlug()
+	existing := storageclient.NetworkProfile{Spec: storageclient.NetworkProfileSpec{
+		ContainerName: "redis",
+		Connections:   []storageclient.NetworkConnection{{Direction: storageclient.NetworkDirectionIngress, Protocol: "tcp", Port: 6379, Address: "10.0.0.9"}},
+	}}
+	existing.SetName(networkProfilePrefix + slug)
+	storageClient.profiles[existing.Name] = existing
+
+	nm.ReportContainerStarted(ctx, container)
+	nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeHost, "tcp", "10.0.0.2", 6379))
+	nm.ReportContainerTerminated(ctx, container)
+
+	profile, _ := storageClient.GetNetworkProfile(ctx, existing.Name)
+	assert.Len(t, profile.Spec.Connections, 2)
+	assert.Equal(t, 1, storageClient.updates)
+}
+
+func TestNetworkManagerMaxConnections(t *testing.T) {
+	nm, storageClient := createNetworkManagerMock(t)
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: "redis-1", Name: "redis"}
+	ctx := context.TODO()
+
+	nm.ReportContainerStarted(ctx, container)
+	for port := 1; port <= maxConnections+10; port++ {
+		nm.ReportNetworkEvent(ctx, container, networkEventMock(packetTypeOutgoing, "tcp", "10.0.0.3", uint16(port)))
+	}
+	nm.ReportContainerTerminated(ctx, container)
+
+	slug, _ := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDMock)
+	name, _ := slug.GetSlug()
+	profile, _ := storageClient.GetNetworkProfile(ctx, networkProfilePrefix+name)
+	assert.Len(t, profile.Spec.Connections, maxConnections)
+}
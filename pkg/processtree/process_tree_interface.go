@@ -0,0 +1,43 @@
+package processtree
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+)
+
+// Process is a process of a container, it is known from its exec event or read from /proc when it was forked
+// without calling exec
+type Process struct {
+	Pid  uint32   `json:"pid"`
+	Ppid uint32   `json:"ppid"`
+	Uid  uint32   `json:"uid"`
+	Comm string   `json:"comm"`
+	Path string   `json:"path,omitempty"`
+	Args []string `json:"args,omitempty"`
+	Cwd  string   `json:"cwd,omitempty"`
+}
+
+// ProcessChain is the ancestry of a process, it starts with the process itself and ends with the first ancestor
+// that belongs to the container
+type ProcessChain []Process
+
+// String returns the chain from the oldest ancestor to the process, like "sh(10) -> curl(12)"
+func (c ProcessChain) String() string {
+	names := make([]string, len(c))
+	for i := range c {
+		names[len(c)-1-i] = fmt.Sprintf("%s(%d)", c[i].Comm, c[i].Pid)
+	}
+	return strings.Join(names, " -> ")
+}
+
+type ProcessTreeClient interface {
+	ReportContainerStarted(ctx context.Context, container *containercollection.Container)
+	ReportContainerTerminated(ctx context.Context, container *containercollection.Container)
+	ReportExecEvent(ctx context.Context, container *containercollection.Container, event *tracerexectype.Event)
+	GetProcessChain(container *containercollection.Container, pid uint32) ProcessChain
+	StartProcessTree(ctx context.Context)
+}
@@ -0,0 +1,274 @@
+package processtree
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"node-agent/pkg/processtree"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// pruneInterval is how often the processes that exited are removed from the trees
+	pruneInterval = 30 * time.Second
+	// maxChainLength bounds the walk up the ancestry of a process
+	maxChainLength = 64
+)
+
+// process is a node of a container process tree
+type process struct {
+	processtree.Process
+	// startTime is the start time of the process in clock ticks after boot, it tells a reused pid apart
+	startTime uint64
+}
+
+type containerProcesses struct {
+	container *containercollection.Container
+	processes map[uint32]*process
+}
+
+// ProcessTree keeps the processes of each container, keyed by pid, from the exec events. Inspektor Gadget has no
+// exit tracer, so the processes that exited are pruned periodically by checking /proc instead, they are kept until
+// then to resolve the ancestry of the events still in flight. The processes forked without calling exec are read
+// from /proc the first time they show up in a chain.
+type ProcessTree struct {
+	procPath   string
+	containers map[string]*containerProcesses
+	mutex      sync.Mutex
+}
+
+var _ processtree.ProcessTreeClient = (*ProcessTree)(nil)
+
+func CreateProcessTree(procPath string) *ProcessTree {
+	return &ProcessTree{
+		procPath:   procPath,
+		containers: make(map[string]*containerProcesses),
+	}
+}
+
+func (pt *ProcessTree) ReportContainerStarted(_ context.Context, container *containercollection.Container) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	if _, ok := pt.containers[container.ID]; !ok {
+		pt.containers[container.ID] = &containerProcesses{container: container, processes: make(map[uint32]*process)}
+	}
+}
+
+func (pt *ProcessTree) ReportContainerTerminated(_ context.Context, container *containercollection.Container) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	delete(pt.containers, container.ID)
+}
+
+func (pt *ProcessTree) ReportExecEvent(_ context.Context, container *containercollection.Container, event *tracerexectype.Event) {
+	processPath := filepath.Join(pt.procPath, strconv.FormatUint(uint64(event.Pid), 10))
+	p := &process{Process: processtree.Process{
+		Pid:  event.Pid,
+		Ppid: event.Ppid,
+		Uid:  event.Uid,
+		Comm: event.Comm,
+		Path: event.Comm,
+		Args: event.Args,
+	}}
+	if len(event.Args) > 0 {
+		p.Path = event.Args[0]
+	}
+	// the exec event has no working directory, it is read from /proc while the process lives
+	p.Cwd, _ = os.Readlink(filepath.Join(processPath, "cwd"))
+	p.startTime, _ = readStartTime(processPath)
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	containerData, ok := pt.containers[container.ID]
+	if !ok {
+		containerData = &containerProcesses{container: container, processes: make(map[uint32]*process)}
+		pt.containers[container.ID] = containerData
+	}
+	containerData.processes[event.Pid] = p
+}
+
+// GetProcessChain returns the ancestry of a process of a container, the walk stops at the first process of the
+// container or at the first ancestor outside of it
+func (pt *ProcessTree) GetProcessChain(container *containercollection.Container, pid uint32) processtree.ProcessChain {
+	var chain processtree.ProcessChain
+	for pid != 0 && len(chain) < maxChainLength {
+		p := pt.getProcess(container, pid)
+		if p == nil {
+			break
+		}
+		chain = append(chain, p.Process)
+		if pid == container.Pid || p.Ppid == pid {
+			break
+		}
+		pid = p.Ppid
+	}
+	return chain
+}
+
+func (pt *ProcessTree) StartProcessTree(ctx context.Context) {
+	_, span := otel.Tracer("").Start(ctx, "ProcessTree.StartProcessTree")
+	defer span.End()
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pt.prune()
+			}
+		}
+	}()
+}
+
+// getProcess returns a process of the tree of a container, a process missing from the tree is read from /proc
+// and added to it when it belongs to the container
+func (pt *ProcessTree) getProcess(container *containercollection.Container, pid uint32) *process {
+	pt.mutex.Lock()
+	if containerData, ok := pt.containers[container.ID]; ok {
+		if p, ok := containerData.processes[pid]; ok {
+			pt.mutex.Unlock()
+			return p
+		}
+	}
+	pt.mutex.Unlock()
+
+	p, mntns, err := readProcess(filepath.Join(pt.procPath, strconv.FormatUint(uint64(pid), 10)))
+	if err != nil || mntns != container.Mntns {
+		return nil
+	}
+	p.Pid = pid
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	if containerData, ok := pt.containers[container.ID]; ok {
+		containerData.processes[pid] = p
+	}
+	return p
+}
+
+// prune removes the processes that exited, or whose pid was reused, from the trees
+func (pt *ProcessTree) prune() {
+	type node struct {
+		containerID string
+		process     *process
+	}
+	var nodes []node
+	pt.mutex.Lock()
+	for containerID, containerData := range pt.containers {
+		for _, p := range containerData.processes {
+			nodes = append(nodes, node{containerID: containerID, process: p})
+		}
+	}
+	pt.mutex.Unlock()
+
+	var exited []node
+	for _, n := range nodes {
+		startTime, err := readStartTime(filepath.Join(pt.procPath, strconv.FormatUint(uint64(n.process.Pid), 10)))
+		if err != nil || (n.process.startTime != 0 && startTime != n.process.startTime) {
+			exited = append(exited, n)
+		}
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	for _, n := range exited {
+		// the pid may have been reported again since it was checked
+		if containerData, ok := pt.containers[n.containerID]; ok && containerData.processes[n.process.Pid] == n.process {
+			delete(containerData.processes, n.process.Pid)
+		}
+	}
+}
+
+// readProcess reads a process and its mount namespace from its /proc/<pid> directory
+func readProcess(processPath string) (*process, uint64, error) {
+	link, err := os.Readlink(filepath.Join(processPath, "ns", "mnt"))
+	if err != nil {
+		return nil, 0, err
+	}
+	// the link reads "mnt:[<inode>]"
+	mntns, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "mnt:["), "]"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unexpected mount namespace link %s: %w", link, err)
+	}
+	p := &process{}
+	if err := readStatus(filepath.Join(processPath, "status"), p); err != nil {
+		return nil, 0, err
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(processPath, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Args = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	p.Path = p.Comm
+	if len(p.Args) > 0 {
+		p.Path = p.Args[0]
+	}
+	p.Cwd, _ = os.Readlink(filepath.Join(processPath, "cwd"))
+	p.startTime, _ = readStartTime(processPath)
+	return p, mntns, nil
+}
+
+// readStatus reads the name, the parent pid and the real uid of a process from its /proc/<pid>/status file
+func readStatus(statusPath string, p *process) error {
+	statusFile, err := os.Open(statusPath)
+	if err != nil {
+		return err
+	}
+	defer statusFile.Close()
+
+	scanner := bufio.NewScanner(statusFile)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "Name":
+			p.Comm = fields[0]
+		case "PPid":
+			ppid, err := strconv.ParseUint(fields[0], 10, 32)
+			if err != nil {
+				return fmt.Errorf("unexpected parent pid %s: %w", fields[0], err)
+			}
+			p.Ppid = uint32(ppid)
+		case "Uid":
+			uid, err := strconv.ParseUint(fields[0], 10, 32)
+			if err != nil {
+				return fmt.Errorf("unexpected uid %s: %w", fields[0], err)
+			}
+			p.Uid = uint32(uid)
+		}
+	}
+	return scanner.Err()
+}
+
+// readStartTime reads the start time of a process from its /proc/<pid>/stat file, it is the 22nd field and the
+// name in the 2nd field may contain spaces and parentheses
+func readStartTime(processPath string) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(processPath, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0, fmt.Errorf("unexpected stat %s", stat)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("unexpected stat %s", stat)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
@@ -0,0 +1,129 @@
+package processtree
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const mntnsMock = "4026532000"
+
+// processMock is a /proc/<pid> directory
+type processMock struct {
+	pid       string
+	ppid      string
+	name      string
+	cmdline   []string
+	mntns     string
+	startTime string
+}
+
+func writeProcMock(t *testing.T, procPath string, p processMock) {
+	processPath := filepath.Join(procPath, p.pid)
+	if err := os.MkdirAll(filepath.Join(processPath, "ns"), 0755); err != nil {
+		t.Fatalf("fail to create directory, err: %v", err)
+	}
+	if err := os.Symlink("mnt:["+p.mntns+"]", filepath.Join(processPath, "ns", "mnt")); err != nil {
+		t.Fatalf("fail to create symlink, err: %v", err)
+	}
+	if err := os.Symlink("/app", filepath.Join(processPath, "cwd")); err != nil {
+		t.Fatalf("fail to create symlink, err: %v", err)
+	}
+	status := fmt.Sprintf("Name:\t%s\nState:\tS (sleeping)\nPPid:\t%s\nUid:\t101\t101\t101\t101\n", p.name, p.ppid)
+	stat := fmt.Sprintf("%s (%s) S %s 1 1 0 -1 4194560 100 0 0 0 1 1 0 0 20 0 1 0 %s 1000 100\n", p.pid, p.name, p.ppid, p.startTime)
+	for file, content := range map[string]string{
+		"status":  status,
+		"stat":    stat,
+		"cmdline": strings.Join(p.cmdline, "\x00") + "\x00",
+	} {
+		if err := os.WriteFile(filepath.Join(processPath, file), []byte(content), 0644); err != nil {
+			t.Fatalf("fail to write %s file, err: %v", file, err)
+		}
+	}
+}
+
+func execEventMock(pid, ppid uint32, comm string, args ...string) *tracerexectype.Event {
+	return &tracerexectype.Event{Pid: pid, Ppid: ppid, Uid: 101, Comm: comm, Args: args}
+}
+
+func TestGetProcessChain(t *testing.T) {
+	procPath := t.TempDir()
+	// nginx is the first process of the container, its worker is forked and runs sh which runs curl
+	writeProcMock(t, procPath, processMock{pid: "5", ppid: "1", name: "containerd-shim", cmdline: []string{"containerd-shim"}, mntns: "4026531840", startTime: "50"})
+	writeProcMock(t, procPath, processMock{pid: "10", ppid: "5", name: "nginx", cmdline: []string{"nginx", "-g", "daemon off;"}, mntns: mntnsMock, startTime: "100"})
+	writeProcMock(t, procPath, processMock{pid: "11", ppid: "10", name: "nginx", cmdline: []string{"nginx: worker process"}, mntns: mntnsMock, startTime: "110"})
+	writeProcMock(t, procPath, processMock{pid: "12", ppid: "11", name: "sh", cmdline: []string{"/bin/sh", "-c", "curl example.com"}, mntns: mntnsMock, startTime: "120"})
+
+	ctx := context.TODO()
+	pt := CreateProcessTree(procPath)
+	container := &containercollection.Container{ID: "abc", Pid: 10, Mntns: 4026532000}
+	pt.ReportContainerStarted(ctx, container)
+	pt.ReportExecEvent(ctx, container, execEventMock(12, 11, "sh", "/bin/sh", "-c", "curl example.com"))
+	pt.ReportExecEvent(ctx, container, execEventMock(13, 12, "curl", "/usr/bin/curl", "example.com"))
+
+	chain := pt.GetProcessChain(container, 13)
+	if assert.Len(t, chain, 4) {
+		assert.Equal(t, "/usr/bin/curl", chain[0].Path)
+		assert.Equal(t, []string{"/usr/bin/curl", "example.com"}, chain[0].Args)
+		assert.Equal(t, uint32(101), chain[0].Uid)
+		// the worker was forked, it is read from /proc
+		assert.Equal(t, uint32(11), chain[2].Pid)
+		assert.Equal(t, []string{"nginx: worker process"}, chain[2].Args)
+		assert.Equal(t, "/app", chain[2].Cwd)
+		assert.Equal(t, uint32(10), chain[3].Pid)
+	}
+	assert.Equal(t, "nginx(10) -> nginx(11) -> sh(12) -> curl(13)", chain.String())
+
+	// the walk stops at the first ancestor outside of the container
+	container.Pid = 0
+	assert.Len(t, pt.GetProcessChain(container, 13), 4)
+	assert.Empty(t, pt.GetProcessChain(container, 5))
+	assert.Empty(t, pt.GetProcessChain(container, 42))
+}
+
+func TestProcessTreePrune(t *testing.T) {
+	procPath := t.TempDir()
+	writeProcMock(t, procPath, processMock{pid: "10", ppid: "5", name: "nginx", cmdline: []string{"nginx"}, mntns: mntnsMock, startTime: "100"})
+	writeProcMock(t, procPath, processMock{pid: "12", ppid: "10", name: "sh", cmdline: []string{"sh"}, mntns: mntnsMock, startTime: "120"})
+
+	ctx := context.TODO()
+	pt := CreateProcessTree(procPath)
+	container := &containercollection.Container{ID: "abc", Pid: 10, Mntns: 4026532000}
+	pt.ReportExecEvent(ctx, container, execEventMock(10, 5, "nginx", "nginx"))
+	pt.ReportExecEvent(ctx, container, execEventMock(12, 10, "sh", "sh"))
+	// the process exited before it was read
+	pt.ReportExecEvent(ctx, container, execEventMock(13, 12, "id", "id"))
+	assert.Len(t, pt.containers["abc"].processes, 3)
+
+	// sh exits and its pid is reused
+	if err := os.RemoveAll(filepath.Join(procPath, "12")); err != nil {
+		t.Fatalf("fail to remove directory, err: %v", err)
+	}
+	writeProcMock(t, procPath, processMock{pid: "12", ppid: "10", name: "cat", cmdline: []string{"cat"}, mntns: mntnsMock, startTime: "200"})
+	pt.prune()
+	assert.Len(t, pt.containers["abc"].processes, 1)
+	assert.Equal(t, "cat", pt.GetProcessChain(container, 12)[0].Comm)
+
+	pt.ReportContainerTerminated(ctx, container)
+	assert.Empty(t, pt.containers)
+}
+
+func TestReadStartTime(t *testing.T) {
+	procPath := t.TempDir()
+	writeProcMock(t, procPath, processMock{pid: "10", ppid: "5", name: "my (app)", mntns: mntnsMock, startTime: "4242"})
+	startTime, err := readStartTime(filepath.Join(procPath, "10"))
+	if err != nil {
+		t.Fatalf("fail to read start time, err: %v", err)
+	}
+	assert.Equal(t, uint64(4242), startTime)
+
+	_, err = readStartTime(filepath.Join(procPath, "20"))
+	assert.Error(t, err)
+}
@@ -3,14 +3,33 @@ package relevancymanager
 import (
 	"context"
 	"node-agent/pkg/containerwatcher"
+	"node-agent/pkg/processtree"
 
 	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
 )
 
+// FileAccessKind tells how a container accessed a file
+type FileAccessKind string
+
+const (
+	// FileAccessExec is an executed binary
+	FileAccessExec FileAccessKind = "exec"
+	// FileAccessOpen is a file opened with open or openat
+	FileAccessOpen FileAccessKind = "open"
+	// FileAccessLibrary is a shared library an executed binary depends on (DT_NEEDED)
+	FileAccessLibrary FileAccessKind = "library"
+	// FileAccessMmap is an executable file mapped in the memory of a process
+	FileAccessMmap FileAccessKind = "mmap"
+)
+
 type RelevancyManagerClient interface {
 	ReportContainerStarted(ctx context.Context, container *containercollection.Container)
 	ReportContainerTerminated(ctx context.Context, container *containercollection.Container)
-	ReportFileAccess(ctx context.Context, namespace, pod, container, file string)
+	// ReportFileAccess records a file accessed by a container, process is the chain of the process that accessed it
+	ReportFileAccess(ctx context.Context, namespace, pod, container, file string, kind FileAccessKind, process processtree.ProcessChain)
+	// GetFileProcesses returns the process chain that first accessed each file of a container during its sniffing
+	// time, the chains are dropped when the sniffing time is over or the container terminates
+	GetFileProcesses(namespace, pod, container string) map[string]string
 	SetContainerHandler(containerHandler containerwatcher.ContainerWatcher)
 	StartRelevancyManager(ctx context.Context)
 }
@@ -7,14 +7,17 @@ import (
 	"node-agent/pkg/config"
 	"node-agent/pkg/containerwatcher"
 	"node-agent/pkg/filehandler"
+	"node-agent/pkg/imageresolver"
+	"node-agent/pkg/metadataprovider"
+	"node-agent/pkg/processtree"
 	"node-agent/pkg/relevancymanager"
 	"node-agent/pkg/sbom"
-	sbomV1 "node-agent/pkg/sbom/v1"
 	"node-agent/pkg/storageclient"
 	"node-agent/pkg/utils"
 	"sync"
 	"time"
 
+	cyclonedx "github.com/CycloneDX/cyclonedx-go"
 	"github.com/armosec/utils-k8s-go/wlid"
 	"github.com/gammazero/workerpool"
 	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
@@ -22,7 +25,6 @@ import (
 	"github.com/kubescape/go-logger/helpers"
 	"github.com/kubescape/k8s-interface/instanceidhandler"
 	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
-	"github.com/kubescape/k8s-interface/k8sinterface"
 	"github.com/kubescape/k8s-interface/workloadinterface"
 	"github.com/spf13/afero"
 	"go.opentelemetry.io/otel"
@@ -33,13 +35,19 @@ import (
 const (
 	RelevantCVEsService = "RelevantCVEsService"
 	StepGetSBOM         = "StepGetSBOM"
-	StepValidateSBOM    = "StepValidateSBOM"
 	StepEventAggregator = "StepEventAggregator"
 )
 
 // Number of workers for handling list of files and submitting to the storage. This number should not be too high so the storage wont get overwhelmed.
 const fileWorkersConcurrency = 4
 
+// maxFileProcesses is the number of files of a container whose process chain is kept
+const maxFileProcesses = 10000
+
+// podStatusTimeout is how long the image ID of a container is waited for in the status of its pod, the SBOM is
+// retried on the next tick after that
+const podStatusTimeout = 30 * time.Second
+
 var (
 	containerHasTerminatedError = errors.New("container has terminated")
 )
@@ -51,27 +59,48 @@ type RelevancyManager struct {
 	// FIXME we need this circular dependency to unregister the tracer at the end of startRelevancyProcess
 	containerHandler  containerwatcher.ContainerWatcher
 	fileHandler       filehandler.FileHandler
-	k8sClient         *k8sinterface.KubernetesApi
+	metadataProvider  metadataprovider.MetadataProviderClient
+	imageResolver     imageresolver.ImageResolverClient
 	sbomFs            afero.Fs
 	storageClient     storageclient.StorageClient
 	watchedContainers sync.Map
 	fileWorkerPool    *workerpool.WorkerPool
+	// fileProcesses maps a k8s container ID to the *accessedFiles of the container
+	fileProcesses sync.Map
+	// exitedContainers maps the ID of a container that exited before its SBOM was resolved to its *exitedContainerData
+	exitedContainers sync.Map
+	// instances maps the key of a filtered SBOM to the *instanceRelevancy of its instance
+	instances sync.Map
+	// resolveSBOM is replaced in the tests
+	resolveSBOM func(ctx context.Context, watchedContainer *watchedContainerData) error
+}
+
+// accessedFiles holds the process chain that first accessed each file of a container, up to maxFileProcesses files
+type accessedFiles struct {
+	mutex     sync.Mutex
+	processes map[string]string
 }
 
 var _ relevancymanager.RelevancyManagerClient = (*RelevancyManager)(nil)
 
-func CreateRelevancyManager(cfg config.Config, clusterName string, fileHandler filehandler.FileHandler, k8sClient *k8sinterface.KubernetesApi, sbomFs afero.Fs, storageClient storageclient.StorageClient) (*RelevancyManager, error) {
-	return &RelevancyManager{
+func CreateRelevancyManager(cfg config.Config, clusterName string, fileHandler filehandler.FileHandler, metadataProvider metadataprovider.MetadataProviderClient, imageResolver imageresolver.ImageResolverClient, sbomFs afero.Fs, storageClient storageclient.StorageClient) (*RelevancyManager, error) {
+	rm := &RelevancyManager{
 		afterTimerActionsChannel: make(chan afterTimerActionsData, 50),
 		cfg:                      cfg,
 		clusterName:              clusterName,
 		fileHandler:              fileHandler,
-		k8sClient:                k8sClient,
+		metadataProvider:         metadataProvider,
+		imageResolver:            imageResolver,
 		sbomFs:                   sbomFs,
 		storageClient:            storageClient,
 		watchedContainers:        sync.Map{},
 		fileWorkerPool:           workerpool.New(fileWorkersConcurrency),
-	}, nil
+	}
+	rm.resolveSBOM = rm.getPodSBOM
+	if cfg.Standalone.Enabled {
+		rm.resolveSBOM = rm.getContainerSBOM
+	}
+	return rm, nil
 }
 
 // Handle relevant data
@@ -80,9 +109,22 @@ func (rm *RelevancyManager) handleRelevancy(ctx context.Context, containerData w
 	ctxPostSBOM, spanPostSBOM := otel.Tracer("").Start(ctx, "PostFilterSBOM")
 	defer spanPostSBOM.End()
 
-	if err := containerData.sbomClient.ValidateSBOM(ctx); err != nil {
-		logger.L().Info("SBOM is incomplete", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
-		containerData.syncChannel[StepValidateSBOM] <- err
+	filterSBOMKey, err := containerData.instanceID.GetSlug()
+	if err != nil {
+		ctx, span := otel.Tracer("").Start(ctxPostSBOM, "filterSBOMKey")
+		defer span.End()
+		logger.L().Ctx(ctx).Warning("failed to get filterSBOMKey for store filter SBOM", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
+		return
+	}
+
+	if err = containerData.sbomClient.ValidateSBOM(ctx); err != nil {
+		// the accessed files are kept in the file handler, the whole history is filtered once the SBOM is regenerated
+		// complete. The incomplete status is stored with the first report.
+		logger.L().Debug("SBOM is incomplete, collecting the accessed files until it is complete", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
+		if err = containerData.sbomClient.StoreFilterSBOM(ctx, containerData.imageID, filterSBOMKey); err != nil && !errors.Is(err, sbom.IsAlreadyExist()) {
+			logger.L().Ctx(ctx).Error("failed to store incomplete filtered SBOM", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
+		}
+		return
 	}
 
 	fileList, err := rm.fileHandler.GetFiles(containerData.k8sContainerID)
@@ -92,34 +134,179 @@ func (rm *RelevancyManager) handleRelevancy(ctx context.Context, containerData w
 	}
 	logger.L().Debug("fileList generated", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.String("file list", fmt.Sprintf("%v", fileList)))
 
-	if err = containerData.sbomClient.FilterSBOM(ctx, fileList); err != nil {
+	if !rm.filterRelevantFiles(ctxPostSBOM, containerData, containerID, filterSBOMKey, fileList) {
 		rm.fileHandler.AddFiles(containerData.k8sContainerID, fileList)
-		ctx, span := otel.Tracer("").Start(ctxPostSBOM, "FilterSBOM")
+	}
+}
+
+// filterRelevantFiles filters the SBOM of a container with the files of its instance and stores it, it returns false
+// when the files should be filtered again later
+func (rm *RelevancyManager) filterRelevantFiles(ctx context.Context, containerData watchedContainerData, containerID, filterSBOMKey string, fileList map[string]bool) bool {
+	// the filtered SBOM is shared by the runs of the instance, it gets the files of all the runs of its image
+	relevantFiles, relevantPackages, ok := rm.mergeInstanceFiles(ctx, filterSBOMKey, containerData, fileList)
+	if !ok {
+		logger.L().Debug("instance runs a newer image, dropping the files of the container", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.String("imageID", containerData.imageID))
+		return true
+	}
+	containerData.sbomClient.RestoreRelevantPackages(relevantPackages)
+
+	if err := containerData.sbomClient.FilterSBOM(ctx, relevantFiles); err != nil {
+		ctx, span := otel.Tracer("").Start(ctx, "FilterSBOM")
 		defer span.End()
 		logger.L().Ctx(ctx).Warning("failed to filter SBOM", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
-		return
+		return false
+	}
+	// it is safe to use containerData.imageID directly since we needed it to retrieve the SBOM
+	if err := containerData.sbomClient.StoreFilterSBOM(ctx, containerData.imageID, filterSBOMKey); err != nil {
+		if errors.Is(err, sbom.IsAlreadyExist()) {
+			return true
+		}
+		ctx, span := otel.Tracer("").Start(ctx, "StoreFilterSBOM")
+		defer span.End()
+		// the filtered SBOM only grows, storing it again would fail the same way
+		if errors.Is(err, storageclient.ErrTooLarge) {
+			logger.L().Ctx(ctx).Error("filtered SBOM does not fit in the storage, its relevant files are dropped", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
+			return true
+		}
+		logger.L().Ctx(ctx).Error("failed to store filtered SBOM", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
+		return false
 	}
+
+	logger.L().Info("filtered SBOM has been stored successfully", helpers.String("containerID", containerID), helpers.String("k8s workload", containerData.k8sContainerID))
+	return true
+}
+
+// filterExitedFiles filters the SBOM of a terminated container with the files taken from the file handler when it
+// terminated, it returns false when the files should be filtered again later
+func (rm *RelevancyManager) filterExitedFiles(ctx context.Context, containerData watchedContainerData, containerID string, fileList map[string]bool) bool {
 	filterSBOMKey, err := containerData.instanceID.GetSlug()
 	if err != nil {
-		rm.fileHandler.AddFiles(containerData.k8sContainerID, fileList)
-		ctx, span := otel.Tracer("").Start(ctxPostSBOM, "filterSBOMKey")
-		defer span.End()
 		logger.L().Ctx(ctx).Warning("failed to get filterSBOMKey for store filter SBOM", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
-		return
+		return true
 	}
-	// it is safe to use containerData.imageID directly since we needed it to retrieve the SBOM
-	if err = containerData.sbomClient.StoreFilterSBOM(ctx, containerData.imageID, filterSBOMKey); err != nil {
-		if !errors.Is(err, sbom.IsAlreadyExist()) {
-			rm.fileHandler.AddFiles(containerData.k8sContainerID, fileList)
-			ctx, span := otel.Tracer("").Start(ctxPostSBOM, "StoreFilterSBOM")
-			defer span.End()
-			logger.L().Ctx(ctx).Error("failed to store filtered SBOM", helpers.String("container ID", containerID), helpers.String("k8s workload", containerData.k8sContainerID), helpers.Error(err))
+	return rm.filterRelevantFiles(ctx, containerData, containerID, filterSBOMKey, fileList)
+}
+
+// mergeInstanceFiles adds the files accessed by a container to the files of its instance and returns all of them with
+// the stored relevant packages of the instance. The files are reset when the container runs a newer image than the
+// instance, they are not merged when it runs an older one since the filtered SBOM is then the one of the newer image.
+func (rm *RelevancyManager) mergeInstanceFiles(ctx context.Context, filterSBOMKey string, containerData watchedContainerData, fileList map[string]bool) (map[string]bool, map[string]bool, bool) {
+	value, exist := rm.instances.Load(filterSBOMKey)
+	if !exist {
+		// the relevancy of the image stored before the agent started is kept
+		files, packages := rm.getStoredRelevancy(ctx, filterSBOMKey, containerData.imageID)
+		value, _ = rm.instances.LoadOrStore(filterSBOMKey, &instanceRelevancy{
+			imageID:      containerData.imageID,
+			podCreated:   containerData.podCreated,
+			restartCount: containerData.restartCount,
+			files:        files,
+			packages:     packages,
+		})
+	}
+	instance := value.(*instanceRelevancy)
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.lastUpdate = time.Now()
+	newer := instance.isOlderThan(containerData)
+	if instance.imageID != containerData.imageID {
+		if !newer {
+			return nil, nil, false
 		}
+		logger.L().Info("image of instance has changed, resetting its filtered SBOM", helpers.String("instance", filterSBOMKey), helpers.String("previous imageID", instance.imageID), helpers.String("imageID", containerData.imageID))
+		instance.imageID = containerData.imageID
+		instance.files = make(map[string]bool)
+		instance.packages = make(map[string]bool)
+	}
+	if newer {
+		instance.podCreated, instance.restartCount = containerData.podCreated, containerData.restartCount
+	}
+	for file := range fileList {
+		instance.files[file] = true
+	}
+	relevantFiles := make(map[string]bool, len(instance.files))
+	for file := range instance.files {
+		relevantFiles[file] = true
+	}
+	relevantPackages := make(map[string]bool, len(instance.packages))
+	for packageID := range instance.packages {
+		relevantPackages[packageID] = true
+	}
+	return relevantFiles, relevantPackages, true
+}
+
+// getStoredRelevancy returns the files and the package identifiers of the stored filtered SBOM of an instance when it
+// is the one of imageID
+func (rm *RelevancyManager) getStoredRelevancy(ctx context.Context, filterSBOMKey, imageID string) (map[string]bool, map[string]bool) {
+	// Syft documents are always filtered into SPDX filtered SBOMs
+	if rm.cfg.SBOMFormat == config.SBOMFormatCycloneDX && rm.cfg.SBOMInput.Format != config.SBOMInputFormatSyft {
+		return rm.getStoredRelevancyCycloneDX(ctx, filterSBOMKey, imageID)
+	}
+	files, packages := make(map[string]bool), make(map[string]bool)
+	filteredSBOM, err := rm.storageClient.GetFilteredSBOM(ctx, filterSBOMKey)
+	if err != nil || filteredSBOM == nil {
+		return files, packages
+	}
+	if filteredSBOM.GetAnnotations()[instanceidhandlerV1.ImageIDMetadataKey] != imageID {
+		return files, packages
+	}
+	for _, file := range filteredSBOM.Spec.SPDX.Files {
+		if file != nil {
+			files[file.FileName] = true
+		}
+	}
+	for _, spdxPackage := range filteredSBOM.Spec.SPDX.Packages {
+		if spdxPackage != nil {
+			packages[string(spdxPackage.PackageSPDXIdentifier)] = true
+		}
+	}
+	return files, packages
+}
+
+func (rm *RelevancyManager) getStoredRelevancyCycloneDX(ctx context.Context, filterSBOMKey, imageID string) (map[string]bool, map[string]bool) {
+	files, packages := make(map[string]bool), make(map[string]bool)
+	filteredSBOM, err := rm.storageClient.GetFilteredSBOMCycloneDX(ctx, filterSBOMKey)
+	if err != nil || filteredSBOM == nil {
+		return files, packages
+	}
+	if filteredSBOM.GetAnnotations()[instanceidhandlerV1.ImageIDMetadataKey] != imageID {
+		return files, packages
+	}
+	addCycloneDXComponents(files, packages, filteredSBOM.Spec.Components)
+	return files, packages
+}
+
+// addCycloneDXComponents adds the file components to files and the other components to packages, the files are nested
+// under the packages containing them and the package references are their SPDX identifiers
+func addCycloneDXComponents(files, packages map[string]bool, components *[]cyclonedx.Component) {
+	if components == nil {
 		return
 	}
+	for i := range *components {
+		component := &(*components)[i]
+		if component.Type == cyclonedx.ComponentTypeFile {
+			files[component.Name] = true
+		} else if component.BOMRef != "" {
+			packages[component.BOMRef] = true
+		}
+		addCycloneDXComponents(files, packages, component.Components)
+	}
+}
 
-	logger.L().Info("filtered SBOM has been stored successfully", helpers.String("containerID", containerID), helpers.String("k8s workload", containerData.k8sContainerID))
+// pruneInstances forgets the instances not updated within the sniffing time, the files and packages of their stored
+// filtered SBOM are read back by getStoredRelevancy when they run again
+func (rm *RelevancyManager) pruneInstances() {
+	rm.instances.Range(func(key, value any) bool {
+		instance := value.(*instanceRelevancy)
+		instance.mutex.Lock()
+		expired := time.Since(instance.lastUpdate) > rm.cfg.MaxSniffingTime
+		instance.mutex.Unlock()
+		if expired {
+			rm.instances.Delete(key)
+		}
+		return true
+	})
 }
+
 func (rm *RelevancyManager) afterTimerActions(ctx context.Context) error {
 	for {
 		afterTimerActionsData := <-rm.afterTimerActionsChannel
@@ -153,13 +340,22 @@ func (rm *RelevancyManager) afterTimerActions(ctx context.Context) error {
 
 func (rm *RelevancyManager) deleteResources(watchedContainer watchedContainerData, containerID string) {
 	watchedContainer.snifferTicker.Stop()
-	if watchedContainer.sbomClient != nil {
-		watchedContainer.sbomClient.CleanResources()
+	// the files and the SBOM resources of a terminated container were handled when it terminated, the bucket may be
+	// the one of its next run
+	data, watched := rm.watchedContainers.LoadAndDelete(containerID)
+	if !watched {
+		return
+	}
+	// the SBOM is resolved on the stored data of the container, not on the copy of the monitoring
+	if current, ok := data.(watchedContainerData); ok && current.sbomClient != nil {
+		current.sbomClient.CleanResources()
 	}
-	rm.watchedContainers.Delete(containerID)
 
 	// Remove container from the file DB
-	rm.fileHandler.RemoveBucket(containerID)
+	if err := rm.fileHandler.RemoveBucket(watchedContainer.k8sContainerID); err != nil {
+		logger.L().Error("failed to remove container bucket", helpers.Error(err), helpers.String("container ID", containerID), helpers.String("k8s workload", watchedContainer.k8sContainerID))
+	}
+	rm.fileProcesses.Delete(watchedContainer.k8sContainerID)
 }
 
 func (rm *RelevancyManager) getSBOM(ctx context.Context, container *containercollection.Container) {
@@ -173,105 +369,138 @@ func (rm *RelevancyManager) getSBOM(ctx context.Context, container *containercol
 	watchedContainer := containerDataInterface.(watchedContainerData)
 	// skip if the SBOM is already retrieved
 	if watchedContainer.sbomClient != nil && watchedContainer.sbomClient.IsSBOMAlreadyExist() {
-		watchedContainer.syncChannel[StepGetSBOM] <- nil
+		var err error
+		if watchedContainer.sbomClient.IsSBOMIncomplete() {
+			// the SBOM of a large image can be truncated, it is fetched again until it is regenerated complete
+			err = watchedContainer.sbomClient.GetSBOM(ctx, watchedContainer.imageTag, watchedContainer.imageID)
+			if err == nil && !watchedContainer.sbomClient.IsSBOMIncomplete() {
+				logger.L().Info("SBOM is complete, filtering the accessed files", helpers.String("container ID", container.ID), helpers.String("k8s workload", watchedContainer.k8sContainerID))
+			}
+		}
+		watchedContainer.syncChannel[StepGetSBOM] <- err
 		return
 	}
-	// FIXME: this is a workaround to let the pod be updated with container information, avoiding another try
-	utils.RandomSleep(2, 10)
-	// get watchedContainer from map
-	containerDataInterface, exist = rm.watchedContainers.Load(container.ID)
-	if !exist {
-		return
+	err := rm.resolveSBOM(ctx, &watchedContainer)
+
+	// save watchedContainer with new fields, unless the container has terminated meanwhile
+	if _, exist = rm.watchedContainers.Load(container.ID); exist {
+		rm.watchedContainers.Store(container.ID, watchedContainer)
 	}
-	watchedContainer = containerDataInterface.(watchedContainerData)
-	// skip if the SBOM is already retrieved
-	if watchedContainer.sbomClient != nil && watchedContainer.sbomClient.IsSBOMAlreadyExist() {
-		watchedContainer.syncChannel[StepGetSBOM] <- nil
-		return
+
+	// notify the channel. This call must be at the end of the function as it will unblock the waitForTicks function
+	watchedContainer.syncChannel[StepGetSBOM] <- err
+}
+
+// getPodSBOM gets the SBOM of the image of a container from its pod, the pod is kept in the container data so the
+// SBOM can still be resolved once the pod is deleted
+func (rm *RelevancyManager) getPodSBOM(ctx context.Context, watchedContainer *watchedContainerData) error {
+	container := watchedContainer.container
+	// the status of the pod is updated with the image ID only after the container started, it is final once the
+	// container exited
+	timeout := podStatusTimeout
+	if _, exited := rm.exitedContainers.Load(container.ID); exited {
+		timeout = 0
 	}
-	// end of FIXME
-	// get pod information, we cannot do this during ReportContainerStarted because the pod might not be updated yet with container information
-	wl, err := rm.k8sClient.GetWorkload(container.Namespace, "Pod", container.Podname)
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	wl, err := rm.metadataProvider.WaitForPod(waitCtx, container.Namespace, container.Podname, func(pod *workloadinterface.Workload) bool {
+		podContainer, err := utils.GetPodContainer(pod, container.Name)
+		return err == nil && podContainer.ImageID != "" && podContainer.ContainerID == container.ID
+	})
 	if err != nil {
-		logger.L().Ctx(ctx).Error("failed to get pod", helpers.Error(err), helpers.String("namespace", container.Namespace), helpers.String("Pod name", container.Podname))
-		watchedContainer.syncChannel[StepGetSBOM] <- err
-		return
+		// the image ID is asked to the container runtime when it is not in the status
+		wl, err = rm.metadataProvider.GetPod(container.Namespace, container.Podname)
 	}
-	workload := wl.(*workloadinterface.Workload)
-	imageID, imageTag, parentWlid, instanceID, err := rm.parsePodData(ctx, workload, container)
-	// This behavior will happen when the running container is an initContainer
-	if err != nil || imageID == "" || imageTag == "" || parentWlid == "" || instanceID == nil {
-		watchedContainer.syncChannel[StepGetSBOM] <- err
-		return
+	if err != nil {
+		if watchedContainer.pod == nil {
+			logger.L().Ctx(ctx).Error("failed to get pod", helpers.Error(err), helpers.String("namespace", container.Namespace), helpers.String("Pod name", container.Podname))
+			return err
+		}
+		logger.L().Debug("failed to get pod, using the last known pod", helpers.Error(err), helpers.String("namespace", container.Namespace), helpers.String("Pod name", container.Podname))
+	} else {
+		watchedContainer.pod = wl
+	}
+	podContainer, parentWlid, instanceID, err := rm.parsePodData(ctx, watchedContainer.pod, container)
+	if err != nil {
+		return err
+	}
+	imageID, imageTag := podContainer.ImageID, podContainer.Image
+	if imageTag == "" || parentWlid == "" || instanceID == nil {
+		return fmt.Errorf("image of container %s not found in pod %s in namespace %s", container.Name, container.Podname, container.Namespace)
+	}
+	// the status can still be the one of the previous run of the container, its image may have changed since
+	restartCount := podContainer.RestartCount
+	if podContainer.ContainerID != "" && podContainer.ContainerID != container.ID {
+		imageID = ""
+		restartCount++
+	}
+	// the image ID of the status can be empty, a tag or runtime specific, the SBOM is looked up by digest
+	imageID, err = rm.imageResolver.ResolveImageID(ctx, container.ID, imageTag, imageID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve image ID of container %s in pod %s in namespace %s: %w", container.Name, container.Podname, container.Namespace, err)
 	}
 	// create sbomClient
-	sbomClient := sbom.CreateSBOMStorageClient(rm.storageClient, parentWlid, instanceID, rm.sbomFs)
+	sbomClient := sbom.CreateSBOMStorageClient(rm.storageClient, parentWlid, instanceID, rm.sbomFs, rm.cfg)
 
 	// get SBOM
 	err = sbomClient.GetSBOM(ctx, imageTag, imageID)
 
-	// save watchedContainer with new fields
+	watchedContainer.imageTag = imageTag
 	watchedContainer.imageID = imageID
 	watchedContainer.instanceID = instanceID
+	watchedContainer.podCreated = podContainer.PodCreated
+	watchedContainer.restartCount = restartCount
 	watchedContainer.sbomClient = sbomClient
-	rm.watchedContainers.Store(container.ID, watchedContainer)
+	return err
+}
 
-	// notify the channel. This call must be at the end of the function as it will unblock the waitForTicks function
-	watchedContainer.syncChannel[StepGetSBOM] <- err
+// getContainerSBOM gets the SBOM of the image of a container of a plain container host from its runtime, the filtered
+// SBOM is keyed by the image ID and the container name. The image is kept in the container data so the SBOM can still
+// be resolved once the container is removed.
+func (rm *RelevancyManager) getContainerSBOM(ctx context.Context, watchedContainer *watchedContainerData) error {
+	container := watchedContainer.container
+	if watchedContainer.instanceID == nil {
+		imageTag, imageID, err := rm.imageResolver.ResolveContainerImage(ctx, container.Runtime, container.ID)
+		if err != nil {
+			return err
+		}
+		instanceID, err := utils.CreateContainerInstanceID(imageID, container.Name)
+		if err != nil {
+			return fmt.Errorf("fail to create InstanceID to container %s with error: %v", container.Name, err)
+		}
+		watchedContainer.imageTag = imageTag
+		watchedContainer.imageID = imageID
+		watchedContainer.instanceID = instanceID
+		watchedContainer.sbomClient = sbom.CreateSBOMStorageClient(rm.storageClient, "", instanceID, rm.sbomFs, rm.cfg)
+	}
+	return watchedContainer.sbomClient.GetSBOM(ctx, watchedContainer.imageTag, watchedContainer.imageID)
 }
 
-func (rm *RelevancyManager) parsePodData(ctx context.Context, pod *workloadinterface.Workload, container *containercollection.Container) (string, string, string, instanceidhandler.IInstanceID, error) {
+func (rm *RelevancyManager) parsePodData(ctx context.Context, pod *workloadinterface.Workload, container *containercollection.Container) (*utils.PodContainer, string, instanceidhandler.IInstanceID, error) {
 
-	kind, name, err := rm.k8sClient.CalculateWorkloadParentRecursive(pod)
-	if err != nil {
-		return "", "", "", nil, fmt.Errorf("fail to get workload owner parent %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
-	}
-	parentWorkload, err := rm.k8sClient.GetWorkload(pod.GetNamespace(), kind, name)
+	kind, name, err := rm.metadataProvider.GetPodParent(pod)
 	if err != nil {
-		return "", "", "", nil, fmt.Errorf("fail to get parent workload %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
+		return nil, "", nil, fmt.Errorf("fail to get workload owner parent %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
 	}
-	w := parentWorkload.(*workloadinterface.Workload)
-	parentWlid := w.GenerateWlid(rm.clusterName)
+	parentWlid := wlid.GetK8sWLID(rm.clusterName, pod.GetNamespace(), kind, name)
 	err = wlid.IsWlidValid(parentWlid)
 	if err != nil {
-		return "", "", "", nil, fmt.Errorf("WLID of parent workload is not in the right %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
-	}
-
-	containers, err := pod.GetContainers()
-	if err != nil {
-		return "", "", "", nil, fmt.Errorf("fail to get containers for pod %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
-	}
-	imageTag := ""
-	for i := range containers {
-		if containers[i].Name == container.Name {
-			imageTag = containers[i].Image
-		}
+		return nil, "", nil, fmt.Errorf("WLID of parent workload is not in the right %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
 	}
 
-	status, err := pod.GetPodStatus() // Careful this is not available on container creation
+	// Careful the image ID is not available on container creation
+	podContainer, err := utils.GetPodContainer(pod, container.Name)
 	if err != nil {
-		return "", "", "", nil, fmt.Errorf("fail to get status for pod %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
-	}
-	imageID := ""
-	for i := range status.ContainerStatuses {
-		if status.ContainerStatuses[i].Name == container.Name {
-			imageID = status.ContainerStatuses[i].ImageID
-		}
+		return nil, "", nil, err
 	}
 
-	instanceIDs, err := instanceidhandlerV1.GenerateInstanceID(pod)
+	instanceID, err := utils.GetPodContainerInstanceID(pod, container.Name)
 	if err != nil {
-		return "", "", "", nil, fmt.Errorf("fail to create InstanceID to pod %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
-	}
-	instanceID := instanceIDs[0]
-	for i := range instanceIDs {
-		if instanceIDs[i].GetContainerName() == name {
-			instanceID = instanceIDs[i]
-		}
+		return nil, "", nil, fmt.Errorf("fail to create InstanceID to pod %s in namespace %s with error: %v", pod.GetName(), pod.GetNamespace(), err)
 	}
 
-	logger.L().Debug("parsePodData", helpers.String("imageID", imageID), helpers.String("imageTag", imageTag), helpers.String("parentWlid", parentWlid), helpers.String("instanceID", instanceID.GetStringFormatted()))
-	return imageID, imageTag, parentWlid, instanceID, nil
+	logger.L().Debug("parsePodData", helpers.String("container type", string(podContainer.Type)), helpers.String("imageID", podContainer.ImageID), helpers.String("imageTag", podContainer.Image), helpers.String("parentWlid", parentWlid), helpers.String("instanceID", instanceID.GetStringFormatted()), helpers.Int("restartCount", int(podContainer.RestartCount)))
+	return podContainer, parentWlid, instanceID, nil
 }
 
 func (rm *RelevancyManager) startRelevancyProcess(ctx context.Context, container *containercollection.Container, k8sContainerID string) {
@@ -284,7 +513,6 @@ func (rm *RelevancyManager) startRelevancyProcess(ctx context.Context, container
 		syncChannel: map[string]chan error{
 			StepGetSBOM:         make(chan error, 10),
 			StepEventAggregator: make(chan error, 10),
-			StepValidateSBOM:    make(chan error, 10),
 		},
 		k8sContainerID: k8sContainerID,
 	}
@@ -309,8 +537,6 @@ func (rm *RelevancyManager) monitorContainer(ctx context.Context, container *con
 		if err != nil {
 			if errors.Is(err, containerHasTerminatedError) {
 				return fmt.Errorf("container terminated")
-			} else if errors.Is(err, sbomV1.SBOMIncomplete) {
-				return fmt.Errorf("incomplete SBOM")
 			}
 		}
 	}
@@ -332,10 +558,6 @@ func (rm *RelevancyManager) waitForTicks(watchedContainer watchedContainerData,
 			watchedContainer.snifferTicker.Stop()
 			err = containerHasTerminatedError
 		}
-	case err = <-watchedContainer.syncChannel[StepValidateSBOM]:
-		if errors.Is(err, sbomV1.SBOMIncomplete) {
-			return err
-		}
 	}
 	return err
 }
@@ -366,16 +588,118 @@ func (rm *RelevancyManager) ReportContainerTerminated(ctx context.Context, conta
 			logger.L().Debug("container not found in memory", helpers.String("container ID", container.ID), helpers.String("k8s workload", k8sContainerID))
 			return
 		}
-		err := rm.fileHandler.RemoveBucket(k8sContainerID)
-		if err != nil {
+		// the bucket of the files is keyed by the k8s container ID, it is shared with the next run of the container
+		files := rm.takeFiles(data, container.ID)
+		if !rm.cfg.EnableRelevancy {
+			rm.terminateContainer(data)
+			return
+		}
+		if !data.isSBOMResolved() {
+			if rm.cfg.ExitedContainerGracePeriod <= 0 {
+				rm.terminateContainer(data)
+				return
+			}
+			// containers of Jobs and init containers often exit before their SBOM is resolved or complete, their files
+			// are kept and filtered once it is
+			logger.L().Debug("container exited before its SBOM was resolved", helpers.String("container ID", container.ID), helpers.String("k8s workload", k8sContainerID))
+			data.snifferTicker.Stop()
+			rm.exitedContainers.Store(container.ID, &exitedContainerData{
+				watchedContainerData: data,
+				files:                files,
+				deadline:             time.Now().Add(rm.cfg.ExitedContainerGracePeriod),
+			})
+			data.syncChannel[StepEventAggregator] <- containerHasTerminatedError
+			return
+		}
+		// the files accessed since the last tick are filtered one last time, the SBOM resources are cleaned and the
+		// monitoring is stopped after the flush
+		data.snifferTicker.Stop()
+		// the span of the report may end before the job runs, the job has its own span linked to it
+		link := trace.LinkFromContext(ctx)
+		rm.fileWorkerPool.Submit(func() {
+			ctx, span := otel.Tracer("").Start(ctx, "RelevancyManager.flushTerminatedContainer", trace.WithNewRoot(), trace.WithLinks(link), trace.WithAttributes(attribute.String("containerID", container.ID), attribute.String("k8s workload", k8sContainerID)))
+			defer span.End()
+			rm.filterExitedFiles(ctx, data, container.ID, files)
+			rm.terminateContainer(data)
+		})
+		return
+	}
+	// the sniffing time of the container is over, the files reported while it ended are dropped
+	if _, exited := rm.exitedContainers.Load(container.ID); !exited {
+		rm.fileProcesses.Delete(k8sContainerID)
+		if err := rm.fileHandler.RemoveBucket(k8sContainerID); err != nil {
 			logger.L().Error("failed to remove container bucket", helpers.Error(err), helpers.String("container ID", container.ID), helpers.String("k8s workload", k8sContainerID))
+		}
+	}
+}
+
+// takeFiles removes the files accessed by a terminated container from the file handler and returns them
+func (rm *RelevancyManager) takeFiles(data watchedContainerData, containerID string) map[string]bool {
+	files, err := rm.fileHandler.GetFiles(data.k8sContainerID)
+	if err != nil {
+		logger.L().Debug("failed to get file list", helpers.String("container ID", containerID), helpers.String("k8s workload", data.k8sContainerID), helpers.Error(err))
+	}
+	rm.fileProcesses.Delete(data.k8sContainerID)
+	if err := rm.fileHandler.RemoveBucket(data.k8sContainerID); err != nil {
+		logger.L().Error("failed to remove container bucket", helpers.Error(err), helpers.String("container ID", containerID), helpers.String("k8s workload", data.k8sContainerID))
+	}
+	return files
+}
+
+// terminateContainer cleans the SBOM resources of a terminated container and stops its monitoring
+func (rm *RelevancyManager) terminateContainer(data watchedContainerData) {
+	if data.sbomClient != nil {
+		data.sbomClient.CleanResources()
+	}
+	data.syncChannel[StepEventAggregator] <- containerHasTerminatedError
+}
+
+// handleExitedContainers retries to resolve the SBOM of the exited containers every update period
+func (rm *RelevancyManager) handleExitedContainers(ctx context.Context) {
+	ticker := time.NewTicker(rm.cfg.UpdateDataPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rm.exitedContainers.Range(func(key, value any) bool {
+				rm.handleExitedContainer(ctx, key.(string), value.(*exitedContainerData))
+				return true
+			})
+			rm.pruneInstances()
+		}
+	}
+}
+
+// handleExitedContainer filters the SBOM of an exited container with its accessed files once the SBOM is resolved, the
+// files are dropped if it is not resolved before the deadline
+func (rm *RelevancyManager) handleExitedContainer(ctx context.Context, containerID string, data *exitedContainerData) {
+	if !data.isSBOMResolved() {
+		if err := rm.resolveSBOM(ctx, &data.watchedContainerData); err != nil || !data.isSBOMResolved() {
+			if time.Now().Before(data.deadline) {
+				logger.L().Debug("SBOM of exited container not yet resolved", helpers.String("container ID", containerID), helpers.String("k8s workload", data.k8sContainerID), helpers.Error(err))
+				return
+			}
+			logger.L().Info("SBOM of exited container not resolved within the grace period, dropping its files", helpers.String("container ID", containerID), helpers.String("k8s workload", data.k8sContainerID), helpers.Error(err))
+			rm.deleteExitedContainer(data, containerID)
 			return
 		}
-		data.syncChannel[StepEventAggregator] <- containerHasTerminatedError
 	}
+	if !rm.filterExitedFiles(ctx, data.watchedContainerData, containerID, data.files) && time.Now().Before(data.deadline) {
+		return
+	}
+	rm.deleteExitedContainer(data, containerID)
 }
 
-func (rm *RelevancyManager) ReportFileAccess(ctx context.Context, namespace, pod, container, file string) {
+func (rm *RelevancyManager) deleteExitedContainer(data *exitedContainerData, containerID string) {
+	if data.sbomClient != nil {
+		data.sbomClient.CleanResources()
+	}
+	rm.exitedContainers.Delete(containerID)
+}
+
+func (rm *RelevancyManager) ReportFileAccess(ctx context.Context, namespace, pod, container, file string, kind relevancymanager.FileAccessKind, process processtree.ProcessChain) {
 	// log accessed files for all containers to avoid race condition
 	// this won't record unnecessary containers as the containerCollection takes care of filtering them
 	if file == "" {
@@ -384,8 +708,31 @@ func (rm *RelevancyManager) ReportFileAccess(ctx context.Context, namespace, pod
 	k8sContainerID := utils.CreateK8sContainerID(namespace, pod, container)
 	err := rm.fileHandler.AddFile(k8sContainerID, file)
 	if err != nil {
-		logger.L().Ctx(ctx).Error("failed to add file to container file list", helpers.Error(err), helpers.Interface("k8sContainerID", k8sContainerID), helpers.String("file", file))
+		logger.L().Ctx(ctx).Error("failed to add file to container file list", helpers.Error(err), helpers.Interface("k8sContainerID", k8sContainerID), helpers.String("file", file), helpers.String("kind", string(kind)))
+	}
+	if len(process) == 0 {
+		return
+	}
+	files, _ := rm.fileProcesses.LoadOrStore(k8sContainerID, &accessedFiles{processes: make(map[string]string)})
+	files.(*accessedFiles).mutex.Lock()
+	defer files.(*accessedFiles).mutex.Unlock()
+	if _, ok := files.(*accessedFiles).processes[file]; !ok && len(files.(*accessedFiles).processes) < maxFileProcesses {
+		files.(*accessedFiles).processes[file] = process.String()
+	}
+}
+
+func (rm *RelevancyManager) GetFileProcesses(namespace, pod, container string) map[string]string {
+	files, ok := rm.fileProcesses.Load(utils.CreateK8sContainerID(namespace, pod, container))
+	if !ok {
+		return map[string]string{}
+	}
+	files.(*accessedFiles).mutex.Lock()
+	defer files.(*accessedFiles).mutex.Unlock()
+	processes := make(map[string]string, len(files.(*accessedFiles).processes))
+	for file, process := range files.(*accessedFiles).processes {
+		processes[file] = process
 	}
+	return processes
 }
 
 func (rm *RelevancyManager) SetContainerHandler(containerHandler containerwatcher.ContainerWatcher) {
@@ -398,4 +745,5 @@ func (rm *RelevancyManager) StartRelevancyManager(ctx context.Context) {
 	go func() {
 		_ = rm.afterTimerActions(ctx)
 	}()
+	go rm.handleExitedContainers(ctx)
 }
@@ -0,0 +1,668 @@
+package relevancymanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"node-agent/pkg/config"
+	"node-agent/pkg/filehandler/v1"
+	"node-agent/pkg/imageresolver"
+	"node-agent/pkg/metadataprovider"
+	"node-agent/pkg/processtree"
+	"node-agent/pkg/relevancymanager"
+	"node-agent/pkg/sbom"
+	sbomV1 "node-agent/pkg/sbom/v1"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	cyclonedx "github.com/CycloneDX/cyclonedx-go"
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sbomClientMock records the files the SBOM is filtered with
+type sbomClientMock struct {
+	mutex      sync.Mutex
+	filtered   []map[string]bool
+	restored   map[string]bool
+	stored     int
+	cleaned    bool
+	incomplete bool
+	fetched    int
+	// completedAt is the fetch the incomplete SBOM is regenerated complete at
+	completedAt int
+	storeErr    error
+}
+
+func (sc *sbomClientMock) GetSBOM(_ context.Context, _, _ string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.fetched++
+	if sc.fetched == sc.completedAt {
+		sc.incomplete = false
+	}
+	return nil
+}
+
+func (sc *sbomClientMock) IsSBOMAlreadyExist() bool {
+	return true
+}
+
+func (sc *sbomClientMock) IsSBOMIncomplete() bool {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.incomplete
+}
+
+func (sc *sbomClientMock) ValidateSBOM(_ context.Context) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.incomplete {
+		return sbomV1.SBOMIncomplete
+	}
+	return nil
+}
+
+func (sc *sbomClientMock) FilterSBOM(_ context.Context, sbomFileRelevantMap map[string]bool) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.filtered = append(sc.filtered, sbomFileRelevantMap)
+	return nil
+}
+
+func (sc *sbomClientMock) RestoreRelevantPackages(packageIDs map[string]bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.restored = packageIDs
+}
+
+func (sc *sbomClientMock) StoreFilterSBOM(_ context.Context, _, _ string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.stored++
+	return sc.storeErr
+}
+
+func (sc *sbomClientMock) CleanResources() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.cleaned = true
+}
+
+// relevancyManagerFixture is a relevancy manager with an in memory file handler watching one container
+type relevancyManagerFixture struct {
+	rm               *RelevancyManager
+	fileHandler      *filehandler.InMemoryFileHandler
+	container        *containercollection.Container
+	instanceID       instanceidhandler.IInstanceID
+	watchedContainer watchedContainerData
+}
+
+// createRelevancyManagerFixture watches the container name of the pod name-1, the SBOM of the container is resolved
+// when sbomClient is set
+func createRelevancyManagerFixture(t *testing.T, cfg config.Config, name string, sbomClient *sbomClientMock) *relevancyManagerFixture {
+	fileHandler, err := filehandler.CreateInMemoryFileHandler()
+	if err != nil {
+		t.Fatalf("fail to create file handler, err: %v", err)
+	}
+	rm, err := CreateRelevancyManager(cfg, "cluster", fileHandler, nil, nil, nil, storageclient.CreateSBOMStorageHttpClientMock())
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(fmt.Sprintf("apiVersion-v1/namespace-default/kind-deployment/name-%s/containerName-%s", name, name))
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	container := &containercollection.Container{ID: "abc", Namespace: "default", Podname: name + "-1", Name: name}
+	watchedContainer := watchedContainerData{
+		snifferTicker: time.NewTicker(time.Hour),
+		container:     container,
+		syncChannel: map[string]chan error{
+			StepGetSBOM:         make(chan error, 10),
+			StepEventAggregator: make(chan error, 10),
+		},
+		k8sContainerID: utils.CreateK8sContainerID(container.Namespace, container.Podname, container.Name),
+	}
+	if sbomClient != nil {
+		watchedContainer.sbomClient = sbomClient
+		watchedContainer.imageTag = name + ":1.0"
+		watchedContainer.imageID = "docker.io/library/" + name + "@sha256:0123456789abcdef"
+		watchedContainer.instanceID = instanceID
+	}
+	rm.watchedContainers.Store(container.ID, watchedContainer)
+	return &relevancyManagerFixture{
+		rm:               rm,
+		fileHandler:      fileHandler,
+		container:        container,
+		instanceID:       instanceID,
+		watchedContainer: watchedContainer,
+	}
+}
+
+func TestReportContainerTerminatedFlush(t *testing.T) {
+	spanRecorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	sbomClient := &sbomClientMock{}
+	fixture := createRelevancyManagerFixture(t, config.Config{EnableRelevancy: true}, "backup", sbomClient)
+	rm, container, watchedContainer := fixture.rm, fixture.container, fixture.watchedContainer
+	ctx := context.TODO()
+
+	// the job accesses its files and exits before the first tick
+	rm.ReportFileAccess(ctx, "default", "backup-1", "backup", "/usr/bin/pg_dump", relevancymanager.FileAccessExec, nil)
+	rm.ReportFileAccess(ctx, "default", "backup-1", "backup", "/usr/lib/libpq.so.5", relevancymanager.FileAccessLibrary, nil)
+	rm.ReportContainerTerminated(ctx, container)
+
+	// the monitoring stops once the last files are filtered
+	select {
+	case err := <-watchedContainer.syncChannel[StepEventAggregator]:
+		assert.ErrorIs(t, err, containerHasTerminatedError)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the monitoring of the container was not stopped")
+	}
+	sbomClient.mutex.Lock()
+	defer sbomClient.mutex.Unlock()
+	if assert.Len(t, sbomClient.filtered, 1) {
+		assert.Equal(t, map[string]bool{"/usr/bin/pg_dump": true, "/usr/lib/libpq.so.5": true}, sbomClient.filtered[0])
+	}
+	// the flush job has its own span, the span of the report may have ended when it runs
+	rm.fileWorkerPool.StopWait()
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spanRecorder.Ended() {
+		spans[span.Name()] = span
+	}
+	report, flush := spans["RelevancyManager.ReportContainerTerminated"], spans["RelevancyManager.flushTerminatedContainer"]
+	if assert.NotNil(t, report) && assert.NotNil(t, flush) {
+		assert.False(t, flush.Parent().IsValid())
+		if assert.Len(t, flush.Links(), 1) {
+			assert.Equal(t, report.SpanContext().SpanID(), flush.Links()[0].SpanContext.SpanID())
+		}
+	}
+	assert.Equal(t, 1, sbomClient.stored)
+	assert.True(t, sbomClient.cleaned)
+	_, err := fixture.fileHandler.GetFiles("default/backup-1/backup")
+	assert.Error(t, err)
+}
+
+func TestDeleteResourcesCleanSBOM(t *testing.T) {
+	sbomClient := &sbomClientMock{}
+	fixture := createRelevancyManagerFixture(t, config.Config{EnableRelevancy: true}, "nginx", sbomClient)
+
+	// the monitoring holds the data of the container from before its SBOM was resolved
+	monitored := fixture.watchedContainer
+	monitored.sbomClient = nil
+	fixture.rm.deleteResources(monitored, fixture.container.ID)
+	assert.True(t, sbomClient.cleaned)
+	_, watched := fixture.rm.watchedContainers.Load(fixture.container.ID)
+	assert.False(t, watched)
+}
+
+func TestReportContainerTerminatedBeforeSBOM(t *testing.T) {
+	cfg := config.Config{EnableRelevancy: true, UpdateDataPeriod: time.Minute, ExitedContainerGracePeriod: time.Hour}
+	fixture := createRelevancyManagerFixture(t, cfg, "backup", nil)
+	rm, container, watchedContainer := fixture.rm, fixture.container, fixture.watchedContainer
+	sbomClient := &sbomClientMock{}
+	var sbomErr error
+	rm.resolveSBOM = func(_ context.Context, watchedContainer *watchedContainerData) error {
+		if sbomErr != nil {
+			return sbomErr
+		}
+		watchedContainer.sbomClient = sbomClient
+		watchedContainer.imageID = "docker.io/library/backup@sha256:0123456789abcdef"
+		watchedContainer.instanceID = fixture.instanceID
+		return nil
+	}
+	ctx := context.TODO()
+
+	// the job exits before its pod status has the image ID
+	rm.ReportFileAccess(ctx, "default", "backup-1", "backup", "/usr/bin/pg_dump", relevancymanager.FileAccessExec, nil)
+	rm.ReportContainerTerminated(ctx, container)
+	assert.ErrorIs(t, <-watchedContainer.syncChannel[StepEventAggregator], containerHasTerminatedError)
+	data, ok := rm.exitedContainers.Load(container.ID)
+	if !assert.True(t, ok) {
+		return
+	}
+	// the container is restarted, its files are not the ones of the exited run
+	rm.ReportFileAccess(ctx, "default", "backup-1", "backup", "/usr/bin/psql", relevancymanager.FileAccessExec, nil)
+
+	// the SBOM is not yet generated, the files are kept until the deadline
+	sbomErr = errors.New("SBOM not found")
+	rm.handleExitedContainer(ctx, container.ID, data.(*exitedContainerData))
+	_, ok = rm.exitedContainers.Load(container.ID)
+	assert.True(t, ok)
+	assert.Empty(t, sbomClient.filtered)
+
+	// the SBOM is resolved after the container exited
+	sbomErr = nil
+	rm.handleExitedContainer(ctx, container.ID, data.(*exitedContainerData))
+	_, ok = rm.exitedContainers.Load(container.ID)
+	assert.False(t, ok)
+	if assert.Len(t, sbomClient.filtered, 1) {
+		assert.Equal(t, map[string]bool{"/usr/bin/pg_dump": true}, sbomClient.filtered[0])
+	}
+	assert.Equal(t, 1, sbomClient.stored)
+	assert.True(t, sbomClient.cleaned)
+	files, err := fixture.fileHandler.GetFiles("default/backup-1/backup")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"/usr/bin/psql": true}, files)
+}
+
+func TestExitedContainerGracePeriod(t *testing.T) {
+	fixture := createRelevancyManagerFixture(t, config.Config{EnableRelevancy: true}, "backup", nil)
+	rm, fileHandler := fixture.rm, fixture.fileHandler
+	rm.watchedContainers.Delete(fixture.container.ID)
+	rm.resolveSBOM = func(_ context.Context, _ *watchedContainerData) error {
+		return errors.New("pod not found")
+	}
+	data := &exitedContainerData{
+		watchedContainerData: fixture.watchedContainer,
+		files:                map[string]bool{"/usr/bin/pg_dump": true},
+		deadline:             time.Now().Add(-time.Second),
+	}
+	rm.exitedContainers.Store("abc", data)
+	// the container was restarted, the new run shares the bucket of the exited one
+	_ = fileHandler.AddFile("default/backup-1/backup", "/usr/bin/psql")
+
+	// the files are dropped once the grace period is over, the ones of the new run are kept
+	rm.handleExitedContainer(context.TODO(), "abc", data)
+	_, ok := rm.exitedContainers.Load("abc")
+	assert.False(t, ok)
+	files, err := fileHandler.GetFiles("default/backup-1/backup")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"/usr/bin/psql": true}, files)
+}
+
+func TestDeleteResources(t *testing.T) {
+	fixture := createRelevancyManagerFixture(t, config.Config{EnableRelevancy: true}, "nginx", nil)
+	rm, container, watchedContainer, fileHandler := fixture.rm, fixture.container, fixture.watchedContainer, fixture.fileHandler
+	ctx := context.TODO()
+	process := processtree.ProcessChain{{Pid: 42, Ppid: 1, Comm: "nginx"}, {Pid: 1, Comm: "sh"}}
+	rm.ReportFileAccess(ctx, "default", "nginx-1", "nginx", "/usr/sbin/nginx", relevancymanager.FileAccessExec, process)
+	assert.Equal(t, map[string]string{"/usr/sbin/nginx": "sh(1) -> nginx(42)"}, rm.GetFileProcesses("default", "nginx-1", "nginx"))
+
+	// the files and their processes are dropped at the end of the sniffing time
+	rm.deleteResources(watchedContainer, container.ID)
+	_, err := fileHandler.GetFiles("default/nginx-1/nginx")
+	assert.Error(t, err)
+	assert.Empty(t, rm.GetFileProcesses("default", "nginx-1", "nginx"))
+
+	// the files reported while the sniffing time ended are dropped once the container terminates
+	rm.ReportFileAccess(ctx, "default", "nginx-1", "nginx", "/etc/nginx/nginx.conf", relevancymanager.FileAccessOpen, nil)
+	rm.ReportContainerTerminated(ctx, container)
+	_, err = fileHandler.GetFiles("default/nginx-1/nginx")
+	assert.Error(t, err)
+}
+
+// metadataProviderMock gives the owner of the pods, the pods are given to the tests directly
+type metadataProviderMock struct {
+	metadataprovider.MetadataProviderClient
+}
+
+func (mp *metadataProviderMock) GetPodParent(_ *workloadinterface.Workload) (string, string, error) {
+	return "Job", "migrate", nil
+}
+
+func TestParsePodData(t *testing.T) {
+	rm, err := CreateRelevancyManager(config.Config{}, "cluster", nil, &metadataProviderMock{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+	pod, err := workloadinterface.NewWorkload([]byte(`{
+		"apiVersion": "v1",
+		"kind": "Pod",
+		"metadata": {"name": "migrate-1", "namespace": "default", "ownerReferences": [{"apiVersion": "batch/v1", "kind": "Job", "name": "migrate", "uid": "1"}]},
+		"spec": {"initContainers": [{"name": "wait", "image": "busybox:1.36"}], "containers": [{"name": "migrate", "image": "migrate:1.0"}]},
+		"status": {"initContainerStatuses": [{"name": "wait", "imageID": "docker.io/library/busybox@sha256:01"}]}
+	}`))
+	if err != nil {
+		t.Fatalf("fail to create pod, err: %v", err)
+	}
+	podContainer, parentWlid, instanceID, err := rm.parsePodData(context.TODO(), pod, &containercollection.Container{Name: "wait"})
+	if err != nil {
+		t.Fatalf("fail to parse pod data, err: %v", err)
+	}
+	assert.Equal(t, "docker.io/library/busybox@sha256:01", podContainer.ImageID)
+	assert.Equal(t, "busybox:1.36", podContainer.Image)
+	assert.Equal(t, "wlid://cluster-cluster/namespace-default/job-migrate", parentWlid)
+	assert.Equal(t, "wait", instanceID.GetContainerName())
+}
+
+// imageResolverMock gives the image of the containers of a plain container host
+type imageResolverMock struct {
+	imageresolver.ImageResolverClient
+	calls int
+}
+
+func (ir *imageResolverMock) ResolveContainerImage(_ context.Context, _, _ string) (string, string, error) {
+	ir.calls++
+	return storageclient.NGINX_IMAGE_TAG, storageclient.NGINX, nil
+}
+
+func TestGetContainerSBOM(t *testing.T) {
+	imageResolver := &imageResolverMock{}
+	cfg := config.Config{EnableRelevancy: true, Standalone: config.StandaloneConfig{Enabled: true}}
+	rm, err := CreateRelevancyManager(cfg, "", nil, nil, imageResolver, afero.NewMemMapFs(), storageclient.CreateSBOMStorageHttpClientMock())
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+	watchedContainer := &watchedContainerData{container: &containercollection.Container{ID: "abc", Runtime: "docker", Name: "web"}}
+	if err := rm.resolveSBOM(context.TODO(), watchedContainer); err != nil {
+		t.Fatalf("fail to get SBOM, err: %v", err)
+	}
+	assert.True(t, watchedContainer.sbomClient.IsSBOMAlreadyExist())
+	assert.Equal(t, storageclient.NGINX, watchedContainer.imageID)
+	assert.Equal(t, "imageID-"+storageclient.NGINX+"/containerName-web", watchedContainer.instanceID.GetStringFormatted())
+
+	// the image is not asked again once the container is removed
+	if err := rm.resolveSBOM(context.TODO(), watchedContainer); err != nil {
+		t.Fatalf("fail to get SBOM, err: %v", err)
+	}
+	assert.Equal(t, 1, imageResolver.calls)
+}
+
+// filteredSBOMStorageMock gives the stored filtered SBOMs and the Syft document of the image
+type filteredSBOMStorageMock struct {
+	storageclient.StorageClient
+	filteredSBOMs          map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered
+	filteredSBOMsCycloneDX map[string]*storageclient.SBOMCycloneDXFiltered
+	syft                   *storageclient.SBOMSyft
+}
+
+func (sc *filteredSBOMStorageMock) GetImageSBOMSyft(_ context.Context, _ string) (*storageclient.SBOMSyft, error) {
+	return sc.syft, nil
+}
+
+func (sc *filteredSBOMStorageMock) CreateFilteredSBOM(_ context.Context, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	sc.filteredSBOMs[SBOM.Name] = SBOM.DeepCopy()
+	return nil
+}
+
+func (sc *filteredSBOMStorageMock) GetFilteredSBOMCycloneDX(_ context.Context, key string) (*storageclient.SBOMCycloneDXFiltered, error) {
+	filteredSBOM, ok := sc.filteredSBOMsCycloneDX[key]
+	if !ok {
+		return nil, storageclient.ErrNotFound
+	}
+	return filteredSBOM, nil
+}
+
+func (sc *filteredSBOMStorageMock) GetFilteredSBOM(_ context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	filteredSBOM, ok := sc.filteredSBOMs[key]
+	if !ok {
+		return nil, storageclient.ErrNotFound
+	}
+	return filteredSBOM, nil
+}
+
+func TestMergeInstanceFiles(t *testing.T) {
+	stored := &spdxv1beta1.SBOMSPDXv2p3Filtered{}
+	stored.SetAnnotations(map[string]string{instanceidhandlerV1.ImageIDMetadataKey: "nginx@sha256:01"})
+	stored.Spec.SPDX.Files = []*spdxv1beta1.File{{FileName: "/usr/sbin/nginx"}}
+	stored.Spec.SPDX.Packages = []*spdxv1beta1.Package{{PackageSPDXIdentifier: "Package-deb-nginx"}}
+	storageClient := &filteredSBOMStorageMock{filteredSBOMs: map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered{"nginx": stored}}
+	rm, err := CreateRelevancyManager(config.Config{MaxSniffingTime: time.Hour}, "cluster", nil, nil, nil, nil, storageClient)
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+	ctx := context.TODO()
+	created := time.Now().Add(-time.Hour)
+
+	// the files stored before the agent started are merged with the ones of the first run
+	files, packages, ok := rm.mergeInstanceFiles(ctx, "nginx", watchedContainerData{imageID: "nginx@sha256:01", podCreated: created}, map[string]bool{"/etc/nginx/nginx.conf": true})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]bool{"/usr/sbin/nginx": true, "/etc/nginx/nginx.conf": true}, files)
+	assert.Equal(t, map[string]bool{"Package-deb-nginx": true}, packages)
+
+	// the restart of the container with the same image adds its files
+	files, _, ok = rm.mergeInstanceFiles(ctx, "nginx", watchedContainerData{imageID: "nginx@sha256:01", podCreated: created, restartCount: 1}, map[string]bool{"/usr/lib/libssl.so.3": true})
+	assert.True(t, ok)
+	assert.Len(t, files, 3)
+
+	// a rolling update to a new image resets the files
+	files, packages, ok = rm.mergeInstanceFiles(ctx, "nginx", watchedContainerData{imageID: "nginx@sha256:02", podCreated: created.Add(time.Minute)}, map[string]bool{"/usr/sbin/nginx": true})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]bool{"/usr/sbin/nginx": true}, files)
+	assert.Empty(t, packages)
+
+	// the old pods still running the previous image do not overwrite the filtered SBOM of the new one
+	_, _, ok = rm.mergeInstanceFiles(ctx, "nginx", watchedContainerData{imageID: "nginx@sha256:01", podCreated: created, restartCount: 1}, map[string]bool{"/etc/nginx/mime.types": true})
+	assert.False(t, ok)
+
+	// the stored filtered SBOM of another image is not merged
+	files, _, ok = rm.mergeInstanceFiles(ctx, "nginx-other", watchedContainerData{imageID: "nginx@sha256:02"}, map[string]bool{"/usr/sbin/nginx": true})
+	assert.True(t, ok)
+	assert.Len(t, files, 1)
+}
+
+func TestFilterRelevantFilesTooLarge(t *testing.T) {
+	rm, err := CreateRelevancyManager(config.Config{MaxSniffingTime: time.Hour}, "cluster", nil, nil, nil, nil, &filteredSBOMStorageMock{})
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+	containerData := watchedContainerData{imageID: "nginx@sha256:01", k8sContainerID: "default/nginx-1/nginx"}
+
+	// the files are kept for the next filter when the storage fails
+	containerData.sbomClient = &sbomClientMock{storeErr: fmt.Errorf("any")}
+	assert.False(t, rm.filterRelevantFiles(context.TODO(), containerData, "abc", "nginx", map[string]bool{"/usr/sbin/nginx": true}))
+	// they are dropped when the filtered SBOM does not fit in the storage
+	containerData.sbomClient = &sbomClientMock{storeErr: fmt.Errorf("create: %w", storageclient.ErrTooLarge)}
+	assert.True(t, rm.filterRelevantFiles(context.TODO(), containerData, "abc", "nginx", map[string]bool{"/usr/sbin/nginx": true}))
+}
+
+func TestGetStoredRelevancyCycloneDX(t *testing.T) {
+	stored := &storageclient.SBOMCycloneDXFiltered{Spec: *cyclonedx.NewBOM()}
+	stored.SetAnnotations(map[string]string{instanceidhandlerV1.ImageIDMetadataKey: "nginx@sha256:01"})
+	stored.Spec.Components = &[]cyclonedx.Component{
+		{
+			Type:   cyclonedx.ComponentTypeLibrary,
+			Name:   "nginx",
+			BOMRef: "Package-deb-nginx",
+			Components: &[]cyclonedx.Component{
+				{Type: cyclonedx.ComponentTypeFile, Name: "/usr/sbin/nginx"},
+			},
+		},
+		{Type: cyclonedx.ComponentTypeFile, Name: "/etc/nginx/nginx.conf"},
+	}
+	storageClient := &filteredSBOMStorageMock{filteredSBOMsCycloneDX: map[string]*storageclient.SBOMCycloneDXFiltered{"nginx": stored}}
+	rm, err := CreateRelevancyManager(config.Config{SBOMFormat: config.SBOMFormatCycloneDX}, "cluster", nil, nil, nil, nil, storageClient)
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+
+	files, packages := rm.getStoredRelevancy(context.TODO(), "nginx", "nginx@sha256:01")
+	assert.Equal(t, map[string]bool{"/usr/sbin/nginx": true, "/etc/nginx/nginx.conf": true}, files)
+	assert.Equal(t, map[string]bool{"Package-deb-nginx": true}, packages)
+	files, packages = rm.getStoredRelevancy(context.TODO(), "nginx", "nginx@sha256:02")
+	assert.Empty(t, files)
+	assert.Empty(t, packages)
+	files, _ = rm.getStoredRelevancy(context.TODO(), "redis", "nginx@sha256:01")
+	assert.Empty(t, files)
+}
+
+func TestStoredRelevantPackagesInstallPath(t *testing.T) {
+	bytes, err := os.ReadFile(path.Join(utils.CurrentDir(), "..", "..", "sbom", "testdata", "nginx-syft-format-mock.json"))
+	if err != nil {
+		t.Fatalf("fail to read SBOM file, err: %v", err)
+	}
+	storageClient := &filteredSBOMStorageMock{filteredSBOMs: make(map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered), syft: &storageclient.SBOMSyft{}}
+	if err := json.Unmarshal(bytes, &storageClient.syft.Spec); err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	cfg := config.Config{
+		EnableRelevancy:   true,
+		RelevancyMatching: config.RelevancyMatchingInstallPath,
+		SBOMInput:         config.SBOMInputConfig{Format: config.SBOMInputFormatSyft},
+	}
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString("apiVersion-v1/namespace-default/kind-deployment/name-web/containerName-web")
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	filterSBOMKey, _ := instanceID.GetSlug()
+	ctx := context.TODO()
+	// each run of the container gets its own SBOM client
+	filter := func(rm *RelevancyManager, file string) []string {
+		sbomClient := sbom.CreateSBOMStorageClient(storageClient, "wlid", instanceID, afero.NewMemMapFs(), cfg)
+		if err := sbomClient.GetSBOM(ctx, "web:1.0", "docker.io/library/web@sha256:0123456789abcdef"); err != nil {
+			t.Fatalf("fail to get SBOM, err: %v", err)
+		}
+		containerData := watchedContainerData{sbomClient: sbomClient, imageID: "docker.io/library/web@sha256:0123456789abcdef", instanceID: instanceID, k8sContainerID: "default/web-1/web"}
+		assert.True(t, rm.filterRelevantFiles(ctx, containerData, "abc", filterSBOMKey, map[string]bool{file: true}))
+		packages := make([]string, 0)
+		for _, spdxPackage := range storageClient.filteredSBOMs[filterSBOMKey].Spec.SPDX.Packages {
+			packages = append(packages, spdxPackage.PackageName)
+		}
+		return packages
+	}
+	rm, err := CreateRelevancyManager(cfg, "cluster", nil, nil, nil, nil, storageClient)
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+
+	// six is matched by its install directory, its module is not listed with the files of the filtered SBOM
+	assert.Equal(t, []string{"six"}, filter(rm, "/usr/lib/python3/dist-packages/six.py"))
+
+	// the instance is forgotten after the sniffing time, the next run of its CronJob keeps six
+	rm.cfg.MaxSniffingTime = -time.Second
+	rm.pruneInstances()
+	assert.ElementsMatch(t, []string{"six", "adduser"}, filter(rm, "/usr/share/adduser/adduser.conf"))
+
+	// the agent restarts
+	rm, err = CreateRelevancyManager(cfg, "cluster", nil, nil, nil, nil, storageClient)
+	if err != nil {
+		t.Fatalf("fail to create relevancy manager, err: %v", err)
+	}
+	assert.ElementsMatch(t, []string{"six", "adduser", "libc6"}, filter(rm, "/var/lib/dpkg/status"))
+}
+
+func TestIncompleteSBOM(t *testing.T) {
+	sbomClient := &sbomClientMock{incomplete: true, completedAt: 2}
+	fixture := createRelevancyManagerFixture(t, config.Config{EnableRelevancy: true}, "ml", sbomClient)
+	rm, container, watchedContainer := fixture.rm, fixture.container, fixture.watchedContainer
+	ctx := context.TODO()
+
+	// the files are collected while the SBOM is incomplete, only its status is stored
+	rm.ReportFileAccess(ctx, "default", "ml-1", "ml", "/usr/bin/python3", relevancymanager.FileAccessExec, nil)
+	rm.handleRelevancy(ctx, watchedContainer, container.ID)
+	rm.ReportFileAccess(ctx, "default", "ml-1", "ml", "/usr/lib/libtorch.so", relevancymanager.FileAccessLibrary, nil)
+	rm.handleRelevancy(ctx, watchedContainer, container.ID)
+	assert.Empty(t, sbomClient.filtered)
+	assert.Equal(t, 2, sbomClient.stored)
+	assert.False(t, watchedContainer.isSBOMResolved())
+
+	// the SBOM is fetched again on each tick until it is regenerated complete
+	rm.getSBOM(ctx, container)
+	assert.NoError(t, <-watchedContainer.syncChannel[StepGetSBOM])
+	rm.getSBOM(ctx, container)
+	assert.NoError(t, <-watchedContainer.syncChannel[StepGetSBOM])
+	assert.Equal(t, 2, sbomClient.fetched)
+	rm.getSBOM(ctx, container)
+	assert.NoError(t, <-watchedContainer.syncChannel[StepGetSBOM])
+	assert.Equal(t, 2, sbomClient.fetched)
+
+	// the whole history of the container is filtered once the SBOM is complete
+	rm.handleRelevancy(ctx, watchedContainer, container.ID)
+	if assert.Len(t, sbomClient.filtered, 1) {
+		assert.Equal(t, map[string]bool{"/usr/bin/python3": true, "/usr/lib/libtorch.so": true}, sbomClient.filtered[0])
+	}
+	assert.True(t, watchedContainer.isSBOMResolved())
+}
+
+// waitForTicks returns once afterTimerActions has handled the ticks sent before, the jobs of the ticks are submitted
+func waitForTicks(rm *RelevancyManager) {
+	sentinel := make(chan error)
+	rm.watchedContainers.Store("sentinel", watchedContainerData{syncChannel: map[string]chan error{StepGetSBOM: sentinel}})
+	defer rm.watchedContainers.Delete("sentinel")
+	rm.afterTimerActionsChannel <- afterTimerActionsData{containerID: "sentinel", service: RelevantCVEsService}
+	sentinel <- nil
+}
+
+func TestReportContainerTerminatedRace(t *testing.T) {
+	sbomClient := &sbomClientMock{}
+	fixture := createRelevancyManagerFixture(t, config.Config{EnableRelevancy: true}, "web", sbomClient)
+	rm, container, watchedContainer := fixture.rm, fixture.container, fixture.watchedContainer
+	ctx := context.TODO()
+	go rm.afterTimerActions(ctx)
+
+	report := func(reporters, files int, prefix string) *sync.WaitGroup {
+		wg := &sync.WaitGroup{}
+		for i := 0; i < reporters; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < files; j++ {
+					rm.ReportFileAccess(ctx, "default", "web-1", "web", fmt.Sprintf("/%s/%d/%d", prefix, i, j), relevancymanager.FileAccessOpen, processtree.ProcessChain{{Pid: uint32(i + 1), Comm: "web"}})
+				}
+			}(i)
+		}
+		return wg
+	}
+	tick := func(ticks int) *sync.WaitGroup {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ticks; i++ {
+				watchedContainer.syncChannel[StepGetSBOM] <- nil
+				rm.afterTimerActionsChannel <- afterTimerActionsData{containerID: container.ID, service: RelevantCVEsService}
+			}
+		}()
+		return wg
+	}
+
+	// the files are filtered on the ticks while they are reported
+	reporters, ticks := report(8, 50, "run"), tick(5)
+	reporters.Wait()
+	ticks.Wait()
+	waitForTicks(rm)
+
+	// the container terminates while files are still reported and a tick is handled
+	reporters, ticks = report(8, 50, "exit"), tick(3)
+	rm.ReportContainerTerminated(ctx, container)
+	select {
+	case err := <-watchedContainer.syncChannel[StepEventAggregator]:
+		assert.ErrorIs(t, err, containerHasTerminatedError)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the monitoring of the container was not stopped")
+	}
+	reporters.Wait()
+	ticks.Wait()
+	waitForTicks(rm)
+	rm.fileWorkerPool.StopWait()
+
+	// the files of the instance only grow, the last filter holds all the files filtered
+	sbomClient.mutex.Lock()
+	defer sbomClient.mutex.Unlock()
+	filtered := make(map[string]bool)
+	for _, files := range sbomClient.filtered {
+		if len(files) > len(filtered) {
+			filtered = files
+		}
+	}
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 50; j++ {
+			assert.True(t, filtered[fmt.Sprintf("/run/%d/%d", i, j)])
+		}
+	}
+	// the files reported while the container terminated are filtered or kept for the next run
+	remaining, _ := fixture.fileHandler.GetFiles("default/web-1/web")
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 50; j++ {
+			file := fmt.Sprintf("/exit/%d/%d", i, j)
+			assert.True(t, filtered[file] || remaining[file], file)
+		}
+	}
+}
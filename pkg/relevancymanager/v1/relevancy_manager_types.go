@@ -2,10 +2,12 @@ package relevancymanager
 
 import (
 	"node-agent/pkg/sbom"
+	"sync"
 	"time"
 
 	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
 	"github.com/kubescape/k8s-interface/instanceidhandler"
+	"github.com/kubescape/k8s-interface/workloadinterface"
 )
 
 type supportedServices string
@@ -20,7 +22,51 @@ type watchedContainerData struct {
 	container      *containercollection.Container
 	syncChannel    map[string]chan error
 	sbomClient     sbom.SBOMClient
+	imageTag       string
 	imageID        string
 	instanceID     instanceidhandler.IInstanceID
 	k8sContainerID string
+	// pod is the last pod of the container read from the API server, it is used once the pod is deleted
+	pod *workloadinterface.Workload
+	// podCreated and restartCount order the runs of the containers of an instance
+	podCreated   time.Time
+	restartCount int32
+}
+
+// isSBOMResolved reports whether the complete SBOM of the image of the container is retrieved
+func (wc *watchedContainerData) isSBOMResolved() bool {
+	return wc.sbomClient != nil && wc.sbomClient.IsSBOMAlreadyExist() && !wc.sbomClient.IsSBOMIncomplete() && wc.instanceID != nil
+}
+
+// exitedContainerData is a container that exited before its SBOM was resolved, its accessed files are kept until the
+// SBOM is resolved or the deadline is over. The files are taken from the file handler when the container exits, its
+// bucket is shared with the next run of the container.
+type exitedContainerData struct {
+	watchedContainerData
+	files    map[string]bool
+	deadline time.Time
+}
+
+// instanceRelevancy holds the files accessed by the runs of the containers of an instance with the same image, the
+// restarts of a container and the replicas of a workload share the filtered SBOM of their instance
+type instanceRelevancy struct {
+	mutex   sync.Mutex
+	imageID string
+	// podCreated and restartCount are the ones of the latest run, its image is the one of the filtered SBOM
+	podCreated   time.Time
+	restartCount int32
+	files        map[string]bool
+	// packages are the packages of the stored filtered SBOM, the ones matched by an install directory or a source info
+	// file are not listed with the files
+	packages   map[string]bool
+	lastUpdate time.Time
+}
+
+// isOlderThan reports whether a container is a later run than the latest run of the instance, the pods created later
+// are later runs and the restarts of a container in a pod are ordered by their restart count
+func (ir *instanceRelevancy) isOlderThan(containerData watchedContainerData) bool {
+	if !ir.podCreated.Equal(containerData.podCreated) {
+		return ir.podCreated.Before(containerData.podCreated)
+	}
+	return ir.restartCount < containerData.restartCount
 }
@@ -0,0 +1,14 @@
+package ruleengine
+
+import (
+	"context"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+)
+
+type RuleEngineClient interface {
+	ReportExecEvent(ctx context.Context, container *containercollection.Container, event *tracerexectype.Event)
+	ReportOpenEvent(ctx context.Context, container *containercollection.Container, event *traceropentype.Event)
+}
@@ -0,0 +1,112 @@
+package ruleengine
+
+import (
+	"context"
+	"node-agent/pkg/exporters"
+	"node-agent/pkg/processtree"
+	"node-agent/pkg/ruleengine"
+	"path/filepath"
+	"strings"
+	"time"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+// RuleEngine evaluates the enabled rules of a rule set over the exec and open events of the containers and sends an
+// alert for each match
+type RuleEngine struct {
+	execRules []Rule
+	openRules []Rule
+	exporter  exporters.Exporter
+	// processTree gives the ancestry of the processes in the alerts, it is optional
+	processTree processtree.ProcessTreeClient
+}
+
+var _ ruleengine.RuleEngineClient = (*RuleEngine)(nil)
+
+func CreateRuleEngine(ruleSet *RuleSet, exporter exporters.Exporter, processTree processtree.ProcessTreeClient) (*RuleEngine, error) {
+	if err := ruleSet.Validate(); err != nil {
+		return nil, err
+	}
+	re := &RuleEngine{exporter: exporter, processTree: processTree}
+	for _, rule := range ruleSet.Rules {
+		if !rule.IsEnabled() {
+			continue
+		}
+		switch rule.EventType {
+		case EventTypeExec:
+			re.execRules = append(re.execRules, rule)
+		case EventTypeOpen:
+			re.openRules = append(re.openRules, rule)
+		}
+	}
+	logger.L().Info("rule engine created", helpers.Int("exec rules", len(re.execRules)), helpers.Int("open rules", len(re.openRules)))
+	return re, nil
+}
+
+func (re *RuleEngine) ReportExecEvent(_ context.Context, container *containercollection.Container, event *tracerexectype.Event) {
+	path := re.execPath(container, event)
+	for i := range re.execRules {
+		if re.execRules[i].Match.matches(path, event.Comm, nil) {
+			re.sendAlert(&re.execRules[i], container, exporters.Alert{
+				Pid:  event.Pid,
+				Comm: event.Comm,
+				Path: path,
+				Args: event.Args,
+			})
+		}
+	}
+}
+
+func (re *RuleEngine) ReportOpenEvent(_ context.Context, container *containercollection.Container, event *traceropentype.Event) {
+	path := event.FullPath
+	if path == "" {
+		path = event.Path
+	}
+	for i := range re.openRules {
+		if re.openRules[i].Match.matches(path, "", event.Flags) {
+			re.sendAlert(&re.openRules[i], container, exporters.Alert{
+				Pid:  event.Pid,
+				Comm: event.Comm,
+				Path: path,
+			})
+		}
+	}
+}
+
+// execPath returns the path of the binary of an exec event, it is the first argument as given by the caller. A
+// relative path like ./x is resolved against the working directory of the process, a bare name is looked up in the
+// PATH of the process, which is not known, so it is only matched by its name.
+func (re *RuleEngine) execPath(container *containercollection.Container, event *tracerexectype.Event) string {
+	path := event.Comm
+	if len(event.Args) > 0 {
+		path = event.Args[0]
+	}
+	if filepath.IsAbs(path) || !strings.Contains(path, "/") || re.processTree == nil {
+		return path
+	}
+	chain := re.processTree.GetProcessChain(container, event.Pid)
+	if len(chain) == 0 || chain[0].Cwd == "" {
+		return path
+	}
+	return filepath.Join(chain[0].Cwd, path)
+}
+
+func (re *RuleEngine) sendAlert(rule *Rule, container *containercollection.Container, alert exporters.Alert) {
+	alert.Time = time.Now()
+	alert.RuleName = rule.Name
+	alert.Severity = rule.Severity
+	alert.Message = rule.Description
+	alert.Namespace = container.Namespace
+	alert.Pod = container.Podname
+	alert.Container = container.Name
+	alert.ContainerID = container.ID
+	if re.processTree != nil {
+		alert.ProcessChain = re.processTree.GetProcessChain(container, alert.Pid)
+	}
+	re.exporter.SendAlert(alert)
+}
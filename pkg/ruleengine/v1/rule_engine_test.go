@@ -0,0 +1,142 @@
+package ruleengine
+
+import (
+	"context"
+	"node-agent/pkg/exporters"
+	"node-agent/pkg/processtree"
+	"node-agent/pkg/testutils"
+	"node-agent/pkg/utils"
+	"path/filepath"
+	"testing"
+
+	containercollection "github.com/inspektor-gadget/inspektor-gadget/pkg/container-collection"
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// processTreeMock gives the ancestry of the processes, the other methods are not used by the rule engine
+type processTreeMock struct {
+	processtree.ProcessTreeClient
+}
+
+func (pt *processTreeMock) GetProcessChain(_ *containercollection.Container, pid uint32) processtree.ProcessChain {
+	return processtree.ProcessChain{{Pid: pid, Ppid: 1, Comm: "sh", Cwd: "/tmp"}, {Pid: 1, Comm: "nginx", Cwd: "/"}}
+}
+
+var containerMock = &containercollection.Container{ID: "abc", Namespace: "default", Podname: "nginx-1", Name: "nginx"}
+
+func createRuleEngineMock(t *testing.T, path string) (*RuleEngine, *testutils.ExporterMock) {
+	ruleSet, err := LoadRuleSet(path)
+	if err != nil {
+		t.Fatalf("fail to load rule set, err: %v", err)
+	}
+	exporter := &testutils.ExporterMock{}
+	re, err := CreateRuleEngine(ruleSet, exporter, &processTreeMock{})
+	if err != nil {
+		t.Fatalf("fail to create rule engine, err: %v", err)
+	}
+	return re, exporter
+}
+
+func TestRuleEngineDefaultRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		exec     *tracerexectype.Event
+		open     *traceropentype.Event
+		wantRule string
+	}{
+		{name: "exec from /tmp", exec: testutils.ExecEvent("miner", "/tmp/miner", "--pool", "x"), wantRule: "ExecFromTmp"},
+		{name: "exec from /dev/shm", exec: testutils.ExecEvent("x", "/dev/shm/x"), wantRule: "ExecFromTmp"},
+		{name: "exec relative to a tmp working directory", exec: testutils.ExecEvent("x", "./x"), wantRule: "ExecFromTmp"},
+		{name: "exec of a tmp named binary", exec: testutils.ExecEvent("tmpreaper", "/usr/sbin/tmpreaper")},
+		{name: "read /etc/shadow", open: testutils.OpenEvent("cat", "/etc/shadow", "O_RDONLY"), wantRule: "ReadShadow"},
+		{name: "read /etc/passwd", open: testutils.OpenEvent("cat", "/etc/passwd", "O_RDONLY")},
+		{name: "kubectl by path", exec: testutils.ExecEvent("kubectl", "/usr/local/bin/kubectl", "get", "secrets"), wantRule: "KubectlInPod"},
+		{name: "kubectl by name", exec: testutils.ExecEvent("kubectl", "kubectl", "get", "pods"), wantRule: "KubectlInPod"},
+		{name: "kubectl by comm", exec: testutils.ExecEvent("kubectl"), wantRule: "KubectlInPod"},
+		{name: "apt-get", exec: testutils.ExecEvent("apt-get", "apt-get", "install", "-y", "nmap"), wantRule: "PackageManagerExec"},
+		{name: "apk", exec: testutils.ExecEvent("apk", "/sbin/apk", "add", "curl"), wantRule: "PackageManagerExec"},
+		{name: "nginx", exec: testutils.ExecEvent("nginx", "/usr/sbin/nginx", "-g", "daemon off;")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, exporter := createRuleEngineMock(t, "")
+			if tt.exec != nil {
+				re.ReportExecEvent(context.TODO(), containerMock, tt.exec)
+			} else {
+				re.ReportOpenEvent(context.TODO(), containerMock, tt.open)
+			}
+			if tt.wantRule == "" {
+				assert.Empty(t, exporter.Alerts())
+				return
+			}
+			if alerts := exporter.Alerts(); assert.Len(t, alerts, 1) {
+				assert.Equal(t, tt.wantRule, alerts[0].RuleName)
+				assert.Equal(t, "nginx-1", alerts[0].Pod)
+				assert.Equal(t, "abc", alerts[0].ContainerID)
+				assert.Equal(t, uint32(42), alerts[0].Pid)
+				assert.NotEmpty(t, alerts[0].Severity)
+				assert.Equal(t, "nginx(1) -> sh(42)", alerts[0].ProcessChain.String())
+			}
+		})
+	}
+}
+
+func TestRuleEngineRuleSetFile(t *testing.T) {
+	re, exporter := createRuleEngineMock(t, filepath.Join(utils.CurrentDir(), "testdata", "rules.json"))
+	// the built-in rules are replaced and disabled rules are not evaluated
+	re.ReportOpenEvent(context.TODO(), containerMock, testutils.OpenEvent("cat", "/etc/shadow", "O_RDONLY"))
+	re.ReportExecEvent(context.TODO(), containerMock, testutils.ExecEvent("curl", "/usr/bin/curl", "http://example.com"))
+	assert.Empty(t, exporter.Alerts())
+
+	// the rules omitting enabled are evaluated
+	re.ReportOpenEvent(context.TODO(), containerMock, testutils.OpenEvent("cat", "/etc/hosts", "O_WRONLY", "O_TRUNC"))
+	if alerts := exporter.Alerts(); assert.Len(t, alerts, 1) {
+		assert.Equal(t, "EtcWrite", alerts[0].RuleName)
+		assert.Equal(t, exporters.SeverityCritical, alerts[0].Severity)
+		assert.Equal(t, "/etc/hosts", alerts[0].Path)
+	}
+}
+
+func TestLoadRuleSet(t *testing.T) {
+	ruleSet, err := LoadRuleSet("")
+	if err != nil {
+		t.Fatalf("fail to load the built-in rules, err: %v", err)
+	}
+	assert.Len(t, ruleSet.Rules, 4)
+
+	_, err = LoadRuleSet(filepath.Join(utils.CurrentDir(), "testdata", "invalid_rules.yaml"))
+	assert.ErrorContains(t, err, "no condition")
+	_, err = LoadRuleSet(filepath.Join(utils.CurrentDir(), "testdata", "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestRuleSetValidate(t *testing.T) {
+	rule := Rule{Name: "r", Severity: exporters.SeverityHigh, EventType: EventTypeOpen, Match: RuleMatch{Paths: []string{"/etc/shadow"}}}
+	tests := []struct {
+		name    string
+		modify  func(rule *Rule)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(rule *Rule) {}},
+		{name: "no name", modify: func(rule *Rule) { rule.Name = "" }, wantErr: true},
+		{name: "unknown severity", modify: func(rule *Rule) { rule.Severity = "urgent" }, wantErr: true},
+		{name: "unknown event type", modify: func(rule *Rule) { rule.EventType = "connect" }, wantErr: true},
+		{name: "flags of exec events", modify: func(rule *Rule) {
+			rule.EventType = EventTypeExec
+			rule.Match.Flags = []string{"O_RDONLY"}
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rule
+			tt.modify(&r)
+			ruleSet := RuleSet{Rules: []Rule{r}}
+			assert.Equal(t, tt.wantErr, ruleSet.Validate() != nil)
+		})
+	}
+
+	duplicated := RuleSet{Rules: []Rule{rule, rule}}
+	assert.Error(t, duplicated.Validate())
+}
@@ -0,0 +1,159 @@
+package ruleengine
+
+import (
+	_ "embed"
+	"fmt"
+	"node-agent/pkg/exporters"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	EventTypeExec = "exec"
+	EventTypeOpen = "open"
+)
+
+//go:embed default_rules.yaml
+var defaultRules []byte
+
+// RuleSet is a declarative set of runtime detection rules, it is read from YAML or JSON
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule raises an alert for each exec or open event matching its conditions
+type Rule struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Enabled defaults to true, a rule is disabled by setting it to false
+	Enabled   *bool     `json:"enabled,omitempty"`
+	Severity  string    `json:"severity"`
+	EventType string    `json:"eventType"`
+	Match     RuleMatch `json:"match"`
+}
+
+// IsEnabled tells whether the rule is evaluated, the rules omitting enabled are
+func (rule *Rule) IsEnabled() bool {
+	return rule.Enabled == nil || *rule.Enabled
+}
+
+// RuleMatch holds the conditions of a rule, an event matches when it matches each condition that is set and a
+// condition matches when one of its values does. The path is the executed binary or the opened file. The binary is
+// the first argument of the exec call, resolved against the working directory of the process when it is relative.
+// The first argument is chosen by the caller and a bare name is not resolved against the PATH, so the path conditions
+// of exec rules can be evaded and the names are matched against the process name (comm) too.
+type RuleMatch struct {
+	// Paths are full paths
+	Paths []string `json:"paths,omitempty"`
+	// PathPrefixes are path prefixes, usually directories ending with a slash
+	PathPrefixes []string `json:"pathPrefixes,omitempty"`
+	// Names are base names of the path, the process name (comm) is matched too for exec events
+	Names []string `json:"names,omitempty"`
+	// Flags are open flags like O_WRONLY, only for open events
+	Flags []string `json:"flags,omitempty"`
+}
+
+var severities = map[string]bool{
+	exporters.SeverityLow:      true,
+	exporters.SeverityMedium:   true,
+	exporters.SeverityHigh:     true,
+	exporters.SeverityCritical: true,
+}
+
+// LoadRuleSet reads a rule set file, the built-in rules are returned when path is empty
+func LoadRuleSet(path string) (*RuleSet, error) {
+	data, source := defaultRules, "built-in"
+	if path != "" {
+		source = path
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rule set %s: %w", path, err)
+		}
+	}
+	var ruleSet RuleSet
+	if err := yaml.UnmarshalStrict(data, &ruleSet); err != nil {
+		return nil, fmt.Errorf("failed to decode %s rule set: %w", source, err)
+	}
+	if err := ruleSet.Validate(); err != nil {
+		return nil, err
+	}
+	return &ruleSet, nil
+}
+
+// Validate checks the rules, disabled rules included
+func (ruleSet *RuleSet) Validate() error {
+	names := make(map[string]bool)
+	for i, rule := range ruleSet.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		if !severities[rule.Severity] {
+			return fmt.Errorf("rule %s has an unknown severity %q", rule.Name, rule.Severity)
+		}
+		switch rule.EventType {
+		case EventTypeExec:
+			if len(rule.Match.Flags) > 0 {
+				return fmt.Errorf("rule %s matches flags of exec events", rule.Name)
+			}
+		case EventTypeOpen:
+		default:
+			return fmt.Errorf("rule %s has an unknown event type %q", rule.Name, rule.EventType)
+		}
+		if len(rule.Match.Paths) == 0 && len(rule.Match.PathPrefixes) == 0 && len(rule.Match.Names) == 0 && len(rule.Match.Flags) == 0 {
+			return fmt.Errorf("rule %s has no condition", rule.Name)
+		}
+	}
+	return nil
+}
+
+// matches tells whether the path of an event, and the process name or the open flags, match the rule
+func (match *RuleMatch) matches(path, comm string, flags []string) bool {
+	if len(match.Paths) > 0 && !contains(match.Paths, path) {
+		return false
+	}
+	if len(match.PathPrefixes) > 0 && !hasAnyPrefix(path, match.PathPrefixes) {
+		return false
+	}
+	if len(match.Names) > 0 && !contains(match.Names, filepath.Base(path)) && (comm == "" || !contains(match.Names, comm)) {
+		return false
+	}
+	if len(match.Flags) > 0 && !containsAny(match.Flags, flags) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,11 +3,14 @@ package sbom
 import (
 	"context"
 	"errors"
+	"node-agent/pkg/config"
 	v1 "node-agent/pkg/sbom/v1"
 	"node-agent/pkg/storageclient"
+	"sync"
 
 	"github.com/kubescape/k8s-interface/instanceidhandler"
 	"github.com/kubescape/k8s-interface/names"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
 	"github.com/spf13/afero"
 )
 
@@ -15,12 +18,17 @@ const (
 	DataAlreadyExist = "already exist"
 )
 
+// SBOMStructure is used by the monitoring goroutine of a container, which fetches the SBOM again while it is
+// incomplete, and by the file workers which filter and store it, the mutex serializes them
 type SBOMStructure struct {
+	mutex         sync.Mutex
 	storageClient SBOMStorageClient
 	SBOMData      v1.SBOMFormat
 	firstReport   bool
-	wlid          string
-	instanceID    instanceidhandler.IInstanceID
+	// incomplete is set while the image SBOM is incomplete, it is fetched again until it is regenerated complete
+	incomplete bool
+	wlid       string
+	instanceID instanceidhandler.IInstanceID
 }
 
 var _ SBOMClient = (*SBOMStructure)(nil)
@@ -36,21 +44,46 @@ func init() {
 	errorsOfSBOM[DataAlreadyExist] = errors.New(DataAlreadyExist)
 }
 
-func CreateSBOMStorageClient(sc storageclient.StorageClient, wlid string, instanceID instanceidhandler.IInstanceID, sbomFs afero.Fs) *SBOMStructure {
+func CreateSBOMStorageClient(sc storageclient.StorageClient, wlid string, instanceID instanceidhandler.IInstanceID, sbomFs afero.Fs, cfg config.Config) *SBOMStructure {
+	var SBOMData v1.SBOMFormat
+	switch {
+	case cfg.SBOMInput.Format == config.SBOMInputFormatSyft:
+		SBOMData = v1.CreateSBOMDataSyft(instanceID, sbomFs, cfg.SBOMInput.Directory)
+	case cfg.SBOMFormat == config.SBOMFormatCycloneDX:
+		SBOMData = v1.CreateSBOMDataCycloneDXVersion15(instanceID, sbomFs)
+	default:
+		SBOMData = v1.CreateSBOMDataSPDXVersionV040(instanceID, sbomFs)
+	}
+	SBOMData.SetInstallPathMatching(cfg.RelevancyMatching == config.RelevancyMatchingInstallPath)
 	return &SBOMStructure{
 		storageClient: SBOMStorageClient{
 			client: sc,
 		},
-		SBOMData:    v1.CreateSBOMDataSPDXVersionV040(instanceID, sbomFs),
+		SBOMData:    SBOMData,
 		firstReport: true,
 		instanceID:  instanceID,
 		wlid:        wlid,
 	}
 }
 
+// FetchImageFiles reads the files of a complete image SBOM with their checksums, the SBOM is read from the source
+// CreateSBOMStorageClient selects
+func FetchImageFiles(ctx context.Context, sc storageclient.StorageClient, imageTag, imageID string, cfg config.Config) (map[string][]spdxv1beta1.Checksum, error) {
+	SBOMKey, err := names.ImageInfoToSlug(imageTag, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SBOMInput.Format == config.SBOMInputFormatSyft {
+		return v1.FetchImageFilesSyft(ctx, sc, cfg.SBOMInput.Directory, SBOMKey)
+	}
+	return v1.FetchImageFilesSPDX(ctx, sc, SBOMKey)
+}
+
 func (sc *SBOMStructure) GetSBOM(ctx context.Context, imageTag, imageID string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
 
-	if sc.SBOMData.IsSBOMAlreadyExist() {
+	if sc.SBOMData.IsSBOMAlreadyExist() && !sc.incomplete {
 		return nil
 	}
 
@@ -59,35 +92,52 @@ func (sc *SBOMStructure) GetSBOM(ctx context.Context, imageTag, imageID string)
 		return err
 	}
 
-	SBOM, err := sc.storageClient.client.GetData(ctx, SBOMKey)
-	if err != nil {
+	if err = sc.SBOMData.FetchSBOM(ctx, sc.storageClient.client, SBOMKey); err != nil {
 		return err
 	}
-	err = sc.SBOMData.StoreSBOM(ctx, SBOM)
-	if err != nil {
-		return err
+	wasIncomplete := sc.incomplete
+	_ = sc.validateSBOM(ctx)
+	if wasIncomplete && !sc.incomplete {
+		// the filtered SBOM stored with the incomplete status is replaced
+		sc.firstReport = true
 	}
 	return nil
 }
 
 func (sc *SBOMStructure) IsSBOMAlreadyExist() bool {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
 	return sc.SBOMData.IsSBOMAlreadyExist()
 }
 
+func (sc *SBOMStructure) IsSBOMIncomplete() bool {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.incomplete
+}
+
 func (sc *SBOMStructure) FilterSBOM(ctx context.Context, sbomFileRelevantMap map[string]bool) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
 	return sc.SBOMData.FilterSBOM(ctx, sbomFileRelevantMap)
 }
 
+func (sc *SBOMStructure) RestoreRelevantPackages(packageIDs map[string]bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.SBOMData.RestoreRelevantPackages(packageIDs)
+}
+
 func (sc *SBOMStructure) StoreFilterSBOM(ctx context.Context, imageID, instanceID string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
 	if sc.firstReport || sc.SBOMData.IsNewRelevantSBOMDataExist() {
 		sc.SBOMData.SetFilteredSBOMName(instanceID)
 		sc.SBOMData.StoreMetadata(ctx, sc.wlid, imageID, sc.instanceID)
-		data := sc.SBOMData.GetFilterSBOMData()
-		err := sc.storageClient.client.PostData(ctx, data)
+		err := sc.SBOMData.CreateFilteredSBOM(ctx, sc.storageClient.client)
 		if err != nil {
 			if storageclient.IsAlreadyExist(err) {
-				data = sc.SBOMData.GetFilterSBOMData()
-				err = sc.storageClient.client.PutData(ctx, instanceID, data)
+				err = sc.SBOMData.UpdateFilteredSBOM(ctx, sc.storageClient.client, instanceID)
 				if err != nil {
 					return err
 				}
@@ -102,6 +152,8 @@ func (sc *SBOMStructure) StoreFilterSBOM(ctx context.Context, imageID, instanceI
 }
 
 func (sc *SBOMStructure) CleanResources() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
 	sc.SBOMData.CleanResources()
 }
 
@@ -110,5 +162,13 @@ func IsAlreadyExist() error {
 }
 
 func (sc *SBOMStructure) ValidateSBOM(ctx context.Context) error {
-	return sc.SBOMData.ValidateSBOM(ctx)
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.validateSBOM(ctx)
+}
+
+func (sc *SBOMStructure) validateSBOM(ctx context.Context) error {
+	err := sc.SBOMData.ValidateSBOM(ctx)
+	sc.incomplete = errors.Is(err, v1.SBOMIncomplete)
+	return err
 }
@@ -5,8 +5,12 @@ import "context"
 type SBOMClient interface {
 	GetSBOM(ctx context.Context, imageTag, imageID string) error
 	IsSBOMAlreadyExist() bool
+	// IsSBOMIncomplete reports whether the image SBOM was incomplete when it was last fetched or validated
+	IsSBOMIncomplete() bool
 	ValidateSBOM(ctx context.Context) error
 	FilterSBOM(ctx context.Context, sbomFileRelevantMap map[string]bool) error
+	// RestoreRelevantPackages marks relevant the packages of the stored filtered SBOM of the instance
+	RestoreRelevantPackages(packageIDs map[string]bool)
 	StoreFilterSBOM(ctx context.Context, imageID, instanceID string) error
 	CleanResources()
 }
@@ -2,15 +2,33 @@ package sbom
 
 import (
 	"context"
+	"node-agent/pkg/config"
 	"node-agent/pkg/storageclient"
+	"sync"
 	"testing"
 
 	"github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
 	"github.com/spf13/afero"
 )
 
+// incompleteStorageClientMock serves the nginx SBOM marked as incomplete
+type incompleteStorageClientMock struct {
+	*storageclient.StorageHttpClientMock
+}
+
+func (sc *incompleteStorageClientMock) GetImageSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3, error) {
+	SBOM, err := sc.StorageHttpClientMock.GetImageSBOM(ctx, key)
+	if err != nil || SBOM == nil {
+		return SBOM, err
+	}
+	SBOM = SBOM.DeepCopy()
+	SBOM.SetAnnotations(map[string]string{instanceidhandler.StatusMetadataKey: instanceidhandler.Incomplete})
+	return SBOM, nil
+}
+
 func TestGetSBOM(t *testing.T) {
-	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs())
+	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs(), config.Config{SBOMFormat: config.SBOMFormatSPDX})
 	err := SBOMClient.GetSBOM(context.TODO(), storageclient.NGINX_IMAGE_TAG, storageclient.NGINX)
 	if err != nil {
 		t.Fatalf("fail to get sbom, %v", err)
@@ -19,7 +37,7 @@ func TestGetSBOM(t *testing.T) {
 }
 
 func TestFilterSBOM(t *testing.T) {
-	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs())
+	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs(), config.Config{SBOMFormat: config.SBOMFormatSPDX})
 	err := SBOMClient.GetSBOM(context.TODO(), storageclient.NGINX_IMAGE_TAG, storageclient.NGINX)
 	if err != nil {
 		t.Fatalf("fail to get sbom, %v", err)
@@ -34,7 +52,7 @@ func TestFilterSBOM(t *testing.T) {
 }
 
 func TestStoreFilterSBOM(t *testing.T) {
-	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs())
+	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs(), config.Config{SBOMFormat: config.SBOMFormatSPDX})
 	err := SBOMClient.GetSBOM(context.TODO(), storageclient.NGINX_IMAGE_TAG, storageclient.NGINX)
 	if err != nil {
 		t.Fatalf("fail to get sbom")
@@ -53,7 +71,7 @@ func TestStoreFilterSBOM(t *testing.T) {
 }
 
 func TestStoreFilterSBOMFailure(t *testing.T) {
-	SBOMClient := CreateSBOMStorageClient(storageclient.CreateStorageHttpClientFailureMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs())
+	SBOMClient := CreateSBOMStorageClient(storageclient.CreateStorageHttpClientFailureMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs(), config.Config{SBOMFormat: config.SBOMFormatSPDX})
 	err := SBOMClient.GetSBOM(context.TODO(), storageclient.NGINX_IMAGE_TAG, storageclient.NGINX)
 	if err != nil {
 		t.Fatalf("fail to get sbom")
@@ -70,3 +88,58 @@ func TestStoreFilterSBOMFailure(t *testing.T) {
 	}
 
 }
+
+func TestStoreFilterSBOMSyft(t *testing.T) {
+	cfg := config.Config{SBOMFormat: config.SBOMFormatSPDX, SBOMInput: config.SBOMInputConfig{Format: config.SBOMInputFormatSyft}}
+	SBOMClient := CreateSBOMStorageClient(storageclient.CreateSBOMStorageHttpClientMock(), "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs(), cfg)
+	err := SBOMClient.GetSBOM(context.TODO(), storageclient.NGINX_IMAGE_TAG, storageclient.NGINX)
+	if err != nil {
+		t.Fatalf("fail to get sbom, %v", err)
+	}
+	err = SBOMClient.FilterSBOM(context.TODO(), map[string]bool{
+		"/usr/share/adduser/adduser.conf": true,
+	})
+	if err != nil {
+		t.Fatalf("fail to filter sbom, %v", err)
+	}
+	err = SBOMClient.StoreFilterSBOM(context.TODO(), "", "anyInstanceID")
+	if err != nil {
+		t.Fatalf("fail to store filter sbom, %v", err)
+	}
+}
+
+func TestGetSBOMWhileFiltering(t *testing.T) {
+	storageClient := &incompleteStorageClientMock{StorageHttpClientMock: storageclient.CreateSBOMStorageHttpClientMock()}
+	SBOMClient := CreateSBOMStorageClient(storageClient, "", &instanceidhandler.InstanceID{}, afero.NewMemMapFs(), config.Config{SBOMFormat: config.SBOMFormatSPDX})
+	ctx := context.TODO()
+	if err := SBOMClient.GetSBOM(ctx, storageclient.NGINX_IMAGE_TAG, storageclient.NGINX); err != nil {
+		t.Fatalf("fail to get sbom, %v", err)
+	}
+	if !SBOMClient.IsSBOMIncomplete() {
+		t.Fatalf("SBOM should be incomplete")
+	}
+
+	// the monitoring goroutine fetches the incomplete SBOM again while a file worker filters it
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if SBOMClient.IsSBOMAlreadyExist() && SBOMClient.IsSBOMIncomplete() {
+				_ = SBOMClient.GetSBOM(ctx, storageclient.NGINX_IMAGE_TAG, storageclient.NGINX)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			_ = SBOMClient.ValidateSBOM(ctx)
+			_ = SBOMClient.FilterSBOM(ctx, map[string]bool{"/usr/share/adduser/adduser.conf": true})
+			_ = SBOMClient.StoreFilterSBOM(ctx, "", "anyInstanceID")
+		}
+	}()
+	wg.Wait()
+	if !SBOMClient.IsSBOMIncomplete() {
+		t.Fatalf("SBOM should still be incomplete")
+	}
+}
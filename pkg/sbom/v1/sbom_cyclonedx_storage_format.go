@@ -0,0 +1,293 @@
+package sbom
+
+import (
+	"context"
+	"node-agent/pkg/storageclient"
+	"sort"
+
+	cyclonedx "github.com/CycloneDX/cyclonedx-go"
+	"github.com/google/uuid"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/spf13/afero"
+)
+
+const (
+	RelationshipDependsOnType    = "DEPENDS_ON"
+	RelationshipDependencyOfType = "DEPENDENCY_OF"
+
+	// component properties, they let VEX tooling tell that a component was loaded at runtime. The relevant files of a
+	// package are the file components nested under it.
+	PropertyRelevant         = "kubescape:relevant"
+	PropertyRelevancyContext = "kubescape:relevancy-context"
+	relevancyContextFiltered = "runtime-filtered"
+
+	spdxNoAssertion = "NOASSERTION"
+	spdxNone        = "NONE"
+)
+
+var spdxToCycloneDXHashAlgorithm = map[spdxv1beta1.ChecksumAlgorithm]cyclonedx.HashAlgorithm{
+	spdxv1beta1.MD5:    cyclonedx.HashAlgoMD5,
+	spdxv1beta1.SHA1:   cyclonedx.HashAlgoSHA1,
+	spdxv1beta1.SHA256: cyclonedx.HashAlgoSHA256,
+	spdxv1beta1.SHA384: cyclonedx.HashAlgoSHA384,
+	spdxv1beta1.SHA512: cyclonedx.HashAlgoSHA512,
+}
+
+// SBOMDataCycloneDX parses and filters the image SBOM exactly like SBOMData and writes the filtered SBOM as a CycloneDX 1.5 BOM
+type SBOMDataCycloneDX struct {
+	*SBOMData
+}
+
+var _ SBOMFormat = (*SBOMDataCycloneDX)(nil)
+
+func CreateSBOMDataCycloneDXVersion15(instanceID instanceidhandler.IInstanceID, sbomFs afero.Fs) SBOMFormat {
+	return &SBOMDataCycloneDX{
+		SBOMData: CreateSBOMDataSPDXVersionV040(instanceID, sbomFs).(*SBOMData),
+	}
+}
+
+func (sc *SBOMDataCycloneDX) GetFilterSBOMData() *storageclient.SBOMCycloneDXFiltered {
+	return convertSPDXToCycloneDX(&sc.filteredSpdxData)
+}
+
+func (sc *SBOMDataCycloneDX) CreateFilteredSBOM(ctx context.Context, client storageclient.StorageClient) error {
+	return client.CreateFilteredSBOMCycloneDX(ctx, sc.GetFilterSBOMData())
+}
+
+func (sc *SBOMDataCycloneDX) UpdateFilteredSBOM(ctx context.Context, client storageclient.StorageClient, key string) error {
+	return client.UpdateFilteredSBOMCycloneDX(ctx, key, sc.GetFilterSBOMData())
+}
+
+func convertSPDXToCycloneDX(filteredSpdxData *spdxv1beta1.SBOMSPDXv2p3Filtered) *storageclient.SBOMCycloneDXFiltered {
+	spdxData := filteredSpdxData.Spec.SPDX
+
+	bom := cyclonedx.NewBOM()
+	// the serial number must stay the same between updates of the same filtered SBOM
+	bom.SerialNumber = uuid.NewSHA1(uuid.NameSpaceURL, []byte(filteredSpdxData.Name+spdxData.DocumentNamespace)).URN()
+	bom.Metadata = convertMetadata(filteredSpdxData)
+
+	filesByID := make(map[spdxv1beta1.ElementID]*spdxv1beta1.File, len(spdxData.Files))
+	for i := range spdxData.Files {
+		filesByID[spdxData.Files[i].FileSPDXIdentifier] = spdxData.Files[i]
+	}
+	packagesByID := make(map[spdxv1beta1.ElementID]bool, len(spdxData.Packages))
+	for i := range spdxData.Packages {
+		packagesByID[spdxData.Packages[i].PackageSPDXIdentifier] = true
+	}
+
+	// files are nested under the package that contains them
+	packageFiles := make(map[spdxv1beta1.ElementID][]spdxv1beta1.ElementID)
+	containedFiles := make(map[spdxv1beta1.ElementID]bool)
+	dependsOn := make(map[string]map[string]bool)
+	addDependency := func(ref, dependency spdxv1beta1.ElementID) {
+		if !packagesByID[ref] || !packagesByID[dependency] {
+			return
+		}
+		if _, ok := dependsOn[string(ref)]; !ok {
+			dependsOn[string(ref)] = make(map[string]bool)
+		}
+		dependsOn[string(ref)][string(dependency)] = true
+	}
+	for _, relationship := range spdxData.Relationships {
+		if relationship == nil {
+			continue
+		}
+		refA, refB := relationship.RefA.ElementRefID, relationship.RefB.ElementRefID
+		switch relationship.Relationship {
+		case RelationshipContainType:
+			if _, ok := filesByID[refB]; ok && packagesByID[refA] && !containedFiles[refB] {
+				packageFiles[refA] = append(packageFiles[refA], refB)
+				containedFiles[refB] = true
+			}
+		case RelationshipDependsOnType:
+			addDependency(refA, refB)
+		case RelationshipDependencyOfType:
+			addDependency(refB, refA)
+		}
+	}
+
+	components := make([]cyclonedx.Component, 0, len(spdxData.Packages))
+	alreadyAdded := make(map[spdxv1beta1.ElementID]bool, len(spdxData.Packages))
+	for _, p := range spdxData.Packages {
+		// the same package can be added more than once to the filtered SPDX
+		if p == nil || alreadyAdded[p.PackageSPDXIdentifier] {
+			continue
+		}
+		alreadyAdded[p.PackageSPDXIdentifier] = true
+		component := convertPackage(p)
+		if fileIDs := packageFiles[p.PackageSPDXIdentifier]; len(fileIDs) > 0 {
+			fileComponents := make([]cyclonedx.Component, 0, len(fileIDs))
+			for _, fileID := range fileIDs {
+				fileComponents = append(fileComponents, convertFile(filesByID[fileID]))
+			}
+			component.Components = &fileComponents
+		}
+		components = append(components, component)
+	}
+	for _, f := range spdxData.Files {
+		if f != nil && !containedFiles[f.FileSPDXIdentifier] {
+			components = append(components, convertFile(f))
+		}
+	}
+	bom.Components = &components
+	bom.Dependencies = convertDependencies(bom.Metadata.Component.BOMRef, components, dependsOn)
+
+	return &storageclient.SBOMCycloneDXFiltered{
+		TypeMeta:   filteredSpdxData.TypeMeta,
+		ObjectMeta: *filteredSpdxData.ObjectMeta.DeepCopy(),
+		Spec:       *bom,
+	}
+}
+
+func convertMetadata(filteredSpdxData *spdxv1beta1.SBOMSPDXv2p3Filtered) *cyclonedx.Metadata {
+	spdxData := filteredSpdxData.Spec.SPDX
+	metadata := &cyclonedx.Metadata{
+		Tools: &[]cyclonedx.Tool{
+			{
+				Vendor: KubescapeOrganizationName,
+				Name:   KubescapeNodeAgentName,
+			},
+		},
+		Component: &cyclonedx.Component{
+			BOMRef: string(spdxData.SPDXIdentifier),
+			Type:   cyclonedx.ComponentTypeContainer,
+			Name:   spdxData.DocumentName,
+		},
+	}
+	if spdxData.CreationInfo != nil {
+		metadata.Timestamp = spdxData.CreationInfo.Created
+		for _, creator := range spdxData.CreationInfo.Creators {
+			if creator.CreatorType == Tool && creator.Creator != KubescapeNodeAgentName {
+				*metadata.Tools = append(*metadata.Tools, cyclonedx.Tool{Name: creator.Creator})
+			}
+		}
+	}
+	properties := []cyclonedx.Property{
+		{Name: PropertyRelevancyContext, Value: relevancyContextFiltered},
+	}
+	annotations := filteredSpdxData.GetAnnotations()
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		properties = append(properties, cyclonedx.Property{Name: k, Value: annotations[k]})
+	}
+	metadata.Properties = &properties
+	return metadata
+}
+
+func convertPackage(p *spdxv1beta1.Package) cyclonedx.Component {
+	component := cyclonedx.Component{
+		BOMRef:      string(p.PackageSPDXIdentifier),
+		Type:        componentType(p.PrimaryPackagePurpose),
+		Name:        p.PackageName,
+		Version:     p.PackageVersion,
+		Description: p.PackageDescription,
+		Properties: &[]cyclonedx.Property{
+			{Name: PropertyRelevant, Value: "true"},
+		},
+	}
+	if p.PackageSupplier != nil && isSPDXValueSet(p.PackageSupplier.Supplier) {
+		component.Supplier = &cyclonedx.OrganizationalEntity{Name: p.PackageSupplier.Supplier}
+	}
+	if p.PackageOriginator != nil && isSPDXValueSet(p.PackageOriginator.Originator) {
+		component.Author = p.PackageOriginator.Originator
+	}
+	if isSPDXValueSet(p.PackageCopyrightText) {
+		component.Copyright = p.PackageCopyrightText
+	}
+	license := p.PackageLicenseConcluded
+	if !isSPDXValueSet(license) {
+		license = p.PackageLicenseDeclared
+	}
+	if isSPDXValueSet(license) {
+		component.Licenses = &cyclonedx.Licenses{{Expression: license}}
+	}
+	component.Hashes = convertChecksums(p.PackageChecksums)
+	for _, ref := range p.PackageExternalReferences {
+		if ref == nil {
+			continue
+		}
+		switch ref.RefType {
+		case "purl":
+			component.PackageURL = ref.Locator
+		case "cpe23Type", "cpe22Type":
+			if component.CPE == "" {
+				component.CPE = ref.Locator
+			}
+		}
+	}
+	return component
+}
+
+func convertFile(f *spdxv1beta1.File) cyclonedx.Component {
+	return cyclonedx.Component{
+		BOMRef: string(f.FileSPDXIdentifier),
+		Type:   cyclonedx.ComponentTypeFile,
+		Name:   f.FileName,
+		Hashes: convertChecksums(f.Checksums),
+		Properties: &[]cyclonedx.Property{
+			{Name: PropertyRelevant, Value: "true"},
+		},
+	}
+}
+
+func convertChecksums(checksums []spdxv1beta1.Checksum) *[]cyclonedx.Hash {
+	hashes := make([]cyclonedx.Hash, 0, len(checksums))
+	for _, checksum := range checksums {
+		if algorithm, ok := spdxToCycloneDXHashAlgorithm[checksum.Algorithm]; ok {
+			hashes = append(hashes, cyclonedx.Hash{Algorithm: algorithm, Value: checksum.Value})
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	return &hashes
+}
+
+func convertDependencies(rootRef string, components []cyclonedx.Component, dependsOn map[string]map[string]bool) *[]cyclonedx.Dependency {
+	rootDependencies := make([]string, 0, len(components))
+	dependencies := make([]cyclonedx.Dependency, 0, len(components)+1)
+	for _, component := range components {
+		if component.Type == cyclonedx.ComponentTypeFile {
+			continue
+		}
+		rootDependencies = append(rootDependencies, component.BOMRef)
+		dependency := cyclonedx.Dependency{Ref: component.BOMRef}
+		if refs, ok := dependsOn[component.BOMRef]; ok {
+			componentDependencies := make([]string, 0, len(refs))
+			for ref := range refs {
+				componentDependencies = append(componentDependencies, ref)
+			}
+			sort.Strings(componentDependencies)
+			dependency.Dependencies = &componentDependencies
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	dependencies = append([]cyclonedx.Dependency{{Ref: rootRef, Dependencies: &rootDependencies}}, dependencies...)
+	return &dependencies
+}
+
+func componentType(primaryPackagePurpose string) cyclonedx.ComponentType {
+	switch primaryPackagePurpose {
+	case "OPERATING-SYSTEM":
+		return cyclonedx.ComponentTypeOS
+	case "APPLICATION":
+		return cyclonedx.ComponentTypeApplication
+	case "FRAMEWORK":
+		return cyclonedx.ComponentTypeFramework
+	case "CONTAINER":
+		return cyclonedx.ComponentTypeContainer
+	case "FILE":
+		return cyclonedx.ComponentTypeFile
+	default:
+		return cyclonedx.ComponentTypeLibrary
+	}
+}
+
+func isSPDXValueSet(value string) bool {
+	return value != "" && value != spdxNoAssertion && value != spdxNone
+}
@@ -0,0 +1,95 @@
+package sbom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"node-agent/pkg/utils"
+	"os"
+	"path"
+	"testing"
+
+	cyclonedx "github.com/CycloneDX/cyclonedx-go"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCycloneDXGetFilterSBOMData(t *testing.T) {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instnaceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	data := CreateSBOMDataCycloneDXVersion15(instanceID, afero.NewMemMapFs())
+	SBOMData := data.(*SBOMDataCycloneDX)
+
+	var SBOMDataMock spdxv1beta1.SBOMSPDXv2p3
+	nginxSBOMPath := path.Join(utils.CurrentDir(), "..", "testdata", "nginx-spdx-format-mock.json")
+	bytesSBOM, err := os.ReadFile(nginxSBOMPath)
+	if err != nil {
+		t.Fatalf("fail to read SBOM file, err: %v", err)
+	}
+	err = json.Unmarshal(bytesSBOM, &SBOMDataMock.Spec.SPDX)
+	if err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	err = SBOMData.StoreSBOM(context.TODO(), &SBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to store SBOM file, err: %v", err)
+	}
+	err = SBOMData.FilterSBOM(context.TODO(), map[string]bool{
+		"/usr/share/adduser/adduser.conf": true,
+	})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	SBOMData.SetFilteredSBOMName("anyInstanceID")
+	SBOMData.StoreMetadata(context.TODO(), "wlid://cluster-test/namespace-aaa/deployment-redis", "e41ced4a64bd065a1a8b79dbc5832b744a3ad82e7fcbe9fb2ebdd1267f972775", instanceID)
+
+	filtered := SBOMData.GetFilterSBOMData()
+	assert.Equal(t, "anyInstanceID", filtered.Name)
+	assert.Equal(t, instnaceIDMock, filtered.Annotations[instanceidhandlerV1.InstanceIDMetadataKey])
+
+	bom := filtered.Spec
+	assert.Equal(t, cyclonedx.SpecVersion1_5, bom.SpecVersion)
+	assert.Equal(t, "nginx", bom.Metadata.Component.Name)
+	assert.Contains(t, *bom.Metadata.Properties, cyclonedx.Property{Name: instanceidhandlerV1.InstanceIDMetadataKey, Value: instnaceIDMock})
+
+	if !assert.Len(t, *bom.Components, 1) {
+		return
+	}
+	component := (*bom.Components)[0]
+	assert.Equal(t, "adduser", component.Name)
+	assert.Equal(t, "3.118", component.Version)
+	assert.Equal(t, cyclonedx.ComponentTypeLibrary, component.Type)
+	assert.Equal(t, "pkg:deb/debian/adduser@3.118?arch=all&distro=debian-11", component.PackageURL)
+	assert.Equal(t, "cpe:2.3:a:adduser:adduser:3.118:*:*:*:*:*:*:*", component.CPE)
+	assert.Equal(t, cyclonedx.Licenses{{Expression: "GPL-2.0-only"}}, *component.Licenses)
+	assert.Contains(t, *component.Properties, cyclonedx.Property{Name: PropertyRelevant, Value: "true"})
+	if assert.NotNil(t, component.Components) && assert.Len(t, *component.Components, 1) {
+		file := (*component.Components)[0]
+		assert.Equal(t, "/usr/share/adduser/adduser.conf", file.Name)
+		assert.Equal(t, cyclonedx.ComponentTypeFile, file.Type)
+		assert.Equal(t, cyclonedx.HashAlgoSHA1, (*file.Hashes)[0].Algorithm)
+	}
+
+	assert.Len(t, *bom.Dependencies, 2)
+	assert.Equal(t, []string{component.BOMRef}, *(*bom.Dependencies)[0].Dependencies)
+
+	// the serial number must not change between two reports of the same filtered SBOM
+	assert.Equal(t, bom.SerialNumber, SBOMData.GetFilterSBOMData().Spec.SerialNumber)
+
+	// the output must be a valid CycloneDX document
+	var buf bytes.Buffer
+	err = cyclonedx.NewBOMEncoder(&buf, cyclonedx.BOMFileFormatJSON).Encode(&bom)
+	if err != nil {
+		t.Fatalf("fail to encode CycloneDX BOM, err: %v", err)
+	}
+	var decoded cyclonedx.BOM
+	err = cyclonedx.NewBOMDecoder(&buf, cyclonedx.BOMFileFormatJSON).Decode(&decoded)
+	if err != nil {
+		t.Fatalf("fail to decode CycloneDX BOM, err: %v", err)
+	}
+	assert.Equal(t, bom.SerialNumber, decoded.SerialNumber)
+}
@@ -0,0 +1,188 @@
+package sbom
+
+import (
+	"strings"
+
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+)
+
+type FileOwnershipSource string
+
+const (
+	FileOwnershipContains     FileOwnershipSource = "contains"
+	FileOwnershipHasFiles     FileOwnershipSource = "hasFiles"
+	FileOwnershipPackageFiles FileOwnershipSource = "packageFiles"
+	FileOwnershipAnnotation   FileOwnershipSource = "annotation"
+	FileOwnershipFallback     FileOwnershipSource = "fallback"
+	FileOwnershipInstallPath  FileOwnershipSource = "installPath"
+)
+
+// fileAnnotationKeys are the package annotation comment keys ("<key>: <path>") holding a file of the package
+var fileAnnotationKeys = []string{"FilePath", "location"}
+
+// PackageFilesFallback returns the files of a package for which the SBOM has no structured file ownership
+type PackageFilesFallback func(spdxPackage *spdxv1beta1.Package) []string
+
+// SourceInfoPackageFiles is the default fallback, it reads the file list the SBOM generator wrote in the package source info
+func SourceInfoPackageFiles(spdxPackage *spdxv1beta1.Package) []string {
+	return parsedFilesBySourceInfo(spdxPackage.PackageSourceInfo)
+}
+
+// FileIndexStats tells how much of the SBOM the file index could map to packages
+type FileIndexStats struct {
+	Packages             int
+	PackagesWithFiles    int
+	PackagesFromFallback int
+	PackagesWithoutFiles int
+	Files                int
+	FilesWithPackage     int
+	OwnershipsBySource   map[FileOwnershipSource]int
+}
+
+// packageFileIndex maps the file paths of an SPDX document to the packages owning them
+type packageFileIndex struct {
+	packagesByFile      map[string][]spdxv1beta1.ElementID
+	packagesByDirectory map[string][]spdxv1beta1.ElementID
+	fileIDsByName       map[string][]spdxv1beta1.ElementID
+	stats               FileIndexStats
+}
+
+// buildPackageFileIndex indexes the files of every package, when matchInstallPaths is set the install
+// directories of language packages are indexed too, see installPathRules
+func buildPackageFileIndex(document *spdxv1beta1.Document, fallback PackageFilesFallback, matchInstallPaths bool) *packageFileIndex {
+	index := &packageFileIndex{
+		packagesByFile:      make(map[string][]spdxv1beta1.ElementID),
+		packagesByDirectory: make(map[string][]spdxv1beta1.ElementID),
+		fileIDsByName:       make(map[string][]spdxv1beta1.ElementID),
+		stats: FileIndexStats{
+			Packages:           len(document.Packages),
+			Files:              len(document.Files),
+			OwnershipsBySource: make(map[FileOwnershipSource]int),
+		},
+	}
+
+	fileNamesByID := make(map[spdxv1beta1.ElementID]string, len(document.Files))
+	for i := range document.Files {
+		fileNamesByID[document.Files[i].FileSPDXIdentifier] = document.Files[i].FileName
+		index.fileIDsByName[document.Files[i].FileName] = append(index.fileIDsByName[document.Files[i].FileName], document.Files[i].FileSPDXIdentifier)
+	}
+
+	ownedFiles := make(map[string]bool)
+	packagesWithFiles := make(map[spdxv1beta1.ElementID]bool)
+	addFile := func(packageID spdxv1beta1.ElementID, fileName string, source FileOwnershipSource) {
+		if fileName == "" {
+			return
+		}
+		if containsElementID(index.packagesByFile[fileName], packageID) {
+			return
+		}
+		index.packagesByFile[fileName] = append(index.packagesByFile[fileName], packageID)
+		index.stats.OwnershipsBySource[source]++
+		packagesWithFiles[packageID] = true
+		ownedFiles[fileName] = true
+	}
+
+	packageIDs := make(map[spdxv1beta1.ElementID]bool, len(document.Packages))
+	for _, spdxPackage := range document.Packages {
+		packageIDs[spdxPackage.PackageSPDXIdentifier] = true
+		for _, fileID := range spdxPackage.HasFiles {
+			addFile(spdxPackage.PackageSPDXIdentifier, fileNamesByID[trimSPDXRefPrefix(fileID)], FileOwnershipHasFiles)
+		}
+		for _, file := range spdxPackage.Files {
+			if file != nil {
+				addFile(spdxPackage.PackageSPDXIdentifier, file.FileName, FileOwnershipPackageFiles)
+			}
+		}
+		for _, annotation := range spdxPackage.Annotations {
+			addFile(spdxPackage.PackageSPDXIdentifier, fileFromAnnotation(annotation.AnnotationComment), FileOwnershipAnnotation)
+		}
+	}
+	for _, relationship := range document.Relationships {
+		if relationship.Relationship != RelationshipContainType || !packageIDs[relationship.RefA.ElementRefID] {
+			continue
+		}
+		addFile(relationship.RefA.ElementRefID, fileNamesByID[relationship.RefB.ElementRefID], FileOwnershipContains)
+	}
+	index.stats.PackagesWithFiles = len(packagesWithFiles)
+
+	// the fallback only covers the packages the structured data says nothing about
+	if fallback != nil {
+		for _, spdxPackage := range document.Packages {
+			if packagesWithFiles[spdxPackage.PackageSPDXIdentifier] {
+				continue
+			}
+			for _, fileName := range fallback(spdxPackage) {
+				addFile(spdxPackage.PackageSPDXIdentifier, fileName, FileOwnershipFallback)
+			}
+		}
+		index.stats.PackagesFromFallback = len(packagesWithFiles) - index.stats.PackagesWithFiles
+	}
+	index.stats.PackagesWithoutFiles = len(packageIDs) - len(packagesWithFiles)
+
+	if matchInstallPaths {
+		index.addInstallPaths(document)
+	}
+
+	for fileName := range index.fileIDsByName {
+		if ownedFiles[fileName] {
+			index.stats.FilesWithPackage += len(index.fileIDsByName[fileName])
+		}
+	}
+
+	return index
+}
+
+func (index *packageFileIndex) addInstallPaths(document *spdxv1beta1.Document) {
+	filesByPackage := make(map[spdxv1beta1.ElementID][]string)
+	for fileName, packageIDs := range index.packagesByFile {
+		for _, packageID := range packageIDs {
+			filesByPackage[packageID] = append(filesByPackage[packageID], fileName)
+		}
+	}
+	for _, spdxPackage := range document.Packages {
+		purl := spdxPackagePURL(spdxPackage)
+		for _, fileName := range filesByPackage[spdxPackage.PackageSPDXIdentifier] {
+			for _, installPath := range installPaths(purl, spdxPackage.PackageName, fileName) {
+				owners := index.packagesByFile
+				if strings.HasSuffix(installPath, "/") {
+					owners = index.packagesByDirectory
+					installPath = strings.TrimSuffix(installPath, "/")
+				}
+				if !containsElementID(owners[installPath], spdxPackage.PackageSPDXIdentifier) {
+					owners[installPath] = append(owners[installPath], spdxPackage.PackageSPDXIdentifier)
+					index.stats.OwnershipsBySource[FileOwnershipInstallPath]++
+				}
+			}
+		}
+	}
+}
+
+// packagesOf returns the packages owning fileName, either directly or through an install directory
+func (index *packageFileIndex) packagesOf(fileName string) ([]spdxv1beta1.ElementID, bool) {
+	if packageIDs, ok := index.packagesByFile[fileName]; ok {
+		return packageIDs, true
+	}
+	return lookupInstallDirectory(index.packagesByDirectory, fileName)
+}
+
+func containsElementID(list []spdxv1beta1.ElementID, id spdxv1beta1.ElementID) bool {
+	for i := range list {
+		if list[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func fileFromAnnotation(comment string) string {
+	for _, key := range fileAnnotationKeys {
+		if value, found := strings.CutPrefix(comment, key+": "); found && strings.HasPrefix(value, "/") {
+			return value
+		}
+	}
+	return ""
+}
+
+func trimSPDXRefPrefix(id string) spdxv1beta1.ElementID {
+	return spdxv1beta1.ElementID(strings.TrimPrefix(id, "SPDXRef-"))
+}
@@ -0,0 +1,153 @@
+package sbom
+
+import (
+	"context"
+	"encoding/json"
+	"node-agent/pkg/utils"
+	"os"
+	"path"
+	"testing"
+
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func fileIndexDocumentMock() *spdxv1beta1.Document {
+	return &spdxv1beta1.Document{
+		SPDXIdentifier: "DOCUMENT",
+		Packages: []*spdxv1beta1.Package{
+			{
+				PackageName:           "contains",
+				PackageSPDXIdentifier: "Package-contains",
+				// ignored, the package has structured file ownership
+				PackageSourceInfo: "acquired package info from the following paths: /contains/manifest",
+			},
+			{
+				PackageName:           "has-files",
+				PackageSPDXIdentifier: "Package-has-files",
+				HasFiles:              []string{"SPDXRef-File-b"},
+			},
+			{
+				PackageName:           "package-files",
+				PackageSPDXIdentifier: "Package-package-files",
+				Files:                 []*spdxv1beta1.File{{FileName: "/package/files/c"}},
+			},
+			{
+				PackageName:           "annotation",
+				PackageSPDXIdentifier: "Package-annotation",
+				Annotations: []spdxv1beta1.Annotation{
+					{AnnotationComment: "PkgType: npm"},
+					{AnnotationComment: "FilePath: /app/node_modules/annotation/package.json"},
+				},
+			},
+			{
+				PackageName:           "source-info",
+				PackageSPDXIdentifier: "Package-source-info",
+				PackageSourceInfo:     "acquired package info from installed node module manifest file: /app/node_modules/source-info/package.json",
+			},
+			{
+				PackageName:           "no-files",
+				PackageSPDXIdentifier: "Package-no-files",
+				PackageSourceInfo:     "acquired package info from DPKG DB: /var/lib/dpkg/status",
+			},
+		},
+		Files: []*spdxv1beta1.File{
+			{FileName: "/contains/a", FileSPDXIdentifier: "File-a"},
+			{FileName: "/has/files/b", FileSPDXIdentifier: "File-b"},
+			{FileName: "/unowned", FileSPDXIdentifier: "File-unowned"},
+		},
+		Relationships: []*spdxv1beta1.Relationship{
+			{
+				RefA:         spdxv1beta1.DocElementID{ElementRefID: "Package-contains"},
+				RefB:         spdxv1beta1.DocElementID{ElementRefID: "File-a"},
+				Relationship: RelationshipContainType,
+			},
+		},
+	}
+}
+
+func TestBuildPackageFileIndex(t *testing.T) {
+	index := buildPackageFileIndex(fileIndexDocumentMock(), SourceInfoPackageFiles, false)
+
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-contains"}, index.packagesByFile["/contains/a"])
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-has-files"}, index.packagesByFile["/has/files/b"])
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-package-files"}, index.packagesByFile["/package/files/c"])
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-annotation"}, index.packagesByFile["/app/node_modules/annotation/package.json"])
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-source-info"}, index.packagesByFile["/app/node_modules/source-info/package.json"])
+	assert.NotContains(t, index.packagesByFile, "/contains/manifest")
+	assert.NotContains(t, index.packagesByFile, "/unowned")
+	assert.Contains(t, index.fileIDsByName, "/unowned")
+
+	assert.Equal(t, FileIndexStats{
+		Packages:             6,
+		PackagesWithFiles:    4,
+		PackagesFromFallback: 1,
+		PackagesWithoutFiles: 1,
+		Files:                3,
+		FilesWithPackage:     2,
+		OwnershipsBySource: map[FileOwnershipSource]int{
+			FileOwnershipContains:     1,
+			FileOwnershipHasFiles:     1,
+			FileOwnershipPackageFiles: 1,
+			FileOwnershipAnnotation:   1,
+			FileOwnershipFallback:     1,
+		},
+	}, index.stats)
+}
+
+func TestBuildPackageFileIndexWithoutFallback(t *testing.T) {
+	index := buildPackageFileIndex(fileIndexDocumentMock(), nil, false)
+	assert.NotContains(t, index.packagesByFile, "/app/node_modules/source-info/package.json")
+	assert.Equal(t, 0, index.stats.PackagesFromFallback)
+	assert.Equal(t, 2, index.stats.PackagesWithoutFiles)
+}
+
+func TestFilterSBOMWithFileIndex(t *testing.T) {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instnaceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	data := CreateSBOMDataSPDXVersionV040(instanceID, afero.NewMemMapFs())
+	SBOMData := data.(*SBOMData)
+
+	var SBOMDataMock spdxv1beta1.SBOMSPDXv2p3
+	nginxSBOMPath := path.Join(utils.CurrentDir(), "..", "testdata", "nginx-spdx-format-mock.json")
+	bytes, err := os.ReadFile(nginxSBOMPath)
+	if err != nil {
+		t.Fatalf("fail to read SBOM file, err: %v", err)
+	}
+	err = json.Unmarshal(bytes, &SBOMDataMock.Spec.SPDX)
+	if err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	err = SBOMData.StoreSBOM(context.TODO(), &SBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to store SBOM file, err: %v", err)
+	}
+	assert.Equal(t, 2, SBOMData.FileIndexStats().Packages)
+	assert.Equal(t, 1, SBOMData.FileIndexStats().PackagesWithFiles)
+
+	for i := 0; i < 2; i++ {
+		err = SBOMData.FilterSBOM(context.TODO(), map[string]bool{
+			"/usr/share/adduser/adduser.conf": true,
+		})
+		if err != nil {
+			t.Fatalf("fail to filter SBOM, err: %v", err)
+		}
+	}
+	assert.False(t, SBOMData.IsNewRelevantSBOMDataExist())
+
+	// filtering the same files twice must not duplicate the filtered data
+	filteredData := SBOMData.GetFilterSBOMData().Spec.SPDX
+	if assert.Len(t, filteredData.Packages, 1) {
+		assert.Equal(t, spdxv1beta1.ElementID("Package-deb-adduser-3e9282034226b93f"), filteredData.Packages[0].PackageSPDXIdentifier)
+	}
+	assert.Len(t, filteredData.Files, 1)
+	for _, relationship := range filteredData.Relationships {
+		if relationship.Relationship == RelationshipContainType {
+			assert.Equal(t, filteredData.Files[0].FileSPDXIdentifier, relationship.RefB.ElementRefID)
+		}
+	}
+}
@@ -2,15 +2,21 @@ package sbom
 
 import (
 	"context"
+	"node-agent/pkg/storageclient"
 
 	"github.com/kubescape/k8s-interface/instanceidhandler"
 )
 
 type SBOMFormat interface {
-	GetFilterSBOMData() any
-	StoreSBOM(ctx context.Context, sbomData any) error
+	CreateFilteredSBOM(ctx context.Context, client storageclient.StorageClient) error
+	UpdateFilteredSBOM(ctx context.Context, client storageclient.StorageClient, key string) error
+	SetInstallPathMatching(enabled bool)
+	FetchSBOM(ctx context.Context, client storageclient.StorageClient, key string) error
 	ValidateSBOM(ctx context.Context) error
 	FilterSBOM(ctx context.Context, sbomFileRelevantMap map[string]bool) error
+	// RestoreRelevantPackages marks relevant the packages of a stored filtered SBOM by their SPDX identifier, they are
+	// added to the filtered SBOM by the next FilterSBOM
+	RestoreRelevantPackages(packageIDs map[string]bool)
 	IsNewRelevantSBOMDataExist() bool
 	IsSBOMAlreadyExist() bool
 	SetFilteredSBOMName(string)
@@ -0,0 +1,94 @@
+package sbom
+
+import (
+	"path"
+	"strings"
+
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+)
+
+// installPathRules returns, per package URL type, the install paths of a language package given one of its files
+// (usually the manifest). Paths ending with "/" are directories, every file under them belongs to the package.
+var installPathRules = map[string]func(packageName, fileName string) []string{
+	"pypi":  pythonInstallPaths,
+	"npm":   nodeInstallPaths,
+	"maven": javaInstallPaths,
+}
+
+// pythonInstallPaths maps <site-packages>/<name>-<version>.dist-info/METADATA (or .egg-info) to the module directory of the package
+func pythonInstallPaths(packageName, fileName string) []string {
+	metadataDir := path.Dir(fileName)
+	if !strings.HasSuffix(metadataDir, ".dist-info") && !strings.HasSuffix(metadataDir, ".egg-info") {
+		return nil
+	}
+	sitePackages := path.Dir(metadataDir)
+	if base := path.Base(sitePackages); base != "site-packages" && base != "dist-packages" {
+		return nil
+	}
+	module := strings.ToLower(strings.NewReplacer("-", "_", ".", "_").Replace(packageName))
+	return []string{
+		metadataDir + "/",
+		path.Join(sitePackages, module) + "/",
+		path.Join(sitePackages, module+".py"),
+	}
+}
+
+// nodeInstallPaths maps node_modules/<pkg>/package.json (or node_modules/@scope/pkg/package.json) to the package directory
+func nodeInstallPaths(_, fileName string) []string {
+	if path.Base(fileName) != "package.json" || !strings.Contains(fileName, "/node_modules/") {
+		return nil
+	}
+	return []string{path.Dir(fileName) + "/"}
+}
+
+// javaInstallPaths maps an archive to the directory it is exploded to, nested archives (<outer>:<inner>) are skipped
+func javaInstallPaths(_, fileName string) []string {
+	if strings.Contains(fileName, ":") {
+		return nil
+	}
+	switch ext := path.Ext(fileName); ext {
+	case ".jar", ".war", ".ear":
+		return []string{strings.TrimSuffix(fileName, ext) + "/"}
+	}
+	return nil
+}
+
+// installPaths returns the install paths of a package, purl is its package URL and fileName one of its files
+func installPaths(purl, packageName, fileName string) []string {
+	rule, ok := installPathRules[purlType(purl)]
+	if !ok {
+		return nil
+	}
+	return rule(packageName, fileName)
+}
+
+// purlType returns the type of a package URL, pkg:<type>/<namespace>/<name>@<version>
+func purlType(purl string) string {
+	purlType, _, found := strings.Cut(strings.TrimPrefix(purl, "pkg:"), "/")
+	if !found || !strings.HasPrefix(purl, "pkg:") {
+		return ""
+	}
+	return purlType
+}
+
+func spdxPackagePURL(spdxPackage *spdxv1beta1.Package) string {
+	for _, ref := range spdxPackage.PackageExternalReferences {
+		if ref != nil && ref.RefType == spdxv1beta1.TypePackageManagerPURL {
+			return ref.Locator
+		}
+	}
+	return ""
+}
+
+// lookupInstallDirectory returns the owners of the deepest install directory holding fileName
+func lookupInstallDirectory[T any](ownersByDirectory map[string][]T, fileName string) ([]T, bool) {
+	if len(ownersByDirectory) == 0 {
+		return nil, false
+	}
+	for dir := path.Dir(fileName); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if owners, ok := ownersByDirectory[dir]; ok {
+			return owners, true
+		}
+	}
+	return nil, false
+}
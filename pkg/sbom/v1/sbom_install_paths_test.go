@@ -0,0 +1,107 @@
+package sbom
+
+import (
+	"testing"
+
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallPaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		purl        string
+		packageName string
+		fileName    string
+		want        []string
+	}{
+		{
+			name:        "python dist-info",
+			purl:        "pkg:pypi/PyYAML@6.0",
+			packageName: "PyYAML",
+			fileName:    "/usr/local/lib/python3.10/site-packages/PyYAML-6.0.dist-info/METADATA",
+			want: []string{
+				"/usr/local/lib/python3.10/site-packages/PyYAML-6.0.dist-info/",
+				"/usr/local/lib/python3.10/site-packages/pyyaml/",
+				"/usr/local/lib/python3.10/site-packages/pyyaml.py",
+			},
+		},
+		{
+			name:        "python file outside of site-packages",
+			purl:        "pkg:pypi/six@1.16.0",
+			packageName: "six",
+			fileName:    "/app/six-1.16.0.dist-info/METADATA",
+		},
+		{
+			name:     "node scoped package",
+			purl:     "pkg:npm/%40babel/core@7.22.0",
+			fileName: "/app/node_modules/@babel/core/package.json",
+			want:     []string{"/app/node_modules/@babel/core/"},
+		},
+		{
+			name:     "node lock file",
+			purl:     "pkg:npm/express@4.18.2",
+			fileName: "/app/package-lock.json",
+		},
+		{
+			name:     "java archive",
+			purl:     "pkg:maven/org.slf4j/slf4j-api@2.0.7",
+			fileName: "/opt/app/lib/slf4j-api-2.0.7.jar",
+			want:     []string{"/opt/app/lib/slf4j-api-2.0.7/"},
+		},
+		{
+			name:     "java nested archive",
+			purl:     "pkg:maven/org.slf4j/slf4j-api@2.0.7",
+			fileName: "/opt/app/app.jar:BOOT-INF/lib/slf4j-api-2.0.7.jar",
+		},
+		{
+			name:     "os package",
+			purl:     "pkg:deb/debian/adduser@3.118",
+			fileName: "/var/lib/dpkg/status",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, installPaths(tt.purl, tt.packageName, tt.fileName))
+		})
+	}
+}
+
+func TestPackagesOfInstallDirectory(t *testing.T) {
+	document := &spdxv1beta1.Document{
+		Packages: []*spdxv1beta1.Package{
+			{
+				PackageName:           "express",
+				PackageSPDXIdentifier: "Package-npm-express",
+				PackageSourceInfo:     "acquired package info from installed node module manifest file: /app/node_modules/express/package.json",
+				PackageExternalReferences: []*spdxv1beta1.PackageExternalReference{
+					{RefType: spdxv1beta1.TypePackageManagerPURL, Locator: "pkg:npm/express@4.18.2"},
+				},
+			},
+			{
+				PackageName:           "debug",
+				PackageSPDXIdentifier: "Package-npm-debug",
+				PackageSourceInfo:     "acquired package info from installed node module manifest file: /app/node_modules/express/node_modules/debug/package.json",
+				PackageExternalReferences: []*spdxv1beta1.PackageExternalReference{
+					{RefType: spdxv1beta1.TypePackageManagerPURL, Locator: "pkg:npm/debug@2.6.9"},
+				},
+			},
+		},
+	}
+
+	index := buildPackageFileIndex(document, SourceInfoPackageFiles, false)
+	_, ok := index.packagesOf("/app/node_modules/express/lib/router/index.js")
+	assert.False(t, ok)
+
+	index = buildPackageFileIndex(document, SourceInfoPackageFiles, true)
+	packageIDs, ok := index.packagesOf("/app/node_modules/express/lib/router/index.js")
+	assert.True(t, ok)
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-npm-express"}, packageIDs)
+	// the deepest install directory wins
+	packageIDs, ok = index.packagesOf("/app/node_modules/express/node_modules/debug/src/index.js")
+	assert.True(t, ok)
+	assert.Equal(t, []spdxv1beta1.ElementID{"Package-npm-debug"}, packageIDs)
+	_, ok = index.packagesOf("/app/server.js")
+	assert.False(t, ok)
+	assert.Equal(t, 2, index.stats.OwnershipsBySource[FileOwnershipInstallPath])
+}
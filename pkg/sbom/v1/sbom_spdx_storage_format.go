@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"node-agent/pkg/storageclient"
 	"node-agent/pkg/utils"
+	"path"
 	"strings"
 	"sync"
 
@@ -53,24 +55,23 @@ var spdxDataDirPath string
 var sourceInfoRequiredPrefix []string
 
 type SBOMData struct {
-	sbomFs                                   afero.Fs
-	spdxDataPath                             string
-	filteredSpdxData                         spdxv1beta1.SBOMSPDXv2p3Filtered
-	relevantRealtimeFilesBySPDXIdentifier    sync.Map
-	relevantRealtimeFilesByPackageSourceInfo sync.Map
-	newRelevantData                          bool
-	alreadyExistSBOM                         bool
-	status                                   string
-	instanceID                               instanceidhandler.IInstanceID
+	sbomFs               afero.Fs
+	spdxDataPath         string
+	filteredSpdxData     spdxv1beta1.SBOMSPDXv2p3Filtered
+	fileIndex            *packageFileIndex
+	packageFilesFallback PackageFilesFallback
+	matchInstallPaths    bool
+	relevantFiles        sync.Map
+	relevantPackages     sync.Map
+	newRelevantData      bool
+	newRestoredPackages  bool
+	alreadyExistSBOM     bool
+	status               string
+	instanceID           instanceidhandler.IInstanceID
 }
 
 var _ SBOMFormat = (*SBOMData)(nil)
 
-type packageSourceInfoData struct {
-	exist                 bool
-	packageSPDXIdentifier []spdxv1beta1.ElementID
-}
-
 func init() {
 	sourceInfoPrefixData := []string{sourceInfoDotnet, sourceInfoNodeModule, sourceInfoPythonPackage, sourceInfoJava, sourceInfoGemFile, sourceInfoGoModule, sourceInfoRustCargo, sourceInfoPHPComposer, sourceInfoCabal, sourceInfoRebar, sourceInfoLinuxKernel, sourceInfoLinuxKernelModule, sourceInfoDefault}
 	sourceInfoRequiredPrefix = append(sourceInfoRequiredPrefix, sourceInfoPrefixData...)
@@ -80,15 +81,16 @@ func CreateSBOMDataSPDXVersionV040(instanceID instanceidhandler.IInstanceID, sbo
 	spdxDataDirPath = "/data/" + directorySBOM
 	_ = sbomFs.Mkdir(spdxDataDirPath, 0755)
 	return &SBOMData{
-		sbomFs:                                   sbomFs,
-		spdxDataPath:                             fmt.Sprintf("%s/%s", spdxDataDirPath, instanceID.GetHashed()),
-		filteredSpdxData:                         spdxv1beta1.SBOMSPDXv2p3Filtered{},
-		relevantRealtimeFilesBySPDXIdentifier:    sync.Map{},
-		relevantRealtimeFilesByPackageSourceInfo: sync.Map{},
-		newRelevantData:                          false,
-		alreadyExistSBOM:                         false,
-		instanceID:                               instanceID,
-		status:                                   "",
+		sbomFs:               sbomFs,
+		spdxDataPath:         fmt.Sprintf("%s/%s", spdxDataDirPath, instanceID.GetHashed()),
+		filteredSpdxData:     spdxv1beta1.SBOMSPDXv2p3Filtered{},
+		packageFilesFallback: SourceInfoPackageFiles,
+		relevantFiles:        sync.Map{},
+		relevantPackages:     sync.Map{},
+		newRelevantData:      false,
+		alreadyExistSBOM:     false,
+		instanceID:           instanceID,
+		status:               "",
 	}
 }
 
@@ -121,12 +123,40 @@ func parsedFilesBySourceInfo(packageSourceInfo string) []string {
 	return []string{}
 }
 
-func (sc *SBOMData) StoreSBOM(ctx context.Context, sbomData any) error {
+func (sc *SBOMData) FetchSBOM(ctx context.Context, client storageclient.StorageClient, key string) error {
+	SBOM, err := client.GetImageSBOM(ctx, key)
+	if err != nil {
+		return err
+	}
+	return sc.StoreSBOM(ctx, SBOM)
+}
+
+// FetchImageFilesSPDX reads the files of a complete SPDX image SBOM with their checksums, by absolute path
+func FetchImageFilesSPDX(ctx context.Context, client storageclient.StorageClient, key string) (map[string][]spdxv1beta1.Checksum, error) {
+	SBOM, err := client.GetImageSBOM(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if SBOM.GetAnnotations()[instanceidhandlerV1.StatusMetadataKey] == instanceidhandlerV1.Incomplete {
+		return nil, fmt.Errorf("SBOM %s: %w", key, SBOMIncomplete)
+	}
+	if len(SBOM.Spec.SPDX.Files) == 0 {
+		return nil, fmt.Errorf("SBOM %s has no files", key)
+	}
+	imageFiles := make(map[string][]spdxv1beta1.Checksum, len(SBOM.Spec.SPDX.Files))
+	for _, file := range SBOM.Spec.SPDX.Files {
+		if file != nil {
+			imageFiles[path.Join("/", file.FileName)] = file.Checksums
+		}
+	}
+	return imageFiles, nil
+}
+
+func (sc *SBOMData) StoreSBOM(ctx context.Context, spdxData *spdxv1beta1.SBOMSPDXv2p3) error {
 	ctx, span := otel.Tracer("").Start(ctx, "SBOMData.StoreSBOM")
 	defer span.End()
-	spdxData, ok := sbomData.(*spdxv1beta1.SBOMSPDXv2p3)
-	if !ok {
-		return fmt.Errorf("storage format: StoreSBOM: SBOM data format is not supported")
+	if spdxData == nil {
+		return fmt.Errorf("storage format: StoreSBOM: SBOM data is missing")
 	}
 
 	err := sc.saveSBOM(ctx, spdxData)
@@ -134,20 +164,17 @@ func (sc *SBOMData) StoreSBOM(ctx context.Context, sbomData any) error {
 		return err
 	}
 
-	for i := range spdxData.Spec.SPDX.Files {
-		sc.relevantRealtimeFilesBySPDXIdentifier.Store(spdxData.Spec.SPDX.Files[i].FileSPDXIdentifier, false)
-	}
-	for i := range spdxData.Spec.SPDX.Packages {
-		filesBySourceInfo := parsedFilesBySourceInfo(spdxData.Spec.SPDX.Packages[i].PackageSourceInfo)
-		for j := range filesBySourceInfo {
-			if data, _ := sc.relevantRealtimeFilesByPackageSourceInfo.Load(filesBySourceInfo[j]); data != nil {
-				packageData := data.(*packageSourceInfoData)
-				packageData.packageSPDXIdentifier = append(packageData.packageSPDXIdentifier, spdxData.Spec.SPDX.Packages[i].PackageSPDXIdentifier)
-			} else {
-				sc.relevantRealtimeFilesByPackageSourceInfo.Store(filesBySourceInfo[j], &packageSourceInfoData{exist: false, packageSPDXIdentifier: []spdxv1beta1.ElementID{spdxData.Spec.SPDX.Packages[i].PackageSPDXIdentifier}})
-			}
-		}
-	}
+	sc.fileIndex = buildPackageFileIndex(&spdxData.Spec.SPDX, sc.packageFilesFallback, sc.matchInstallPaths)
+	stats := sc.fileIndex.stats
+	logger.L().Debug("SBOM file index coverage",
+		helpers.String("instanceID", sc.instanceID.GetStringFormatted()),
+		helpers.Int("packages", stats.Packages),
+		helpers.Int("packagesWithFiles", stats.PackagesWithFiles),
+		helpers.Int("packagesFromFallback", stats.PackagesFromFallback),
+		helpers.Int("packagesWithoutFiles", stats.PackagesWithoutFiles),
+		helpers.Int("files", stats.Files),
+		helpers.Int("filesWithPackage", stats.FilesWithPackage),
+		helpers.Interface("ownershipsBySource", stats.OwnershipsBySource))
 
 	sc.filteredSpdxData.Spec = spdxData.Spec
 	sc.filteredSpdxData.Status = spdxData.Status
@@ -195,76 +222,113 @@ func (sc *SBOMData) FilterSBOM(ctx context.Context, sbomFileRelevantMap map[stri
 		return nil
 	}
 	sc.newRelevantData = false
+	if sc.fileIndex == nil {
+		return nil
+	}
+	sc.newRelevantData, sc.newRestoredPackages = sc.newRestoredPackages, false
+
+	for realtimeFileName := range sbomFileRelevantMap {
+		packageIDs, owned := sc.fileIndex.packagesOf(realtimeFileName)
+		_, listed := sc.fileIndex.fileIDsByName[realtimeFileName]
+		if !owned && !listed {
+			continue
+		}
+		if _, alreadyRelevant := sc.relevantFiles.LoadOrStore(realtimeFileName, true); alreadyRelevant {
+			continue
+		}
+		for i := range packageIDs {
+			sc.relevantPackages.Store(packageIDs[i], true)
+		}
+		sc.newRelevantData = true
+	}
+	if !sc.newRelevantData {
+		return nil
+	}
 
 	spdxData, err := sc.getSBOMDataSPDXFormat(ctx)
 	if err != nil {
 		return err
 	}
+	sc.filterDocument(&spdxData.Spec.SPDX)
 
-	//filter relevant file list
-	for i := range spdxData.Spec.SPDX.Files {
-		if exist := sbomFileRelevantMap[spdxData.Spec.SPDX.Files[i].FileName]; exist {
-			if data, _ := sc.relevantRealtimeFilesBySPDXIdentifier.Load(spdxData.Spec.SPDX.Files[i].FileSPDXIdentifier); data != nil && !data.(bool) {
-				sc.filteredSpdxData.Spec.SPDX.Files = append(sc.filteredSpdxData.Spec.SPDX.Files, spdxData.Spec.SPDX.Files[i])
-				sc.relevantRealtimeFilesBySPDXIdentifier.Store(spdxData.Spec.SPDX.Files[i].FileSPDXIdentifier, true)
-				sc.newRelevantData = true
-			}
+	return nil
+}
+
+// filterDocument rebuilds the files, packages and relationships of the filtered SBOM from the relevant files and packages
+func (sc *SBOMData) filterDocument(spdxData *spdxv1beta1.Document) {
+	filteredData := &sc.filteredSpdxData.Spec.SPDX
+	filteredData.Files = make([]*spdxv1beta1.File, 0)
+	filteredData.Packages = make([]*spdxv1beta1.Package, 0)
+	filteredData.Relationships = make([]*spdxv1beta1.Relationship, 0)
+
+	relevantElements := map[spdxv1beta1.ElementID]bool{
+		spdxData.SPDXIdentifier: true,
+	}
+	for i := range spdxData.Files {
+		if _, ok := sc.relevantFiles.Load(spdxData.Files[i].FileName); ok {
+			filteredData.Files = append(filteredData.Files, spdxData.Files[i])
+			relevantElements[spdxData.Files[i].FileSPDXIdentifier] = true
 		}
 	}
-
-	//filter relevant file list from package source Info
-	relevantPackageFromSourceInfoMap := make(map[spdxv1beta1.ElementID]bool)
-	for realtimeFileName := range sbomFileRelevantMap {
-		if data, _ := sc.relevantRealtimeFilesByPackageSourceInfo.Load(realtimeFileName); data != nil && !data.(*packageSourceInfoData).exist {
-			packageData := data.(*packageSourceInfoData)
-			packageData.exist = true
-			for i := range packageData.packageSPDXIdentifier {
-				relevantPackageFromSourceInfoMap[packageData.packageSPDXIdentifier[i]] = true
-			}
-			sc.newRelevantData = true
+	for i := range spdxData.Packages {
+		packageID := spdxData.Packages[i].PackageSPDXIdentifier
+		if _, ok := sc.relevantPackages.Load(packageID); ok && !relevantElements[packageID] {
+			filteredData.Packages = append(filteredData.Packages, spdxData.Packages[i])
+			relevantElements[packageID] = true
 		}
 	}
-
-	//filter relationship list
-	for i := range spdxData.Spec.SPDX.Relationships {
-		switch spdxData.Spec.SPDX.Relationships[i].Relationship {
-		case RelationshipContainType:
-			if data, _ := sc.relevantRealtimeFilesBySPDXIdentifier.Load(spdxData.Spec.SPDX.Relationships[i].RefB.ElementRefID); data != nil && data.(bool) {
-				sc.filteredSpdxData.Spec.SPDX.Relationships = append(sc.filteredSpdxData.Spec.SPDX.Relationships, spdxData.Spec.SPDX.Relationships[i])
-			}
-			if exist := relevantPackageFromSourceInfoMap[spdxData.Spec.SPDX.Relationships[i].RefA.ElementRefID]; exist {
-				sc.filteredSpdxData.Spec.SPDX.Relationships = append(sc.filteredSpdxData.Spec.SPDX.Relationships, spdxData.Spec.SPDX.Relationships[i])
-			}
-		default:
-			sc.filteredSpdxData.Spec.SPDX.Relationships = append(sc.filteredSpdxData.Spec.SPDX.Relationships, spdxData.Spec.SPDX.Relationships[i])
+	isRelevant := func(element spdxv1beta1.DocElementID) bool {
+		// special values (NONE, NOASSERTION) and elements of other documents are kept as is
+		return element.ElementRefID == "" || element.DocumentRefID != "" || relevantElements[element.ElementRefID]
+	}
+	for i := range spdxData.Relationships {
+		if isRelevant(spdxData.Relationships[i].RefA) && isRelevant(spdxData.Relationships[i].RefB) {
+			filteredData.Relationships = append(filteredData.Relationships, spdxData.Relationships[i])
 		}
 	}
+}
 
-	//filter relevant package list
-	for i := range spdxData.Spec.SPDX.Packages {
-		relevantPackageMap := make(map[spdxv1beta1.DocElementID]bool)
-		for j := range sc.filteredSpdxData.Spec.SPDX.Relationships {
-			switch sc.filteredSpdxData.Spec.SPDX.Relationships[j].Relationship {
-			case RelationshipContainType:
-				if alreadyExist := relevantPackageMap[sc.filteredSpdxData.Spec.SPDX.Relationships[j].RefA]; !alreadyExist {
-					if sc.filteredSpdxData.Spec.SPDX.Relationships[j].RefA.ElementRefID == spdxData.Spec.SPDX.Packages[i].PackageSPDXIdentifier {
-						sc.filteredSpdxData.Spec.SPDX.Packages = append(sc.filteredSpdxData.Spec.SPDX.Packages, spdxData.Spec.SPDX.Packages[i])
-					}
-				}
-			}
-		}
-		if exist := relevantPackageFromSourceInfoMap[spdxData.Spec.SPDX.Packages[i].PackageSPDXIdentifier]; exist {
-			sc.filteredSpdxData.Spec.SPDX.Packages = append(sc.filteredSpdxData.Spec.SPDX.Packages, spdxData.Spec.SPDX.Packages[i])
+// SetPackageFilesFallback replaces the fallback used for packages without structured file ownership, nil disables it
+func (sc *SBOMData) SetPackageFilesFallback(fallback PackageFilesFallback) {
+	sc.packageFilesFallback = fallback
+}
+
+// RestoreRelevantPackages keeps the packages of the stored filtered SBOM, the packages made relevant by an install
+// directory or a source info file are not listed with their files
+func (sc *SBOMData) RestoreRelevantPackages(packageIDs map[string]bool) {
+	for packageID := range packageIDs {
+		if _, alreadyRelevant := sc.relevantPackages.LoadOrStore(spdxv1beta1.ElementID(packageID), true); !alreadyRelevant {
+			sc.newRestoredPackages = true
 		}
 	}
+}
 
-	return nil
+// SetInstallPathMatching makes a language package relevant when any file under its install directory is accessed,
+// it must be called before the SBOM is stored
+func (sc *SBOMData) SetInstallPathMatching(enabled bool) {
+	sc.matchInstallPaths = enabled
 }
 
-func (sc *SBOMData) GetFilterSBOMData() any {
+// FileIndexStats returns the coverage of the file index of the last stored SBOM
+func (sc *SBOMData) FileIndexStats() FileIndexStats {
+	if sc.fileIndex == nil {
+		return FileIndexStats{}
+	}
+	return sc.fileIndex.stats
+}
+
+func (sc *SBOMData) GetFilterSBOMData() *spdxv1beta1.SBOMSPDXv2p3Filtered {
 	return &sc.filteredSpdxData
 }
 
+func (sc *SBOMData) CreateFilteredSBOM(ctx context.Context, client storageclient.StorageClient) error {
+	return client.CreateFilteredSBOM(ctx, &sc.filteredSpdxData)
+}
+
+func (sc *SBOMData) UpdateFilteredSBOM(ctx context.Context, client storageclient.StorageClient, key string) error {
+	return client.UpdateFilteredSBOM(ctx, key, &sc.filteredSpdxData)
+}
+
 func (sc *SBOMData) IsNewRelevantSBOMDataExist() bool {
 	return sc.newRelevantData
 }
@@ -339,5 +403,7 @@ func (sc *SBOMData) ValidateSBOM(ctx context.Context) error {
 			return SBOMIncomplete
 		}
 	}
+	// the SBOM fetched again once it is regenerated complete is filtered
+	sc.status = ""
 	return nil
 }
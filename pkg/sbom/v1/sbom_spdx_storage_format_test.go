@@ -17,10 +17,6 @@ const (
 	instnaceIDMock = "apiVersion-v1/namespace-aaa/kind-deployment/name-redis/containerName-redis"
 )
 
-type notSPDXFormatSBOMData struct {
-	Data int `json:"aaa"`
-}
-
 func TestStoreLabels(t *testing.T) {
 	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instnaceIDMock)
 	if err != nil {
@@ -193,17 +189,7 @@ func TestStoreSBOM(t *testing.T) {
 		t.Fatalf("fail to store SBOM file, err: %v", err)
 	}
 
-	var notSPDXFormatSBOMDataMock notSPDXFormatSBOMData
-	nginxSBOMPath = path.Join(utils.CurrentDir(), "..", "testdata", "not-spdx-format.json")
-	bytes, err = os.ReadFile(nginxSBOMPath)
-	if err != nil {
-		t.Fatalf("fail to read SBOM file, err: %v", err)
-	}
-	err = json.Unmarshal(bytes, &notSPDXFormatSBOMDataMock)
-	if err != nil {
-		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
-	}
-	err = SBOMData.StoreSBOM(context.TODO(), &notSPDXFormatSBOMDataMock)
+	err = SBOMData.StoreSBOM(context.TODO(), nil)
 	if err == nil {
 		t.Fatalf("StoreSBOM should fail")
 	}
@@ -526,4 +512,25 @@ func TestSBOMIncomplete(t *testing.T) {
 	if SBOMData.status != instanceidhandlerV1.Incomplete {
 		t.Fatalf("SBOM status should be in complete")
 	}
+
+	// the SBOM is regenerated complete
+	var completeSBOMDataMock spdxv1beta1.SBOMSPDXv2p3
+	bytes, err = os.ReadFile(path.Join(utils.CurrentDir(), "..", "testdata", "nginx-spdx-format-mock.json"))
+	if err != nil {
+		t.Fatalf("fail to read SBOM file, err: %v", err)
+	}
+	err = json.Unmarshal(bytes, &completeSBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	err = SBOMData.StoreSBOM(context.TODO(), &completeSBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to store SBOM file, err: %v", err)
+	}
+	if err = SBOMData.ValidateSBOM(context.TODO()); err != nil {
+		t.Fatalf("SBOM should be complete, err: %v", err)
+	}
+	if SBOMData.status != "" {
+		t.Fatalf("SBOM status should be reset")
+	}
 }
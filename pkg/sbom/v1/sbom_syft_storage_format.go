@@ -0,0 +1,531 @@
+package sbom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"node-agent/pkg/storageclient"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	syftRelationshipContains     = "contains"
+	syftRelationshipDependencyOf = "dependency-of"
+	syftDocumentFileExtension    = ".json"
+
+	spdxVersion                  = "SPDX-2.3"
+	spdxDataLicense              = "CC0-1.0"
+	spdxDocumentIdentifier       = "DOCUMENT"
+	spdxCategorySecurity         = "SECURITY"
+	spdxCategoryPackageManager   = "PACKAGE-MANAGER"
+	syftDocumentNamespacePattern = "https://kubescape.io/node-agent/syft/%s/%s-%s"
+)
+
+var spdxIdentifierInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// SBOMDataSyft reads the image SBOM in the Syft JSON format. A package is relevant when a file listed in its
+// locations, or a file it contains according to the artifact relationships, was accessed.
+// The filtered SBOM is written in the SPDX format, like SBOMData does.
+type SBOMDataSyft struct {
+	*SBOMData
+	inputFs               afero.Fs
+	inputDirectory        string
+	packageIDsByFile      map[string][]string
+	packageIDsByDirectory map[string][]string
+	relevantFiles         map[string]bool
+	relevantPackages      map[string]bool
+	// packageIDsBySPDXIdentifier maps the packages of the filtered SBOM back to the Syft packages
+	packageIDsBySPDXIdentifier map[spdxv1beta1.ElementID]string
+}
+
+var _ SBOMFormat = (*SBOMDataSyft)(nil)
+
+// CreateSBOMDataSyft creates a Syft SBOM format, the Syft documents are read from inputDirectory when it is set and from the storage otherwise
+func CreateSBOMDataSyft(instanceID instanceidhandler.IInstanceID, sbomFs afero.Fs, inputDirectory string) SBOMFormat {
+	return &SBOMDataSyft{
+		SBOMData:                   CreateSBOMDataSPDXVersionV040(instanceID, sbomFs).(*SBOMData),
+		inputFs:                    afero.NewOsFs(),
+		inputDirectory:             inputDirectory,
+		packageIDsByFile:           make(map[string][]string),
+		packageIDsByDirectory:      make(map[string][]string),
+		relevantFiles:              make(map[string]bool),
+		relevantPackages:           make(map[string]bool),
+		packageIDsBySPDXIdentifier: make(map[spdxv1beta1.ElementID]string),
+	}
+}
+
+func (sc *SBOMDataSyft) FetchSBOM(ctx context.Context, client storageclient.StorageClient, key string) error {
+	if sc.inputDirectory == "" {
+		SBOM, err := client.GetImageSBOMSyft(ctx, key)
+		if err != nil {
+			return err
+		}
+		return sc.StoreSBOM(ctx, SBOM)
+	}
+	SBOM, err := sc.readSBOMFromDirectory(key)
+	if err != nil {
+		return err
+	}
+	return sc.StoreSBOM(ctx, SBOM)
+}
+
+// FetchImageFilesSyft reads the files of a complete Syft image SBOM with their checksums, by path and by access path.
+// The document is read from inputDirectory when it is set and from the storage otherwise, like FetchSBOM does.
+func FetchImageFilesSyft(ctx context.Context, client storageclient.StorageClient, inputDirectory, key string) (map[string][]spdxv1beta1.Checksum, error) {
+	var SBOM *storageclient.SBOMSyft
+	var err error
+	if inputDirectory == "" {
+		SBOM, err = client.GetImageSBOMSyft(ctx, key)
+	} else {
+		SBOM, err = (&SBOMDataSyft{inputFs: afero.NewOsFs(), inputDirectory: inputDirectory}).readSBOMFromDirectory(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if SBOM.GetAnnotations()[instanceidhandlerV1.StatusMetadataKey] == instanceidhandlerV1.Incomplete {
+		return nil, fmt.Errorf("Syft SBOM %s: %w", key, SBOMIncomplete)
+	}
+	if len(SBOM.Spec.Files) == 0 {
+		return nil, fmt.Errorf("Syft SBOM %s has no files", key)
+	}
+	imageFiles := make(map[string][]spdxv1beta1.Checksum, len(SBOM.Spec.Files))
+	for i := range SBOM.Spec.Files {
+		checksums := convertSyftFile("", &SBOM.Spec.Files[i]).Checksums
+		for _, filePath := range []string{SBOM.Spec.Files[i].Location.Path, SBOM.Spec.Files[i].Location.AccessPath} {
+			if filePath != "" {
+				imageFiles[filePath] = checksums
+			}
+		}
+	}
+	return imageFiles, nil
+}
+
+func (sc *SBOMDataSyft) readSBOMFromDirectory(key string) (*storageclient.SBOMSyft, error) {
+	SBOMPath := filepath.Join(sc.inputDirectory, key+syftDocumentFileExtension)
+	bytes, err := afero.ReadFile(sc.inputFs, SBOMPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Syft SBOM %s: %w", SBOMPath, storageclient.ErrNotFound)
+		}
+		return nil, err
+	}
+	SBOM := &storageclient.SBOMSyft{}
+	if err := json.Unmarshal(bytes, &SBOM.Spec); err != nil {
+		return nil, fmt.Errorf("failed to decode Syft SBOM %s: %v", SBOMPath, err)
+	}
+	SBOM.SetName(key)
+	return SBOM, nil
+}
+
+func (sc *SBOMDataSyft) StoreSBOM(ctx context.Context, syftData *storageclient.SBOMSyft) error {
+	ctx, span := otel.Tracer("").Start(ctx, "SBOMDataSyft.StoreSBOM")
+	defer span.End()
+	if syftData == nil {
+		return fmt.Errorf("storage format: StoreSBOM: SBOM data is missing")
+	}
+
+	logger.L().Debug("saving SBOM", helpers.String("path", sc.spdxDataPath))
+	data, err := json.Marshal(syftData)
+	if err != nil {
+		return err
+	}
+	err = afero.WriteFile(sc.sbomFs, sc.spdxDataPath, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	// the index and the relevant files of a previous document refer to packages that may be gone
+	sc.packageIDsByFile = make(map[string][]string)
+	sc.packageIDsByDirectory = make(map[string][]string)
+	sc.relevantFiles = make(map[string]bool)
+	sc.relevantPackages = make(map[string]bool)
+	sc.packageIDsBySPDXIdentifier = make(map[spdxv1beta1.ElementID]string)
+	sc.newRestoredPackages = false
+	sc.indexPackageFiles(&syftData.Spec)
+
+	sc.filteredSpdxData.ObjectMeta = metav1.ObjectMeta{}
+	sc.filteredSpdxData.Spec = spdxv1beta1.SBOMSPDXv2p3Spec{
+		Metadata: spdxv1beta1.SPDXMeta{
+			Tool: spdxv1beta1.ToolMeta{
+				Name:    syftData.Spec.Descriptor.Name,
+				Version: syftData.Spec.Descriptor.Version,
+			},
+			Report: spdxv1beta1.ReportMeta{
+				CreatedAt: metav1.Now(),
+			},
+		},
+		SPDX: spdxv1beta1.Document{
+			SPDXVersion:       spdxVersion,
+			DataLicense:       spdxDataLicense,
+			SPDXIdentifier:    spdxDocumentIdentifier,
+			DocumentName:      syftDocumentName(syftData),
+			DocumentNamespace: fmt.Sprintf(syftDocumentNamespacePattern, syftData.Spec.Source.Type, syftDocumentName(syftData), syftData.Spec.Source.ID),
+			CreationInfo: &spdxv1beta1.CreationInfo{
+				Created: time.Now().UTC().Format(time.RFC3339),
+				Creators: []spdxv1beta1.Creator{
+					{
+						CreatorType: Tool,
+						Creator:     fmt.Sprintf("%s-%s", syftData.Spec.Descriptor.Name, syftData.Spec.Descriptor.Version),
+					},
+					{
+						CreatorType: Organization,
+						Creator:     KubescapeOrganizationName,
+					},
+					{
+						CreatorType: Tool,
+						Creator:     KubescapeNodeAgentName,
+					},
+				},
+			},
+			Files:         make([]*spdxv1beta1.File, 0),
+			Packages:      make([]*spdxv1beta1.Package, 0),
+			Relationships: make([]*spdxv1beta1.Relationship, 0),
+		},
+	}
+	sc.alreadyExistSBOM = true
+
+	return nil
+}
+
+// indexPackageFiles maps every file path (real and access path) to the packages owning it
+func (sc *SBOMDataSyft) indexPackageFiles(syftData *storageclient.SyftDocument) {
+	addFile := func(location storageclient.SyftLocation, packageID string) {
+		for _, filePath := range []string{location.Path, location.AccessPath} {
+			if filePath != "" {
+				sc.packageIDsByFile[filePath] = appendUnique(sc.packageIDsByFile[filePath], packageID)
+			}
+		}
+	}
+
+	packageIDs := make(map[string]bool, len(syftData.Artifacts))
+	for i := range syftData.Artifacts {
+		packageIDs[syftData.Artifacts[i].ID] = true
+		sc.packageIDsBySPDXIdentifier[syftPackageIdentifier(&syftData.Artifacts[i])] = syftData.Artifacts[i].ID
+		for j := range syftData.Artifacts[i].Locations {
+			addFile(syftData.Artifacts[i].Locations[j], syftData.Artifacts[i].ID)
+		}
+	}
+
+	filesByID := make(map[string]storageclient.SyftLocation, len(syftData.Files))
+	for i := range syftData.Files {
+		filesByID[syftData.Files[i].ID] = syftData.Files[i].Location
+	}
+	for i := range syftData.ArtifactRelationships {
+		relationship := syftData.ArtifactRelationships[i]
+		if relationship.Type != syftRelationshipContains || !packageIDs[relationship.Parent] {
+			continue
+		}
+		if location, ok := filesByID[relationship.Child]; ok {
+			addFile(location, relationship.Parent)
+		}
+	}
+
+	if sc.matchInstallPaths {
+		sc.indexInstallPaths(syftData)
+	}
+}
+
+// indexInstallPaths indexes the install directories of the language packages, see installPathRules
+func (sc *SBOMDataSyft) indexInstallPaths(syftData *storageclient.SyftDocument) {
+	filesByPackage := make(map[string][]string)
+	for fileName, packageIDs := range sc.packageIDsByFile {
+		for _, packageID := range packageIDs {
+			filesByPackage[packageID] = append(filesByPackage[packageID], fileName)
+		}
+	}
+	for i := range syftData.Artifacts {
+		syftPackage := &syftData.Artifacts[i]
+		for _, fileName := range filesByPackage[syftPackage.ID] {
+			for _, installPath := range installPaths(syftPackage.PURL, syftPackage.Name, fileName) {
+				if strings.HasSuffix(installPath, "/") {
+					installPath = strings.TrimSuffix(installPath, "/")
+					sc.packageIDsByDirectory[installPath] = appendUnique(sc.packageIDsByDirectory[installPath], syftPackage.ID)
+				} else {
+					sc.packageIDsByFile[installPath] = appendUnique(sc.packageIDsByFile[installPath], syftPackage.ID)
+				}
+			}
+		}
+	}
+}
+
+func (sc *SBOMDataSyft) getSBOMDataSyftFormat() (*storageclient.SBOMSyft, error) {
+	bytes, err := afero.ReadFile(sc.sbomFs, sc.spdxDataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	syftData := storageclient.SBOMSyft{}
+	err = json.Unmarshal(bytes, &syftData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syftData, nil
+}
+
+func (sc *SBOMDataSyft) FilterSBOM(ctx context.Context, sbomFileRelevantMap map[string]bool) error {
+
+	if sc.status == instanceidhandlerV1.Incomplete {
+		return nil
+	}
+	sc.newRelevantData, sc.newRestoredPackages = sc.newRestoredPackages, false
+
+	for realtimeFileName := range sbomFileRelevantMap {
+		if sc.relevantFiles[realtimeFileName] {
+			continue
+		}
+		packageIDs, ok := sc.packageIDsByFile[realtimeFileName]
+		if !ok {
+			packageIDs, ok = lookupInstallDirectory(sc.packageIDsByDirectory, realtimeFileName)
+		}
+		if !ok {
+			continue
+		}
+		sc.relevantFiles[realtimeFileName] = true
+		for i := range packageIDs {
+			sc.relevantPackages[packageIDs[i]] = true
+		}
+		sc.newRelevantData = true
+	}
+	if !sc.newRelevantData {
+		return nil
+	}
+
+	syftData, err := sc.getSBOMDataSyftFormat()
+	if err != nil {
+		return err
+	}
+	sc.filterSyftDocument(&syftData.Spec)
+
+	return nil
+}
+
+// filterSyftDocument rebuilds the SPDX packages, files and relationships of the filtered SBOM from the relevant Syft packages
+func (sc *SBOMDataSyft) filterSyftDocument(syftData *storageclient.SyftDocument) {
+	spdxData := &sc.filteredSpdxData.Spec.SPDX
+	spdxData.Packages = make([]*spdxv1beta1.Package, 0)
+	spdxData.Files = make([]*spdxv1beta1.File, 0)
+	spdxData.Relationships = make([]*spdxv1beta1.Relationship, 0)
+
+	packageIdentifiers := make(map[string]spdxv1beta1.ElementID)
+	for i := range syftData.Artifacts {
+		if !sc.relevantPackages[syftData.Artifacts[i].ID] {
+			continue
+		}
+		spdxPackage := convertSyftPackage(&syftData.Artifacts[i])
+		packageIdentifiers[syftData.Artifacts[i].ID] = spdxPackage.PackageSPDXIdentifier
+		spdxData.Packages = append(spdxData.Packages, spdxPackage)
+	}
+
+	// only the accessed files that Syft catalogued are listed, the other accessed files are package locations
+	filesByPath := make(map[string]*storageclient.SyftFile, len(syftData.Files))
+	for i := range syftData.Files {
+		filesByPath[syftData.Files[i].Location.Path] = &syftData.Files[i]
+		if syftData.Files[i].Location.AccessPath != "" {
+			filesByPath[syftData.Files[i].Location.AccessPath] = &syftData.Files[i]
+		}
+	}
+	relevantFiles := make([]string, 0, len(sc.relevantFiles))
+	for fileName := range sc.relevantFiles {
+		relevantFiles = append(relevantFiles, fileName)
+	}
+	sort.Strings(relevantFiles)
+	for _, fileName := range relevantFiles {
+		syftFile, ok := filesByPath[fileName]
+		if !ok {
+			continue
+		}
+		spdxFile := convertSyftFile(fileName, syftFile)
+		spdxData.Files = append(spdxData.Files, spdxFile)
+		for _, packageID := range sc.packageIDsByFile[fileName] {
+			spdxData.Relationships = append(spdxData.Relationships, &spdxv1beta1.Relationship{
+				RefA:         spdxv1beta1.DocElementID{ElementRefID: packageIdentifiers[packageID]},
+				RefB:         spdxv1beta1.DocElementID{ElementRefID: spdxFile.FileSPDXIdentifier},
+				Relationship: RelationshipContainType,
+			})
+		}
+	}
+
+	for i := range syftData.ArtifactRelationships {
+		relationship := syftData.ArtifactRelationships[i]
+		if relationship.Type != syftRelationshipDependencyOf {
+			continue
+		}
+		parent, parentOK := packageIdentifiers[relationship.Parent]
+		child, childOK := packageIdentifiers[relationship.Child]
+		if parentOK && childOK {
+			spdxData.Relationships = append(spdxData.Relationships, &spdxv1beta1.Relationship{
+				RefA:         spdxv1beta1.DocElementID{ElementRefID: parent},
+				RefB:         spdxv1beta1.DocElementID{ElementRefID: child},
+				Relationship: RelationshipDependencyOfType,
+			})
+		}
+	}
+}
+
+func (sc *SBOMDataSyft) ValidateSBOM(ctx context.Context) error {
+	ctx, span := otel.Tracer("").Start(ctx, "SBOMDataSyft.ValidateSBOM")
+	defer span.End()
+	sbom, err := sc.getSBOMDataSyftFormat()
+	if err != nil {
+		logger.L().Debug("fail to validate SBOM", helpers.String("file name", sc.spdxDataPath), helpers.Error(err))
+		return nil
+	}
+	if sbom.GetAnnotations()[instanceidhandlerV1.StatusMetadataKey] == instanceidhandlerV1.Incomplete {
+		sc.status = instanceidhandlerV1.Incomplete
+		return SBOMIncomplete
+	}
+	// the SBOM fetched again once it is regenerated complete is filtered
+	sc.status = ""
+	return nil
+}
+
+func syftDocumentName(syftData *storageclient.SBOMSyft) string {
+	if syftData.Spec.Source.Name != "" {
+		return syftData.Spec.Source.Name
+	}
+	return syftData.Name
+}
+
+// RestoreRelevantPackages keeps the packages of the stored filtered SBOM, the packages made relevant by an install
+// directory are not listed with their files
+func (sc *SBOMDataSyft) RestoreRelevantPackages(packageIDs map[string]bool) {
+	for identifier := range packageIDs {
+		packageID, ok := sc.packageIDsBySPDXIdentifier[spdxv1beta1.ElementID(identifier)]
+		if ok && !sc.relevantPackages[packageID] {
+			sc.relevantPackages[packageID] = true
+			sc.newRestoredPackages = true
+		}
+	}
+}
+
+func syftPackageIdentifier(syftPackage *storageclient.SyftPackage) spdxv1beta1.ElementID {
+	return spdxv1beta1.ElementID(spdxIdentifierInvalidChars.ReplaceAllString(fmt.Sprintf("Package-%s-%s-%s", syftPackage.Type, syftPackage.Name, syftPackage.ID), "-"))
+}
+
+func convertSyftPackage(syftPackage *storageclient.SyftPackage) *spdxv1beta1.Package {
+	locations := make([]string, 0, len(syftPackage.Locations))
+	for i := range syftPackage.Locations {
+		locations = append(locations, syftPackage.Locations[i].Path)
+	}
+	license := strings.Join(syftLicenses(syftPackage.Licenses), " AND ")
+	if license == "" {
+		license = spdxNoAssertion
+	}
+
+	spdxPackage := &spdxv1beta1.Package{
+		PackageName:             syftPackage.Name,
+		PackageSPDXIdentifier:   syftPackageIdentifier(syftPackage),
+		PackageVersion:          syftPackage.Version,
+		PackageDownloadLocation: spdxNoAssertion,
+		PackageSourceInfo:       fmt.Sprintf("%s: %s", sourceInfoDefault, strings.Join(locations, ", ")),
+		PackageLicenseConcluded: license,
+		PackageLicenseDeclared:  license,
+		PackageCopyrightText:    spdxNoAssertion,
+	}
+	for _, cpe := range syftCPEs(syftPackage.CPEs) {
+		spdxPackage.PackageExternalReferences = append(spdxPackage.PackageExternalReferences, &spdxv1beta1.PackageExternalReference{
+			Category: spdxCategorySecurity,
+			RefType:  spdxv1beta1.TypeSecurityCPE23Type,
+			Locator:  cpe,
+		})
+	}
+	if syftPackage.PURL != "" {
+		spdxPackage.PackageExternalReferences = append(spdxPackage.PackageExternalReferences, &spdxv1beta1.PackageExternalReference{
+			Category: spdxCategoryPackageManager,
+			RefType:  spdxv1beta1.TypePackageManagerPURL,
+			Locator:  syftPackage.PURL,
+		})
+	}
+	return spdxPackage
+}
+
+func convertSyftFile(fileName string, syftFile *storageclient.SyftFile) *spdxv1beta1.File {
+	spdxFile := &spdxv1beta1.File{
+		FileName:           fileName,
+		FileSPDXIdentifier: spdxv1beta1.ElementID(spdxIdentifierInvalidChars.ReplaceAllString(syftFile.ID, "-")),
+		LicenseConcluded:   spdxNoAssertion,
+	}
+	if syftFile.Location.LayerID != "" {
+		spdxFile.FileComment = fmt.Sprintf("layerID: %s", syftFile.Location.LayerID)
+	}
+	for i := range syftFile.Digests {
+		spdxFile.Checksums = append(spdxFile.Checksums, spdxv1beta1.Checksum{
+			Algorithm: spdxv1beta1.ChecksumAlgorithm(strings.ToUpper(syftFile.Digests[i].Algorithm)),
+			Value:     syftFile.Digests[i].Value,
+		})
+	}
+	return spdxFile
+}
+
+// syftLicenses reads both the old (list of strings) and the new (list of objects) Syft license schemas
+func syftLicenses(data json.RawMessage) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		return names
+	}
+	names = nil
+	var licenses []struct {
+		Value          string `json:"value"`
+		SPDXExpression string `json:"spdxExpression"`
+	}
+	if err := json.Unmarshal(data, &licenses); err != nil {
+		return nil
+	}
+	for i := range licenses {
+		if licenses[i].SPDXExpression != "" {
+			names = append(names, licenses[i].SPDXExpression)
+		} else if licenses[i].Value != "" {
+			names = append(names, licenses[i].Value)
+		}
+	}
+	return names
+}
+
+// syftCPEs reads both the old (list of strings) and the new (list of objects) Syft CPE schemas
+func syftCPEs(data json.RawMessage) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	var cpes []string
+	if err := json.Unmarshal(data, &cpes); err == nil {
+		return cpes
+	}
+	cpes = nil
+	var cpeObjects []struct {
+		CPE string `json:"cpe"`
+	}
+	if err := json.Unmarshal(data, &cpeObjects); err != nil {
+		return nil
+	}
+	for i := range cpeObjects {
+		cpes = append(cpes, cpeObjects[i].CPE)
+	}
+	return cpes
+}
+
+func appendUnique(list []string, value string) []string {
+	for i := range list {
+		if list[i] == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
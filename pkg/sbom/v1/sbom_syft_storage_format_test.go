@@ -0,0 +1,274 @@
+package sbom
+
+import (
+	"context"
+	"encoding/json"
+	"node-agent/pkg/storageclient"
+	"node-agent/pkg/utils"
+	"os"
+	"path"
+	"testing"
+
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func readSyftSBOMMock(t *testing.T) []byte {
+	bytes, err := os.ReadFile(path.Join(utils.CurrentDir(), "..", "testdata", "nginx-syft-format-mock.json"))
+	if err != nil {
+		t.Fatalf("fail to read SBOM file, err: %v", err)
+	}
+	return bytes
+}
+
+func createSyftSBOMDataMock(t *testing.T) *SBOMDataSyft {
+	return createSyftSBOMDataMockMatching(t, false)
+}
+
+func createSyftSBOMDataMockMatching(t *testing.T, matchInstallPaths bool) *SBOMDataSyft {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instnaceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	SBOMData := CreateSBOMDataSyft(instanceID, afero.NewMemMapFs(), "").(*SBOMDataSyft)
+	SBOMData.SetInstallPathMatching(matchInstallPaths)
+
+	var SBOMDataMock storageclient.SBOMSyft
+	err = json.Unmarshal(readSyftSBOMMock(t), &SBOMDataMock.Spec)
+	if err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	err = SBOMData.StoreSBOM(context.TODO(), &SBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to store SBOM file, err: %v", err)
+	}
+	return SBOMData
+}
+
+func TestSyftStoreSBOM(t *testing.T) {
+	SBOMData := createSyftSBOMDataMock(t)
+	assert.True(t, SBOMData.IsSBOMAlreadyExist())
+	assert.ElementsMatch(t, []string{"3e9282034226b93f", "b4d5d1e8a6d0f1c2"}, SBOMData.packageIDsByFile["/var/lib/dpkg/status"])
+	assert.Equal(t, []string{"3e9282034226b93f"}, SBOMData.packageIDsByFile["/usr/sbin/deluser"])
+	// access paths are indexed together with the real paths
+	assert.Equal(t, []string{"9a3c0c7d4f7a8b21"}, SBOMData.packageIDsByFile["/usr/local/lib/python3/dist-packages/six-1.16.0.egg-info/PKG-INFO"])
+	assert.Equal(t, "nginx", SBOMData.GetFilterSBOMData().Spec.SPDX.DocumentName)
+
+	err := SBOMData.StoreSBOM(context.TODO(), nil)
+	assert.Error(t, err)
+}
+
+func TestSyftStoreSBOMRefetch(t *testing.T) {
+	SBOMData := createSyftSBOMDataMock(t)
+	err := SBOMData.FilterSBOM(context.TODO(), map[string]bool{"/usr/share/adduser/adduser.conf": true})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+
+	// the new document of the image no longer holds adduser
+	var SBOMDataMock storageclient.SBOMSyft
+	err = json.Unmarshal(readSyftSBOMMock(t), &SBOMDataMock.Spec)
+	if err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	artifacts := SBOMDataMock.Spec.Artifacts[:0]
+	for _, artifact := range SBOMDataMock.Spec.Artifacts {
+		if artifact.Name != "adduser" {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	SBOMDataMock.Spec.Artifacts = artifacts
+	err = SBOMData.StoreSBOM(context.TODO(), &SBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to store SBOM file, err: %v", err)
+	}
+
+	assert.NotContains(t, SBOMData.packageIDsByFile, "/usr/sbin/deluser")
+	assert.Equal(t, []string{"b4d5d1e8a6d0f1c2"}, SBOMData.packageIDsByFile["/var/lib/dpkg/status"])
+	assert.Empty(t, SBOMData.relevantFiles)
+	assert.Empty(t, SBOMData.relevantPackages)
+
+	err = SBOMData.FilterSBOM(context.TODO(), map[string]bool{"/usr/share/adduser/adduser.conf": true})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.Empty(t, SBOMData.GetFilterSBOMData().Spec.SPDX.Packages)
+}
+
+func TestSyftFilterSBOM(t *testing.T) {
+	SBOMData := createSyftSBOMDataMock(t)
+
+	err := SBOMData.FilterSBOM(context.TODO(), map[string]bool{
+		"/usr/share/adduser/adduser.conf": true,
+		"/not/in/sbom":                    true,
+	})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.True(t, SBOMData.IsNewRelevantSBOMDataExist())
+
+	spdxData := SBOMData.GetFilterSBOMData().Spec.SPDX
+	if assert.Len(t, spdxData.Packages, 1) {
+		assert.Equal(t, "adduser", spdxData.Packages[0].PackageName)
+		assert.Equal(t, spdxv1beta1.ElementID("Package-deb-adduser-3e9282034226b93f"), spdxData.Packages[0].PackageSPDXIdentifier)
+		assert.Equal(t, "GPL-2.0-only", spdxData.Packages[0].PackageLicenseDeclared)
+		assert.Len(t, spdxData.Packages[0].PackageExternalReferences, 2)
+	}
+	if assert.Len(t, spdxData.Files, 1) {
+		assert.Equal(t, "/usr/share/adduser/adduser.conf", spdxData.Files[0].FileName)
+		assert.Equal(t, spdxv1beta1.SHA1, spdxData.Files[0].Checksums[0].Algorithm)
+	}
+	if assert.Len(t, spdxData.Relationships, 1) {
+		assert.Equal(t, RelationshipContainType, spdxData.Relationships[0].Relationship)
+		assert.Equal(t, spdxData.Packages[0].PackageSPDXIdentifier, spdxData.Relationships[0].RefA.ElementRefID)
+	}
+
+	// the same files again do not bring new relevant data
+	err = SBOMData.FilterSBOM(context.TODO(), map[string]bool{
+		"/usr/share/adduser/adduser.conf": true,
+	})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.False(t, SBOMData.IsNewRelevantSBOMDataExist())
+
+	// a package location makes the package relevant without listing the location as a file
+	err = SBOMData.FilterSBOM(context.TODO(), map[string]bool{
+		"/var/lib/dpkg/status": true,
+	})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.True(t, SBOMData.IsNewRelevantSBOMDataExist())
+	spdxData = SBOMData.GetFilterSBOMData().Spec.SPDX
+	assert.Len(t, spdxData.Packages, 2)
+	assert.Len(t, spdxData.Files, 1)
+	// adduser is a dependency of libc6
+	assert.Len(t, spdxData.Relationships, 2)
+	assert.Equal(t, RelationshipDependencyOfType, spdxData.Relationships[1].Relationship)
+}
+
+func TestSyftFetchSBOMFromDirectory(t *testing.T) {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instnaceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	SBOMData := CreateSBOMDataSyft(instanceID, afero.NewMemMapFs(), "/sboms").(*SBOMDataSyft)
+	SBOMData.inputFs = afero.NewMemMapFs()
+	err = afero.WriteFile(SBOMData.inputFs, "/sboms/nginx-c9b3ae.json", readSyftSBOMMock(t), 0644)
+	if err != nil {
+		t.Fatalf("fail to write SBOM file, err: %v", err)
+	}
+
+	err = SBOMData.FetchSBOM(context.TODO(), nil, "nginx-c9b3ae")
+	if err != nil {
+		t.Fatalf("fail to fetch SBOM, err: %v", err)
+	}
+	assert.True(t, SBOMData.IsSBOMAlreadyExist())
+
+	err = SBOMData.FetchSBOM(context.TODO(), nil, "unknown")
+	assert.True(t, storageclient.IsNotFound(err))
+}
+
+func TestFetchImageFilesSyft(t *testing.T) {
+	inputDirectory := t.TempDir()
+	err := os.WriteFile(path.Join(inputDirectory, "nginx-c9b3ae.json"), readSyftSBOMMock(t), 0644)
+	if err != nil {
+		t.Fatalf("fail to write SBOM file, err: %v", err)
+	}
+
+	imageFiles, err := FetchImageFilesSyft(context.TODO(), nil, inputDirectory, "nginx-c9b3ae")
+	if err != nil {
+		t.Fatalf("fail to fetch image files, err: %v", err)
+	}
+	assert.Len(t, imageFiles, 3)
+	assert.Equal(t, []spdxv1beta1.Checksum{{Algorithm: spdxv1beta1.SHA256, Value: "1111111111111111111111111111111111111111111111111111111111111111"}}, imageFiles["/usr/sbin/deluser"])
+	assert.Contains(t, imageFiles, "/lib/x86_64-linux-gnu/libc.so.6")
+
+	_, err = FetchImageFilesSyft(context.TODO(), nil, inputDirectory, "unknown")
+	assert.True(t, storageclient.IsNotFound(err))
+}
+
+func TestSyftValidateSBOM(t *testing.T) {
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instnaceIDMock)
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	SBOMData := CreateSBOMDataSyft(instanceID, afero.NewMemMapFs(), "").(*SBOMDataSyft)
+
+	var SBOMDataMock storageclient.SBOMSyft
+	err = json.Unmarshal(readSyftSBOMMock(t), &SBOMDataMock.Spec)
+	if err != nil {
+		t.Fatalf("fail to unmarshal SBOM file, err: %v", err)
+	}
+	SBOMDataMock.SetAnnotations(map[string]string{instanceidhandlerV1.StatusMetadataKey: instanceidhandlerV1.Incomplete})
+	err = SBOMData.StoreSBOM(context.TODO(), &SBOMDataMock)
+	if err != nil {
+		t.Fatalf("fail to store SBOM file, err: %v", err)
+	}
+	assert.ErrorIs(t, SBOMData.ValidateSBOM(context.TODO()), SBOMIncomplete)
+}
+
+func TestSyftLicensesAndCPEs(t *testing.T) {
+	assert.Equal(t, []string{"MIT", "GPL-2.0-only"}, syftLicenses(json.RawMessage(`["MIT", "GPL-2.0-only"]`)))
+	assert.Equal(t, []string{"MIT", "Custom"}, syftLicenses(json.RawMessage(`[{"value": "MIT License", "spdxExpression": "MIT"}, {"value": "Custom"}]`)))
+	assert.Nil(t, syftLicenses(nil))
+	assert.Equal(t, []string{"cpe:2.3:a:six:six:1.16.0:*:*:*:*:*:*:*"}, syftCPEs(json.RawMessage(`["cpe:2.3:a:six:six:1.16.0:*:*:*:*:*:*:*"]`)))
+	assert.Equal(t, []string{"cpe:2.3:a:six:six:1.16.0:*:*:*:*:*:*:*"}, syftCPEs(json.RawMessage(`[{"cpe": "cpe:2.3:a:six:six:1.16.0:*:*:*:*:*:*:*", "source": "syft-generated"}]`)))
+}
+
+func TestSyftFilterSBOMInstallPath(t *testing.T) {
+	SBOMData := createSyftSBOMDataMockMatching(t, true)
+
+	// six is listed by its egg-info only, the module itself is a sibling
+	err := SBOMData.FilterSBOM(context.TODO(), map[string]bool{
+		"/usr/lib/python3/dist-packages/six.py": true,
+	})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.True(t, SBOMData.IsNewRelevantSBOMDataExist())
+	spdxData := SBOMData.GetFilterSBOMData().Spec.SPDX
+	if assert.Len(t, spdxData.Packages, 1) {
+		assert.Equal(t, "six", spdxData.Packages[0].PackageName)
+	}
+}
+
+func TestSyftRestoreRelevantPackages(t *testing.T) {
+	SBOMData := createSyftSBOMDataMockMatching(t, true)
+	err := SBOMData.FilterSBOM(context.TODO(), map[string]bool{"/usr/lib/python3/dist-packages/six.py": true})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	stored := make(map[string]bool)
+	for _, spdxPackage := range SBOMData.GetFilterSBOMData().Spec.SPDX.Packages {
+		stored[string(spdxPackage.PackageSPDXIdentifier)] = true
+	}
+	// six is matched by its install directory, the module is not listed with the files
+	assert.Len(t, stored, 1)
+	assert.Empty(t, SBOMData.GetFilterSBOMData().Spec.SPDX.Files)
+
+	// the agent restarts, only the filtered SBOM is left
+	restarted := createSyftSBOMDataMockMatching(t, true)
+	restarted.RestoreRelevantPackages(stored)
+	err = restarted.FilterSBOM(context.TODO(), map[string]bool{"/usr/share/adduser/adduser.conf": true})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.True(t, restarted.IsNewRelevantSBOMDataExist())
+	packages := make([]string, 0)
+	for _, spdxPackage := range restarted.GetFilterSBOMData().Spec.SPDX.Packages {
+		packages = append(packages, spdxPackage.PackageName)
+	}
+	assert.ElementsMatch(t, []string{"six", "adduser"}, packages)
+
+	// the packages are restored once
+	restarted.RestoreRelevantPackages(stored)
+	err = restarted.FilterSBOM(context.TODO(), map[string]bool{"/usr/share/adduser/adduser.conf": true})
+	if err != nil {
+		t.Fatalf("fail to filter SBOM, err: %v", err)
+	}
+	assert.False(t, restarted.IsNewRelevantSBOMDataExist())
+}
@@ -3,6 +3,7 @@ package storageclient
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -12,6 +13,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
@@ -26,6 +28,15 @@ const (
 	SBOMServerStateExist   SBOMServerState = "Exist"
 	SBOMServerStateDeleted SBOMServerState = "Deleted"
 	retryWatcherSleep                      = 5
+	// listPageSize bounds the objects of each list request, the lists are read page by page
+	listPageSize = 100
+)
+
+var (
+	ErrAlreadyExist = errors.New("already exist")
+	ErrNotFound     = errors.New("not found")
+	ErrNotSupported = errors.New("not supported")
+	ErrTooLarge     = errors.New("too large")
 )
 
 type SBOMServerState string
@@ -36,8 +47,10 @@ type SBOMMetadata struct {
 }
 
 type StorageK8SAggregatedAPIClient struct {
-	clientset  *spdxclient.Clientset
-	readySBOMs sync.Map
+	clientset *spdxclient.Clientset
+	// objects that are not served by the aggregated API are kept in config maps
+	k8sClientset kubernetes.Interface
+	readySBOMs   sync.Map
 }
 
 var _ StorageClient = (*StorageK8SAggregatedAPIClient)(nil)
@@ -58,9 +71,14 @@ func CreateSBOMStorageK8SAggregatedAPIClient(ctx context.Context) (*StorageK8SAg
 	if err != nil {
 		return nil, fmt.Errorf("failed to create K8S Aggregated API Client with err: %v", err)
 	}
+	k8sClientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create K8S Aggregated API Client with err: %v", err)
+	}
 	storageClient := &StorageK8SAggregatedAPIClient{
-		clientset:  clientset,
-		readySBOMs: sync.Map{},
+		clientset:    clientset,
+		k8sClientset: k8sClientset,
+		readySBOMs:   sync.Map{},
 	}
 
 	go storageClient.watchForSBOMs(ctx)
@@ -112,9 +130,9 @@ func (sc *StorageK8SAggregatedAPIClient) watchForSBOMs(ctx context.Context) {
 	}
 }
 
-func (sc *StorageK8SAggregatedAPIClient) GetData(ctx context.Context, key string) (any, error) {
+func (sc *StorageK8SAggregatedAPIClient) GetImageSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3, error) {
 
-	SBOM, err := sc.clientset.SpdxV1beta1().SBOMSPDXv2p3s(KubescapeNamespace).Get(context.TODO(), key, metav1.GetOptions{})
+	SBOM, err := sc.clientset.SpdxV1beta1().SBOMSPDXv2p3s(KubescapeNamespace).Get(ctx, key, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -128,34 +146,186 @@ func (sc *StorageK8SAggregatedAPIClient) GetData(ctx context.Context, key string
 	return SBOM, nil
 }
 
-func (sc *StorageK8SAggregatedAPIClient) PutData(ctx context.Context, key string, data any) error {
-	SBOM, ok := data.(*spdxv1beta1.SBOMSPDXv2p3Filtered)
-	if !ok {
-		return fmt.Errorf("failed to update SBOM: SBOM is not in the right form")
+// GetImageSBOMSyft always fails, the kubescape storage only generates SPDX SBOMs. Syft documents are read from
+// sbomInput.directory or from the http storage.
+func (sc *StorageK8SAggregatedAPIClient) GetImageSBOMSyft(_ context.Context, key string) (*SBOMSyft, error) {
+	return nil, fmt.Errorf("get Syft SBOM %s: the kubescape storage does not serve Syft documents: %w", key, ErrNotSupported)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) GetFilteredSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	return sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).Get(ctx, key, metav1.GetOptions{})
+}
+
+func (sc *StorageK8SAggregatedAPIClient) ListFilteredSBOMs(ctx context.Context) ([]spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	var items []spdxv1beta1.SBOMSPDXv2p3Filtered
+	options := metav1.ListOptions{Limit: listPageSize}
+	for {
+		list, err := sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.Items...)
+		if list.Continue == "" {
+			return items, nil
+		}
+		options.Continue = list.Continue
 	}
+}
+
+func (sc *StorageK8SAggregatedAPIClient) UpdateFilteredSBOM(ctx context.Context, key string, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
 	bytes, err := json.Marshal(SBOM)
 	if err != nil {
 		return err
 	}
-	_, err = sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).Patch(context.TODO(), key, types.StrategicMergePatchType, bytes, metav1.PatchOptions{})
-	if err != nil {
-		return err
+	_, err = sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).Patch(ctx, key, types.StrategicMergePatchType, bytes, metav1.PatchOptions{})
+	return err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) CreateFilteredSBOM(ctx context.Context, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	_, err := sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).Create(ctx, SBOM, metav1.CreateOptions{})
+	return err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) DeleteFilteredSBOM(ctx context.Context, key string) error {
+	return sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).Delete(ctx, key, metav1.DeleteOptions{})
+}
+
+func (sc *StorageK8SAggregatedAPIClient) CreateFilteredSBOMCycloneDX(ctx context.Context, SBOM *SBOMCycloneDXFiltered) error {
+	return sc.createConfigMapObject(ctx, SBOMCycloneDXFilteredKind, SBOM.ObjectMeta, SBOM)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) UpdateFilteredSBOMCycloneDX(ctx context.Context, key string, SBOM *SBOMCycloneDXFiltered) error {
+	return sc.updateConfigMapObject(ctx, SBOMCycloneDXFilteredKind, key, SBOM.ObjectMeta, SBOM)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) GetFilteredSBOMCycloneDX(ctx context.Context, key string) (*SBOMCycloneDXFiltered, error) {
+	var SBOM SBOMCycloneDXFiltered
+	if err := sc.getConfigMapObject(ctx, SBOMCycloneDXFilteredKind, key, &SBOM); err != nil {
+		return nil, err
 	}
-	return nil
+	return &SBOM, nil
 }
 
-func (sc *StorageK8SAggregatedAPIClient) PostData(ctx context.Context, data any) error {
-	SBOM, ok := data.(*spdxv1beta1.SBOMSPDXv2p3Filtered)
-	if !ok {
-		return fmt.Errorf("failed to update SBOM: SBOM is not in the right form")
+func (sc *StorageK8SAggregatedAPIClient) ListFilteredSBOMsCycloneDX(ctx context.Context) ([]SBOMCycloneDXFiltered, error) {
+	var list []SBOMCycloneDXFiltered
+	err := sc.listConfigMapObjects(ctx, SBOMCycloneDXFilteredKind, func(data []byte) error {
+		var SBOM SBOMCycloneDXFiltered
+		if err := json.Unmarshal(data, &SBOM); err != nil {
+			return err
+		}
+		list = append(list, SBOM)
+		return nil
+	})
+	return list, err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) DeleteFilteredSBOMCycloneDX(ctx context.Context, key string) error {
+	return sc.deleteConfigMapObject(ctx, SBOMCycloneDXFilteredKind, key)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) GetNetworkProfile(ctx context.Context, key string) (*NetworkProfile, error) {
+	var profile NetworkProfile
+	if err := sc.getConfigMapObject(ctx, NetworkProfileKind, key, &profile); err != nil {
+		return nil, err
 	}
-	_, err := sc.clientset.SpdxV1beta1().SBOMSPDXv2p3Filtereds(KubescapeNamespace).Create(context.TODO(), SBOM, metav1.CreateOptions{})
-	if err != nil {
-		return err
+	return &profile, nil
+}
+
+func (sc *StorageK8SAggregatedAPIClient) CreateNetworkProfile(ctx context.Context, profile *NetworkProfile) error {
+	return sc.createConfigMapObject(ctx, NetworkProfileKind, profile.ObjectMeta, profile)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) UpdateNetworkProfile(ctx context.Context, key string, profile *NetworkProfile) error {
+	return sc.updateConfigMapObject(ctx, NetworkProfileKind, key, profile.ObjectMeta, profile)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) ListNetworkProfiles(ctx context.Context) ([]NetworkProfile, error) {
+	var list []NetworkProfile
+	err := sc.listConfigMapObjects(ctx, NetworkProfileKind, func(data []byte) error {
+		var profile NetworkProfile
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return err
+		}
+		list = append(list, profile)
+		return nil
+	})
+	return list, err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) DeleteNetworkProfile(ctx context.Context, key string) error {
+	return sc.deleteConfigMapObject(ctx, NetworkProfileKind, key)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) GetApplicationProfile(ctx context.Context, key string) (*ApplicationProfile, error) {
+	var profile ApplicationProfile
+	if err := sc.getConfigMapObject(ctx, ApplicationProfileKind, key, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (sc *StorageK8SAggregatedAPIClient) CreateApplicationProfile(ctx context.Context, profile *ApplicationProfile) error {
+	return sc.createConfigMapObject(ctx, ApplicationProfileKind, profile.ObjectMeta, profile)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) UpdateApplicationProfile(ctx context.Context, key string, profile *ApplicationProfile) error {
+	return sc.updateConfigMapObject(ctx, ApplicationProfileKind, key, profile.ObjectMeta, profile)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) ListApplicationProfiles(ctx context.Context) ([]ApplicationProfile, error) {
+	var list []ApplicationProfile
+	err := sc.listConfigMapObjects(ctx, ApplicationProfileKind, func(data []byte) error {
+		var profile ApplicationProfile
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return err
+		}
+		list = append(list, profile)
+		return nil
+	})
+	return list, err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) DeleteApplicationProfile(ctx context.Context, key string) error {
+	return sc.deleteConfigMapObject(ctx, ApplicationProfileKind, key)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) GetDriftReport(ctx context.Context, key string) (*DriftReport, error) {
+	var report DriftReport
+	if err := sc.getConfigMapObject(ctx, DriftReportKind, key, &report); err != nil {
+		return nil, err
 	}
-	return nil
+	return &report, nil
+}
+
+func (sc *StorageK8SAggregatedAPIClient) CreateDriftReport(ctx context.Context, report *DriftReport) error {
+	return sc.createConfigMapObject(ctx, DriftReportKind, report.ObjectMeta, report)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) UpdateDriftReport(ctx context.Context, key string, report *DriftReport) error {
+	return sc.updateConfigMapObject(ctx, DriftReportKind, key, report.ObjectMeta, report)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) ListDriftReports(ctx context.Context) ([]DriftReport, error) {
+	var list []DriftReport
+	err := sc.listConfigMapObjects(ctx, DriftReportKind, func(data []byte) error {
+		var report DriftReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return err
+		}
+		list = append(list, report)
+		return nil
+	})
+	return list, err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) DeleteDriftReport(ctx context.Context, key string) error {
+	return sc.deleteConfigMapObject(ctx, DriftReportKind, key)
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || apimachineryerrors.IsNotFound(err)
 }
 
 func IsAlreadyExist(err error) bool {
-	return apimachineryerrors.IsAlreadyExists(err)
+	return errors.Is(err, ErrAlreadyExist) || apimachineryerrors.IsAlreadyExists(err)
 }
@@ -0,0 +1,146 @@
+package storageclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The kubescape storage has no kinds for the CycloneDX filtered SBOMs, the network and application profiles and the
+// drift reports, StorageK8SAggregatedAPIClient keeps them in config maps of the kubescape namespace instead. Each
+// config map is named after the object and holds:
+//
+//	metadata.labels[kubescape.io/node-agent-object-kind]  the kind of the object, e.g. SBOMCycloneDXFiltered
+//	metadata.labels, metadata.annotations                  the labels and annotations of the object
+//	data["object.json"]                                    the object marshalled in JSON
+//
+// The config maps without the kind label of the object are never read, updated or deleted as that object. A config
+// map cannot hold more than 1 MiB, larger objects are refused with ErrTooLarge. Consumers that need the objects should
+// list the config maps with the kind label.
+const (
+	// ObjectKindMetadataKey is the label used to tell apart the objects kept in config maps
+	ObjectKindMetadataKey = "kubescape.io/node-agent-object-kind"
+	configMapObjectKey    = "object.json"
+	// maxConfigMapObjectSize keeps room for the metadata of the config map under its 1 MiB limit
+	maxConfigMapObjectSize = 1024*1024 - 16*1024
+)
+
+func configMapObject(kind string, objectMeta metav1.ObjectMeta, object any) (*corev1.ConfigMap, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConfigMapObjectSize {
+		return nil, fmt.Errorf("%s %s is %d bytes, a config map holds at most %d bytes: %w", kind, objectMeta.Name, len(data), maxConfigMapObjectSize, ErrTooLarge)
+	}
+	labels := make(map[string]string, len(objectMeta.Labels)+1)
+	for k, v := range objectMeta.Labels {
+		labels[k] = v
+	}
+	labels[ObjectKindMetadataKey] = kind
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        objectMeta.Name,
+			Namespace:   KubescapeNamespace,
+			Labels:      labels,
+			Annotations: objectMeta.Annotations,
+		},
+		Data: map[string]string{
+			configMapObjectKey: string(data),
+		},
+	}, nil
+}
+
+// getConfigMap returns the config map holding the object of kind named key, the other config maps of the namespace
+// are not found
+func (sc *StorageK8SAggregatedAPIClient) getConfigMap(ctx context.Context, kind, key string) (*corev1.ConfigMap, error) {
+	configMap, err := sc.k8sClientset.CoreV1().ConfigMaps(KubescapeNamespace).Get(ctx, key, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if configMap.Labels[ObjectKindMetadataKey] != kind {
+		return nil, fmt.Errorf("config map %s does not hold a %s: %w", key, kind, ErrNotFound)
+	}
+	return configMap, nil
+}
+
+func (sc *StorageK8SAggregatedAPIClient) getConfigMapObject(ctx context.Context, kind, key string, object any) error {
+	configMap, err := sc.getConfigMap(ctx, kind, key)
+	if err != nil {
+		return err
+	}
+	data, ok := configMap.Data[configMapObjectKey]
+	if !ok {
+		return fmt.Errorf("config map %s is missing the %s key", key, configMapObjectKey)
+	}
+	return json.Unmarshal([]byte(data), object)
+}
+
+func (sc *StorageK8SAggregatedAPIClient) createConfigMapObject(ctx context.Context, kind string, objectMeta metav1.ObjectMeta, object any) error {
+	configMap, err := configMapObject(kind, objectMeta, object)
+	if err != nil {
+		return err
+	}
+	_, err = sc.k8sClientset.CoreV1().ConfigMaps(KubescapeNamespace).Create(ctx, configMap, metav1.CreateOptions{})
+	return err
+}
+
+func (sc *StorageK8SAggregatedAPIClient) updateConfigMapObject(ctx context.Context, kind, key string, objectMeta metav1.ObjectMeta, object any) error {
+	objectMeta.Name = key
+	configMap, err := configMapObject(kind, objectMeta, object)
+	if err != nil {
+		return err
+	}
+	current, err := sc.getConfigMap(ctx, kind, key)
+	if err != nil {
+		return err
+	}
+	// the update fails with a conflict when the config map changed since it was read
+	configMap.ResourceVersion = current.ResourceVersion
+	_, err = sc.k8sClientset.CoreV1().ConfigMaps(KubescapeNamespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// listConfigMapObjects passes the JSON data of every object of kind to appendObject, the objects that cannot be
+// decoded are skipped so that they do not hide the others
+func (sc *StorageK8SAggregatedAPIClient) listConfigMapObjects(ctx context.Context, kind string, appendObject func(data []byte) error) error {
+	options := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", ObjectKindMetadataKey, kind),
+		Limit:         listPageSize,
+	}
+	for {
+		list, err := sc.k8sClientset.CoreV1().ConfigMaps(KubescapeNamespace).List(ctx, options)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			data, ok := list.Items[i].Data[configMapObjectKey]
+			if !ok {
+				continue
+			}
+			if err := appendObject([]byte(data)); err != nil {
+				logger.L().Warning("failed to decode config map object", helpers.String("name", list.Items[i].Name), helpers.String("kind", kind), helpers.Error(err))
+			}
+		}
+		if list.Continue == "" {
+			return nil
+		}
+		options.Continue = list.Continue
+	}
+}
+
+func (sc *StorageK8SAggregatedAPIClient) deleteConfigMapObject(ctx context.Context, kind, key string) error {
+	configMap, err := sc.getConfigMap(ctx, kind, key)
+	if err != nil {
+		return err
+	}
+	// the precondition keeps a config map recreated since it was read
+	return sc.k8sClientset.CoreV1().ConfigMaps(KubescapeNamespace).Delete(ctx, key, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &configMap.UID},
+	})
+}
@@ -0,0 +1,95 @@
+package storageclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cyclonedx "github.com/CycloneDX/cyclonedx-go"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapObjectTooLarge(t *testing.T) {
+	profile := &ApplicationProfile{Spec: ApplicationProfileSpec{Opens: []OpenCalls{{Path: strings.Repeat("a", maxConfigMapObjectSize)}}}}
+	profile.SetName("application-anyInstanceID")
+	_, err := configMapObject(ApplicationProfileKind, profile.ObjectMeta, profile)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestConfigMapFilteredSBOMCycloneDX(t *testing.T) {
+	sc := &StorageK8SAggregatedAPIClient{k8sClientset: fake.NewSimpleClientset()}
+	ctx := context.TODO()
+
+	SBOM := &SBOMCycloneDXFiltered{ObjectMeta: metav1.ObjectMeta{Name: "anyInstanceID", Annotations: map[string]string{"a": "b"}}, Spec: *cyclonedx.NewBOM()}
+	if err := sc.CreateFilteredSBOMCycloneDX(ctx, SBOM); err != nil {
+		t.Fatalf("fail to create CycloneDX filtered SBOM, err: %v", err)
+	}
+	// the profiles are kept in config maps too, they are not listed with the filtered SBOMs
+	profile := &NetworkProfile{ObjectMeta: metav1.ObjectMeta{Name: "network-anyInstanceID"}}
+	if err := sc.CreateNetworkProfile(ctx, profile); err != nil {
+		t.Fatalf("fail to create network profile, err: %v", err)
+	}
+
+	// a BOM without spec version cannot be decoded, it does not hide the others
+	if err := sc.CreateFilteredSBOMCycloneDX(ctx, &SBOMCycloneDXFiltered{ObjectMeta: metav1.ObjectMeta{Name: "broken"}}); err != nil {
+		t.Fatalf("fail to create CycloneDX filtered SBOM, err: %v", err)
+	}
+
+	list, err := sc.ListFilteredSBOMsCycloneDX(ctx)
+	if err != nil {
+		t.Fatalf("fail to list CycloneDX filtered SBOMs, err: %v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "anyInstanceID", list[0].Name)
+		assert.Equal(t, "b", list[0].Annotations["a"])
+	}
+
+	got, err := sc.GetFilteredSBOMCycloneDX(ctx, "anyInstanceID")
+	if err != nil {
+		t.Fatalf("fail to get CycloneDX filtered SBOM, err: %v", err)
+	}
+	assert.Equal(t, "anyInstanceID", got.Name)
+	_, err = sc.GetFilteredSBOMCycloneDX(ctx, "network-anyInstanceID")
+	assert.True(t, IsNotFound(err))
+
+	if err := sc.DeleteFilteredSBOMCycloneDX(ctx, "anyInstanceID"); err != nil {
+		t.Fatalf("fail to delete CycloneDX filtered SBOM, err: %v", err)
+	}
+	_, err = sc.GetFilteredSBOMCycloneDX(ctx, "anyInstanceID")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestConfigMapObjectOtherKind(t *testing.T) {
+	sc := &StorageK8SAggregatedAPIClient{k8sClientset: fake.NewSimpleClientset(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kubescape-config", Namespace: KubescapeNamespace}})}
+	ctx := context.TODO()
+	profile := &NetworkProfile{ObjectMeta: metav1.ObjectMeta{Name: "network-anyInstanceID"}}
+	if err := sc.CreateNetworkProfile(ctx, profile); err != nil {
+		t.Fatalf("fail to create network profile, err: %v", err)
+	}
+
+	// the config maps that do not hold a CycloneDX filtered SBOM are neither overwritten nor deleted
+	SBOM := &SBOMCycloneDXFiltered{Spec: *cyclonedx.NewBOM()}
+	assert.True(t, IsNotFound(sc.UpdateFilteredSBOMCycloneDX(ctx, "kubescape-config", SBOM)))
+	assert.True(t, IsNotFound(sc.UpdateFilteredSBOMCycloneDX(ctx, "network-anyInstanceID", SBOM)))
+	assert.True(t, IsNotFound(sc.DeleteFilteredSBOMCycloneDX(ctx, "kubescape-config")))
+	assert.True(t, IsNotFound(sc.DeleteFilteredSBOMCycloneDX(ctx, "network-anyInstanceID")))
+	configMap, err := sc.k8sClientset.CoreV1().ConfigMaps(KubescapeNamespace).Get(ctx, "kubescape-config", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Empty(t, configMap.Data)
+	}
+	_, err = sc.GetNetworkProfile(ctx, "network-anyInstanceID")
+	assert.NoError(t, err)
+
+	// the object of the kind is updated in place
+	profile.SetAnnotations(map[string]string{"a": "b"})
+	if err := sc.UpdateNetworkProfile(ctx, "network-anyInstanceID", profile); err != nil {
+		t.Fatalf("fail to update network profile, err: %v", err)
+	}
+	got, err := sc.GetNetworkProfile(ctx, "network-anyInstanceID")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "b", got.Annotations["a"])
+	}
+}
@@ -0,0 +1,380 @@
+package storageclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"node-agent/pkg/config"
+	"os"
+	"strings"
+	"time"
+
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+)
+
+const (
+	defaultHTTPTimeout   = 30 * time.Second
+	sbomsHTTPPath        = "sboms"
+	syftSBOMsHTTPPath    = "sboms-syft"
+	filteredSBOMHTTPPath = "filtered-sboms"
+	cycloneDXHTTPPath    = "filtered-sboms-cyclonedx"
+	networkProfilesPath  = "network-profiles"
+	appProfilesPath      = "application-profiles"
+	driftReportsPath     = "drift-reports"
+)
+
+// StorageHttpClient is a StorageClient backed by a generic REST service:
+//
+//	GET    <url>/sboms/<slug>           returns the image SBOM
+//	GET    <url>/sboms-syft/<slug>      returns the image SBOM in the Syft JSON format
+//	GET    <url>/filtered-sboms         lists the filtered SBOMs
+//	POST   <url>/filtered-sboms         creates a filtered SBOM (409 if it already exists)
+//	GET    <url>/filtered-sboms/<name>  returns a filtered SBOM
+//	PUT    <url>/filtered-sboms/<name>  updates a filtered SBOM
+//	DELETE <url>/filtered-sboms/<name>  deletes a filtered SBOM
+//
+// CycloneDX filtered SBOMs, network profiles, application profiles and drift reports are listed, created, read,
+// updated and deleted the same way under <url>/filtered-sboms-cyclonedx, <url>/network-profiles,
+// <url>/application-profiles and <url>/drift-reports.
+type StorageHttpClient struct {
+	baseURL         *url.URL
+	httpClient      *http.Client
+	headers         map[string]string
+	bearerTokenFile string
+}
+
+var _ StorageClient = (*StorageHttpClient)(nil)
+
+func CreateStorageHttpClient(cfg config.HTTPStorageConfig) (*StorageHttpClient, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("failed to create HTTP storage client: url is not set")
+	}
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP storage client with err: %v", err)
+	}
+
+	tlsConfig, err := createTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP storage client with err: %v", err)
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &StorageHttpClient{
+		baseURL:         baseURL,
+		httpClient:      &http.Client{Timeout: timeout, Transport: transport},
+		headers:         cfg.Headers,
+		bearerTokenFile: cfg.BearerTokenFile,
+	}, nil
+}
+
+func createTLSConfig(cfg config.HTTPStorageConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		caCert, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (sc *StorageHttpClient) endpoint(elem ...string) string {
+	return sc.baseURL.JoinPath(elem...).String()
+}
+
+func (sc *StorageHttpClient) do(ctx context.Context, method, endpoint string, body any) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range sc.headers {
+		req.Header.Set(k, v)
+	}
+	if sc.bearerTokenFile != "" {
+		// read the token on every request so rotated tokens are picked up
+		token, err := os.ReadFile(sc.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token file: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := sc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return nil, fmt.Errorf("%s %s: %w", method, endpoint, ErrAlreadyExist)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s %s: %w", method, endpoint, ErrNotFound)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s %s: unexpected status code %d: %s", method, endpoint, resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+func (sc *StorageHttpClient) GetImageSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(sbomsHTTPPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var SBOM spdxv1beta1.SBOMSPDXv2p3
+	if err := json.Unmarshal(respBody, &SBOM); err != nil {
+		return nil, fmt.Errorf("failed to decode SBOM %s: %v", key, err)
+	}
+	return &SBOM, nil
+}
+
+func (sc *StorageHttpClient) GetImageSBOMSyft(ctx context.Context, key string) (*SBOMSyft, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(syftSBOMsHTTPPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var SBOM SBOMSyft
+	if err := json.Unmarshal(respBody, &SBOM); err != nil {
+		return nil, fmt.Errorf("failed to decode Syft SBOM %s: %v", key, err)
+	}
+	return &SBOM, nil
+}
+
+func (sc *StorageHttpClient) GetFilteredSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(filteredSBOMHTTPPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var SBOM spdxv1beta1.SBOMSPDXv2p3Filtered
+	if err := json.Unmarshal(respBody, &SBOM); err != nil {
+		return nil, fmt.Errorf("failed to decode filtered SBOM %s: %v", key, err)
+	}
+	return &SBOM, nil
+}
+
+func (sc *StorageHttpClient) ListFilteredSBOMs(ctx context.Context) ([]spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(filteredSBOMHTTPPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list spdxv1beta1.SBOMSPDXv2p3FilteredList
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode filtered SBOM list: %v", err)
+	}
+	return list.Items, nil
+}
+
+func (sc *StorageHttpClient) UpdateFilteredSBOM(ctx context.Context, key string, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	_, err := sc.do(ctx, http.MethodPut, sc.endpoint(filteredSBOMHTTPPath, key), SBOM)
+	return err
+}
+
+func (sc *StorageHttpClient) CreateFilteredSBOM(ctx context.Context, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	_, err := sc.do(ctx, http.MethodPost, sc.endpoint(filteredSBOMHTTPPath), SBOM)
+	return err
+}
+
+func (sc *StorageHttpClient) DeleteFilteredSBOM(ctx context.Context, key string) error {
+	_, err := sc.do(ctx, http.MethodDelete, sc.endpoint(filteredSBOMHTTPPath, key), nil)
+	return err
+}
+
+func (sc *StorageHttpClient) CreateFilteredSBOMCycloneDX(ctx context.Context, SBOM *SBOMCycloneDXFiltered) error {
+	_, err := sc.do(ctx, http.MethodPost, sc.endpoint(cycloneDXHTTPPath), SBOM)
+	return err
+}
+
+func (sc *StorageHttpClient) UpdateFilteredSBOMCycloneDX(ctx context.Context, key string, SBOM *SBOMCycloneDXFiltered) error {
+	_, err := sc.do(ctx, http.MethodPut, sc.endpoint(cycloneDXHTTPPath, key), SBOM)
+	return err
+}
+
+func (sc *StorageHttpClient) GetFilteredSBOMCycloneDX(ctx context.Context, key string) (*SBOMCycloneDXFiltered, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(cycloneDXHTTPPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var SBOM SBOMCycloneDXFiltered
+	if err := json.Unmarshal(respBody, &SBOM); err != nil {
+		return nil, fmt.Errorf("failed to decode CycloneDX filtered SBOM %s: %v", key, err)
+	}
+	return &SBOM, nil
+}
+
+func (sc *StorageHttpClient) ListFilteredSBOMsCycloneDX(ctx context.Context) ([]SBOMCycloneDXFiltered, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(cycloneDXHTTPPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list SBOMCycloneDXFilteredList
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode CycloneDX filtered SBOM list: %v", err)
+	}
+	return list.Items, nil
+}
+
+func (sc *StorageHttpClient) DeleteFilteredSBOMCycloneDX(ctx context.Context, key string) error {
+	_, err := sc.do(ctx, http.MethodDelete, sc.endpoint(cycloneDXHTTPPath, key), nil)
+	return err
+}
+
+func (sc *StorageHttpClient) GetNetworkProfile(ctx context.Context, key string) (*NetworkProfile, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(networkProfilesPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var profile NetworkProfile
+	if err := json.Unmarshal(respBody, &profile); err != nil {
+		return nil, fmt.Errorf("failed to decode network profile %s: %v", key, err)
+	}
+	return &profile, nil
+}
+
+func (sc *StorageHttpClient) CreateNetworkProfile(ctx context.Context, profile *NetworkProfile) error {
+	_, err := sc.do(ctx, http.MethodPost, sc.endpoint(networkProfilesPath), profile)
+	return err
+}
+
+func (sc *StorageHttpClient) UpdateNetworkProfile(ctx context.Context, key string, profile *NetworkProfile) error {
+	_, err := sc.do(ctx, http.MethodPut, sc.endpoint(networkProfilesPath, key), profile)
+	return err
+}
+
+func (sc *StorageHttpClient) ListNetworkProfiles(ctx context.Context) ([]NetworkProfile, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(networkProfilesPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list NetworkProfileList
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode network profile list: %v", err)
+	}
+	return list.Items, nil
+}
+
+func (sc *StorageHttpClient) DeleteNetworkProfile(ctx context.Context, key string) error {
+	_, err := sc.do(ctx, http.MethodDelete, sc.endpoint(networkProfilesPath, key), nil)
+	return err
+}
+
+func (sc *StorageHttpClient) GetApplicationProfile(ctx context.Context, key string) (*ApplicationProfile, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(appProfilesPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var profile ApplicationProfile
+	if err := json.Unmarshal(respBody, &profile); err != nil {
+		return nil, fmt.Errorf("failed to decode application profile %s: %v", key, err)
+	}
+	return &profile, nil
+}
+
+func (sc *StorageHttpClient) CreateApplicationProfile(ctx context.Context, profile *ApplicationProfile) error {
+	_, err := sc.do(ctx, http.MethodPost, sc.endpoint(appProfilesPath), profile)
+	return err
+}
+
+func (sc *StorageHttpClient) UpdateApplicationProfile(ctx context.Context, key string, profile *ApplicationProfile) error {
+	_, err := sc.do(ctx, http.MethodPut, sc.endpoint(appProfilesPath, key), profile)
+	return err
+}
+
+func (sc *StorageHttpClient) ListApplicationProfiles(ctx context.Context) ([]ApplicationProfile, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(appProfilesPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list ApplicationProfileList
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode application profile list: %v", err)
+	}
+	return list.Items, nil
+}
+
+func (sc *StorageHttpClient) DeleteApplicationProfile(ctx context.Context, key string) error {
+	_, err := sc.do(ctx, http.MethodDelete, sc.endpoint(appProfilesPath, key), nil)
+	return err
+}
+
+func (sc *StorageHttpClient) GetDriftReport(ctx context.Context, key string) (*DriftReport, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(driftReportsPath, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	var report DriftReport
+	if err := json.Unmarshal(respBody, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode drift report %s: %v", key, err)
+	}
+	return &report, nil
+}
+
+func (sc *StorageHttpClient) CreateDriftReport(ctx context.Context, report *DriftReport) error {
+	_, err := sc.do(ctx, http.MethodPost, sc.endpoint(driftReportsPath), report)
+	return err
+}
+
+func (sc *StorageHttpClient) UpdateDriftReport(ctx context.Context, key string, report *DriftReport) error {
+	_, err := sc.do(ctx, http.MethodPut, sc.endpoint(driftReportsPath, key), report)
+	return err
+}
+
+func (sc *StorageHttpClient) ListDriftReports(ctx context.Context) ([]DriftReport, error) {
+	respBody, err := sc.do(ctx, http.MethodGet, sc.endpoint(driftReportsPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list DriftReportList
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode drift report list: %v", err)
+	}
+	return list.Items, nil
+}
+
+func (sc *StorageHttpClient) DeleteDriftReport(ctx context.Context, key string) error {
+	_, err := sc.do(ctx, http.MethodDelete, sc.endpoint(driftReportsPath, key), nil)
+	return err
+}
@@ -0,0 +1,301 @@
+package storageclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"node-agent/pkg/config"
+	"node-agent/pkg/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+	"github.com/stretchr/testify/assert"
+)
+
+type httpStorageServerMock struct {
+	mutex         sync.Mutex
+	sbom          []byte
+	syftSBOM      []byte
+	filteredSBOMs map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered
+	profiles      map[string]*NetworkProfile
+	headers       http.Header
+}
+
+func (s *httpStorageServerMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.headers = r.Header.Clone()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/sboms/"+NGINX_KEY:
+		_, _ = w.Write(s.sbom)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/sboms-syft/"+NGINX_KEY:
+		_, _ = w.Write(s.syftSBOM)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/filtered-sboms":
+		list := spdxv1beta1.SBOMSPDXv2p3FilteredList{}
+		for _, data := range s.filteredSBOMs {
+			list.Items = append(list.Items, *data)
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/filtered-sboms":
+		var data spdxv1beta1.SBOMSPDXv2p3Filtered
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.filteredSBOMs[data.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.filteredSBOMs[data.Name] = &data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && path.Dir(r.URL.Path) == "/api/filtered-sboms":
+		data, ok := s.filteredSBOMs[path.Base(r.URL.Path)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(data)
+	case r.Method == http.MethodDelete && path.Dir(r.URL.Path) == "/api/filtered-sboms":
+		if _, ok := s.filteredSBOMs[path.Base(r.URL.Path)]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.filteredSBOMs, path.Base(r.URL.Path))
+	case r.Method == http.MethodPut && path.Dir(r.URL.Path) == "/api/filtered-sboms":
+		var data spdxv1beta1.SBOMSPDXv2p3Filtered
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.filteredSBOMs[path.Base(r.URL.Path)] = &data
+	case r.Method == http.MethodPost && r.URL.Path == "/api/network-profiles":
+		var data NetworkProfile
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.profiles[data.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.profiles[data.Name] = &data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && path.Dir(r.URL.Path) == "/api/network-profiles":
+		data, ok := s.profiles[path.Base(r.URL.Path)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(data)
+	case r.Method == http.MethodPut && path.Dir(r.URL.Path) == "/api/network-profiles":
+		var data NetworkProfile
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.profiles[path.Base(r.URL.Path)] = &data
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func createHttpStorageServerMock(t *testing.T) *httpStorageServerMock {
+	bytes, err := os.ReadFile(path.Join(utils.CurrentDir(), "testdata", "nginx-spdx-format-mock.json"))
+	if err != nil {
+		t.Fatalf("fail to read SBOM file, err: %v", err)
+	}
+	syftBytes, err := json.Marshal(readSyftSBOMMock())
+	if err != nil {
+		t.Fatalf("fail to marshal Syft SBOM, err: %v", err)
+	}
+	return &httpStorageServerMock{
+		sbom:          bytes,
+		syftSBOM:      syftBytes,
+		filteredSBOMs: map[string]*spdxv1beta1.SBOMSPDXv2p3Filtered{},
+		profiles:      map[string]*NetworkProfile{},
+	}
+}
+
+func TestStorageHttpClientGetImageSBOM(t *testing.T) {
+	server := httptest.NewServer(createHttpStorageServerMock(t))
+	defer server.Close()
+
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL + "/api"})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+
+	SBOM, err := sc.GetImageSBOM(context.TODO(), NGINX_KEY)
+	if err != nil {
+		t.Fatalf("fail to get SBOM, err: %v", err)
+	}
+	assert.Equal(t, "nginx", SBOM.Spec.SPDX.DocumentName)
+
+	_, err = sc.GetImageSBOM(context.TODO(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStorageHttpClientGetImageSBOMSyft(t *testing.T) {
+	server := httptest.NewServer(createHttpStorageServerMock(t))
+	defer server.Close()
+
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL + "/api"})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+
+	SBOM, err := sc.GetImageSBOMSyft(context.TODO(), NGINX_KEY)
+	if err != nil {
+		t.Fatalf("fail to get SBOM, err: %v", err)
+	}
+	assert.Equal(t, "syft", SBOM.Spec.Descriptor.Name)
+	assert.Len(t, SBOM.Spec.Artifacts, 3)
+
+	_, err = sc.GetImageSBOMSyft(context.TODO(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStorageHttpClientFilteredSBOM(t *testing.T) {
+	mock := createHttpStorageServerMock(t)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL + "/api"})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+
+	filtered := &spdxv1beta1.SBOMSPDXv2p3Filtered{}
+	filtered.SetName("anyInstanceID")
+	if err := sc.CreateFilteredSBOM(context.TODO(), filtered); err != nil {
+		t.Fatalf("fail to post filtered SBOM, err: %v", err)
+	}
+	err = sc.CreateFilteredSBOM(context.TODO(), filtered)
+	assert.True(t, IsAlreadyExist(err))
+
+	filtered.Spec.SPDX.DocumentName = "updated"
+	if err := sc.UpdateFilteredSBOM(context.TODO(), "anyInstanceID", filtered); err != nil {
+		t.Fatalf("fail to put filtered SBOM, err: %v", err)
+	}
+	assert.Equal(t, "updated", mock.filteredSBOMs["anyInstanceID"].Spec.SPDX.DocumentName)
+
+	list, err := sc.ListFilteredSBOMs(context.TODO())
+	if err != nil {
+		t.Fatalf("fail to list filtered SBOMs, err: %v", err)
+	}
+	assert.Len(t, list, 1)
+
+	got, err := sc.GetFilteredSBOM(context.TODO(), "anyInstanceID")
+	if err != nil {
+		t.Fatalf("fail to get filtered SBOM, err: %v", err)
+	}
+	assert.Equal(t, "updated", got.Spec.SPDX.DocumentName)
+
+	if err := sc.DeleteFilteredSBOM(context.TODO(), "anyInstanceID"); err != nil {
+		t.Fatalf("fail to delete filtered SBOM, err: %v", err)
+	}
+	_, err = sc.GetFilteredSBOM(context.TODO(), "anyInstanceID")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestStorageHttpClientNetworkProfile(t *testing.T) {
+	mock := createHttpStorageServerMock(t)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL + "/api"})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+
+	_, err = sc.GetNetworkProfile(context.TODO(), "network-anyInstanceID")
+	assert.True(t, IsNotFound(err))
+
+	profile := &NetworkProfile{Spec: NetworkProfileSpec{ContainerName: "nginx"}}
+	profile.SetName("network-anyInstanceID")
+	if err := sc.CreateNetworkProfile(context.TODO(), profile); err != nil {
+		t.Fatalf("fail to post network profile, err: %v", err)
+	}
+	err = sc.CreateNetworkProfile(context.TODO(), profile)
+	assert.True(t, IsAlreadyExist(err))
+
+	profile.Spec.Connections = []NetworkConnection{{Direction: NetworkDirectionIngress, Protocol: "tcp", Port: 80, Address: "10.0.0.2"}}
+	if err := sc.UpdateNetworkProfile(context.TODO(), "network-anyInstanceID", profile); err != nil {
+		t.Fatalf("fail to put network profile, err: %v", err)
+	}
+	got, err := sc.GetNetworkProfile(context.TODO(), "network-anyInstanceID")
+	if err != nil {
+		t.Fatalf("fail to get network profile, err: %v", err)
+	}
+	assert.Equal(t, profile.Spec, got.Spec)
+}
+
+func TestStorageHttpClientHeaders(t *testing.T) {
+	mock := createHttpStorageServerMock(t)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("fail to write token file, err: %v", err)
+	}
+
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{
+		URL:             server.URL + "/api",
+		Headers:         map[string]string{"X-Tenant": "kubescape"},
+		BearerTokenFile: tokenFile,
+	})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+	if _, err := sc.GetImageSBOM(context.TODO(), NGINX_KEY); err != nil {
+		t.Fatalf("fail to get SBOM, err: %v", err)
+	}
+	assert.Equal(t, "kubescape", mock.headers.Get("X-Tenant"))
+	assert.Equal(t, "Bearer secret", mock.headers.Get("Authorization"))
+}
+
+func TestStorageHttpClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(createHttpStorageServerMock(t))
+	defer server.Close()
+
+	// self-signed certificate is rejected by default
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL + "/api"})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+	_, err = sc.GetImageSBOM(context.TODO(), NGINX_KEY)
+	assert.Error(t, err)
+
+	sc, err = CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL + "/api", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+	_, err = sc.GetImageSBOM(context.TODO(), NGINX_KEY)
+	assert.NoError(t, err)
+}
+
+func TestStorageHttpClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	sc, err := CreateStorageHttpClient(config.HTTPStorageConfig{URL: server.URL, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("fail to create client, err: %v", err)
+	}
+	_, err = sc.GetImageSBOM(context.TODO(), NGINX_KEY)
+	assert.Error(t, err)
+}
+
+func TestCreateStorageHttpClientMissingURL(t *testing.T) {
+	_, err := CreateStorageHttpClient(config.HTTPStorageConfig{})
+	assert.Error(t, err)
+}
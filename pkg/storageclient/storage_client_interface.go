@@ -1,9 +1,37 @@
 package storageclient
 
-import "context"
+import (
+	"context"
+
+	spdxv1beta1 "github.com/kubescape/storage/pkg/apis/softwarecomposition/v1beta1"
+)
 
 type StorageClient interface {
-	GetData(ctx context.Context, key string) (any, error)
-	PutData(ctx context.Context, key string, data any) error
-	PostData(ctx context.Context, data any) error
+	GetImageSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3, error)
+	GetImageSBOMSyft(ctx context.Context, key string) (*SBOMSyft, error)
+	GetFilteredSBOM(ctx context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3Filtered, error)
+	ListFilteredSBOMs(ctx context.Context) ([]spdxv1beta1.SBOMSPDXv2p3Filtered, error)
+	CreateFilteredSBOM(ctx context.Context, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error
+	UpdateFilteredSBOM(ctx context.Context, key string, SBOM *spdxv1beta1.SBOMSPDXv2p3Filtered) error
+	DeleteFilteredSBOM(ctx context.Context, key string) error
+	CreateFilteredSBOMCycloneDX(ctx context.Context, SBOM *SBOMCycloneDXFiltered) error
+	UpdateFilteredSBOMCycloneDX(ctx context.Context, key string, SBOM *SBOMCycloneDXFiltered) error
+	GetFilteredSBOMCycloneDX(ctx context.Context, key string) (*SBOMCycloneDXFiltered, error)
+	ListFilteredSBOMsCycloneDX(ctx context.Context) ([]SBOMCycloneDXFiltered, error)
+	DeleteFilteredSBOMCycloneDX(ctx context.Context, key string) error
+	GetNetworkProfile(ctx context.Context, key string) (*NetworkProfile, error)
+	CreateNetworkProfile(ctx context.Context, profile *NetworkProfile) error
+	UpdateNetworkProfile(ctx context.Context, key string, profile *NetworkProfile) error
+	ListNetworkProfiles(ctx context.Context) ([]NetworkProfile, error)
+	DeleteNetworkProfile(ctx context.Context, key string) error
+	GetApplicationProfile(ctx context.Context, key string) (*ApplicationProfile, error)
+	CreateApplicationProfile(ctx context.Context, profile *ApplicationProfile) error
+	UpdateApplicationProfile(ctx context.Context, key string, profile *ApplicationProfile) error
+	ListApplicationProfiles(ctx context.Context) ([]ApplicationProfile, error)
+	DeleteApplicationProfile(ctx context.Context, key string) error
+	GetDriftReport(ctx context.Context, key string) (*DriftReport, error)
+	CreateDriftReport(ctx context.Context, report *DriftReport) error
+	UpdateDriftReport(ctx context.Context, key string, report *DriftReport) error
+	ListDriftReports(ctx context.Context) ([]DriftReport, error)
+	DeleteDriftReport(ctx context.Context, key string) error
 }
@@ -13,18 +13,39 @@ import (
 
 type StorageHttpClientMock struct {
 	nginxSBOMSpdxBytes *spdxv1beta1.SBOMSPDXv2p3
+	nginxSBOMSyft      *SBOMSyft
 }
 
+var _ StorageClient = (*StorageHttpClientMock)(nil)
+
 type StorageHttpClientFailureMock struct {
 	nginxSBOMSpdxBytes *spdxv1beta1.SBOMSPDXv2p3
+	nginxSBOMSyft      *SBOMSyft
 }
 
+var _ StorageClient = (*StorageHttpClientFailureMock)(nil)
+
 const (
 	NGINX_KEY       = "nginx-c9b3ae"
 	NGINX           = "6a59f1cbb8d28ac484176d52c473494859a512ddba3ea62a547258cf16c9b3ae"
 	NGINX_IMAGE_TAG = "nginx"
 )
 
+func readSyftSBOMMock() *SBOMSyft {
+	var data SBOMSyft
+	nginxSBOMPath := path.Join(utils.CurrentDir(), "testdata", "nginx-syft-format-mock.json")
+	bytes, err := os.ReadFile(nginxSBOMPath)
+	if err != nil {
+		return nil
+	}
+	err = json.Unmarshal(bytes, &data.Spec)
+	if err != nil {
+		return nil
+	}
+	data.SetName(NGINX_KEY)
+	return &data
+}
+
 func CreateSBOMStorageHttpClientMock() *StorageHttpClientMock {
 	var data spdxv1beta1.SBOMSPDXv2p3
 	nginxSBOMPath := path.Join(utils.CurrentDir(), "testdata", "nginx-spdx-format-mock.json")
@@ -39,23 +60,96 @@ func CreateSBOMStorageHttpClientMock() *StorageHttpClientMock {
 
 	return &StorageHttpClientMock{
 		nginxSBOMSpdxBytes: &data,
+		nginxSBOMSyft:      readSyftSBOMMock(),
 	}
 }
 
-func (sc *StorageHttpClientMock) GetData(_ context.Context, key string) (any, error) {
+func (sc *StorageHttpClientMock) GetImageSBOM(_ context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3, error) {
 	if key == NGINX_KEY {
 		return sc.nginxSBOMSpdxBytes, nil
 	}
 	return nil, nil
 }
-func (sc *StorageHttpClientMock) PutData(_ context.Context, _ string, _ any) error {
+func (sc *StorageHttpClientMock) GetImageSBOMSyft(_ context.Context, key string) (*SBOMSyft, error) {
+	if key == NGINX_KEY {
+		return sc.nginxSBOMSyft, nil
+	}
+	return nil, ErrNotFound
+}
+func (sc *StorageHttpClientMock) GetFilteredSBOM(_ context.Context, _ string) (*spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	return nil, ErrNotFound
+}
+func (sc *StorageHttpClientMock) ListFilteredSBOMs(_ context.Context) ([]spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	return nil, nil
+}
+func (sc *StorageHttpClientMock) UpdateFilteredSBOM(_ context.Context, _ string, _ *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) CreateFilteredSBOM(_ context.Context, _ *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) DeleteFilteredSBOM(_ context.Context, _ string) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) CreateFilteredSBOMCycloneDX(_ context.Context, _ *SBOMCycloneDXFiltered) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) UpdateFilteredSBOMCycloneDX(_ context.Context, _ string, _ *SBOMCycloneDXFiltered) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) GetFilteredSBOMCycloneDX(_ context.Context, _ string) (*SBOMCycloneDXFiltered, error) {
+	return nil, ErrNotFound
+}
+func (sc *StorageHttpClientMock) ListFilteredSBOMsCycloneDX(_ context.Context) ([]SBOMCycloneDXFiltered, error) {
+	return nil, nil
+}
+func (sc *StorageHttpClientMock) DeleteFilteredSBOMCycloneDX(_ context.Context, _ string) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) GetNetworkProfile(_ context.Context, _ string) (*NetworkProfile, error) {
+	return nil, ErrNotFound
+}
+func (sc *StorageHttpClientMock) CreateNetworkProfile(_ context.Context, _ *NetworkProfile) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) UpdateNetworkProfile(_ context.Context, _ string, _ *NetworkProfile) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) ListNetworkProfiles(_ context.Context) ([]NetworkProfile, error) {
+	return nil, nil
+}
+func (sc *StorageHttpClientMock) DeleteNetworkProfile(_ context.Context, _ string) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) GetApplicationProfile(_ context.Context, _ string) (*ApplicationProfile, error) {
+	return nil, ErrNotFound
+}
+func (sc *StorageHttpClientMock) CreateApplicationProfile(_ context.Context, _ *ApplicationProfile) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) UpdateApplicationProfile(_ context.Context, _ string, _ *ApplicationProfile) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) ListApplicationProfiles(_ context.Context) ([]ApplicationProfile, error) {
+	return nil, nil
+}
+func (sc *StorageHttpClientMock) DeleteApplicationProfile(_ context.Context, _ string) error {
+	return nil
+}
+func (sc *StorageHttpClientMock) GetDriftReport(_ context.Context, _ string) (*DriftReport, error) {
+	return nil, ErrNotFound
+}
+func (sc *StorageHttpClientMock) CreateDriftReport(_ context.Context, _ *DriftReport) error {
 	return nil
 }
-func (sc *StorageHttpClientMock) PostData(_ context.Context, _ any) error {
+func (sc *StorageHttpClientMock) UpdateDriftReport(_ context.Context, _ string, _ *DriftReport) error {
 	return nil
 }
-func (sc *StorageHttpClientMock) GetResourceVersion(_ context.Context, _ string) string {
-	return "123"
+func (sc *StorageHttpClientMock) ListDriftReports(_ context.Context) ([]DriftReport, error) {
+	return nil, nil
+}
+func (sc *StorageHttpClientMock) DeleteDriftReport(_ context.Context, _ string) error {
+	return nil
 }
 
 func CreateStorageHttpClientFailureMock() *StorageHttpClientFailureMock {
@@ -72,23 +166,120 @@ func CreateStorageHttpClientFailureMock() *StorageHttpClientFailureMock {
 
 	return &StorageHttpClientFailureMock{
 		nginxSBOMSpdxBytes: &data,
+		nginxSBOMSyft:      readSyftSBOMMock(),
 	}
 }
 
-func (sc *StorageHttpClientFailureMock) GetData(_ context.Context, key string) (any, error) {
+func (sc *StorageHttpClientFailureMock) GetImageSBOM(_ context.Context, key string) (*spdxv1beta1.SBOMSPDXv2p3, error) {
 	if key == NGINX_KEY {
 		return sc.nginxSBOMSpdxBytes, nil
 	}
 	return nil, nil
 }
 
-func (sc *StorageHttpClientFailureMock) PutData(_ context.Context, _ string, _ any) error {
+func (sc *StorageHttpClientFailureMock) GetImageSBOMSyft(_ context.Context, key string) (*SBOMSyft, error) {
+	if key == NGINX_KEY {
+		return sc.nginxSBOMSyft, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (sc *StorageHttpClientFailureMock) GetFilteredSBOM(_ context.Context, _ string) (*spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) ListFilteredSBOMs(_ context.Context) ([]spdxv1beta1.SBOMSPDXv2p3Filtered, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) UpdateFilteredSBOM(_ context.Context, _ string, _ *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) CreateFilteredSBOM(_ context.Context, _ *spdxv1beta1.SBOMSPDXv2p3Filtered) error {
+	return fmt.Errorf("error %w", ErrAlreadyExist)
+}
+
+func (sc *StorageHttpClientFailureMock) DeleteFilteredSBOM(_ context.Context, _ string) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) CreateFilteredSBOMCycloneDX(_ context.Context, _ *SBOMCycloneDXFiltered) error {
+	return fmt.Errorf("error %w", ErrAlreadyExist)
+}
+
+func (sc *StorageHttpClientFailureMock) UpdateFilteredSBOMCycloneDX(_ context.Context, _ string, _ *SBOMCycloneDXFiltered) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) GetFilteredSBOMCycloneDX(_ context.Context, _ string) (*SBOMCycloneDXFiltered, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) ListFilteredSBOMsCycloneDX(_ context.Context) ([]SBOMCycloneDXFiltered, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) DeleteFilteredSBOMCycloneDX(_ context.Context, _ string) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) GetNetworkProfile(_ context.Context, _ string) (*NetworkProfile, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) CreateNetworkProfile(_ context.Context, _ *NetworkProfile) error {
+	return fmt.Errorf("error %w", ErrAlreadyExist)
+}
+
+func (sc *StorageHttpClientFailureMock) UpdateNetworkProfile(_ context.Context, _ string, _ *NetworkProfile) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) ListNetworkProfiles(_ context.Context) ([]NetworkProfile, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) DeleteNetworkProfile(_ context.Context, _ string) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) GetApplicationProfile(_ context.Context, _ string) (*ApplicationProfile, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) CreateApplicationProfile(_ context.Context, _ *ApplicationProfile) error {
+	return fmt.Errorf("error %w", ErrAlreadyExist)
+}
+
+func (sc *StorageHttpClientFailureMock) UpdateApplicationProfile(_ context.Context, _ string, _ *ApplicationProfile) error {
 	return fmt.Errorf("any")
 }
 
-func (sc *StorageHttpClientFailureMock) PostData(_ context.Context, _ any) error {
-	return fmt.Errorf("error already exist")
+func (sc *StorageHttpClientFailureMock) ListApplicationProfiles(_ context.Context) ([]ApplicationProfile, error) {
+	return nil, fmt.Errorf("any")
 }
-func (sc *StorageHttpClientFailureMock) IsAlreadyExist(_ error) bool {
-	return true
+
+func (sc *StorageHttpClientFailureMock) DeleteApplicationProfile(_ context.Context, _ string) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) GetDriftReport(_ context.Context, _ string) (*DriftReport, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) CreateDriftReport(_ context.Context, _ *DriftReport) error {
+	return fmt.Errorf("error %w", ErrAlreadyExist)
+}
+
+func (sc *StorageHttpClientFailureMock) UpdateDriftReport(_ context.Context, _ string, _ *DriftReport) error {
+	return fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) ListDriftReports(_ context.Context) ([]DriftReport, error) {
+	return nil, fmt.Errorf("any")
+}
+
+func (sc *StorageHttpClientFailureMock) DeleteDriftReport(_ context.Context, _ string) error {
+	return fmt.Errorf("any")
 }
@@ -0,0 +1,227 @@
+package storageclient
+
+import (
+	"encoding/json"
+
+	cyclonedx "github.com/CycloneDX/cyclonedx-go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	SBOMCycloneDXFilteredKind = "SBOMCycloneDXFiltered"
+)
+
+// SBOMCycloneDXFiltered is a filtered SBOM in the CycloneDX format, it carries the same metadata as SBOMSPDXv2p3Filtered
+type SBOMCycloneDXFiltered struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec cyclonedx.BOM `json:"spec"`
+}
+
+// SBOMCycloneDXFilteredList is the list of CycloneDX filtered SBOMs returned by the http storage
+type SBOMCycloneDXFilteredList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SBOMCycloneDXFiltered `json:"items"`
+}
+
+// SBOMSyft is an image SBOM in the Syft native JSON format
+type SBOMSyft struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SyftDocument `json:"spec"`
+}
+
+// SyftDocument holds the parts of a Syft JSON document (syft -o json) the node agent needs
+type SyftDocument struct {
+	Artifacts             []SyftPackage      `json:"artifacts"`
+	ArtifactRelationships []SyftRelationship `json:"artifactRelationships"`
+	Files                 []SyftFile         `json:"files,omitempty"`
+	Source                SyftSource         `json:"source"`
+	Descriptor            SyftDescriptor     `json:"descriptor"`
+	Schema                SyftSchema         `json:"schema"`
+}
+
+type SyftPackage struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Version   string         `json:"version"`
+	Type      string         `json:"type"`
+	FoundBy   string         `json:"foundBy"`
+	Locations []SyftLocation `json:"locations"`
+	// Licenses and CPEs are lists of strings in old schemas and lists of objects in new ones
+	Licenses     json.RawMessage `json:"licenses,omitempty"`
+	Language     string          `json:"language"`
+	CPEs         json.RawMessage `json:"cpes,omitempty"`
+	PURL         string          `json:"purl"`
+	MetadataType string          `json:"metadataType,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+}
+
+type SyftLocation struct {
+	Path        string            `json:"path"`
+	LayerID     string            `json:"layerID,omitempty"`
+	AccessPath  string            `json:"accessPath,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type SyftRelationship struct {
+	Parent   string          `json:"parent"`
+	Child    string          `json:"child"`
+	Type     string          `json:"type"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+type SyftFile struct {
+	ID       string       `json:"id"`
+	Location SyftLocation `json:"location"`
+	Digests  []SyftDigest `json:"digests,omitempty"`
+}
+
+type SyftDigest struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+type SyftSource struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name,omitempty"`
+	Version  string          `json:"version,omitempty"`
+	Type     string          `json:"type"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+type SyftDescriptor struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type SyftSchema struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+const (
+	NetworkProfileKind = "NetworkProfile"
+)
+
+const (
+	NetworkDirectionIngress = "ingress"
+	NetworkDirectionEgress  = "egress"
+)
+
+// NetworkProfile lists the network connections observed for a container
+type NetworkProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NetworkProfileSpec `json:"spec"`
+}
+
+// NetworkProfileList is the list of network profiles returned by the http storage
+type NetworkProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NetworkProfile `json:"items"`
+}
+
+type NetworkProfileSpec struct {
+	ContainerName string              `json:"containerName"`
+	Connections   []NetworkConnection `json:"connections"`
+}
+
+// NetworkConnection is a remote endpoint a container talked to, Port is the port of the container for ingress
+// connections and the port of the remote endpoint for egress connections
+type NetworkConnection struct {
+	Direction string `json:"direction"`
+	Protocol  string `json:"protocol"`
+	Port      uint16 `json:"port"`
+	Address   string `json:"address"`
+}
+
+const (
+	ApplicationProfileKind = "ApplicationProfile"
+)
+
+// ApplicationProfile lists the behaviour observed for a container: the executed binaries and the opened files
+type ApplicationProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ApplicationProfileSpec `json:"spec"`
+}
+
+// ApplicationProfileList is the list of application profiles returned by the http storage
+type ApplicationProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ApplicationProfile `json:"items"`
+}
+
+type ApplicationProfileSpec struct {
+	ContainerName string      `json:"containerName"`
+	Execs         []ExecCalls `json:"execs"`
+	Opens         []OpenCalls `json:"opens"`
+}
+
+type ExecCalls struct {
+	Path string   `json:"path"`
+	Args []string `json:"args,omitempty"`
+}
+
+type OpenCalls struct {
+	Path  string   `json:"path"`
+	Flags []string `json:"flags,omitempty"`
+}
+
+const (
+	DriftReportKind = "DriftReport"
+)
+
+const (
+	DriftOperationExec  = "exec"
+	DriftOperationWrite = "write"
+)
+
+const (
+	// DriftKindAdded is a file absent from the image SBOM
+	DriftKindAdded = "added"
+	// DriftKindModified is a file whose checksum differs from the one in the image SBOM
+	DriftKindModified = "modified"
+)
+
+// DriftReport lists the files of a container that drifted from its image: the executables run and the files
+// written at runtime that are absent from the image SBOM or whose checksum differs
+type DriftReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DriftReportSpec `json:"spec"`
+}
+
+// DriftReportList is the list of drift reports returned by the http storage
+type DriftReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []DriftReport `json:"items"`
+}
+
+type DriftReportSpec struct {
+	ContainerName string        `json:"containerName"`
+	ImageID       string        `json:"imageID"`
+	Files         []DriftedFile `json:"files"`
+}
+
+type DriftedFile struct {
+	Path      string `json:"path"`
+	Operation string `json:"operation"`
+	Kind      string `json:"kind"`
+	// Checksum is the SHA256 of the file when it was checked, it is empty when the file could not be read
+	Checksum string `json:"checksum,omitempty"`
+}
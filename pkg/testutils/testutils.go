@@ -0,0 +1,101 @@
+// Package testutils holds the fixtures shared by the tests of the event consumers
+package testutils
+
+import (
+	"context"
+	"node-agent/pkg/exporters"
+	"node-agent/pkg/storageclient"
+	"sync"
+
+	tracerexectype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/exec/types"
+	traceropentype "github.com/inspektor-gadget/inspektor-gadget/pkg/gadgets/trace/open/types"
+	eventtypes "github.com/inspektor-gadget/inspektor-gadget/pkg/types"
+)
+
+// EventPid is the pid of the processes of the events
+const EventPid = 42
+
+// ExecEvent returns an exec event of the process comm, the first argument is the executed binary
+func ExecEvent(comm string, args ...string) *tracerexectype.Event {
+	return &tracerexectype.Event{Event: eventtypes.Event{Type: eventtypes.NORMAL}, Pid: EventPid, Comm: comm, Args: args}
+}
+
+// OpenEvent returns an open event of the process comm
+func OpenEvent(comm, path string, flags ...string) *traceropentype.Event {
+	return &traceropentype.Event{Event: eventtypes.Event{Type: eventtypes.NORMAL}, Pid: EventPid, Comm: comm, FullPath: path, Flags: flags}
+}
+
+// ExporterMock records the alerts
+type ExporterMock struct {
+	mutex  sync.Mutex
+	alerts []exporters.Alert
+}
+
+var _ exporters.Exporter = (*ExporterMock)(nil)
+
+func (e *ExporterMock) SendAlert(alert exporters.Alert) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.alerts = append(e.alerts, alert)
+}
+
+func (e *ExporterMock) Stop() {}
+
+// Alerts returns the alerts sent so far
+func (e *ExporterMock) Alerts() []exporters.Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]exporters.Alert(nil), e.alerts...)
+}
+
+// ApplicationProfileStorageMock keeps the application profiles in memory and counts the calls, the other methods of
+// the storage client are not implemented
+type ApplicationProfileStorageMock struct {
+	storageclient.StorageClient
+	mutex    sync.Mutex
+	profiles map[string]storageclient.ApplicationProfile
+	Gets     int
+	Creates  int
+	Updates  int
+}
+
+func CreateApplicationProfileStorageMock() *ApplicationProfileStorageMock {
+	return &ApplicationProfileStorageMock{profiles: make(map[string]storageclient.ApplicationProfile)}
+}
+
+// StoreApplicationProfile stores a profile without counting the call
+func (sc *ApplicationProfileStorageMock) StoreApplicationProfile(profile storageclient.ApplicationProfile) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.profiles[profile.Name] = profile
+}
+
+func (sc *ApplicationProfileStorageMock) GetApplicationProfile(_ context.Context, key string) (*storageclient.ApplicationProfile, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.Gets++
+	profile, ok := sc.profiles[key]
+	if !ok {
+		return nil, storageclient.ErrNotFound
+	}
+	return &profile, nil
+}
+
+func (sc *ApplicationProfileStorageMock) CreateApplicationProfile(_ context.Context, profile *storageclient.ApplicationProfile) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.Creates++
+	if _, ok := sc.profiles[profile.Name]; ok {
+		return storageclient.ErrAlreadyExist
+	}
+	sc.profiles[profile.Name] = *profile
+	return nil
+}
+
+func (sc *ApplicationProfileStorageMock) UpdateApplicationProfile(_ context.Context, key string, profile *storageclient.ApplicationProfile) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.Updates++
+	sc.profiles[key] = *profile
+	return nil
+}
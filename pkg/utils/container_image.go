@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/kubescape/k8s-interface/workloadinterface"
+)
+
+// GetPodContainerImage returns the image tag and the image ID of a container of a pod whatever its type, the image ID
+// is only known once the container status is in the pod
+func GetPodContainerImage(pod *workloadinterface.Workload, containerName string) (string, string, error) {
+	podContainer, err := GetPodContainer(pod, containerName)
+	if err != nil {
+		return "", "", err
+	}
+	if podContainer.Image == "" || podContainer.ImageID == "" {
+		return "", "", fmt.Errorf("image of container %s not found in pod %s in namespace %s", containerName, pod.GetName(), pod.GetNamespace())
+	}
+	return podContainer.Image, podContainer.ImageID, nil
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/kubescape/k8s-interface/names"
+)
+
+// ContainerInstanceID identifies a container of a plain container host by its image ID and its name, there is no
+// workload to identify it by. The workload fields are empty and their setters do nothing.
+type ContainerInstanceID struct {
+	imageID       string
+	containerName string
+}
+
+var _ instanceidhandler.IInstanceID = (*ContainerInstanceID)(nil)
+
+func CreateContainerInstanceID(imageID, containerName string) (*ContainerInstanceID, error) {
+	if imageID == "" {
+		return nil, errors.New("invalid instanceID: imageID cannot be empty")
+	}
+	if containerName == "" {
+		return nil, errors.New("invalid instanceID: containerName cannot be empty")
+	}
+	return &ContainerInstanceID{imageID: imageID, containerName: containerName}, nil
+}
+
+func (id *ContainerInstanceID) GetAPIVersion() string {
+	return ""
+}
+
+func (id *ContainerInstanceID) GetNamespace() string {
+	return ""
+}
+
+func (id *ContainerInstanceID) GetKind() string {
+	return ""
+}
+
+func (id *ContainerInstanceID) GetName() string {
+	return ""
+}
+
+func (id *ContainerInstanceID) GetContainerName() string {
+	return id.containerName
+}
+
+func (id *ContainerInstanceID) SetAPIVersion(string) {}
+
+func (id *ContainerInstanceID) SetNamespace(string) {}
+
+func (id *ContainerInstanceID) SetKind(string) {}
+
+func (id *ContainerInstanceID) SetName(string) {}
+
+func (id *ContainerInstanceID) SetContainerName(containerName string) {
+	id.containerName = containerName
+}
+
+func (id *ContainerInstanceID) GetStringFormatted() string {
+	return fmt.Sprintf("imageID-%s/containerName-%s", id.imageID, id.containerName)
+}
+
+func (id *ContainerInstanceID) GetHashed() string {
+	hash := sha256.Sum256([]byte(id.GetStringFormatted()))
+	return hex.EncodeToString(hash[:])
+}
+
+// GetLabels only gives the container name, the image ID is not a valid label value
+func (id *ContainerInstanceID) GetLabels() map[string]string {
+	return map[string]string{
+		instanceidhandlerV1.ContainerNameMetadataKey: id.containerName,
+	}
+}
+
+// GetSlug returns the container name followed by the end of the hash, the same name can be given to the containers of
+// different images
+func (id *ContainerInstanceID) GetSlug() (string, error) {
+	return names.ImageInfoToSlug(id.containerName, id.GetHashed())
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/kubescape/k8s-interface/names"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerInstanceID(t *testing.T) {
+	_, err := CreateContainerInstanceID("", "web")
+	assert.Error(t, err)
+	_, err = CreateContainerInstanceID("docker.io/library/nginx@sha256:01", "")
+	assert.Error(t, err)
+
+	instanceID, err := CreateContainerInstanceID("docker.io/library/nginx@sha256:01", "web")
+	if err != nil {
+		t.Fatalf("fail to create instance ID, err: %v", err)
+	}
+	assert.Equal(t, "imageID-docker.io/library/nginx@sha256:01/containerName-web", instanceID.GetStringFormatted())
+	slug, err := instanceID.GetSlug()
+	if err != nil {
+		t.Fatalf("fail to get slug, err: %v", err)
+	}
+	assert.True(t, names.IsValidSlug(slug))
+
+	// the same container name with another image gets another key
+	other, _ := CreateContainerInstanceID("docker.io/library/nginx@sha256:02", "web")
+	otherSlug, _ := other.GetSlug()
+	assert.NotEqual(t, slug, otherSlug)
+	assert.NotEqual(t, instanceID.GetHashed(), other.GetHashed())
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"node-agent/pkg/metadataprovider"
+
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// GetContainerInstanceID returns the instance ID of a container of a pod, the pod is read from the pods of the node
+// held by the metadata provider
+func GetContainerInstanceID(metadataProvider metadataprovider.MetadataProviderClient, namespace, podName, containerName string) (instanceidhandler.IInstanceID, error) {
+	pod, err := GetPod(metadataProvider, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+	return GetPodContainerInstanceID(pod, containerName)
+}
+
+// GetPod returns a pod of the node, there is no metadata provider when the containers have no pod
+func GetPod(metadataProvider metadataprovider.MetadataProviderClient, namespace, podName string) (*workloadinterface.Workload, error) {
+	if metadataProvider == nil {
+		return nil, errors.New("no metadata provider, the containers do not run in pods")
+	}
+	pod, err := metadataProvider.GetPod(namespace, podName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s in namespace %s: %w", podName, namespace, err)
+	}
+	return pod, nil
+}
+
+// InstanceIDLabels returns the labels of an instance ID, the values that are not valid label values are dropped
+func InstanceIDLabels(instanceID instanceidhandler.IInstanceID) map[string]string {
+	labels := instanceID.GetLabels()
+	for key, value := range labels {
+		if value == "" || len(validation.IsValidLabelValue(value)) != 0 {
+			delete(labels, key)
+		}
+	}
+	return labels
+}
+
+// InstanceIDAnnotations returns the annotations telling the instance ID of the objects stored for a container, the
+// garbage collector reads them to find the workload of an object
+func InstanceIDAnnotations(instanceID instanceidhandler.IInstanceID) map[string]string {
+	return map[string]string{
+		instanceidhandlerV1.InstanceIDMetadataKey: instanceID.GetStringFormatted(),
+	}
+}
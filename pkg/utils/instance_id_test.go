@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"errors"
+	"node-agent/pkg/metadataprovider"
+	"testing"
+
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"github.com/stretchr/testify/assert"
+)
+
+// metadataProviderMock holds the pod of podMock
+type metadataProviderMock struct {
+	metadataprovider.MetadataProviderClient
+	pod *workloadinterface.Workload
+}
+
+func (mp *metadataProviderMock) GetPod(namespace, name string) (*workloadinterface.Workload, error) {
+	if namespace != mp.pod.GetNamespace() || name != mp.pod.GetName() {
+		return nil, errors.New("pod not found")
+	}
+	return mp.pod, nil
+}
+
+func TestGetContainerInstanceID(t *testing.T) {
+	pod, err := workloadinterface.NewWorkload([]byte(podMock))
+	if err != nil {
+		t.Fatalf("fail to create pod, err: %v", err)
+	}
+	provider := &metadataProviderMock{pod: pod}
+
+	instanceID, err := GetContainerInstanceID(provider, "default", "nginx-1", "nginx")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "nginx", instanceID.GetContainerName())
+		assert.Equal(t, "ReplicaSet", instanceID.GetKind())
+	}
+	_, err = GetContainerInstanceID(provider, "default", "nginx-2", "nginx")
+	assert.Error(t, err)
+	// the containers of a plain container host have no pod
+	_, err = GetContainerInstanceID(nil, "default", "nginx-1", "nginx")
+	assert.Error(t, err)
+
+	imageTag, imageID, err := GetPodContainerImage(pod, "nginx")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "nginx:1.25", imageTag)
+		assert.Equal(t, "docker.io/library/nginx@sha256:03", imageID)
+	}
+	_, _, err = GetPodContainerImage(pod, "debugger")
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubescape/k8s-interface/instanceidhandler"
+	instanceidhandlerV1 "github.com/kubescape/k8s-interface/instanceidhandler/v1"
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ContainerType is the kind of a container in the pod spec
+type ContainerType string
+
+const (
+	ContainerTypeContainer     ContainerType = "container"
+	ContainerTypeInitContainer ContainerType = "initContainer"
+	// ContainerTypeSidecar is an init container restarted for the whole life of the pod
+	ContainerTypeSidecar   ContainerType = "sidecar"
+	ContainerTypeEphemeral ContainerType = "ephemeralContainer"
+)
+
+// PodContainer is a container of a pod, ImageID, ContainerID and RestartCount are empty until the status of the
+// container is in the pod. They are the ones of the last run of the container, ContainerID is without the runtime prefix.
+type PodContainer struct {
+	Name         string
+	Type         ContainerType
+	Image        string
+	ImageID      string
+	ContainerID  string
+	RestartCount int32
+	// PodCreated is the creation time of the pod, it orders the containers of different pods
+	PodCreated time.Time
+}
+
+// podContainerSpec holds the fields common to all the container kinds, restartPolicy is only set on the native
+// sidecars
+type podContainerSpec struct {
+	Name          string `json:"name"`
+	Image         string `json:"image"`
+	RestartPolicy string `json:"restartPolicy"`
+}
+
+type podContainers struct {
+	Metadata struct {
+		CreationTimestamp metav1.Time `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers          []podContainerSpec `json:"containers"`
+		InitContainers      []podContainerSpec `json:"initContainers"`
+		EphemeralContainers []podContainerSpec `json:"ephemeralContainers"`
+	} `json:"spec"`
+	Status corev1.PodStatus `json:"status"`
+}
+
+// GetPodContainer looks for a container in the containers, the init containers and the ephemeral containers of a pod
+func GetPodContainer(pod *workloadinterface.Workload, containerName string) (*PodContainer, error) {
+	b, err := json.Marshal(pod.GetObject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pod %s in namespace %s: %w", pod.GetName(), pod.GetNamespace(), err)
+	}
+	var containers podContainers
+	if err := json.Unmarshal(b, &containers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pod %s in namespace %s: %w", pod.GetName(), pod.GetNamespace(), err)
+	}
+	lookups := []struct {
+		containerType ContainerType
+		specs         []podContainerSpec
+		statuses      []corev1.ContainerStatus
+	}{
+		{ContainerTypeContainer, containers.Spec.Containers, containers.Status.ContainerStatuses},
+		{ContainerTypeInitContainer, containers.Spec.InitContainers, containers.Status.InitContainerStatuses},
+		{ContainerTypeEphemeral, containers.Spec.EphemeralContainers, containers.Status.EphemeralContainerStatuses},
+	}
+	for _, lookup := range lookups {
+		for i := range lookup.specs {
+			if lookup.specs[i].Name != containerName {
+				continue
+			}
+			podContainer := &PodContainer{Name: containerName, Type: lookup.containerType, Image: lookup.specs[i].Image, PodCreated: containers.Metadata.CreationTimestamp.Time}
+			if lookup.containerType == ContainerTypeInitContainer && lookup.specs[i].RestartPolicy == string(corev1.RestartPolicyAlways) {
+				podContainer.Type = ContainerTypeSidecar
+			}
+			for j := range lookup.statuses {
+				if lookup.statuses[j].Name == containerName {
+					podContainer.ImageID = lookup.statuses[j].ImageID
+					podContainer.RestartCount = lookup.statuses[j].RestartCount
+					if _, containerID, ok := strings.Cut(lookup.statuses[j].ContainerID, "://"); ok {
+						podContainer.ContainerID = containerID
+					}
+				}
+			}
+			return podContainer, nil
+		}
+	}
+	return nil, fmt.Errorf("container %s not found in pod %s in namespace %s", containerName, pod.GetName(), pod.GetNamespace())
+}
+
+// GetPodContainerInstanceID returns the instance ID of a container of a pod whatever its type. The instance IDs are
+// only generated for the containers, the other kinds get the instance ID of the first container with their name.
+func GetPodContainerInstanceID(pod *workloadinterface.Workload, containerName string) (instanceidhandler.IInstanceID, error) {
+	podContainer, err := GetPodContainer(pod, containerName)
+	if err != nil {
+		return nil, err
+	}
+	instanceIDs, err := instanceidhandlerV1.GenerateInstanceID(pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InstanceID to pod %s in namespace %s: %w", pod.GetName(), pod.GetNamespace(), err)
+	}
+	if podContainer.Type == ContainerTypeContainer {
+		for i := range instanceIDs {
+			if instanceIDs[i].GetContainerName() == containerName {
+				return instanceIDs[i], nil
+			}
+		}
+	}
+	instanceID, err := instanceidhandlerV1.GenerateInstanceIDFromString(instanceIDs[0].GetStringFormatted())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InstanceID to container %s of pod %s in namespace %s: %w", containerName, pod.GetName(), pod.GetNamespace(), err)
+	}
+	instanceID.SetContainerName(containerName)
+	return instanceID, nil
+}
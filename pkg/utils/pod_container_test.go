@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubescape/k8s-interface/workloadinterface"
+	"github.com/stretchr/testify/assert"
+)
+
+const podMock = `{
+	"apiVersion": "v1",
+	"kind": "Pod",
+	"metadata": {
+		"name": "nginx-1",
+		"namespace": "default",
+		"creationTimestamp": "2023-07-01T10:00:00Z",
+		"ownerReferences": [{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "nginx-5d8f", "uid": "1"}]
+	},
+	"spec": {
+		"initContainers": [
+			{"name": "migrate", "image": "migrate:1.0"},
+			{"name": "proxy", "image": "envoy:1.27", "restartPolicy": "Always"}
+		],
+		"containers": [{"name": "nginx", "image": "nginx:1.25"}],
+		"ephemeralContainers": [{"name": "debugger", "image": "busybox:1.36"}]
+	},
+	"status": {
+		"initContainerStatuses": [
+			{"name": "migrate", "imageID": "docker.io/library/migrate@sha256:01"},
+			{"name": "proxy", "imageID": "docker.io/library/envoy@sha256:02"}
+		],
+		"containerStatuses": [{"name": "nginx", "imageID": "docker.io/library/nginx@sha256:03", "containerID": "containerd://abc", "restartCount": 2}],
+		"ephemeralContainerStatuses": [{"name": "debugger", "imageID": ""}]
+	}
+}`
+
+func TestGetPodContainer(t *testing.T) {
+	pod, err := workloadinterface.NewWorkload([]byte(podMock))
+	if err != nil {
+		t.Fatalf("fail to create pod, err: %v", err)
+	}
+	created := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC).Local()
+	tests := []struct {
+		name string
+		want PodContainer
+	}{
+		{"nginx", PodContainer{Name: "nginx", Type: ContainerTypeContainer, Image: "nginx:1.25", ImageID: "docker.io/library/nginx@sha256:03", ContainerID: "abc", RestartCount: 2, PodCreated: created}},
+		{"migrate", PodContainer{Name: "migrate", Type: ContainerTypeInitContainer, Image: "migrate:1.0", ImageID: "docker.io/library/migrate@sha256:01", PodCreated: created}},
+		{"proxy", PodContainer{Name: "proxy", Type: ContainerTypeSidecar, Image: "envoy:1.27", ImageID: "docker.io/library/envoy@sha256:02", PodCreated: created}},
+		{"debugger", PodContainer{Name: "debugger", Type: ContainerTypeEphemeral, Image: "busybox:1.36", PodCreated: created}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetPodContainer(pod, tt.name)
+			if err != nil {
+				t.Fatalf("fail to get container, err: %v", err)
+			}
+			assert.Equal(t, tt.want, *got)
+
+			instanceID, err := GetPodContainerInstanceID(pod, tt.name)
+			if err != nil {
+				t.Fatalf("fail to get instance ID, err: %v", err)
+			}
+			assert.Equal(t, "apiVersion-apps/v1/namespace-default/kind-ReplicaSet/name-nginx-5d8f/containerName-"+tt.name, instanceID.GetStringFormatted())
+		})
+	}
+	_, err = GetPodContainer(pod, "unknown")
+	assert.Error(t, err)
+}
@@ -56,3 +56,18 @@ func RandomSleep(min, max int) {
 	randomDuration := time.Duration(rand.Intn(max+1-min)+min) * time.Second
 	time.Sleep(randomDuration)
 }
+
+// writeOpenFlags are the open flags of a file opened for writing
+var writeOpenFlags = map[string]bool{
+	"O_WRONLY": true, "O_RDWR": true, "O_CREAT": true, "O_TRUNC": true, "O_APPEND": true,
+}
+
+// IsWriteOpen tells whether the flags of an open event open the file for writing
+func IsWriteOpen(flags []string) bool {
+	for _, flag := range flags {
+		if writeOpenFlags[flag] {
+			return true
+		}
+	}
+	return false
+}
@@ -42,3 +42,12 @@ func TestCurrentDir(t *testing.T) {
 		t.Fatalf("CurrentDir failed")
 	}
 }
+
+func TestIsWriteOpen(t *testing.T) {
+	if IsWriteOpen([]string{"O_RDONLY", "O_CLOEXEC"}) {
+		t.Fatalf("TestIsWriteOpen failed, O_RDONLY is not a write")
+	}
+	if !IsWriteOpen([]string{"O_WRONLY", "O_APPEND"}) {
+		t.Fatalf("TestIsWriteOpen failed, O_WRONLY is a write")
+	}
+}